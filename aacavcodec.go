@@ -0,0 +1,213 @@
+//go:build avcodec
+// +build avcodec
+
+package engine
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <stdlib.h>
+#include <string.h>
+#include <libavcodec/avcodec.h>
+#include <libavutil/audio_fifo.h>
+#include <libavutil/channel_layout.h>
+
+typedef struct {
+	AVCodecContext *dec;
+	AVCodecContext *enc;
+	AVAudioFifo *fifo;
+	AVFrame *decoded;
+	AVFrame *frame;
+	AVPacket *pkt;
+	// out is the aac frames of the last transcode, each prefixed by its size in 4 bytes
+	uint8_t *out;
+	int outcap;
+} aacenc;
+
+static void aacenc_close(aacenc *e) {
+	avcodec_free_context(&e->dec);
+	avcodec_free_context(&e->enc);
+	if (e->fifo) {
+		av_audio_fifo_free(e->fifo);
+	}
+	av_frame_free(&e->decoded);
+	av_frame_free(&e->frame);
+	av_packet_free(&e->pkt);
+	free(e->out);
+	free(e);
+}
+
+static void aacenc_strerror(int err, char *buf, int size) {
+	av_strerror(err, buf, size);
+}
+
+static int aacenc_open(aacenc **out, int channels, int bitrate) {
+	const AVCodec *dec = avcodec_find_decoder(AV_CODEC_ID_OPUS);
+	const AVCodec *enc = avcodec_find_encoder(AV_CODEC_ID_AAC);
+	if (!dec) {
+		return AVERROR_DECODER_NOT_FOUND;
+	}
+	if (!enc) {
+		return AVERROR_ENCODER_NOT_FOUND;
+	}
+	aacenc *e = calloc(1, sizeof(aacenc));
+	if (!e) {
+		return AVERROR(ENOMEM);
+	}
+	int ret = AVERROR(ENOMEM);
+	if (!(e->dec = avcodec_alloc_context3(dec)) || !(e->enc = avcodec_alloc_context3(enc)) ||
+			!(e->decoded = av_frame_alloc()) || !(e->frame = av_frame_alloc()) || !(e->pkt = av_packet_alloc())) {
+		goto fail;
+	}
+	e->dec->sample_rate = 48000;
+	e->dec->channels = channels;
+	e->dec->channel_layout = av_get_default_channel_layout(channels);
+	e->dec->request_sample_fmt = AV_SAMPLE_FMT_FLTP;
+	if ((ret = avcodec_open2(e->dec, dec, NULL)) < 0) {
+		goto fail;
+	}
+	e->enc->sample_rate = 48000;
+	e->enc->channels = channels;
+	e->enc->channel_layout = av_get_default_channel_layout(channels);
+	e->enc->sample_fmt = AV_SAMPLE_FMT_FLTP;
+	e->enc->bit_rate = bitrate;
+	e->enc->profile = FF_PROFILE_AAC_LOW;
+	if ((ret = avcodec_open2(e->enc, enc, NULL)) < 0) {
+		goto fail;
+	}
+	if (!(e->fifo = av_audio_fifo_alloc(AV_SAMPLE_FMT_FLTP, channels, e->enc->frame_size * 2))) {
+		ret = AVERROR(ENOMEM);
+		goto fail;
+	}
+	e->frame->nb_samples = e->enc->frame_size;
+	e->frame->format = AV_SAMPLE_FMT_FLTP;
+	e->frame->channels = channels;
+	e->frame->channel_layout = e->enc->channel_layout;
+	e->frame->sample_rate = 48000;
+	if ((ret = av_frame_get_buffer(e->frame, 0)) < 0) {
+		goto fail;
+	}
+	*out = e;
+	return 0;
+fail:
+	aacenc_close(e);
+	return ret;
+}
+
+static int aacenc_append(aacenc *e, int n, const uint8_t *data, int size) {
+	if (n + 4 + size > e->outcap) {
+		int cap = (n + 4 + size) * 2;
+		uint8_t *out = realloc(e->out, cap);
+		if (!out) {
+			return AVERROR(ENOMEM);
+		}
+		e->out = out;
+		e->outcap = cap;
+	}
+	e->out[n] = size >> 24;
+	e->out[n + 1] = size >> 16;
+	e->out[n + 2] = size >> 8;
+	e->out[n + 3] = size;
+	memcpy(e->out + n + 4, data, size);
+	return n + 4 + size;
+}
+
+// aacenc_transcode decode an opus packet and encode the full aac frames, it return the size of out
+static int aacenc_transcode(aacenc *e, uint8_t *data, int size) {
+	e->pkt->data = data;
+	e->pkt->size = size;
+	int ret = avcodec_send_packet(e->dec, e->pkt);
+	e->pkt->data = NULL;
+	e->pkt->size = 0;
+	if (ret < 0) {
+		return ret;
+	}
+	while ((ret = avcodec_receive_frame(e->dec, e->decoded)) >= 0) {
+		ret = av_audio_fifo_write(e->fifo, (void **)e->decoded->extended_data, e->decoded->nb_samples);
+		av_frame_unref(e->decoded);
+		if (ret < 0) {
+			return ret;
+		}
+	}
+	if (ret != AVERROR(EAGAIN) && ret != AVERROR_EOF) {
+		return ret;
+	}
+	int n = 0;
+	while (av_audio_fifo_size(e->fifo) >= e->enc->frame_size) {
+		if ((ret = av_frame_make_writable(e->frame)) < 0 ||
+				(ret = av_audio_fifo_read(e->fifo, (void **)e->frame->data, e->enc->frame_size)) < 0 ||
+				(ret = avcodec_send_frame(e->enc, e->frame)) < 0) {
+			return ret;
+		}
+		while ((ret = avcodec_receive_packet(e->enc, e->pkt)) >= 0) {
+			n = aacenc_append(e, n, e->pkt->data, e->pkt->size);
+			av_packet_unref(e->pkt);
+			if (n < 0) {
+				return n;
+			}
+		}
+		if (ret != AVERROR(EAGAIN) && ret != AVERROR_EOF) {
+			return ret;
+		}
+	}
+	return n;
+}
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// build with -tags avcodec to transcode the opus of HLSWriter to aac, it needs cgo and libavcodec
+func init() {
+	newAACEncoder = newAVAACEncoder
+}
+
+// avAACEncoder decode opus and encode aac lc by libavcodec
+type avAACEncoder struct {
+	enc *C.aacenc
+}
+
+func newAVAACEncoder(channels, bitrate int) (aacEncoder, error) {
+	e := &avAACEncoder{}
+	if ret := C.aacenc_open(&e.enc, C.int(channels), C.int(bitrate)); ret < 0 {
+		return nil, aacError("open", ret)
+	}
+	return e, nil
+}
+
+func aacError(op string, ret C.int) error {
+	buf := make([]byte, 128)
+	C.aacenc_strerror(ret, (*C.char)(unsafe.Pointer(&buf[0])), C.int(len(buf)))
+	return fmt.Errorf("%w: aac %v %v", errAACEncoder, op, C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+}
+
+func (e *avAACEncoder) encode(opus []byte) ([][]byte, error) {
+	if len(opus) == 0 {
+		return nil, nil
+	}
+	// libavcodec may read past the packet
+	data := C.CBytes(append(opus, make([]byte, 64)...))
+	defer C.free(data)
+	ret := C.aacenc_transcode(e.enc, (*C.uint8_t)(data), C.int(len(opus)))
+	if ret < 0 {
+		return nil, aacError("transcode", ret)
+	}
+	out := C.GoBytes(unsafe.Pointer(e.enc.out), ret)
+	var frames [][]byte
+	for len(out) >= 4 {
+		n := int(binary.BigEndian.Uint32(out))
+		if 4+n > len(out) {
+			break
+		}
+		frames = append(frames, out[4:4+n])
+		out = out[4+n:]
+	}
+	return frames, nil
+}
+
+func (e *avAACEncoder) close() {
+	C.aacenc_close(e.enc)
+}
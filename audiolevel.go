@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+const (
+	defaultAudioLevelInterval = 100 * time.Millisecond
+	// silentAudioLevel is the level of an interval without packet, e.g. a muted or dtx participant
+	silentAudioLevel = -127
+)
+
+// opusLevelDecoder decode an opus packet and return its level in dBov
+type opusLevelDecoder interface {
+	level(packet []byte) (int, error)
+	close()
+}
+
+// newOpusLevelDecoder is set by the avcodec build tag, else only the rfc 6464 levels are metered
+var newOpusLevelDecoder func(channels int) (opusLevelDecoder, error)
+
+// AudioLevel is the level of an audio track over an interval
+type AudioLevel struct {
+	TrackID string
+	// DBov is the loudest level of the interval, 0 is the loudest and -127 the silence
+	DBov int
+	// Voice is set if a packet of the interval was flagged with voice activity by the sender
+	Voice bool
+}
+
+// AudioMeter report the level of a subscribed audio track periodically, see MeterAudio
+type AudioMeter struct {
+	client   *Client
+	trackID  string
+	interval time.Duration
+	fn       func(AudioLevel)
+	done     chan struct{}
+	once     sync.Once
+
+	mu       sync.Mutex
+	tap      *rtpTap
+	ssrc     uint32
+	extID    uint8
+	decoder  opusLevelDecoder
+	level    int
+	voice    bool
+	received int
+	measured int
+	warned   bool
+}
+
+// writeRTP take the level of the header extension, or decode the packet without it
+func (m *AudioMeter) writeRTP(b []byte) {
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(b); err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received++
+	if m.extID == 0 {
+		m.extID = m.tap.extensionID(m.ssrc, sdp.AudioLevelURI)
+	}
+	var ext []byte
+	if m.extID != 0 {
+		ext = pkt.GetExtension(m.extID)
+	}
+	level, voice := silentAudioLevel, false
+	if len(ext) > 0 {
+		level, voice = -int(ext[0]&0x7f), ext[0]&0x80 != 0
+	} else if m.decoder != nil && len(pkt.Payload) > 0 {
+		l, err := m.decoder.level(pkt.Payload)
+		if err != nil {
+			log.Debugf("meter track=%v err=%v", m.trackID, err)
+			return
+		}
+		level = l
+	} else {
+		return
+	}
+	m.measured++
+	if level > m.level {
+		m.level = level
+	}
+	m.voice = m.voice || voice
+}
+
+// attach follow the track, it may be received later or again after a reconnect
+func (m *AudioMeter) attach() bool {
+	track, sub := m.client.subscribedTrack(m.trackID), m.client.getSub()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.done:
+		return false
+	default:
+	}
+	if track == nil || sub == nil {
+		m.detach()
+		return false
+	}
+	tap, ssrc := sub.tap, uint32(track.SSRC())
+	if m.tap == tap && m.ssrc == ssrc {
+		return true
+	}
+	m.detach()
+	if m.decoder == nil && newOpusLevelDecoder != nil && strings.EqualFold(track.Codec().MimeType, mimeTypeOpus) {
+		channels := int(track.Codec().Channels)
+		if channels == 0 {
+			channels = 2
+		}
+		d, err := newOpusLevelDecoder(channels)
+		if err != nil {
+			log.Warnf("meter track=%v decoder err=%v", m.trackID, err)
+		}
+		m.decoder = d
+	}
+	m.tap, m.ssrc, m.extID = tap, ssrc, 0
+	m.level, m.voice, m.received, m.measured = silentAudioLevel, false, 0, 0
+	tap.add(ssrc, m)
+	return true
+}
+
+// detach stop getting the packets, m.mu must be held
+func (m *AudioMeter) detach() {
+	if m.tap != nil {
+		m.tap.remove(m.ssrc, m)
+		m.tap = nil
+	}
+}
+
+func (m *AudioMeter) loop() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		case <-m.client.notify:
+			m.Stop()
+			return
+		}
+		if !m.attach() {
+			continue
+		}
+		m.mu.Lock()
+		l := AudioLevel{TrackID: m.trackID, DBov: m.level, Voice: m.voice}
+		// the packets without level are not silence
+		known := m.received == 0 || m.measured > 0
+		if !known && !m.warned {
+			m.warned = true
+			log.Warnf("meter track=%v has no audio level, build with -tags avcodec to decode opus", m.trackID)
+		}
+		m.level, m.voice, m.received, m.measured = silentAudioLevel, false, 0, 0
+		m.mu.Unlock()
+		if known {
+			m.fn(l)
+		}
+	}
+}
+
+// Stop stop metering
+func (m *AudioMeter) Stop() {
+	m.once.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.detach()
+		if m.decoder != nil {
+			m.decoder.close()
+			m.decoder = nil
+		}
+	})
+}
+
+// MeterAudio call fn every interval(default 100ms) with the level of a subscribed audio track, e.g. for volume
+// meters or to detect silent participants, the level is the rfc 6464 header extension of the packets or
+// the decoded opus with the avcodec build tag, an interval without packet is silent
+// it can be set before the track is received, the track must still be read, by OnTrack or by default
+func (c *Client) MeterAudio(trackID string, interval time.Duration, fn func(AudioLevel)) *AudioMeter {
+	if interval <= 0 {
+		interval = defaultAudioLevelInterval
+	}
+	m := &AudioMeter{
+		client:   c,
+		trackID:  trackID,
+		interval: interval,
+		fn:       fn,
+		done:     make(chan struct{}),
+		level:    silentAudioLevel,
+	}
+	go m.loop()
+	return m
+}
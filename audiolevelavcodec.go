@@ -0,0 +1,127 @@
+//go:build avcodec
+// +build avcodec
+
+package engine
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <math.h>
+#include <stdlib.h>
+#include <libavcodec/avcodec.h>
+#include <libavutil/channel_layout.h>
+
+static int level_open(AVCodecContext **out, int channels) {
+	const AVCodec *codec = avcodec_find_decoder(AV_CODEC_ID_OPUS);
+	if (!codec) {
+		return AVERROR_DECODER_NOT_FOUND;
+	}
+	AVCodecContext *ctx = avcodec_alloc_context3(codec);
+	if (!ctx) {
+		return AVERROR(ENOMEM);
+	}
+	ctx->sample_rate = 48000;
+	ctx->channels = channels;
+	ctx->channel_layout = av_get_default_channel_layout(channels);
+	ctx->request_sample_fmt = AV_SAMPLE_FMT_FLT;
+	int ret = avcodec_open2(ctx, codec, NULL);
+	if (ret < 0) {
+		avcodec_free_context(&ctx);
+		return ret;
+	}
+	*out = ctx;
+	return 0;
+}
+
+static void level_close(AVCodecContext *ctx) {
+	avcodec_free_context(&ctx);
+}
+
+static void level_strerror(int err, char *buf, int size) {
+	av_strerror(err, buf, size);
+}
+
+// level_decode decode a packet and set the rms of its float samples in dBov
+static int level_decode(AVCodecContext *ctx, uint8_t *data, int size, double *dbov) {
+	AVPacket *pkt = av_packet_alloc();
+	AVFrame *frame = av_frame_alloc();
+	int ret = AVERROR(ENOMEM);
+	if (!pkt || !frame) {
+		goto end;
+	}
+	pkt->data = data;
+	pkt->size = size;
+	if ((ret = avcodec_send_packet(ctx, pkt)) < 0) {
+		goto end;
+	}
+	double sum = 0;
+	long n = 0;
+	while ((ret = avcodec_receive_frame(ctx, frame)) >= 0) {
+		int planar = frame->format == AV_SAMPLE_FMT_FLTP;
+		int planes = planar ? frame->channels : 1;
+		int samples = planar ? frame->nb_samples : frame->nb_samples * frame->channels;
+		if (planar || frame->format == AV_SAMPLE_FMT_FLT) {
+			for (int p = 0; p < planes; p++) {
+				float *s = (float *)frame->extended_data[p];
+				for (int i = 0; i < samples; i++) {
+					sum += (double)s[i] * s[i];
+				}
+				n += samples;
+			}
+		}
+		av_frame_unref(frame);
+	}
+	if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+		ret = 0;
+	}
+	*dbov = n > 0 && sum > 0 ? 10 * log10(sum / n) : -127;
+end:
+	av_frame_free(&frame);
+	av_packet_free(&pkt);
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+func init() {
+	newOpusLevelDecoder = newAVLevelDecoder
+}
+
+// avLevelDecoder decode opus by libavcodec to meter the tracks without rfc 6464 level
+type avLevelDecoder struct {
+	ctx *C.AVCodecContext
+}
+
+func newAVLevelDecoder(channels int) (opusLevelDecoder, error) {
+	d := &avLevelDecoder{}
+	if ret := C.level_open(&d.ctx, C.int(channels)); ret < 0 {
+		return nil, levelError(ret)
+	}
+	return d, nil
+}
+
+func levelError(ret C.int) error {
+	buf := make([]byte, 128)
+	C.level_strerror(ret, (*C.char)(unsafe.Pointer(&buf[0])), C.int(len(buf)))
+	return fmt.Errorf("opus decode: %v", C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+}
+
+func (d *avLevelDecoder) level(packet []byte) (int, error) {
+	// libavcodec may read past the packet
+	data := C.CBytes(append(packet, make([]byte, 64)...))
+	defer C.free(data)
+	var dbov C.double
+	if ret := C.level_decode(d.ctx, (*C.uint8_t)(data), C.int(len(packet)), &dbov); ret < 0 {
+		return 0, levelError(ret)
+	}
+	return int(math.Max(silentAudioLevel, math.Min(0, math.Round(float64(dbov))))), nil
+}
+
+func (d *avLevelDecoder) close() {
+	C.level_close(d.ctx)
+}
@@ -0,0 +1,140 @@
+package engine
+
+const (
+	av1OBUSequenceHeader    = 1
+	av1OBUTemporalDelimiter = 2
+	av1OBUTileList          = 8
+	av1OBUPadding           = 15
+
+	// aggregation header bits
+	av1Z = 0x80
+	av1Y = 0x40
+	av1N = 0x08
+)
+
+// readLEB128 decode an unsigned leb128, return its value and length
+func readLEB128(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 8; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func appendLEB128(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func leb128Size(v int) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// splitOBUs return the obus of a temporal unit in low overhead format, without their size fields
+// the temporal delimiters, tile lists and padding are dropped as rfc av1 rtp recommends
+func splitOBUs(b []byte) ([][]byte, bool) {
+	var obus [][]byte
+	seqHeader := false
+	for len(b) > 0 {
+		header := 1
+		if b[0]&0x04 != 0 {
+			header = 2
+		}
+		if len(b) < header {
+			break
+		}
+		size := len(b) - header
+		n := 0
+		if b[0]&0x02 != 0 {
+			v, l := readLEB128(b[header:])
+			if l == 0 || int(v) > len(b)-header-l {
+				break
+			}
+			size, n = int(v), l
+		}
+		typ := (b[0] >> 3) & 0x0f
+		if typ == av1OBUSequenceHeader {
+			seqHeader = true
+		}
+		if typ != av1OBUTemporalDelimiter && typ != av1OBUTileList && typ != av1OBUPadding {
+			obu := make([]byte, 0, header+size)
+			obu = append(obu, b[0]&^0x02)
+			obu = append(obu, b[1:header]...)
+			obu = append(obu, b[header+n:header+n+size]...)
+			obus = append(obus, obu)
+		}
+		b = b[header+n+size:]
+	}
+	return obus, seqHeader
+}
+
+// av1Payloader packetize temporal units by the av1 rtp spec, every obu element is length prefixed
+type av1Payloader struct{}
+
+func (p *av1Payloader) Payload(mtu int, payload []byte) [][]byte {
+	obus, seqHeader := splitOBUs(payload)
+	if len(obus) == 0 || mtu < 4 {
+		return nil
+	}
+	var out [][]byte
+	pkt := []byte{0}
+	if seqHeader {
+		// the first packet of a coded video sequence
+		pkt[0] |= av1N
+	}
+	for _, obu := range obus {
+		for len(obu) > 0 {
+			space := mtu - len(pkt)
+			if space < 2 {
+				out = append(out, pkt)
+				pkt = []byte{0}
+				continue
+			}
+			n := space - leb128Size(space)
+			if n > len(obu) {
+				n = len(obu)
+			}
+			pkt = appendLEB128(pkt, uint64(n))
+			pkt = append(pkt, obu[:n]...)
+			obu = obu[n:]
+			if len(obu) > 0 {
+				// the element continue in the next packet
+				pkt[0] |= av1Y
+				out = append(out, pkt)
+				pkt = []byte{av1Z}
+			}
+		}
+	}
+	if len(pkt) > 1 {
+		out = append(out, pkt)
+	}
+	return out
+}
+
+// AV1Track is a sample track of av1, write temporal units like the frames of an ivf file
+type AV1Track struct {
+	*payloadTrack
+}
+
+// NewAV1Track create an av1 track, the codec must be negotiated by the sfu
+func NewAV1Track(id, streamID string) (*AV1Track, error) {
+	track, err := newPayloadTrack(av1Codec, id, streamID, &av1Payloader{})
+	if err != nil {
+		return nil, err
+	}
+	return &AV1Track{payloadTrack: track}, nil
+}
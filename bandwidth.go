@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	rembCycle = time.Second
+)
+
+// SetReceiveBandwidthLimit ask sfu to send at most kbps to sub by REMB, sfu may switch to lower layers
+// 0 means unlimited
+func (c *Client) SetReceiveBandwidthLimit(kbps int) {
+	old := atomic.SwapInt64(&c.recvLimit, int64(kbps))
+	log.Infof("id=%v SetReceiveBandwidthLimit %v kbps", c.uid, kbps)
+	if old == 0 && kbps > 0 {
+		go c.rembLoop()
+	}
+}
+
+// rembLoop send REMB for all received tracks until limit removed or client closed
+func (c *Client) rembLoop() {
+	ticker := time.NewTicker(rembCycle)
+	defer ticker.Stop()
+	for {
+		kbps := atomic.LoadInt64(&c.recvLimit)
+		if kbps <= 0 {
+			return
+		}
+
+		var ssrcs []uint32
+		// no sub before Join or with NoSubscribe
+		sub := c.getSub()
+		if sub != nil {
+			for _, r := range sub.pc.GetReceivers() {
+				for _, track := range r.Tracks() {
+					ssrcs = append(ssrcs, uint32(track.SSRC()))
+				}
+			}
+		}
+		if len(ssrcs) > 0 {
+			err := sub.pc.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{
+				Bitrate: uint64(kbps) * 1000,
+				SSRCs:   ssrcs,
+			}})
+			if err != nil {
+				log.Debugf("id=%v send remb err=%v", c.uid, err)
+			}
+		}
+
+		select {
+		case <-c.notify:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,10 +1,15 @@
+//go:build biz
+// +build biz
+
+// the biz tests need an ion biz server on bizAddr, run them with -tags biz
+
 package engine
 
 import (
 	"sync"
 	"testing"
 
-	log "github.com/pion/ion-log"
+	ilog "github.com/pion/ion-log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,9 +30,7 @@ var (
 )
 
 func init() {
-	fixByFile := []string{"asm_amd64.s", "proc.go"}
-	fixByFunc := []string{}
-	log.Init("debug", fixByFile, fixByFunc)
+	ilog.Init("debug")
 
 	wg = new(sync.WaitGroup)
 
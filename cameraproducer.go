@@ -0,0 +1,111 @@
+//go:build gst
+// +build gst
+
+package engine
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// CameraConfig is the capture of a local camera, the zero values are the defaults of the device
+type CameraConfig struct {
+	// Device is /dev/videoN on linux or the index on macos and windows, empty is the first camera
+	Device    string
+	Width     int
+	Height    int
+	FrameRate int
+	// Audio capture the default microphone too
+	Audio bool
+}
+
+// CameraProducer capture a local camera by gstreamer and publish it, e.g. on an embedded linux device
+// or a headless capture box, build with -tags gst
+// the source is v4l2src on linux, avfvideosrc on macos and mfvideosrc on windows, raw or mjpeg cameras are decoded
+type CameraProducer struct {
+	*GstProducer
+}
+
+// cameraSource return the gstreamer source of the camera with the caps of cfg
+func cameraSource(cfg CameraConfig) (string, error) {
+	var src string
+	switch runtime.GOOS {
+	case "linux":
+		device := cfg.Device
+		if device == "" {
+			device = "/dev/video0"
+		}
+		if !strings.HasPrefix(device, "/dev/") {
+			return "", errInvalidCamera
+		}
+		src = fmt.Sprintf("v4l2src device=%s do-timestamp=true ! decodebin", device)
+	case "darwin", "windows":
+		index := 0
+		if cfg.Device != "" {
+			var err error
+			if index, err = strconv.Atoi(cfg.Device); err != nil || index < 0 {
+				return "", errInvalidCamera
+			}
+		}
+		element := "avfvideosrc"
+		if runtime.GOOS == "windows" {
+			element = "mfvideosrc"
+		}
+		src = fmt.Sprintf("%s device-index=%d", element, index)
+	default:
+		return "", errNoCamera
+	}
+	src += " ! videoconvert ! videoscale ! videorate"
+	var caps []string
+	if cfg.Width > 0 && cfg.Height > 0 {
+		caps = append(caps, fmt.Sprintf("width=%d,height=%d", cfg.Width, cfg.Height))
+	}
+	if cfg.FrameRate > 0 {
+		caps = append(caps, fmt.Sprintf("framerate=%d/1", cfg.FrameRate))
+	}
+	if len(caps) > 0 {
+		src += " ! video/x-raw," + strings.Join(caps, ",")
+	}
+	return src, nil
+}
+
+// NewCameraProducer open the camera of cfg, videoCodec is vp8|vp9|h264 or a hardware h264, see NewGstProducer
+func NewCameraProducer(id, videoCodec string, cfg CameraConfig) (*CameraProducer, error) {
+	src, err := cameraSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var audio string
+	if cfg.Audio {
+		audio = "autoaudiosrc ! audioconvert ! audioresample"
+	}
+	p := NewGstProducer(id, videoCodec, src, audio)
+	p.streamID = fmt.Sprintf("camera_%p", p)
+	return &CameraProducer{GstProducer: p}, nil
+}
+
+// PublishCamera capture and publish a local camera, and the microphone if cfg.Audio, see CameraProducer
+func (c *Client) PublishCamera(videoCodec string, cfg CameraConfig) error {
+	pub := c.getPub()
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewCameraProducer(c.uid, videoCodec, cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := p.AddTrack(pub.pc, "video"); err != nil {
+		return err
+	}
+	if cfg.Audio {
+		if _, err := p.AddTrack(pub.pc, "audio"); err != nil {
+			return err
+		}
+	}
+	c.setProducer(p)
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
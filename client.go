@@ -1,14 +1,19 @@
 package engine
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lucsky/cuid"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
 )
 
 func NewJoinConfig() *JoinConfig {
@@ -25,9 +30,22 @@ func (j JoinConfig) SetNoPublish() *JoinConfig {
 
 func (j JoinConfig) SetNoSubscribe() *JoinConfig {
 	j["NoSubscribe"] = "true"
+	// the ion-sfu grpc server reads this misspelled key
+	j["NoSublish"] = "true"
 	return &j
 }
 
+// SetNoAutoSubscribe join without receiving remote tracks until Client.Subscribe is called, the tracks of the sub
+// offers are unselected before they are answered
+func (j JoinConfig) SetNoAutoSubscribe() *JoinConfig {
+	j["NoAutoSubscribe"] = "true"
+	return &j
+}
+
+func (j JoinConfig) isSet(key string) bool {
+	return j[key] == "true"
+}
+
 func SetRelay(j JoinConfig) *JoinConfig {
 	j["Relay"] = "true"
 	return &j
@@ -39,7 +57,7 @@ const (
 	SUBSCRIBER  = 1
 )
 
-//Call dc api
+// Call dc api
 type Call struct {
 	StreamID string `json:"streamId"`
 	Video    string `json:"video"`
@@ -48,63 +66,218 @@ type Call struct {
 
 // Client a sdk client
 type Client struct {
+	addr   string
 	uid    string
 	sid    string
 	pub    *Transport
 	sub    *Transport
 	cfg    WebRTCTransportConfig
-	signal *Signal
+	signal Signal
 
 	//export to user
-	OnTrack       func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
-	OnDataChannel func(*webrtc.DataChannel)
-	OnError       func(error)
+	OnTrack        func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+	OnTrackRemoved func(trackID, streamID string)
+	OnDataChannel  func(*webrtc.DataChannel)
+	OnError        func(error)
+	// role is PUBLISHER or SUBSCRIBER
+	OnConnectionStateChange    func(role int, state webrtc.PeerConnectionState)
+	OnICEConnectionStateChange func(role int, state webrtc.ICEConnectionState)
+	OnSignalingStateChange     func(role int, state webrtc.SignalingState)
+	// OnPublisherRTCP is called when sfu send PLI/FIR/NACK/REMB for a published track
+	OnPublisherRTCP func(pkt rtcp.Packet, trackID string)
+	// OnNegotiationError is called when sdp offer/answer failed, role is PUBLISHER or SUBSCRIBER
+	OnNegotiationError func(role int, err error)
+	// OnPeerMetadata is called when a peer of the session announce its join metadata
+	OnPeerMetadata func(uid string, meta map[string]string)
+	// OnPeerLeave is called when a peer of the session close with a reason
+	OnPeerLeave func(uid string, reason LeaveReason)
+	// OnKicked is called when the server ask the client to leave
+	OnKicked func(reason string)
+	// OnRemoteMuteRequest is called when the server ask to mute or stop publishing tracks
+	OnRemoteMuteRequest func(msg ControlMessage)
+	// OnReconnecting is called before each reconnect attempt, attempt starts from 1
+	OnReconnecting func(attempt int)
+	// OnReconnected is called after the session is joined again
+	OnReconnected func()
+	// OnReconnectFailed is called when all attempts failed, the client is closed after it
+	OnReconnectFailed func(err error)
+	// OnTokenExpired return a new token, it is called before each reconnect attempt
+	OnTokenExpired func() (string, error)
+	// OnSignalTimeout is called when the sfu stop responding, before reconnecting or closing
+	OnSignalTimeout func()
+	// OnSignalSent and OnSignalReceived trace every sdp and candidate, called inline so keep them fast
+	OnSignalSent     func(msg SignalMessage)
+	OnSignalReceived func(msg SignalMessage)
+	// OnProducerProgress is called every second by the published file or generator
+	OnProducerProgress func(p ProducerProgress)
+	// OnProducerEnd is called when the published file ends or fails to read, err is nil at the end
+	OnProducerEnd func(err error)
+
+	producer   sender
+	pacer      *pacer
+	negotiator *negotiator
+	iceRestart int32
+	recvLimit  int64
+	recvByte   uint64
+	notify     chan struct{}
+	// sfu offers and candidates wait here until join
+	inbox *inbox
+
+	answered   *event
+	connected  *event
+	firstTrack *event
+
+	// failed pub negotiations since the last success
+	negotiationRetries int32
 
-	producer *WebMProducer
-	recvByte int
-	notify   chan struct{}
+	// signal, pub and sub are replaced on reconnect
+	signalLock   sync.RWMutex
+	joinConfig   *JoinConfig
+	reconnecting int32
+	token        string
+	customSignal bool
+	// the sfu addr is found by Engine.FindNode on every reconnect
+	discovered bool
+	// the sfu addr was picked by Engine.PickNode before the session is known, see place
+	placed bool
+
+	// ServerInfo, checked on the join answer and the first sub offer
+	server        atomic.Value
+	serverChecked int32
+	apiChecked    int32
+	serverErr     error
+
+	sigStats signalStats
+
+	rtcpLock    sync.Mutex
+	rtcpSenders map[*webrtc.RTPSender]bool
 
 	//cache remote sid for subscribe/unsubscribe
 	streamLock     sync.RWMutex
 	remoteStreamId map[string]string
+	remoteTracks   map[string]remoteTrack
+	streamSubs     map[string]*streamSub
+	// the OnSample handlers and their sinks by track id
+	sampleHandlers map[string]func(media.Sample)
+	sampleSinks    map[string]*sampleSink
 
 	//cache datachannel api operation before dc.OnOpen
 	apiQueue []Call
+	// guard apiQueue and the api datachannel of sub, the datachannel is opened by pion
+	apiLock sync.Mutex
+
+	// join config flags
+	noPublish       bool
+	noSubscribe     bool
+	noAutoSubscribe bool
 
-	engine *Engine
+	labelLock sync.RWMutex
+	labels    map[string]string
+
+	metaLock sync.RWMutex
+	metadata map[string]string
+	peerMeta map[string]map[string]string
+	metaDC   *webrtc.DataChannel
+
+	engine    *Engine
+	closeOnce sync.Once
 }
 
 // NewClient create a sdk client
+// addr is the sfu grpc addr or a unix socket, if empty a node is picked from Config.SFUAddrs
+// with session affinity the client move to the node of the session at Join
 func NewClient(engine *Engine, addr string, cid string) (*Client, error) {
+	return NewClientWithLabels(engine, addr, cid, nil)
+}
+
+// NewClientWithLabels create a sdk client with labels, e.g. role=bot, region=eu
+func NewClientWithLabels(engine *Engine, addr string, cid string, labels map[string]string) (*Client, error) {
+	uid := cid
+	if uid == "" {
+		uid = cuid.New()
+	}
+
+	placed := addr == ""
+	if placed {
+		var err error
+		addr, err = engine.PickNode("")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := engine.newSignal(addr, uid)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClient(engine, addr, uid, s, labels)
+	if err != nil {
+		return nil, err
+	}
+	c.placed = placed
+	return c, nil
+}
+
+// NewClientWithSignal create a sdk client on a custom signal, e.g. over nats or an embedded sfu
+// the client is not reconnected since the sdk can not redial the signal
+func NewClientWithSignal(engine *Engine, s Signal, cid string) (*Client, error) {
 	uid := cid
 	if uid == "" {
 		uid = cuid.New()
 	}
+	c, err := newClient(engine, "", uid, s, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.customSignal = true
+	return c, nil
+}
 
-	s, err := NewSignal(addr, uid)
+func newClient(engine *Engine, addr, uid string, s Signal, labels map[string]string) (*Client, error) {
+	cfg, err := engine.getConfig().webrtcConfig()
 	if err != nil {
+		s.Close()
 		return nil, err
 	}
 	c := &Client{
 		engine:         engine,
+		addr:           addr,
 		uid:            uid,
 		signal:         s,
-		cfg:            engine.cfg.WebRTC,
+		cfg:            cfg,
 		notify:         make(chan struct{}),
+		inbox:          newInbox(),
+		answered:       newEvent(),
+		connected:      newEvent(),
+		firstTrack:     newEvent(),
 		remoteStreamId: make(map[string]string),
+		remoteTracks:   make(map[string]remoteTrack),
+		streamSubs:     make(map[string]*streamSub),
+		sampleHandlers: make(map[string]func(media.Sample)),
+		sampleSinks:    make(map[string]*sampleSink),
+		rtcpSenders:    make(map[*webrtc.RTPSender]bool),
+		pacer:          newPacer(engine.getConfig().MaxSendBitrate),
+		labels:         make(map[string]string, len(labels)),
+		peerMeta:       make(map[string]map[string]string),
 	}
-
-	c.signal.OnNegotiate = c.Negotiate
-	c.signal.OnTrickle = c.Trickle
-	c.signal.OnSetRemoteSDP = c.SetRemoteSDP
-	c.signal.OnError = func(err error) {
-		if c.OnError != nil {
-			c.OnError(err)
-		}
+	for k, v := range labels {
+		c.labels[k] = v
 	}
 
+	c.bindSignal(s)
+
+	c.negotiator = newNegotiator(c.cfg.NegotiationDebounce, engine.getConfig().OfferTimeout, c.sendOffer, func() {
+		c.onNegotiationError(PUBLISHER, ErrOfferTimeout)
+	})
+	// sub is created at Join, unless joining with NoSubscribe
 	c.pub = NewTransport(PUBLISHER, c.signal, c.cfg)
-	c.sub = NewTransport(SUBSCRIBER, c.signal, c.cfg)
+	if c.pub == nil {
+		c.signal.Close()
+		return nil, errInvalidPC
+	}
+	c.pub.trace = c.traceSent
+	c.pub.pacing.setPacer(c.pacer)
+	c.handleStateChange(PUBLISHER, c.pub.pc)
 
 	// engine.AddClient(c)
 
@@ -113,49 +286,23 @@ func NewClient(engine *Engine, addr string, cid string) (*Client, error) {
 	return c, nil
 }
 
-// SetRemoteSDP pub SetRemoteDescription and send cadidate to sfu
-func (c *Client) SetRemoteSDP(sdp webrtc.SessionDescription) error {
-	err := c.pub.pc.SetRemoteDescription(sdp)
-	if err != nil {
-		log.Errorf("id=%v err=%v", c.uid, err)
-		return err
-	}
-
-	// it's safe to add cand now after SetRemoteDescription
-	if len(c.pub.RecvCandidates) > 0 {
-		for _, candidate := range c.pub.RecvCandidates {
-			log.Debugf("id=%v c.pub.pc.AddICECandidate candidate=%v", c.uid, candidate)
-			err = c.pub.pc.AddICECandidate(candidate)
-			if err != nil {
-				log.Errorf("id=%v c.pub.pc.AddICECandidate err=%v", c.uid, err)
-			}
-		}
-		c.pub.RecvCandidates = []webrtc.ICECandidateInit{}
-	}
-
-	// it's safe to send cand now after join ok
-	if len(c.pub.SendCandidates) > 0 {
-		for _, cand := range c.pub.SendCandidates {
-			log.Debugf("id=%v sending c.pub.SendCandidates cand=%v", c.uid, cand)
-			c.signal.Trickle(cand, PUBLISHER)
-		}
-		c.pub.SendCandidates = []*webrtc.ICECandidate{}
-	}
-	return nil
-}
-
-// Join client join a session
-func (c *Client) Join(sid string, config *JoinConfig) error {
-	log.Debugf("[Client.Join] sid=%v uid=%v", sid, c.uid)
-	c.sub.pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+// handleSubscriber set the callbacks of a new sub
+func (c *Client) handleSubscriber(sub *Transport) {
+	sub.trace = c.traceSent
+	c.handleStateChange(SUBSCRIBER, sub.pc)
+	sub.pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		log.Debugf("[c.sub.pc.OnTrack] got track streamId=%v kind=%v ssrc=%v ", track.StreamID(), track.Kind(), track.SSRC())
 		c.streamLock.Lock()
 		c.remoteStreamId[track.StreamID()] = track.StreamID()
+		c.addRemoteTrack(track)
+		c.attachSampleSink(track)
 		log.Debugf("id=%v len(c.remoteStreamId)=%+v", c.uid, len(c.remoteStreamId))
 		c.streamLock.Unlock()
+		c.firstTrack.fire()
+		c.engine.interceptTrack(c, track, receiver)
 		// user define
 		if c.OnTrack != nil {
-			c.OnTrack(track, receiver)
+			c.engine.dispatcher.Dispatch(func() { c.OnTrack(track, receiver) })
 		} else {
 			//for read and calc
 			b := make([]byte, 1500)
@@ -168,32 +315,37 @@ func (c *Client) Join(sid string, config *JoinConfig) error {
 					if err != nil {
 						if err == io.EOF {
 							log.Errorf("id=%v track.ReadRTP err=%v", c.uid, err)
+							c.removeRemoteTrack(track.ID())
 							return
 						}
 						log.Errorf("id=%v Error reading track rtp %s", c.uid, err)
 						continue
 					}
-					c.recvByte += n
+					atomic.AddUint64(&c.recvByte, uint64(n))
 				}
 			}
 		}
 	})
 
-	c.sub.pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+	sub.pc.OnDataChannel(func(dc *webrtc.DataChannel) {
 		log.Debugf("id=%v [c.sub.pc.OnDataChannel] got dc %v", c.uid, dc.Label())
 		if dc.Label() == API_CHANNEL {
 			log.Debugf("%v got dc %v", c.uid, dc.Label())
-			c.sub.api = dc
+			c.apiLock.Lock()
+			sub.api = dc
+			c.apiLock.Unlock()
 			// send cmd after open
-			c.sub.api.OnOpen(func() {
+			dc.OnOpen(func() {
+				c.apiLock.Lock()
+				defer c.apiLock.Unlock()
 				if len(c.apiQueue) > 0 {
 					for _, cmd := range c.apiQueue {
-						log.Debugf("%v c.sub.api.OnOpen send cmd=%v", c.uid, cmd)
+						log.Debugf("%v sub.api.OnOpen send cmd=%v", c.uid, cmd)
 						marshalled, err := json.Marshal(cmd)
 						if err != nil {
 							continue
 						}
-						err = c.sub.api.Send(marshalled)
+						err = sub.api.Send(marshalled)
 						if err != nil {
 							log.Errorf("id=%v err=%v", c.uid, err)
 						}
@@ -204,49 +356,293 @@ func (c *Client) Join(sid string, config *JoinConfig) error {
 			})
 			return
 		}
+		if dc.Label() == metadataChannel {
+			c.onMetadataChannel(dc)
+			return
+		}
 		log.Debugf("%v got dc %v", c.uid, dc.Label())
 		if c.OnDataChannel != nil {
-			c.OnDataChannel(dc)
+			c.engine.dispatcher.Dispatch(func() { c.OnDataChannel(dc) })
+		}
+	})
+}
+
+// bindSignal set the callbacks of signal s
+func (c *Client) bindSignal(s Signal) {
+	// offers and candidates arriving before join are replayed once the pcs are ready
+	// the ones of a replaced signal are dropped
+	s.OnNegotiate(func(sdp webrtc.SessionDescription) error {
+		c.traceReceived(SignalMessage{Type: SignalOffer, SDP: &sdp})
+		var err error
+		c.inbox.push(func() {
+			if c.getSignal() == s {
+				err = c.Negotiate(sdp)
+			}
+		})
+		return err
+	})
+	s.OnTrickle(func(candidate webrtc.ICECandidateInit, target int) {
+		c.traceReceived(SignalMessage{Type: SignalTrickle, Target: target, Candidate: &candidate})
+		c.inbox.push(func() {
+			if c.getSignal() == s {
+				c.Trickle(candidate, target)
+			}
+		})
+	})
+	s.OnSetRemoteSDP(func(sdp webrtc.SessionDescription) error {
+		c.traceReceived(SignalMessage{Type: SignalAnswer, SDP: &sdp})
+		return c.SetRemoteSDP(sdp)
+	})
+	s.OnError(func(err error) {
+		// a replaced signal is closed after reconnected
+		if c.getSignal() != s {
+			return
+		}
+		if c.OnError != nil {
+			c.engine.dispatcher.Dispatch(func() { c.OnError(err) })
+		}
+		if err == ErrSignalTimeout && c.OnSignalTimeout != nil {
+			c.engine.dispatcher.Dispatch(c.OnSignalTimeout)
+		}
+		if c.closed() || !c.reconnect(err) {
+			// signaling is gone, close client and remove it from engine
+			c.CloseWithReason(LeaveError)
+		}
+	})
+}
+
+// ID return client id
+func (c *Client) ID() string {
+	return c.uid
+}
+
+// Addr return the sfu addr of client
+func (c *Client) Addr() string {
+	c.signalLock.RLock()
+	defer c.signalLock.RUnlock()
+	return c.addr
+}
+
+// SessionID return the joined session id
+func (c *Client) SessionID() string {
+	return c.sid
+}
+
+// Labels return a copy of client labels
+func (c *Client) Labels() map[string]string {
+	c.labelLock.RLock()
+	defer c.labelLock.RUnlock()
+	labels := make(map[string]string, len(c.labels))
+	for k, v := range c.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// SetLabel set a label, empty value delete it
+func (c *Client) SetLabel(key, value string) {
+	c.labelLock.Lock()
+	defer c.labelLock.Unlock()
+	if value == "" {
+		delete(c.labels, key)
+		return
+	}
+	c.labels[key] = value
+}
+
+// matchLabels return true if client has all labels in selector
+func (c *Client) matchLabels(selector map[string]string) bool {
+	c.labelLock.RLock()
+	defer c.labelLock.RUnlock()
+	for k, v := range selector {
+		if c.labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// handleStateChange forward the pc state changes to user
+func (c *Client) handleStateChange(role int, pc *webrtc.PeerConnection) {
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Debugf("id=%v role=%v connection state %v", c.uid, role, state)
+		c.onConnectionState(role, state)
+		if c.OnConnectionStateChange != nil {
+			c.OnConnectionStateChange(role, state)
+		}
+	})
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		log.Debugf("id=%v role=%v ice connection state %v", c.uid, role, state)
+		if c.OnICEConnectionStateChange != nil {
+			c.OnICEConnectionStateChange(role, state)
+		}
+	})
+	pc.OnSignalingStateChange(func(state webrtc.SignalingState) {
+		log.Debugf("id=%v role=%v signaling state %v", c.uid, role, state)
+		if c.OnSignalingStateChange != nil {
+			c.OnSignalingStateChange(role, state)
 		}
 	})
+}
+
+// SetRemoteSDP pub SetRemoteDescription and send cadidate to sfu
+func (c *Client) SetRemoteSDP(sdp webrtc.SessionDescription) error {
+	pub := c.getPub()
+	// streams are bound in SetRemoteDescription, check fec payloads before
+	pub.fec.negotiate(sdp)
+	if err := c.checkServer(); err != nil {
+		c.negotiator.Done()
+		return err
+	}
+	err := pub.pc.SetRemoteDescription(sdp)
+	c.negotiator.Done()
+	if err != nil {
+		c.onNegotiationError(PUBLISHER, err)
+		return err
+	}
+	atomic.StoreInt32(&c.negotiationRetries, 0)
+	c.answered.fire()
+
+	// it's safe to add cand now after SetRemoteDescription
+	send, recv := pub.takeCandidates()
+	for _, candidate := range recv {
+		log.Debugf("id=%v c.pub.pc.AddICECandidate candidate=%v", c.uid, candidate)
+		err = pub.pc.AddICECandidate(candidate)
+		if err != nil {
+			log.Errorf("id=%v c.pub.pc.AddICECandidate err=%v", c.uid, err)
+		}
+	}
+
+	// it's safe to send cand now after join ok
+	for _, cand := range send {
+		log.Debugf("id=%v sending c.pub.SendCandidates cand=%v", c.uid, cand)
+		pub.trickle(cand)
+	}
+	return nil
+}
+
+// Join client join a session
+// return ErrJoinTimeout if Config.JoinTimeout is set and the sfu does not answer in time
+func (c *Client) Join(sid string, config *JoinConfig) error {
+	if timeout := c.engine.getConfig().JoinTimeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return c.JoinContext(ctx, sid, config)
+	}
+	return c.doJoin(sid, config)
+}
 
-	offer, err := c.pub.pc.CreateOffer(nil)
+// doJoin join without waiting for the answer
+func (c *Client) doJoin(sid string, config *JoinConfig) error {
+	log.Debugf("[Client.Join] sid=%v uid=%v", sid, c.uid)
+	if err := c.engine.Admit(sid, c.uid); err != nil {
+		return err
+	}
+	if config != nil {
+		c.noPublish = config.isSet("NoPublish")
+		c.noSubscribe = config.isSet("NoSubscribe")
+		c.noAutoSubscribe = config.isSet("NoAutoSubscribe")
+	}
+	c.joinConfig = config
+	if err := c.place(sid); err != nil {
+		return err
+	}
+	if err := c.join(sid, config); err != nil {
+		return err
+	}
+	c.sid = sid
+	return c.engine.AddClient(c)
+}
+
+// join negotiate the pcs with sfu, used by Join and reconnect
+func (c *Client) join(sid string, config *JoinConfig) error {
+	var meta map[string]string
+	if config != nil {
+		meta = config.metadata()
+	}
+	if err := c.createMetadataChannel(meta); err != nil {
+		return err
+	}
+	// sub is never negotiated with NoSubscribe, it is not created
+	if !c.noSubscribe && c.getSub() == nil {
+		sub := NewTransport(SUBSCRIBER, c.getSignal(), c.cfg)
+		if sub == nil {
+			return errInvalidPC
+		}
+		c.handleSubscriber(sub)
+		c.signalLock.Lock()
+		c.sub = sub
+		c.signalLock.Unlock()
+	}
+
+	pub := c.getPub()
+	offer, err := pub.pc.CreateOffer(nil)
 	if err != nil {
 		return err
 	}
-	err = c.pub.pc.SetLocalDescription(offer)
+	offer, err = pub.setLocalDescription(offer)
 	if err != nil {
 		return err
 	}
-	err = c.signal.Join(sid, c.uid, offer, config)
-	if err == nil {
-		c.sid = sid
-		c.engine.AddClient(c)
+	c.negotiator.Begin()
+	atomic.StoreInt32(&c.serverChecked, 0)
+	atomic.StoreInt32(&c.apiChecked, 0)
+	c.traceSent(SignalMessage{Type: SignalJoin, SDP: &offer})
+	err = c.getSignal().Join(sid, c.uid, offer, c.withToken(withProtocol(config)))
+	if err != nil {
+		c.negotiator.Done()
+		return err
 	}
-	return err
+	c.inbox.open()
+	return nil
 }
 
 // GetPubStats get pub stats
 func (c *Client) GetPubStats() webrtc.StatsReport {
-	return c.pub.pc.GetStats()
+	return c.getPub().pc.GetStats()
 }
 
-// GetSubStats get sub stats
+// GetSubStats get sub stats, empty without sub
 func (c *Client) GetSubStats() webrtc.StatsReport {
-	return c.sub.pc.GetStats()
+	sub := c.getSub()
+	if sub == nil {
+		return webrtc.StatsReport{}
+	}
+	return sub.pc.GetStats()
 }
 
 func (c *Client) GetPubTransport() *Transport {
-	return c.pub
+	return c.getPub()
 }
 
+// GetSubTransport return the sub, nil before Join or with NoSubscribe
 func (c *Client) GetSubTransport() *Transport {
-	return c.sub
+	return c.getSub()
+}
+
+// PublisherPC return the pub pc, advanced use only
+// the sdk own the negotiation, call OnNegotiationNeeded after adding transceivers, never set sdp or close it
+func (c *Client) PublisherPC() *webrtc.PeerConnection {
+	return c.getPub().pc
+}
+
+// SubscriberPC return the sub pc, advanced use only, it is negotiated by sfu offers
+// prefer read only access like GetStats, never set sdp or close it, nil before Join or with NoSubscribe
+func (c *Client) SubscriberPC() *webrtc.PeerConnection {
+	sub := c.getSub()
+	if sub == nil {
+		return nil
+	}
+	return sub.pc
 }
 
 // Publish a local track
 func (c *Client) Publish(track webrtc.TrackLocal) (*webrtc.RTPTransceiver, error) {
-	t, err := c.pub.pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+	pub := c.getPub()
+	if c.noPublish {
+		return nil, errNoPublish
+	}
+	t, err := pub.pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
 		Direction: webrtc.RTPTransceiverDirectionSendonly,
 	})
 	c.OnNegotiationNeeded()
@@ -255,49 +651,61 @@ func (c *Client) Publish(track webrtc.TrackLocal) (*webrtc.RTPTransceiver, error
 
 // UnPublish a local track by Transceiver
 func (c *Client) UnPublish(t *webrtc.RTPTransceiver) error {
-	err := c.pub.pc.RemoveTrack(t.Sender())
+	pub := c.getPub()
+	err := pub.pc.RemoveTrack(t.Sender())
 	c.OnNegotiationNeeded()
 	return err
 }
 
-// Close client close
+// Close send a normal leave and close the client
 func (c *Client) Close() {
+	c.CloseWithReason(LeaveNormal)
+}
+
+// close tear down the client, called once
+func (c *Client) close() {
+	pub := c.getPub()
+	sub := c.getSub()
 	log.Debugf("id=%v", c.uid)
 	close(c.notify)
-	c.signal.Close()
-	if c.pub != nil {
-		c.pub.pc.Close()
+	c.negotiator.Stop()
+	if pub != nil {
+		pub.pc.Close()
 	}
-	if c.sub != nil {
-		c.sub.pc.Close()
+	if sub != nil {
+		sub.pc.Close()
 	}
 
 	if c.producer != nil {
 		c.producer.Stop()
 	}
-	c.signal.Close()
+	c.getSignal().Close()
 	c.engine.RemoveClient(c)
 }
 
 // CreateDataChannel create a custom datachannel
 func (c *Client) CreateDataChannel(label string) (*webrtc.DataChannel, error) {
+	pub := c.getPub()
 	log.Debugf("id=%v CreateDataChannel %v", c.uid, label)
-	return c.pub.pc.CreateDataChannel(label, &webrtc.DataChannelInit{})
+	return pub.pc.CreateDataChannel(label, &webrtc.DataChannelInit{})
 }
 
 // Trickle receive candidate from sfu and add to pc
 func (c *Client) Trickle(candidate webrtc.ICECandidateInit, target int) {
+	pub := c.getPub()
+	sub := c.getSub()
 	log.Debugf("id=%v candidate=%v target=%v", c.uid, candidate, target)
 	var t *Transport
 	if target == SUBSCRIBER {
-		t = c.sub
+		if sub == nil {
+			return
+		}
+		t = sub
 	} else {
-		t = c.pub
+		t = pub
 	}
 
-	if t.pc.CurrentRemoteDescription() == nil {
-		t.RecvCandidates = append(t.RecvCandidates, candidate)
-	} else {
+	if !t.queueRecvCandidate(candidate) {
 		err := t.pc.AddICECandidate(candidate)
 		if err != nil {
 			log.Errorf("id=%v err=%v", c.uid, err)
@@ -308,73 +716,117 @@ func (c *Client) Trickle(candidate webrtc.ICECandidateInit, target int) {
 
 // Negotiate sub negotiate
 func (c *Client) Negotiate(sdp webrtc.SessionDescription) error {
+	sub := c.getSub()
 	log.Debugf("id=%v Negotiate sdp=%v", c.uid, sdp)
+	if c.noSubscribe || sub == nil {
+		log.Errorf("id=%v got sub offer with NoSubscribe", c.uid)
+		return errNoSubscribe
+	}
+	if atomic.LoadInt32(&c.apiChecked) == 0 {
+		c.checkAPIChannel(sdp)
+	}
+	if c.noAutoSubscribe {
+		c.refuseOfferedTracks(sdp)
+	}
 	// 1.sub set remote sdp
-	err := c.sub.pc.SetRemoteDescription(sdp)
+	err := sub.pc.SetRemoteDescription(sdp)
 	if err != nil {
-		log.Errorf("id=%v Negotiate c.sub.pc.SetRemoteDescription err=%v", c.uid, err)
+		c.onNegotiationError(SUBSCRIBER, err)
 		return err
 	}
+	c.checkRemovedTracks(sdp)
 
 	// 2. safe to send candiate to sfu after join ok
-	if len(c.sub.SendCandidates) > 0 {
-		for _, cand := range c.sub.SendCandidates {
-			log.Debugf("id=%v send sub.SendCandidates c.uid, c.signal.Trickle cand=%v", c.uid, cand)
-			c.signal.Trickle(cand, SUBSCRIBER)
-		}
-		c.sub.SendCandidates = []*webrtc.ICECandidate{}
+	send, recv := sub.takeCandidates()
+	for _, cand := range send {
+		log.Debugf("id=%v send sub.SendCandidates c.uid, c.signal.Trickle cand=%v", c.uid, cand)
+		sub.trickle(cand)
 	}
 
 	// 3. safe to add candidate after SetRemoteDescription
-	if len(c.sub.RecvCandidates) > 0 {
-		for _, candidate := range c.sub.RecvCandidates {
-			log.Debugf("id=%v Negotiate c.sub.pc.AddICECandidate candidate=%v", c.uid, candidate)
-			_ = c.sub.pc.AddICECandidate(candidate)
-		}
-		c.sub.RecvCandidates = []webrtc.ICECandidateInit{}
+	for _, candidate := range recv {
+		log.Debugf("id=%v Negotiate c.sub.pc.AddICECandidate candidate=%v", c.uid, candidate)
+		_ = sub.pc.AddICECandidate(candidate)
 	}
 
 	// 4. create answer after add ice candidate
-	answer, err := c.sub.pc.CreateAnswer(nil)
+	answer, err := sub.pc.CreateAnswer(nil)
 	if err != nil {
-		log.Errorf("id=%v err=%v", c.uid, err)
+		c.onNegotiationError(SUBSCRIBER, err)
 		return err
 	}
 
 	// 5. set local sdp(answer)
-	err = c.sub.pc.SetLocalDescription(answer)
+	answer, err = sub.setLocalDescription(answer)
 	if err != nil {
-		log.Errorf("id=%v err=%v", c.uid, err)
+		c.onNegotiationError(SUBSCRIBER, err)
 		return err
 	}
 
 	// 6. send answer to sfu
-	c.signal.Answer(answer)
+	c.traceSent(SignalMessage{Type: SignalAnswer, SDP: &answer})
+	c.getSignal().Answer(answer)
 
 	return err
 }
 
 // OnNegotiationNeeded will be called when add/remove track, but never trigger, call by hand
+// calls in a short window are coalesced into one offer, sent after the previous answer
 func (c *Client) OnNegotiationNeeded() {
+	c.readSenderRTCP()
+	c.negotiator.Request()
+}
+
+// SetTrickle override WebRTCTransportConfig.NoTrickle for this client, call it before Join
+func (c *Client) SetTrickle(enabled bool) {
+	pub := c.getPub()
+	sub := c.getSub()
+	c.cfg.NoTrickle = !enabled
+	pub.noTrickle = !enabled
+	if sub != nil {
+		sub.noTrickle = !enabled
+	}
+}
+
+// ICERestart restart ice of pub with a new offer, new candidates are trickled to sfu
+// sub is answerer, its ice is restarted when sfu send a restart offer
+func (c *Client) ICERestart() {
+	log.Infof("id=%v ICERestart", c.uid)
+	atomic.StoreInt32(&c.iceRestart, 1)
+	c.OnNegotiationNeeded()
+}
+
+// sendOffer create and send a pub offer, return false if no offer sent
+func (c *Client) sendOffer() bool {
+	pub := c.getPub()
 	// 1. pub create offer
-	offer, err := c.pub.pc.CreateOffer(nil)
+	var options *webrtc.OfferOptions
+	if atomic.CompareAndSwapInt32(&c.iceRestart, 1, 0) {
+		options = &webrtc.OfferOptions{ICERestart: true}
+	}
+	offer, err := pub.pc.CreateOffer(options)
 	if err != nil {
-		log.Debugf("id=%v err=%v", c.uid, err)
+		c.onNegotiationError(PUBLISHER, err)
+		return false
 	}
 
 	// 2. pub set local sdp(offer)
-	err = c.pub.pc.SetLocalDescription(offer)
+	offer, err = pub.setLocalDescription(offer)
 	if err != nil {
-		log.Debugf("id=%v err=%v", c.uid, err)
+		c.onNegotiationError(PUBLISHER, err)
+		return false
 	}
 
 	log.Debugf("id=%v OnNegotiationNeeded!! c.pub.pc.CreateOffer and send offer=%v", c.uid, offer)
 	//3. send offer to sfu
-	c.signal.Offer(offer)
+	c.traceSent(SignalMessage{Type: SignalOffer, SDP: &offer})
+	c.getSignal().Offer(offer)
+	return true
 }
 
 // selectRemote select remote video/audio
 func (c *Client) selectRemote(streamId, video string, audio bool) error {
+	sub := c.getSub()
 	log.Debugf("id=%v streamId=%v video=%v audio=%v", c.uid, streamId, video, audio)
 	call := Call{
 		StreamID: streamId,
@@ -382,8 +834,15 @@ func (c *Client) selectRemote(streamId, video string, audio bool) error {
 		Audio:    audio,
 	}
 
+	if atomic.LoadInt32(&c.apiChecked) == 1 && !c.ServerInfo().APIChannel {
+		log.Errorf("id=%v sfu has no api datachannel, drop call=%v", c.uid, call)
+		return errNoAPIChannel
+	}
+
+	c.apiLock.Lock()
+	defer c.apiLock.Unlock()
 	// cache cmd when dc not ready
-	if c.sub.api == nil || c.sub.api.ReadyState() != webrtc.DataChannelStateOpen {
+	if sub == nil || sub.api == nil || sub.api.ReadyState() != webrtc.DataChannelStateOpen {
 		log.Debugf("id=%v append to c.apiQueue call=%v", c.uid, call)
 		c.apiQueue = append(c.apiQueue, call)
 		return nil
@@ -397,7 +856,7 @@ func (c *Client) selectRemote(streamId, video string, audio bool) error {
 			if err != nil {
 				continue
 			}
-			err = c.sub.api.Send(marshalled)
+			err = sub.api.Send(marshalled)
 			if err != nil {
 				log.Errorf("err=%v", err)
 			}
@@ -412,7 +871,7 @@ func (c *Client) selectRemote(streamId, video string, audio bool) error {
 	if err != nil {
 		return err
 	}
-	err = c.sub.api.Send(marshalled)
+	err = sub.api.Send(marshalled)
 	if err != nil {
 		log.Errorf("id=%v err=%v", c.uid, err)
 	}
@@ -443,42 +902,213 @@ func (c *Client) SubscribeAll(video string, audio bool) {
 
 // PublishWebm publish a webm producer
 func (c *Client) PublishWebm(file string, video, audio bool) error {
-	ext := filepath.Ext(file)
+	return c.PublishFile(file, video, audio)
+}
+
+// PublishFile publish a webm(vp8/vp9, opus), mp4(h264, opus), ogg(opus), ivf(vp8/vp9/av1), h265 elementary stream
+// or y4m(raw video encoded to vp8) file
+func (c *Client) PublishFile(file string, video, audio bool, opts ...FileOption) error {
+	var o fileOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if c.noPublish {
+		return errNoPublish
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == ".h265" || ext == ".hevc" {
+		return c.publishH265(file, o)
+	}
+	if ext == ".ivf" {
+		p, err := NewIVFProducer(c.uid, file)
+		if err != nil {
+			return err
+		}
+		return c.publishIVF(p, o)
+	}
+	if ext == ".y4m" {
+		return c.publishY4M(file, o)
+	}
+	var p Producer
 	switch ext {
 	case ".webm":
-		c.producer = NewWebMProducer(c.uid, file, 0)
+		w := NewWebMProducer(c.uid, file, 0)
+		if w == nil {
+			return errInvalidFile
+		}
+		p = w
+	case ".mp4", ".m4a", ".m4v":
+		m, err := NewMP4Producer(c.uid, file)
+		if err != nil {
+			return err
+		}
+		p = m
+	case ".ogg", ".opus":
+		ogg, err := NewOggProducer(c.uid, file)
+		if err != nil {
+			return err
+		}
+		p = ogg
+		// audio only
+		video = false
 	default:
 		return errInvalidFile
 	}
+	return c.publishProducer(p, o, video, audio)
+}
+
+// PublishReader publish a webm or ivf file read from r, e.g. a http body or an object storage download,
+// format is webm or ivf, an io.Closer r is closed when the producer ends
+func (c *Client) PublishReader(r io.Reader, format string, video, audio bool, opts ...FileOption) error {
+	var o fileOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if c.noPublish {
+		return errNoPublish
+	}
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "webm":
+		p, err := NewWebMProducerFromReader(c.uid, r)
+		if err != nil {
+			return err
+		}
+		return c.publishProducer(p, o, video, audio)
+	case "ivf":
+		p, err := NewIVFProducerFromReader(c.uid, r)
+		if err != nil {
+			return err
+		}
+		return c.publishIVF(p, o)
+	}
+	return errInvalidFile
+}
+
+// publishProducer add the tracks of a file producer and start it
+func (c *Client) publishProducer(p Producer, o fileOptions, video, audio bool) error {
+	pub := c.getPub()
+	c.setProducer(p)
+	if o.loop != nil {
+		setLoop(p, *o.loop)
+	}
 	if video {
-		_, err := c.producer.AddTrack(c.pub.pc, "video")
+		_, err := p.AddTrack(pub.pc, "video")
 		if err != nil {
 			log.Debugf("err=%v", err)
 			return err
 		}
 	}
 	if audio {
-		_, err := c.producer.AddTrack(c.pub.pc, "audio")
+		_, err := p.AddTrack(pub.pc, "audio")
 		if err != nil {
 			log.Debugf("err=%v", err)
 			return err
 		}
 	}
-	c.producer.Start()
+	p.Start()
 	//trigger by hand
 	c.OnNegotiationNeeded()
 	return nil
 }
 
-func (c *Client) getBandWidth(cycle int) (int, int) {
-	var recvBW, sendBW int
+// setProducer set the published producer and forward its progress to the client
+func (c *Client) setProducer(p sender) {
+	c.producer = p
+	r, ok := p.(progressive)
+	if !ok {
+		return
+	}
+	r.progressOf().OnProgress = func(s ProducerProgress) {
+		if c.OnProducerProgress != nil {
+			c.OnProducerProgress(s)
+		}
+	}
+	r.progressOf().OnEnd = func(err error) {
+		if c.OnProducerEnd != nil {
+			c.OnProducerEnd(err)
+		}
+	}
+}
+
+// ProducerProgress return the progress of the published producer, false if it has none
+func (c *Client) ProducerProgress() (ProducerProgress, bool) {
+	r, ok := c.producer.(interface{ Progress() ProducerProgress })
+	if !ok {
+		return ProducerProgress{}, false
+	}
+	return r.Progress(), true
+}
+
+// SeekTo seek the published file to the keyframe at or before d, see Producer.SeekTo
+func (c *Client) SeekTo(d time.Duration) error {
+	s, ok := c.producer.(seeker)
+	if !ok {
+		return errNotSeekable
+	}
+	return s.SeekTo(d)
+}
+
+// PauseProducer halt the samples of the published file or generator, see Producer.Pause
+func (c *Client) PauseProducer() error {
+	p, ok := c.producer.(pausable)
+	if !ok {
+		return errNotPausable
+	}
+	p.Pause()
+	return nil
+}
+
+// ResumeProducer resume the samples after PauseProducer
+func (c *Client) ResumeProducer() error {
+	p, ok := c.producer.(pausable)
+	if !ok {
+		return errNotPausable
+	}
+	p.Resume()
+	return nil
+}
+
+// getBytes return the total received and sent bytes
+func (c *Client) getBytes() (uint64, uint64) {
+	var sendByte uint64
 	if c.producer != nil {
-		sendBW = c.producer.GetSendBandwidth(cycle)
+		sendByte = c.producer.SendBytes()
 	}
+	return atomic.LoadUint64(&c.recvByte), sendByte
+}
 
-	recvBW = c.recvByte / cycle / 1000
-	c.recvByte = 0
-	return recvBW, sendBW
+// setICEServers update the ice servers of pub and sub, used by next ice gathering
+func (c *Client) setICEServers(servers []webrtc.ICEServer) {
+	for _, t := range []*Transport{c.getPub(), c.getSub()} {
+		if t == nil {
+			continue
+		}
+		conf := t.pc.GetConfiguration()
+		conf.ICEServers = servers
+		if err := t.pc.SetConfiguration(conf); err != nil {
+			log.Errorf("id=%v SetConfiguration err=%v", c.uid, err)
+		}
+	}
+}
+
+// getTrackCount return the number of published and subscribed tracks
+func (c *Client) getTrackCount() (int, int) {
+	var pubCount, subCount int
+	for _, s := range c.getPub().pc.GetSenders() {
+		if s.Track() != nil {
+			pubCount++
+		}
+	}
+	sub := c.getSub()
+	if sub == nil {
+		return pubCount, 0
+	}
+	for _, r := range sub.pc.GetReceivers() {
+		if r.Track() != nil {
+			subCount++
+		}
+	}
+	return pubCount, subCount
 }
 
 func (c *Client) Simulcast(layer string) {
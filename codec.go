@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// orderCodecs return codecs with the mime types of prefs first, in the order of prefs, the others keep their order
+func orderCodecs(codecs []webrtc.RTPCodecParameters, prefs []string) []webrtc.RTPCodecParameters {
+	ordered := make([]webrtc.RTPCodecParameters, 0, len(codecs))
+	used := make([]bool, len(codecs))
+	for _, mime := range prefs {
+		for i, codec := range codecs {
+			if !used[i] && strings.EqualFold(codec.MimeType, mime) {
+				ordered = append(ordered, codec)
+				used[i] = true
+			}
+		}
+	}
+	for i, codec := range codecs {
+		if !used[i] {
+			ordered = append(ordered, codec)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderCodecs(t *testing.T) {
+	mimes := func(codecs []webrtc.RTPCodecParameters) []string {
+		var m []string
+		for _, c := range codecs {
+			if len(m) == 0 || m[len(m)-1] != c.MimeType {
+				m = append(m, c.MimeType)
+			}
+		}
+		return m
+	}
+	for _, tc := range []struct {
+		prefs []string
+		want  []string
+	}{
+		{nil, []string{mimeTypeVP8, mimeTypeVP9, mimeTypeH264, mimeTypeH265, mimeTypeAV1}},
+		{[]string{"video/VP9"}, []string{mimeTypeVP9, mimeTypeVP8, mimeTypeH264, mimeTypeH265, mimeTypeAV1}},
+		{[]string{mimeTypeAV1, mimeTypeH264}, []string{mimeTypeAV1, mimeTypeH264, mimeTypeVP8, mimeTypeVP9, mimeTypeH265}},
+		{[]string{"video/unknown"}, []string{mimeTypeVP8, mimeTypeVP9, mimeTypeH264, mimeTypeH265, mimeTypeAV1}},
+	} {
+		ordered := orderCodecs(videoRTPCodecParameters, tc.prefs)
+		assert.Len(t, ordered, len(videoRTPCodecParameters))
+		assert.Equal(t, tc.want, mimes(ordered), "prefs %v", tc.prefs)
+	}
+}
+
+// negotiate offer the pub to a plain pion answerer, as sfu would
+func negotiate(t *testing.T, pub *Transport, remote *webrtc.PeerConnection) string {
+	offer, err := pub.pc.CreateOffer(nil)
+	require.NoError(t, err)
+	offer, err = pub.setLocalDescription(offer)
+	require.NoError(t, err)
+	require.NoError(t, remote.SetRemoteDescription(offer))
+	answer, err := remote.CreateAnswer(nil)
+	require.NoError(t, err)
+	require.NoError(t, remote.SetLocalDescription(answer))
+	require.NoError(t, pub.pc.SetRemoteDescription(answer))
+	return offer.SDP
+}
+
+func TestPreferredCodecRenegotiate(t *testing.T) {
+	pub := NewTransport(PUBLISHER, nil, WebRTCTransportConfig{PreferredCodecs: []string{mimeTypeVP9}, NoTrickle: true})
+	require.NotNil(t, pub)
+	defer pub.pc.Close()
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer remote.Close()
+
+	vp9, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeTypeVP9, ClockRate: 90000}, "vp9", "stream")
+	require.NoError(t, err)
+	_, err = pub.pc.AddTransceiverFromTrack(vp9, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	require.NoError(t, err)
+	offer := negotiate(t, pub, remote)
+
+	parsed := &sdp.SessionDescription{}
+	require.NoError(t, parsed.Unmarshal([]byte(offer)))
+	var video *sdp.MediaDescription
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Media == "video" {
+			video = m
+		}
+	}
+	require.NotNil(t, video)
+	// 98 is vp9 profile 0
+	assert.Equal(t, "98", video.MediaName.Formats[0])
+
+	// the later offers must still be accepted by pion
+	vp8, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeTypeVP8, ClockRate: 90000}, "vp8", "stream")
+	require.NoError(t, err)
+	_, err = pub.pc.AddTransceiverFromTrack(vp8, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	require.NoError(t, err)
+	offer = negotiate(t, pub, remote)
+	assert.Equal(t, 2, strings.Count(offer, "m=video"))
+	negotiate(t, pub, remote)
+}
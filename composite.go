@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultCompositeWidth     = 1280
+	defaultCompositeHeight    = 720
+	defaultCompositeFrameRate = 30
+)
+
+// CompositeConfig is the output of a SessionRecorder, the zero values are the defaults
+type CompositeConfig struct {
+	// Width and Height of the mosaic, default 1280x720, each video is fit in a cell of the grid
+	Width  int
+	Height int
+	// FrameRate of the mosaic, default 30
+	FrameRate int
+	// FFmpeg is the path of the ffmpeg binary composing the file, default ffmpeg in PATH
+	FFmpeg string
+	// Dir keep the track files, else they are recorded in a temporary dir removed after composing
+	Dir string
+}
+
+// SessionRecorder record every subscribed track of a client and compose them into a single file at Stop,
+// the audio are mixed and the videos laid out in a grid, each track start at the time it was received
+// e.g. for compliance recording of meetings, set Client.OnTrack to its OnTrack
+// the tracks are recorded by WebMRecorder, the file is composed by ffmpeg, mp4 is h264/aac, else vp8/opus
+type SessionRecorder struct {
+	client *Client
+	file   string
+	cfg    CompositeConfig
+	dir    string
+	start  time.Time
+
+	mu        sync.Mutex
+	recorders []*WebMRecorder
+	stopped   bool
+}
+
+// NewSessionRecorder create a recorder of the tracks of c to file, the recording start now
+func NewSessionRecorder(c *Client, file string, cfg CompositeConfig) (*SessionRecorder, error) {
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		cfg.Width, cfg.Height = defaultCompositeWidth, defaultCompositeHeight
+	}
+	if cfg.FrameRate <= 0 {
+		cfg.FrameRate = defaultCompositeFrameRate
+	}
+	if cfg.FFmpeg == "" {
+		cfg.FFmpeg = "ffmpeg"
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		var err error
+		if dir, err = ioutil.TempDir("", "ion_record_"); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &SessionRecorder{
+		client: c,
+		file:   file,
+		cfg:    cfg,
+		dir:    dir,
+		start:  time.Now(),
+	}, nil
+}
+
+// OnTrack record the track, h264 tracks are not recorded
+func (s *SessionRecorder) OnTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	file := filepath.Join(s.dir, fmt.Sprintf("%d_%s_%d.webm", len(s.recorders), track.Kind(), track.SSRC()))
+	r, err := s.client.RecordTrack(track, file)
+	if err != nil {
+		log.Warnf("record track=%v codec=%v err=%v", track.ID(), track.Codec().MimeType, err)
+		return
+	}
+	s.recorders = append(s.recorders, r)
+}
+
+// Stop close the tracks and compose the file, it block until ffmpeg is done
+func (s *SessionRecorder) Stop() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	recorders := s.recorders
+	s.mu.Unlock()
+
+	for _, r := range recorders {
+		r.Stop()
+		<-r.Done()
+	}
+	if s.cfg.Dir == "" {
+		defer os.RemoveAll(s.dir)
+	}
+	args, err := s.ffmpegArgs(recorders)
+	if err != nil {
+		return err
+	}
+	log.Infof("compose %v tracks to %v", len(recorders), s.file)
+	if out, err := exec.Command(s.cfg.FFmpeg, args...).CombinedOutput(); err != nil {
+		log.Errorf("compose %v err=%v %s", s.file, err, out)
+		return err
+	}
+	return nil
+}
+
+// ffmpegArgs return the ffmpeg command line composing the recorded tracks
+func (s *SessionRecorder) ffmpegArgs(recorders []*WebMRecorder) ([]string, error) {
+	args := []string{"-y", "-hide_banner", "-loglevel", "error"}
+	// the inputs of each kind with their delay from the start
+	type input struct {
+		index int
+		delay time.Duration
+	}
+	var videos, audios []input
+	for _, r := range recorders {
+		start := r.StartTime()
+		if start.IsZero() {
+			continue
+		}
+		in := input{index: len(videos) + len(audios), delay: start.Sub(s.start)}
+		if in.delay < 0 {
+			in.delay = 0
+		}
+		args = append(args, "-i", r.file)
+		if r.track.Kind() == webrtc.RTPCodecTypeVideo {
+			videos = append(videos, in)
+		} else {
+			audios = append(audios, in)
+		}
+	}
+	if len(videos)+len(audios) == 0 {
+		return nil, errNoRecordedTracks
+	}
+
+	var filters []string
+	width, height := s.cfg.Width&^1, s.cfg.Height&^1
+	if len(videos) > 0 {
+		// the grid of the videos, the cells are even for yuv420
+		cols := int(math.Ceil(math.Sqrt(float64(len(videos)))))
+		rows := (len(videos) + cols - 1) / cols
+		w, h := width/cols&^1, height/rows&^1
+		var labels, layout []string
+		for i, in := range videos {
+			filters = append(filters, fmt.Sprintf("[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%d,tpad=start_duration=%.3f:color=black[v%d]",
+				in.index, w, h, w, h, s.cfg.FrameRate, in.delay.Seconds(), i))
+			labels = append(labels, fmt.Sprintf("[v%d]", i))
+			layout = append(layout, fmt.Sprintf("%d_%d", i%cols*w, i/cols*h))
+		}
+		if len(videos) == 1 {
+			filters = append(filters, fmt.Sprintf("[v0]pad=%d:%d:(ow-iw)/2:(oh-ih)/2[v]", width, height))
+		} else {
+			filters = append(filters, fmt.Sprintf("%sxstack=inputs=%d:layout=%s:fill=black,pad=%d:%d[v]",
+				strings.Join(labels, ""), len(videos), strings.Join(layout, "|"), width, height))
+		}
+	}
+	if len(audios) > 0 {
+		var labels []string
+		for i, in := range audios {
+			ms := in.delay.Milliseconds()
+			filters = append(filters, fmt.Sprintf("[%d:a]aresample=48000,adelay=%d|%d[a%d]", in.index, ms, ms, i))
+			labels = append(labels, fmt.Sprintf("[a%d]", i))
+		}
+		filters = append(filters, fmt.Sprintf("%samix=inputs=%d:duration=longest[a]", strings.Join(labels, ""), len(audios)))
+	}
+	args = append(args, "-filter_complex", strings.Join(filters, ";"))
+
+	mp4 := strings.EqualFold(filepath.Ext(s.file), ".mp4")
+	if len(videos) > 0 {
+		args = append(args, "-map", "[v]", "-r", fmt.Sprint(s.cfg.FrameRate))
+		if mp4 {
+			args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+		} else {
+			args = append(args, "-c:v", "libvpx", "-deadline", "realtime", "-cpu-used", "8", "-b:v", "2M")
+		}
+	}
+	if len(audios) > 0 {
+		args = append(args, "-map", "[a]")
+		if mp4 {
+			args = append(args, "-c:a", "aac")
+		} else {
+			args = append(args, "-c:a", "libopus")
+		}
+	}
+	return append(args, s.file), nil
+}
+
+// RecordSession record the tracks of the client to a single file, see SessionRecorder
+// it set OnTrack, call Stop of the recorder to compose the file
+func (c *Client) RecordSession(file string, cfg CompositeConfig) (*SessionRecorder, error) {
+	r, err := NewSessionRecorder(c, file, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.OnTrack = r.OnTrack
+	return r, nil
+}
@@ -1,13 +1,96 @@
 package engine
 
 import (
+	"time"
+
 	"github.com/pion/webrtc/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Config ..
 type Config struct {
 	// WebRTC WebRTCConf `mapstructure:"webrtc"`
 	WebRTC WebRTCTransportConfig `mapstructure:"webrtc"`
+
+	// Logger replace the sdk logger if not nil, the logger is shared by all engines
+	Logger Logger `mapstructure:"-"`
+	// LogLevel is the sdk log level: trace|debug|info|warn|error, empty means unchanged
+	// like Logger it is process wide, UpdateConfig only apply it when it changed
+	LogLevel string `mapstructure:"loglevel"`
+
+	// admission limits, 0 means unlimited
+	MaxClients           int `mapstructure:"maxclients"`
+	MaxClientsPerSession int `mapstructure:"maxclientspersession"`
+	MaxSessions          int `mapstructure:"maxsessions"`
+
+	// MaxSendBitrate cap the rtp bitrate(bits/s) sent by each client, all its published tracks with fec and rtx, 0
+	// means unlimited
+	MaxSendBitrate int `mapstructure:"maxsendbitrate"`
+
+	// SFUAddrs is the sfu grpc addrs used when NewClient addr is empty
+	// an addr may be a unix socket like unix:///var/run/ion-sfu.sock, see UnixAddr
+	SFUAddrs []string `mapstructure:"sfuaddrs"`
+	// ISLBAddr is the grpc addr of the islb of an ion cluster, it is asked for the sfu node of the session by
+	// NewSessionClient, a hook of UseDiscovery replace it
+	ISLBAddr string `mapstructure:"islbaddr"`
+	// Signal is the signaling protocol, SignalGRPC by default or SignalJSONRPC with ws:// addrs
+	Signal string `mapstructure:"signal"`
+	// TLS dial sfu with tls/mtls, also used for wss:// with SignalJSONRPC
+	TLS TLSConfig `mapstructure:"tls"`
+	// DialOptions are appended to the sdk grpc dial options, e.g. interceptors, resolvers, retries
+	DialOptions []grpc.DialOption `mapstructure:"-"`
+	// Credentials replace the insecure or Config.TLS transport credentials, grpc refuse both in DialOptions
+	Credentials credentials.TransportCredentials `mapstructure:"-"`
+	// Proxy dial the signaling and turn over tcp/tls through a socks5 or http proxy
+	Proxy ProxyConfig `mapstructure:"proxy"`
+	// JoinTimeout make Join wait for the sfu answer and fail with ErrJoinTimeout, 0 means no wait
+	JoinTimeout time.Duration `mapstructure:"jointimeout"`
+	// OfferTimeout fail a renegotiation with ErrOfferTimeout if no answer, default 10s
+	OfferTimeout time.Duration `mapstructure:"offertimeout"`
+	// TrickleTimeout drop the signal stream if a message is blocked longer, 0 is disabled
+	TrickleTimeout time.Duration `mapstructure:"trickletimeout"`
+	// KeepaliveInterval ping the sfu every interval to detect dead connections, 0 is disabled
+	// the ping is a websocket ping for json-rpc and a grpc health check call for grpc
+	KeepaliveInterval time.Duration `mapstructure:"keepaliveinterval"`
+	// KeepaliveTimeout is the time to wait for the ping answer, then ErrSignalTimeout is reported, default 5s
+	KeepaliveTimeout time.Duration `mapstructure:"keepalivetimeout"`
+	// Placement is the strategy to pick a sfu: roundrobin|leastloaded|affinity, default roundrobin
+	Placement string `mapstructure:"placement"`
+
+	// ReconnectAttempts redial and rejoin when the signaling is lost, 0 means close the client at once
+	ReconnectAttempts int `mapstructure:"reconnectattempts"`
+	// ReconnectBackoff is the first delay, doubled every attempt with jitter, default 500ms
+	ReconnectBackoff time.Duration `mapstructure:"reconnectbackoff"`
+	// ReconnectMaxBackoff cap the delay, default 30s
+	ReconnectMaxBackoff time.Duration `mapstructure:"reconnectmaxbackoff"`
+
+	// ControlAutoComply leave, mute or unpublish when the server ask so, else only the callbacks are called
+	ControlAutoComply bool `mapstructure:"controlautocomply"`
+
+	// SessionIdleTimeout keep an empty session for a while before removing it, 0 means remove at once
+	SessionIdleTimeout time.Duration `mapstructure:"sessionidletimeout"`
+
+	// WatchdogInterval check clients for stuck every interval, 0 means disabled
+	WatchdogInterval time.Duration `mapstructure:"watchdoginterval"`
+	// WatchdogTimeout a subscribing client is stuck if no rtp received in timeout, 0 only check ice state
+	WatchdogTimeout time.Duration `mapstructure:"watchdogtimeout"`
+	// WatchdogAutoClose close the stuck clients
+	WatchdogAutoClose bool `mapstructure:"watchdogautoclose"`
+
+	// CallbackWorkers run the callbacks of the clients, OnTrack/OnDataChannel/OnError..., and of the engine,
+	// OnClientAdded/OnClientRemoved/OnSessionEmpty/OnSessionClosed, on this number of workers, 0 means
+	// run on sdk goroutines
+	// with workers, OnTrack should not block, start your own goroutine to read the track
+	CallbackWorkers int `mapstructure:"callbackworkers"`
+	// CallbackQueueSize is the number of pending callbacks before dispatching blocks
+	CallbackQueueSize int `mapstructure:"callbackqueuesize"`
+
+	// ConnPoolSize is the max grpc connections shared by clients per sfu addr, default 1
+	ConnPoolSize int `mapstructure:"connpoolsize"`
+	// ConnPoolMaxStreams multiplex this many clients on a connection before dialing the next
+	// e.g. 500 streams and 4 connections for a load test, 0 spread clients over all connections
+	ConnPoolMaxStreams int `mapstructure:"connpoolmaxstreams"`
 }
 
 // WebRTCTransportConfig represents configuration options
@@ -15,4 +98,27 @@ type WebRTCTransportConfig struct {
 	VideoMime     string
 	Configuration webrtc.Configuration
 	Setting       webrtc.SettingEngine
+	// PreferredCodecs register these video mime types first in the publisher, e.g. video/vp9, so they are first in
+	// the offer of every published track, pion v3.0.29 has no codec preferences per transceiver
+	PreferredCodecs []string
+	// NegotiationDebounce coalesce renegotiations in this window, default 20ms
+	NegotiationDebounce time.Duration
+	// NegotiationRetries re-offer the pub after a failed negotiation, 0 is disabled
+	NegotiationRetries int
+	// NegotiationRetryDelay is the delay of the first retry, growing linearly, default 1s
+	NegotiationRetryDelay time.Duration
+	// FEC protect published tracks by red/ulpfec, disabled by default
+	FEC FECConfig
+	// RTX resend published video on nack, disabled by default
+	RTX RTXConfig
+	// Impairment drop, duplicate, reorder or delay the published rtp for testing, disabled by default
+	Impairment ImpairmentConfig
+	// JitterBuffer order the rtp of the subscribed tracks, disabled by default
+	JitterBuffer JitterBufferConfig
+	// NACK ask sfu to resend the lost packets of the subscribed video, enabled by default
+	NACK NACKConfig
+	// PLI limit or disable the key frame requests of the subscribed tracks, unlimited by default
+	PLI PLIConfig
+	// NoTrickle gather all candidates before sending the sdp, for sfu or proxy without trickle
+	NoTrickle bool
 }
@@ -0,0 +1,98 @@
+package engine
+
+import "github.com/pion/webrtc/v3"
+
+const (
+	// ControlKick ask the client to leave the session
+	ControlKick = "kick"
+	// ControlMute ask the client to mute its published tracks of a kind, or a track
+	ControlMute = "mute"
+	// ControlStopPublish ask the client to unpublish its tracks of a kind, or a track
+	ControlStopPublish = "stoppublish"
+
+	// controlKey mark a biz message as a control message
+	controlKey = "control"
+)
+
+// ControlMessage is an instruction from the server, sent as a biz message like
+// {"control":"mute","kind":"audio","reason":"muted by host"}
+type ControlMessage struct {
+	Type   string
+	Reason string
+	// Kind is audio or video, empty means all
+	Kind string
+	// TrackID select one track, empty means all the tracks of Kind
+	TrackID string
+}
+
+// parseControl return the control message of biz message data, false if it is not one
+func parseControl(data map[string]interface{}) (ControlMessage, bool) {
+	t, ok := data[controlKey].(string)
+	if !ok || t == "" {
+		return ControlMessage{}, false
+	}
+	msg := ControlMessage{Type: t}
+	msg.Reason, _ = data["reason"].(string)
+	msg.Kind, _ = data["kind"].(string)
+	msg.TrackID, _ = data["track"].(string)
+	return msg, true
+}
+
+// HandleControl surface a control message by OnKicked or OnRemoteMuteRequest
+// and comply with it if Config.ControlAutoComply, e.g. for messages from your own signaling
+func (c *Client) HandleControl(msg ControlMessage) {
+	log.Infof("id=%v control=%+v", c.uid, msg)
+	comply := c.engine.getConfig().ControlAutoComply
+	switch msg.Type {
+	case ControlKick:
+		if c.OnKicked != nil {
+			c.engine.dispatcher.Dispatch(func() { c.OnKicked(msg.Reason) })
+		}
+		if comply {
+			go c.CloseWithReason(LeaveKicked)
+		}
+	case ControlMute, ControlStopPublish:
+		if c.OnRemoteMuteRequest != nil {
+			c.engine.dispatcher.Dispatch(func() { c.OnRemoteMuteRequest(msg) })
+		}
+		if comply {
+			c.comply(msg)
+		}
+	default:
+		log.Warnf("id=%v unknown control=%v", c.uid, msg.Type)
+	}
+}
+
+// comply pause or unpublish the tracks selected by msg
+func (c *Client) comply(msg ControlMessage) {
+	for _, s := range c.controlSenders(msg) {
+		var err error
+		if msg.Type == ControlMute {
+			err = c.PauseTrack(s.Track().ID())
+		} else {
+			err = c.UnPublishTrack(s)
+		}
+		if err != nil {
+			log.Errorf("id=%v control=%v track=%v err=%v", c.uid, msg.Type, s.Track().ID(), err)
+		}
+	}
+}
+
+// controlSenders return the published senders matching kind and track of msg
+func (c *Client) controlSenders(msg ControlMessage) []*webrtc.RTPSender {
+	var senders []*webrtc.RTPSender
+	for _, s := range c.getPub().pc.GetSenders() {
+		track := s.Track()
+		if track == nil {
+			continue
+		}
+		if msg.TrackID != "" && track.ID() != msg.TrackID {
+			continue
+		}
+		if msg.Kind != "" && track.Kind().String() != msg.Kind {
+			continue
+		}
+		senders = append(senders, s)
+	}
+	return senders
+}
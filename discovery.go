@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/lucsky/cuid"
+	"github.com/pion/ion-sdk-go/pkg/grpc/ion"
+	"github.com/pion/ion-sdk-go/pkg/grpc/islb"
+)
+
+const (
+	// sfuService is the service name of the sfu nodes, the nodes of another service are skipped
+	sfuService = "sfu"
+
+	islbTimeout = 5 * time.Second
+)
+
+// NodeDiscovery return the ion nodes able to host the session, best first, the node already hosting the
+// session should come first
+// by default the nodes are asked to the islb of Config.ISLBAddr, a hook may use the own registry of the app
+type NodeDiscovery func(sid string) ([]*ion.Node, error)
+
+// UseDiscovery find sfu nodes by the hook of the app instead of Config.ISLBAddr or Config.SFUAddrs
+func (e *Engine) UseDiscovery(d NodeDiscovery) {
+	e.Lock()
+	defer e.Unlock()
+	e.discovery = d
+}
+
+// FindNode return the sfu addr for the session, by the discovery hook, else by islb, else by PickNode
+func (e *Engine) FindNode(sid string) (string, error) {
+	e.RLock()
+	d := e.discovery
+	e.RUnlock()
+	if d == nil && e.getConfig().ISLBAddr != "" {
+		d = e.findISLBNodes
+	}
+	if d == nil {
+		return e.PickNode(sid)
+	}
+	nodes, err := d(sid)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		if n == nil || n.Rpc == nil || n.Rpc.Addr == "" {
+			continue
+		}
+		if n.Service != "" && n.Service != sfuService {
+			continue
+		}
+		log.Infof("discovered sfu node sid=%v nid=%v dc=%v addr=%v", sid, n.Nid, n.Dc, n.Rpc.Addr)
+		return n.Rpc.Addr, nil
+	}
+	return "", errNoSFUNode
+}
+
+// findISLBNodes ask the islb of Config.ISLBAddr for the sfu nodes of the session
+// islb return the node hosting the session, or the sfu nodes if it is not hosted yet
+func (e *Engine) findISLBNodes(sid string) ([]*ion.Node, error) {
+	addr := e.getConfig().ISLBAddr
+	conn, err := e.pool.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer e.pool.Put(addr, conn)
+	ctx, cancel := context.WithTimeout(context.Background(), islbTimeout)
+	defer cancel()
+	reply, err := islb.NewISLBClient(conn).FindNode(ctx, &islb.FindNodeRequest{Sid: sid, Service: sfuService})
+	if err != nil {
+		log.Errorf("islb %v find node sid=%v err=%v", addr, sid, err)
+		return nil, err
+	}
+	return reply.GetNodes(), nil
+}
+
+// NewSessionClient create a sdk client connected to the sfu node found for the session
+// the node is found again when reconnecting, so a client can follow the session to a new node
+func NewSessionClient(engine *Engine, sid string, cid string) (*Client, error) {
+	addr, err := engine.FindNode(sid)
+	if err != nil {
+		return nil, err
+	}
+	uid := cid
+	if uid == "" {
+		uid = cuid.New()
+	}
+	s, err := engine.newSignal(addr, uid)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClient(engine, addr, uid, s, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.discovered = true
+	return c, nil
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/pion/ion-sdk-go/pkg/grpc/ion"
+	"github.com/pion/ion-sdk-go/pkg/grpc/islb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFindNode(t *testing.T) {
+	errDiscovery := errors.New("discovery failed")
+	node := func(service, addr string) *ion.Node {
+		return &ion.Node{Service: service, Rpc: &ion.RPC{Addr: addr}}
+	}
+	for _, tc := range []struct {
+		name  string
+		nodes []*ion.Node
+		err   error
+		want  string
+		fails error
+	}{
+		{"first sfu", []*ion.Node{node("sfu", "a:5551"), node("sfu", "b:5551")}, nil, "a:5551", nil},
+		{"skip other services", []*ion.Node{node("islb", "a:5551"), node("sfu", "b:5551")}, nil, "b:5551", nil},
+		{"no service is sfu", []*ion.Node{node("", "a:5551")}, nil, "a:5551", nil},
+		{"skip without addr", []*ion.Node{nil, {Service: "sfu"}, node("sfu", ""), node("sfu", "c:5551")}, nil, "c:5551", nil},
+		{"no node", nil, nil, "", errNoSFUNode},
+		{"discovery error", nil, errDiscovery, "", errDiscovery},
+	} {
+		e := NewEngine(Config{})
+		e.UseDiscovery(func(sid string) ([]*ion.Node, error) {
+			assert.Equal(t, "room", sid)
+			return tc.nodes, tc.err
+		})
+		addr, err := e.FindNode("room")
+		assert.Equal(t, tc.want, addr, tc.name)
+		assert.Equal(t, tc.fails, err, tc.name)
+		e.Close()
+	}
+}
+
+// fakeISLB answer the nodes of the sessions, unknown sessions are an error
+type fakeISLB struct {
+	islb.UnimplementedISLBServer
+	nodes    map[string][]*ion.Node
+	requests []*islb.FindNodeRequest
+}
+
+func (f *fakeISLB) FindNode(ctx context.Context, req *islb.FindNodeRequest) (*islb.FindNodeReply, error) {
+	f.requests = append(f.requests, req)
+	nodes, ok := f.nodes[req.Sid]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no node")
+	}
+	return &islb.FindNodeReply{Nodes: nodes}, nil
+}
+
+func TestFindNodeByISLB(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeISLB{nodes: map[string][]*ion.Node{
+		"room":  {{Service: "sfu", Nid: "sfu-2", Rpc: &ion.RPC{Addr: "b:5551"}}},
+		"empty": nil,
+	}}
+	srv := grpc.NewServer()
+	islb.RegisterISLBServer(srv, f)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	e := NewEngine(Config{SFUAddrs: []string{"a:5551"}, ISLBAddr: lis.Addr().String()})
+	defer e.Close()
+	addr, err := e.FindNode("room")
+	assert.NoError(t, err)
+	assert.Equal(t, "b:5551", addr)
+	require.Len(t, f.requests, 1)
+	assert.Equal(t, "room", f.requests[0].Sid)
+	assert.Equal(t, "sfu", f.requests[0].Service)
+
+	_, err = e.FindNode("empty")
+	assert.Equal(t, errNoSFUNode, err)
+	_, err = e.FindNode("unknown")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the hook of the app replace islb
+	e.UseDiscovery(func(sid string) ([]*ion.Node, error) {
+		return []*ion.Node{{Service: "sfu", Rpc: &ion.RPC{Addr: "c:5551"}}}, nil
+	})
+	addr, err = e.FindNode("room")
+	assert.NoError(t, err)
+	assert.Equal(t, "c:5551", addr)
+	assert.Len(t, f.requests, 3)
+}
@@ -0,0 +1,79 @@
+package engine
+
+import "sync"
+
+// dispatcher run user callbacks on a bounded number of workers
+type dispatcher struct {
+	jobs chan func()
+	done <-chan struct{}
+	// held by Dispatch while queueing, the workers wait for it before draining the queue
+	mu sync.RWMutex
+}
+
+func newDispatcher(workers, queueSize int, done <-chan struct{}) *dispatcher {
+	d := &dispatcher{
+		jobs: make(chan func(), queueSize),
+		done: done,
+	}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *dispatcher) work() {
+	for {
+		select {
+		case <-d.done:
+			d.drain()
+			return
+		case f := <-d.jobs:
+			f()
+		}
+	}
+}
+
+// drain run the jobs queued before done, nothing is queued after it
+func (d *dispatcher) drain() {
+	d.mu.Lock()
+	d.mu.Unlock()
+	for {
+		select {
+		case f := <-d.jobs:
+			f()
+		default:
+			return
+		}
+	}
+}
+
+// Dispatch queue f to workers, block when queue is full
+// f is called in place if there is no dispatcher or once it is done
+func (d *dispatcher) Dispatch(f func()) {
+	if d == nil || !d.queue(f) {
+		f()
+	}
+}
+
+// queue queue f, return false once done
+func (d *dispatcher) queue(f func()) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	select {
+	case <-d.done:
+		return false
+	default:
+	}
+	select {
+	case d.jobs <- f:
+		return true
+	default:
+		log.Warnf("callback queue is full, waiting")
+		select {
+		case d.jobs <- f:
+			return true
+		case <-d.done:
+			return false
+		}
+	}
+}
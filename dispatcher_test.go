@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherDone(t *testing.T) {
+	done := make(chan struct{})
+	d := newDispatcher(1, 1, done)
+	release := make(chan struct{})
+	ran := make(chan int, 3)
+	// the worker is blocked by the first job, the second is queued and the third wait for the queue
+	d.Dispatch(func() {
+		<-release
+		ran <- 1
+	})
+	d.Dispatch(func() { ran <- 2 })
+	blocked := make(chan struct{})
+	go func() {
+		d.Dispatch(func() { ran <- 3 })
+		close(blocked)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the waiting job run in place and the queued one is drained by the worker
+	close(done)
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch still blocked after done")
+	}
+	close(release)
+	var got []int
+	for i := 0; i < 3; i++ {
+		select {
+		case n := <-ran:
+			got = append(got, n)
+		case <-time.After(time.Second):
+			t.Fatalf("only %v ran", got)
+		}
+	}
+	assert.ElementsMatch(t, []int{1, 2, 3}, got)
+
+	// after done the jobs run in place
+	d.Dispatch(func() { ran <- 4 })
+	assert.Equal(t, 4, <-ran)
+}
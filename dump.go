@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+// TrackState is a track of a transceiver
+type TrackState struct {
+	Mid       string   `json:"mid"`
+	Kind      string   `json:"kind"`
+	Direction string   `json:"direction"`
+	TrackID   string   `json:"trackId"`
+	StreamID  string   `json:"streamId"`
+	Codecs    []string `json:"codecs"`
+}
+
+// PCState is the state of a publisher or subscriber peer connection
+type PCState struct {
+	ConnectionState    string       `json:"connectionState"`
+	ICEConnectionState string       `json:"iceConnectionState"`
+	SignalingState     string       `json:"signalingState"`
+	Tracks             []TrackState `json:"tracks"`
+}
+
+// ClientState is the state of a client
+type ClientState struct {
+	ID         string            `json:"id"`
+	SessionID  string            `json:"sessionId"`
+	Addr       string            `json:"addr"`
+	Labels     map[string]string `json:"labels"`
+	Publisher  PCState           `json:"publisher"`
+	Subscriber PCState           `json:"subscriber"`
+}
+
+// EngineState is the topology of the engine
+type EngineState struct {
+	Sessions map[string][]ClientState `json:"sessions"`
+}
+
+// DumpState return all sessions and clients with their pc states, codecs and tracks, for debugging
+func (e *Engine) DumpState() EngineState {
+	state := EngineState{Sessions: make(map[string][]ClientState)}
+	e.RLock()
+	defer e.RUnlock()
+	for sid, m := range e.clients {
+		clients := make([]ClientState, 0, len(m))
+		for _, c := range m {
+			if c != nil {
+				clients = append(clients, c.dumpState())
+			}
+		}
+		state.Sessions[sid] = clients
+	}
+	return state
+}
+
+func (c *Client) dumpState() ClientState {
+	pub := c.getPub()
+	sub := c.getSub()
+	return ClientState{
+		ID:         c.uid,
+		SessionID:  c.sid,
+		Addr:       c.Addr(),
+		Labels:     c.Labels(),
+		Publisher:  dumpPCState(pub),
+		Subscriber: dumpPCState(sub),
+	}
+}
+
+func dumpPCState(t *Transport) PCState {
+	if t == nil {
+		return PCState{}
+	}
+	pc := t.pc
+	state := PCState{
+		ConnectionState:    pc.ConnectionState().String(),
+		ICEConnectionState: pc.ICEConnectionState().String(),
+		SignalingState:     pc.SignalingState().String(),
+	}
+	for _, tr := range pc.GetTransceivers() {
+		ts := TrackState{
+			Mid:       tr.Mid(),
+			Kind:      tr.Kind().String(),
+			Direction: tr.Direction().String(),
+		}
+		var codecs []webrtc.RTPCodecParameters
+		if s := tr.Sender(); s != nil {
+			if track := s.Track(); track != nil {
+				ts.TrackID = track.ID()
+				ts.StreamID = track.StreamID()
+				codecs = s.GetParameters().Codecs
+			}
+		}
+		if r := tr.Receiver(); r != nil && ts.TrackID == "" {
+			if track := r.Track(); track != nil {
+				ts.TrackID = track.ID()
+				ts.StreamID = track.StreamID()
+				codecs = r.GetParameters().Codecs
+			}
+		}
+		for _, codec := range codecs {
+			ts.Codecs = append(ts.Codecs, codec.MimeType)
+		}
+		state.Tracks = append(state.Tracks, ts)
+	}
+	return state
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync"
@@ -8,130 +9,507 @@ import (
 
 	_ "net/http/pprof"
 
-	ilog "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
 )
 
-var (
-	log = ilog.NewLoggerWithFields(ilog.WarnLevel, "engine", nil)
+const (
+	statCycle = time.Second
 )
 
-type stat struct {
-	clients     int
-	totalRecvBW int
-	totalSendBW int
+// ClientStat is the stats of one client, bandwidth in KB/s
+type ClientStat struct {
+	ID        string `json:"id"`
+	RecvBW    int    `json:"recvBW"`
+	SendBW    int    `json:"sendBW"`
+	PubTracks int    `json:"pubTracks"`
+	SubTracks int    `json:"subTracks"`
 }
 
+// SessionStat is the stats of one session, bandwidth in KB/s
+type SessionStat struct {
+	Clients     int          `json:"clients"`
+	RecvBW      int          `json:"recvBW"`
+	SendBW      int          `json:"sendBW"`
+	PubTracks   int          `json:"pubTracks"`
+	SubTracks   int          `json:"subTracks"`
+	ClientStats []ClientStat `json:"clientStats"`
+}
+
+// Stat is a snapshot of engine stats, bandwidth in KB/s
+type Stat struct {
+	Time        time.Time              `json:"time"`
+	Clients     int                    `json:"clients"`
+	TotalRecvBW int                    `json:"totalRecvBW"`
+	TotalSendBW int                    `json:"totalSendBW"`
+	Sessions    map[string]SessionStat `json:"sessions"`
+}
+
+// TrackInterceptor is called for every remote track received by any client of the engine
+type TrackInterceptor func(c *Client, track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+
 // Engine a sdk engine
 type Engine struct {
 	cfg Config
 
 	sync.RWMutex
 	clients map[string]map[string]*Client
-	stats   stat
+	stats   Stat
+	// the time a session became empty, only used with SessionIdleTimeout
+	emptySince map[string]time.Time
+
+	closeOnce  sync.Once
+	done       chan struct{}
+	pool       *connPool
+	nextNode   uint32
+	dispatcher *dispatcher
+
+	trackInterceptors []TrackInterceptor
+	discovery         NodeDiscovery
+
+	//export to user
+	OnClientAdded   func(c *Client)
+	OnClientRemoved func(c *Client)
+	OnSessionEmpty  func(sid string)
+	OnSessionClosed func(sid string)
+	OnClientStuck   func(c *Client, reason string)
 }
 
 // NewEngine create a engine
 func NewEngine(cfg Config) *Engine {
 	e := &Engine{
-		clients: make(map[string]map[string]*Client),
+		clients:    make(map[string]map[string]*Client),
+		emptySince: make(map[string]time.Time),
+		done:       make(chan struct{}),
 	}
+	e.pool = newConnPool(cfg.ConnPoolSize, e.dialOptions)
+	e.pool.SetMaxStreams(cfg.ConnPoolMaxStreams)
 	e.cfg = cfg
+	SetLogger(cfg.Logger)
+	setLogLevel(cfg.LogLevel)
+	if cfg.CallbackWorkers > 0 {
+		e.dispatcher = newDispatcher(cfg.CallbackWorkers, cfg.CallbackQueueSize, e.done)
+	}
+	go e.statLoop()
+	if cfg.WatchdogInterval > 0 {
+		go e.watchdog(cfg.WatchdogInterval)
+	}
 	return e
 }
 
+// UpdateConfig apply a new config to a running engine
+// new clients use the whole config, existing clients get the new log level, limits and ice servers
+func (e *Engine) UpdateConfig(cfg Config) {
+	e.Lock()
+	old := e.cfg
+	e.cfg = cfg
+	var clients []*Client
+	for _, m := range e.clients {
+		for _, c := range m {
+			if c != nil {
+				clients = append(clients, c)
+			}
+		}
+	}
+	e.Unlock()
+
+	e.pool.SetSize(cfg.ConnPoolSize)
+	e.pool.SetMaxStreams(cfg.ConnPoolMaxStreams)
+	// the logger is process wide, it is only changed if this engine change it
+	if !sameLogger(cfg.Logger, old.Logger) {
+		SetLogger(cfg.Logger)
+	}
+	if cfg.LogLevel != old.LogLevel {
+		setLogLevel(cfg.LogLevel)
+	}
+	for _, c := range clients {
+		c.setICEServers(cfg.WebRTC.Configuration.ICEServers)
+		c.pacer.SetBitrate(cfg.MaxSendBitrate)
+	}
+}
+
+// getConfig return the current config
+func (e *Engine) getConfig() Config {
+	e.RLock()
+	defer e.RUnlock()
+	return e.cfg
+}
+
+// UseTrackInterceptor register a interceptor, interceptors are called in order before Client.OnTrack
+// they should not block and should not read from track, the track is read by Client.OnTrack
+func (e *Engine) UseTrackInterceptor(i TrackInterceptor) {
+	e.Lock()
+	defer e.Unlock()
+	e.trackInterceptors = append(e.trackInterceptors, i)
+}
+
+// interceptTrack call all registered interceptors
+func (e *Engine) interceptTrack(c *Client, track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	e.RLock()
+	interceptors := e.trackInterceptors
+	e.RUnlock()
+	for _, i := range interceptors {
+		i(c, track, receiver)
+	}
+}
+
 // AddClient add a client
 // addr: grpc addr
 // sid: session/room id
 // cid: client id
 func (e *Engine) AddClient(c *Client) error {
+	if c == nil {
+		err := fmt.Errorf("client is nil")
+		log.Errorf("%v", err)
+		return err
+	}
+
 	e.Lock()
-	defer e.Unlock()
+	if err := e.checkLimit(c.sid, c.uid); err != nil {
+		e.Unlock()
+		log.Errorf("id=%v sid=%v err=%v", c.uid, c.sid, err)
+		return err
+	}
 	if e.clients[c.sid] == nil {
 		e.clients[c.sid] = make(map[string]*Client)
 	}
-
 	e.clients[c.sid][c.uid] = c
-	if c == nil {
-		err := fmt.Errorf("client is nil")
-		log.Errorf("%v", err)
-		return err
+	delete(e.emptySince, c.sid)
+	e.Unlock()
+
+	if e.OnClientAdded != nil {
+		e.dispatcher.Dispatch(func() { e.OnClientAdded(c) })
 	}
+	return nil
+}
 
+// FindClients return the clients matching all labels in selector, all clients if selector is empty
+func (e *Engine) FindClients(selector map[string]string) []*Client {
+	e.RLock()
+	defer e.RUnlock()
+	var clients []*Client
+	for _, m := range e.clients {
+		for _, c := range m {
+			if c != nil && c.matchLabels(selector) {
+				clients = append(clients, c)
+			}
+		}
+	}
+	return clients
+}
+
+// Admit check if a client uid could join session sid under the engine limits
+func (e *Engine) Admit(sid, uid string) error {
+	e.RLock()
+	defer e.RUnlock()
+	return e.checkLimit(sid, uid)
+}
+
+// checkLimit must be called with lock held
+func (e *Engine) checkLimit(sid, uid string) error {
+	if _, ok := e.clients[sid][uid]; ok {
+		// replacing an existing client
+		return nil
+	}
+	if e.cfg.MaxSessions > 0 && len(e.clients[sid]) == 0 {
+		sessions := 0
+		for _, m := range e.clients {
+			if len(m) > 0 {
+				sessions++
+			}
+		}
+		if sessions >= e.cfg.MaxSessions {
+			return ErrMaxSessionsReached
+		}
+	}
+	if e.cfg.MaxClientsPerSession > 0 && len(e.clients[sid]) >= e.cfg.MaxClientsPerSession {
+		return ErrMaxClientsPerSessionReached
+	}
+	if e.cfg.MaxClients > 0 {
+		n := 0
+		for _, m := range e.clients {
+			n += len(m)
+		}
+		if n >= e.cfg.MaxClients {
+			return ErrMaxClientsReached
+		}
+	}
 	return nil
 }
 
 // DelClient delete a client
 func (e *Engine) DelClient(c *Client) error {
-	e.Lock()
-	if e.clients[c.sid] == nil {
-		e.Unlock()
-		return errInvalidSessID
+	removed, err := e.removeClient(c)
+	if err != nil {
+		return err
 	}
-	if c, ok := e.clients[c.sid][c.uid]; ok && (c != nil) {
-		delete(e.clients[c.sid], c.uid)
-		e.Unlock()
+	if removed {
 		c.Close()
-	} else {
-		e.Unlock()
 	}
 	return nil
 }
 
+// RemoveClient remove a client from engine without closing it
 func (e *Engine) RemoveClient(c *Client) error {
+	_, err := e.removeClient(c)
+	return err
+}
+
+// removeClient delete c from its session and fire the lifecycle callbacks
+func (e *Engine) removeClient(c *Client) (bool, error) {
 	e.Lock()
-	defer e.Unlock()
 	if e.clients[c.sid] == nil {
-		return errInvalidSessID
+		e.Unlock()
+		return false, errInvalidSessID
 	}
-	if c, ok := e.clients[c.sid][c.uid]; ok && (c != nil) {
-		delete(e.clients[c.sid], c.uid)
+	cur, ok := e.clients[c.sid][c.uid]
+	if !ok || cur != c {
+		e.Unlock()
+		return false, nil
 	}
-	return nil
+	delete(e.clients[c.sid], c.uid)
+	empty := len(e.clients[c.sid]) == 0
+	closed := false
+	if empty {
+		if e.cfg.SessionIdleTimeout > 0 {
+			e.emptySince[c.sid] = time.Now()
+		} else {
+			delete(e.clients, c.sid)
+			closed = true
+		}
+	}
+	e.Unlock()
+
+	// one job keep the callbacks of the removal in order
+	sid := c.sid
+	e.dispatcher.Dispatch(func() {
+		if e.OnClientRemoved != nil {
+			e.OnClientRemoved(c)
+		}
+		if empty && e.OnSessionEmpty != nil {
+			e.OnSessionEmpty(sid)
+		}
+		if closed && e.OnSessionClosed != nil {
+			e.OnSessionClosed(sid)
+		}
+	})
+	return true, nil
+}
+
+// gcSessions remove the sessions which have been empty longer than SessionIdleTimeout
+func (e *Engine) gcSessions(now time.Time) {
+	var closed []string
+	e.Lock()
+	for sid, since := range e.emptySince {
+		if len(e.clients[sid]) > 0 {
+			delete(e.emptySince, sid)
+			continue
+		}
+		if now.Sub(since) >= e.cfg.SessionIdleTimeout {
+			delete(e.emptySince, sid)
+			delete(e.clients, sid)
+			closed = append(closed, sid)
+		}
+	}
+	e.Unlock()
+
+	if e.OnSessionClosed != nil && len(closed) > 0 {
+		e.dispatcher.Dispatch(func() {
+			for _, sid := range closed {
+				e.OnSessionClosed(sid)
+			}
+		})
+	}
+}
+
+// GetSessions return all session ids
+func (e *Engine) GetSessions() []string {
+	e.RLock()
+	defer e.RUnlock()
+	sessions := make([]string, 0, len(e.clients))
+	for sid := range e.clients {
+		sessions = append(sessions, sid)
+	}
+	return sessions
+}
+
+// GetClientsBySession return all clients in session sid
+func (e *Engine) GetClientsBySession(sid string) []*Client {
+	e.RLock()
+	defer e.RUnlock()
+	clients := make([]*Client, 0, len(e.clients[sid]))
+	for _, c := range e.clients[sid] {
+		if c != nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients
 }
 
-// Stats show a total stats to console: clients and bandwidth
-func (e *Engine) Stats(cycle int, close <-chan struct{}) string {
+// GetClient return the client by sid and uid, nil if not found
+func (e *Engine) GetClient(sid, uid string) *Client {
+	e.RLock()
+	defer e.RUnlock()
+	if e.clients[sid] == nil {
+		return nil
+	}
+	return e.clients[sid][uid]
+}
+
+// Stats show the total stats every cycle(s) until ctx is done or engine is closed
+// a snapshot is pushed to the returned channel, it is dropped if the reader is not ready
+// a cycle <= 0 is a second
+func (e *Engine) Stats(ctx context.Context, cycle int) <-chan Stat {
+	interval := time.Duration(cycle) * time.Second
+	if interval <= 0 {
+		interval = statCycle
+	}
+	ch := make(chan Stat, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.done:
+				return
+			case <-ticker.C:
+				st := e.getStat()
+
+				info := "\n-------stats-------\n"
+				info += fmt.Sprintf("Clients: %d\n", st.Clients)
+				info += fmt.Sprintf("RecvBandWidth: %d KB/s\n", st.TotalRecvBW)
+				info += fmt.Sprintf("SendBandWidth: %d KB/s\n", st.TotalSendBW)
+				for sid, ss := range st.Sessions {
+					info += fmt.Sprintf("Session %s: clients=%d recv=%d KB/s send=%d KB/s pubTracks=%d subTracks=%d\n", sid, ss.Clients, ss.RecvBW, ss.SendBW, ss.PubTracks, ss.SubTracks)
+				}
+				log.Infof("%s", info)
+
+				select {
+				case ch <- st:
+				default:
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+type byteCount struct {
+	recv uint64
+	send uint64
+}
+
+// statLoop aggregate the stats every statCycle, so GetStat always return live values
+func (e *Engine) statLoop() {
+	ticker := time.NewTicker(statCycle)
+	defer ticker.Stop()
+	prev := make(map[*Client]byteCount)
+	last := time.Now()
 	for {
 		select {
-		case <-close:
-			return ""
-		default:
-			info := "\n-------stats-------\n"
-
-			e.RLock()
-			if len(e.clients) == 0 {
-				e.RUnlock()
+		case <-e.done:
+			return
+		case now := <-ticker.C:
+			var st Stat
+			st, prev = e.calcStat(prev, now.Sub(last))
+			last = now
+			e.Lock()
+			e.stats = st
+			e.Unlock()
+			e.gcSessions(now)
+		}
+	}
+}
+
+// calcStat sum clients, bandwidth and tracks per session and in total
+// bandwidth is the bytes since prev in elapsed
+func (e *Engine) calcStat(prev map[*Client]byteCount, elapsed time.Duration) (Stat, map[*Client]byteCount) {
+	st := Stat{Time: time.Now(), Sessions: make(map[string]SessionStat)}
+	cur := make(map[*Client]byteCount)
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	e.RLock()
+	defer e.RUnlock()
+	for sid, m := range e.clients {
+		var ss SessionStat
+		for _, c := range m {
+			if c == nil {
 				continue
 			}
-			n := 0
-			for _, m := range e.clients {
-				n += len(m)
+			cs := ClientStat{ID: c.uid}
+			recv, send := c.getBytes()
+			cur[c] = byteCount{recv: recv, send: send}
+			if p, ok := prev[c]; ok {
+				cs.RecvBW = int(float64(recv-p.recv) / seconds / 1000)
+				cs.SendBW = int(float64(send-p.send) / seconds / 1000)
 			}
-			info += fmt.Sprintf("Clients: %d\n", n)
-
-			totalRecvBW, totalSendBW := 0, 0
-			for _, m := range e.clients {
-				for _, c := range m {
-					if c == nil {
-						continue
-					}
-					recvBW, sendBW := c.getBandWidth(cycle)
-					totalRecvBW += recvBW
-					totalSendBW += sendBW
+			cs.PubTracks, cs.SubTracks = c.getTrackCount()
+
+			ss.Clients++
+			ss.RecvBW += cs.RecvBW
+			ss.SendBW += cs.SendBW
+			ss.PubTracks += cs.PubTracks
+			ss.SubTracks += cs.SubTracks
+			ss.ClientStats = append(ss.ClientStats, cs)
+		}
+		st.Clients += ss.Clients
+		st.TotalRecvBW += ss.RecvBW
+		st.TotalSendBW += ss.SendBW
+		st.Sessions[sid] = ss
+	}
+	return st, cur
+}
+
+// getStat return a copy of the latest aggregated stats
+func (e *Engine) getStat() Stat {
+	e.RLock()
+	defer e.RUnlock()
+	st := e.stats
+	st.Sessions = make(map[string]SessionStat, len(e.stats.Sessions))
+	for sid, ss := range e.stats.Sessions {
+		ss.ClientStats = append([]ClientStat(nil), ss.ClientStats...)
+		st.Sessions[sid] = ss
+	}
+	return st
+}
+
+// StatsSnapshot return the latest stats with per session and per client fields, could be marshaled to json
+func (e *Engine) StatsSnapshot() Stat {
+	return e.getStat()
+}
+
+// Close close all clients in all sessions and stop the stats loop
+func (e *Engine) Close() {
+	e.closeOnce.Do(func() {
+		close(e.done)
+
+		e.RLock()
+		var clients []*Client
+		for _, m := range e.clients {
+			for _, c := range m {
+				if c != nil {
+					clients = append(clients, c)
 				}
 			}
+		}
+		e.RUnlock()
 
-			info += fmt.Sprintf("RecvBandWidth: %d KB/s\n", totalRecvBW)
-			info += fmt.Sprintf("SendBandWidth: %d KB/s\n", totalSendBW)
-			e.RUnlock()
-			log.Infof(info)
-			time.Sleep(time.Duration(cycle) * time.Second)
+		for _, c := range clients {
+			c.Close()
 		}
-	}
+		e.pool.Close()
+	})
 }
 
+// GetStat return the live clients and bandwidth(KB/s), updated every second
 func (e *Engine) GetStat() (clients int, totalRecvBW int, totalSendBW int) {
-	return e.stats.clients, e.stats.totalRecvBW, e.stats.totalSendBW
+	e.RLock()
+	defer e.RUnlock()
+	return e.stats.Clients, e.stats.TotalRecvBW, e.stats.TotalSendBW
 }
 
 // ServePProf listening pprof
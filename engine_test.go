@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCycle(t *testing.T) {
+	e := NewEngine(Config{})
+	defer e.Close()
+	for _, cycle := range []int{-1, 0, 1} {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		select {
+		case st := <-e.Stats(ctx, cycle):
+			assert.Equal(t, 0, st.Clients, "cycle %v", cycle)
+		case <-ctx.Done():
+			t.Errorf("no stats with cycle %v", cycle)
+		}
+		cancel()
+	}
+}
+
+func TestLifecycleCallbacks(t *testing.T) {
+	e := NewEngine(Config{CallbackWorkers: 1, CallbackQueueSize: 10})
+	events := make(chan string, 10)
+	release := make(chan struct{})
+	e.OnClientAdded = func(c *Client) {
+		<-release
+		events <- "added " + c.uid
+	}
+	e.OnClientRemoved = func(c *Client) { events <- "removed " + c.uid }
+	e.OnSessionEmpty = func(sid string) { events <- "empty " + sid }
+	e.OnSessionClosed = func(sid string) { events <- "closed " + sid }
+
+	// the callbacks run on the workers, AddClient does not wait for them
+	for _, uid := range []string{"a", "b"} {
+		assert.NoError(t, e.AddClient(&Client{sid: "room", uid: uid}))
+	}
+	for _, uid := range []string{"a", "b"} {
+		assert.NoError(t, e.RemoveClient(e.GetClient("room", uid)))
+	}
+	close(release)
+	for _, want := range []string{"added a", "added b", "removed a", "removed b", "empty room", "closed room"} {
+		select {
+		case got := <-events:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("no %v", want)
+		}
+	}
+
+	// after close the callbacks run in place
+	e.Close()
+	assert.NoError(t, e.AddClient(&Client{sid: "room", uid: "c"}))
+	assert.Equal(t, "added c", <-events)
+}
+
+func TestCloseDeliversRemoval(t *testing.T) {
+	e := NewEngine(Config{CallbackWorkers: 1, CallbackQueueSize: 10})
+	events := make(chan string, 10)
+	release := make(chan struct{})
+	e.OnClientAdded = func(c *Client) { <-release }
+	e.OnClientRemoved = func(c *Client) { events <- "removed " + c.uid }
+	e.OnSessionEmpty = func(sid string) { events <- "empty " + sid }
+
+	// the worker is blocked while a removal is queued and the engine is closed
+	var clients []*Client
+	for _, uid := range []string{"a", "b"} {
+		c, err := NewClientWithSignal(e, &joinSignal{}, uid)
+		require.NoError(t, err)
+		require.NoError(t, c.doJoin("room", nil))
+		clients = append(clients, c)
+	}
+	clients[0].Close()
+	e.Close()
+	close(release)
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-time.After(time.Second):
+			t.Fatalf("only %v after close", got)
+		}
+	}
+	assert.ElementsMatch(t, []string{"removed a", "removed b", "empty room"}, got)
+}
@@ -3,9 +3,64 @@ package engine
 import "errors"
 
 var (
-	errInvalidClientID = errors.New("invalid client id")
-	errInvalidSessID   = errors.New("invalid session id")
-	errInvalidFile     = errors.New("invalid file")
-	errInvalidPC       = errors.New("invalid pc")
-	errInvalidKind     = errors.New("invalid kind, shoud be audio or video")
+	errInvalidClientID  = errors.New("invalid client id")
+	errInvalidSessID    = errors.New("invalid session id")
+	errInvalidFile      = errors.New("invalid file")
+	errInvalidPC        = errors.New("invalid pc")
+	errInvalidKind      = errors.New("invalid kind, shoud be audio or video")
+	errNoSFUNode        = errors.New("no sfu node configured")
+	errInvalidTrack     = errors.New("invalid track")
+	errDuplicateTrack   = errors.New("track already published")
+	errNoPublish        = errors.New("joined with NoPublish")
+	errNoSubscribe      = errors.New("joined with NoSubscribe")
+	errClientClosed     = errors.New("client closed")
+	errSignalClosed     = errors.New("signal closed")
+	errInvalidCA        = errors.New("no certificate found in ca file")
+	errInvalidProxy     = errors.New("invalid proxy url, should be socks5:// or http://")
+	errProxyRefused     = errors.New("proxy refused the connection")
+	errNoAPIChannel     = errors.New("sfu has no api datachannel")
+	errUnsupportedCodec = errors.New("no track with a supported codec")
+	errInvalidRTSP      = errors.New("invalid rtsp url or response")
+	errRTSPStatus       = errors.New("rtsp request failed with status")
+	errInvalidPtime     = errors.New("invalid opus ptime, should be 2.5, 5, 10, 20, 40 or 60ms")
+	errNoOpusEncoder    = errors.New("no opus encoder, build with -tags opus for tones")
+	errNotSeekable      = errors.New("producer is not seekable")
+	errNotPausable      = errors.New("producer can not pause")
+	errUnsupportedY4M   = errors.New("unsupported y4m, should be 4:2:0 8 bit")
+	errInvalidSRT       = errors.New("invalid srt url, should be srt://host:port or srt://:port to listen")
+	errSRTEncrypted     = errors.New("srt encryption is not supported")
+	errSRTRejected      = errors.New("srt handshake rejected")
+	errSRTTimeout       = errors.New("srt peer timeout")
+	errNoScreenCapture  = errors.New("screen capture is not supported on this os")
+	errNoCamera         = errors.New("camera capture is not supported on this os")
+	errInvalidCamera    = errors.New("invalid camera device")
+	errNoRecordedTracks = errors.New("no track recorded")
+	errNoHWEncoder      = errors.New("hardware encoder is not available, build with -tags avcodec")
+	errHWEncoder        = errors.New("hardware encoder failed")
+	errAACEncoder       = errors.New("aac encoder failed")
+	errNoRTPPort        = errors.New("no free rtp port")
+	errSnapshotTimeout  = errors.New("snapshot key frame timeout")
+	errInvalidStorage   = errors.New("invalid storage config, a bucket and credentials are required")
+	errStorageStatus    = errors.New("storage request failed with status")
+
+	// ErrMaxClientsReached is returned when Config.MaxClients is exceeded
+	ErrMaxClientsReached = errors.New("max clients reached")
+	// ErrMaxClientsPerSessionReached is returned when Config.MaxClientsPerSession is exceeded
+	ErrMaxClientsPerSessionReached = errors.New("max clients per session reached")
+	// ErrMaxSessionsReached is returned when Config.MaxSessions is exceeded
+	ErrMaxSessionsReached = errors.New("max sessions reached")
+	// ErrJoinTimeout is returned when the sfu does not answer the join in time
+	ErrJoinTimeout = errors.New("join timeout")
+	// ErrConnectTimeout is returned when the pc is not connected in time
+	ErrConnectTimeout = errors.New("connect timeout")
+	// ErrMediaTimeout is returned when no remote track arrives in time
+	ErrMediaTimeout = errors.New("media timeout")
+	// ErrSignalTimeout is passed to OnError when the sfu stop answering keepalive
+	ErrSignalTimeout = errors.New("signal keepalive timeout")
+	// ErrOfferTimeout is passed to OnNegotiationError when the sfu does not answer an offer in time
+	ErrOfferTimeout = errors.New("offer timeout")
+	// ErrIncompatibleServer is returned when the sfu protocol is not supported by the sdk
+	ErrIncompatibleServer = errors.New("incompatible sfu protocol")
+	// ErrSignalSendTimeout is returned when a signal message is blocked longer than Config.TrickleTimeout
+	ErrSignalSendTimeout = errors.New("signal send timeout")
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"time"
@@ -20,7 +21,9 @@ func run(e *sdk.Engine, addr, session, file, role string, total, duration, cycle
 	log.Infof("run session=%v file=%v role=%v total=%v duration=%v cycle=%v video=%v audio=%v simulcast=%v\n", session, file, role, total, duration, cycle, audio, video, simulcast)
 	timer := time.NewTimer(time.Duration(duration) * time.Second)
 
-	go e.Stats(3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Stats(ctx, 3)
 	for i := 0; i < total; i++ {
 		switch role {
 		case "pubsub":
@@ -72,7 +75,7 @@ func run(e *sdk.Engine, addr, session, file, role string, total, duration, cycle
 func main() {
 	//get args
 	var session, gaddr, file, role, loglevel, simulcast, paddr string
-	var total, cycle, duration int
+	var total, cycle, duration, conns, streams int
 	var video, audio bool
 
 	flag.StringVar(&file, "file", "./file.webm", "Path to the file media")
@@ -87,6 +90,8 @@ func main() {
 	flag.BoolVar(&audio, "a", false, "Publish audio stream from webm file")
 	flag.StringVar(&simulcast, "simulcast", "", "simulcast layer q|h|f")
 	flag.StringVar(&paddr, "paddr", "", "pprof listening addr")
+	flag.IntVar(&conns, "conns", 1, "Max grpc connections shared by clients")
+	flag.IntVar(&streams, "streams", 0, "Clients multiplexed on a grpc connection before dialing the next")
 	flag.Parse()
 	switch loglevel {
 	case "error":
@@ -114,6 +119,8 @@ func main() {
 			Setting:       se,
 			Configuration: webrtcCfg,
 		},
+		ConnPoolSize:       conns,
+		ConnPoolMaxStreams: streams,
 	}
 	if gaddr == "" {
 		log.Errorf("gaddr is \"\"!")
@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	mimeTypeRED    = "red"
+	mimeTypeULPFEC = "ulpfec"
+
+	// the payload types offered, the ones of the answer are sent
+	audioREDPayloadType  = 63
+	videoREDPayloadType  = 116
+	ulpfecPayloadType    = 117
+	maxREDTimestampDelta = 1 << 14
+	maxREDBlockLength    = 1 << 10
+	// ulpfec with a 16 bits mask protect at most 16 packets
+	maxFECGroupSize = 16
+	rtpHeaderSize   = 12
+)
+
+// FECConfig add redundancy to published tracks, it is only applied if the sfu accept the payloads
+// audio is sent in red and video in red with ulpfec, there is no flexfec: it needs a separate ssrc which pion v3.0
+// does not signal
+type FECConfig struct {
+	// AudioRedundancy send this many previous opus frames in each packet by RED, 0 is disabled
+	AudioRedundancy int
+	// VideoGroupSize send one ulpfec packet per this many video packets, 0 is disabled, max 16
+	VideoGroupSize int
+}
+
+// registerFECCodecs register the red/ulpfec payloads for the enabled protections
+func registerFECCodecs(me *webrtc.MediaEngine, cfg FECConfig) error {
+	if cfg.AudioRedundancy > 0 {
+		// the redundant blocks are opus
+		fmtp := fmt.Sprintf("%d/%d", opusPayloadType, opusPayloadType)
+		if err := me.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/" + mimeTypeRED, ClockRate: 48000, Channels: 2, SDPFmtpLine: fmtp},
+			PayloadType:        audioREDPayloadType,
+		}, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	if cfg.VideoGroupSize > 0 {
+		for mime, pt := range map[string]webrtc.PayloadType{mimeTypeRED: videoREDPayloadType, mimeTypeULPFEC: ulpfecPayloadType} {
+			if err := me.RegisterCodec(webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/" + mime, ClockRate: 90000},
+				PayloadType:        pt,
+			}, webrtc.RTPCodecTypeVideo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fecEncoder wrap published rtp into red and append ulpfec packets
+type fecEncoder struct {
+	interceptor.NoOp
+
+	cfg FECConfig
+
+	// the payload types of the answer, 0 if not accepted
+	sync.RWMutex
+	audioRED webrtc.PayloadType
+	videoRED webrtc.PayloadType
+	ulpfec   webrtc.PayloadType
+}
+
+func newFECEncoder(cfg FECConfig) *fecEncoder {
+	if cfg.VideoGroupSize > maxFECGroupSize {
+		cfg.VideoGroupSize = maxFECGroupSize
+	}
+	return &fecEncoder{cfg: cfg}
+}
+
+// negotiate take the red/ulpfec payload types of the answer, streams bound later use them
+func (f *fecEncoder) negotiate(desc webrtc.SessionDescription) {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(desc.SDP)); err != nil {
+		return
+	}
+	var audioRED, videoRED, ulpfec webrtc.PayloadType
+	for _, md := range parsed.MediaDescriptions {
+		for _, a := range md.Attributes {
+			if a.Key != "rtpmap" {
+				continue
+			}
+			// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<channels>]
+			fields := strings.Fields(strings.ToLower(a.Value))
+			if len(fields) != 2 {
+				continue
+			}
+			pt, err := strconv.ParseUint(fields[0], 10, 7)
+			if err != nil {
+				continue
+			}
+			switch {
+			case md.MediaName.Media == "audio" && fields[1] == mimeTypeRED+"/48000/2":
+				audioRED = webrtc.PayloadType(pt)
+			case md.MediaName.Media == "video" && fields[1] == mimeTypeRED+"/90000":
+				videoRED = webrtc.PayloadType(pt)
+			case md.MediaName.Media == "video" && fields[1] == mimeTypeULPFEC+"/90000":
+				ulpfec = webrtc.PayloadType(pt)
+			}
+		}
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.audioRED, f.videoRED, f.ulpfec = 0, 0, 0
+	if f.cfg.AudioRedundancy > 0 {
+		f.audioRED = audioRED
+	}
+	if f.cfg.VideoGroupSize > 0 && videoRED != 0 && ulpfec != 0 {
+		f.videoRED, f.ulpfec = videoRED, ulpfec
+	}
+}
+
+// BindLocalStream wrap the writer if the codec of the stream is protected
+func (f *fecEncoder) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	f.RLock()
+	defer f.RUnlock()
+	mime := strings.ToLower(info.MimeType)
+	switch {
+	case f.audioRED != 0 && mime == mimeTypeOpus:
+		return newREDWriter(writer, uint8(f.audioRED), f.cfg.AudioRedundancy)
+	case f.ulpfec != 0 && strings.HasPrefix(mime, "video/"):
+		return newULPFECWriter(writer, uint8(f.videoRED), uint8(f.ulpfec), f.cfg.VideoGroupSize)
+	}
+	return writer
+}
+
+type redBlock struct {
+	timestamp uint32
+	payload   []byte
+}
+
+// redWriter send the previous payloads with each packet, RFC 2198
+type redWriter struct {
+	sync.Mutex
+	next        interceptor.RTPWriter
+	payloadType uint8
+	distance    int
+	history     []redBlock
+}
+
+func newREDWriter(next interceptor.RTPWriter, payloadType uint8, distance int) *redWriter {
+	return &redWriter{next: next, payloadType: payloadType, distance: distance}
+}
+
+func (w *redWriter) Write(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	var blocks []redBlock
+	for _, b := range w.history {
+		if header.Timestamp-b.timestamp < maxREDTimestampDelta && len(b.payload) < maxREDBlockLength {
+			blocks = append(blocks, b)
+		}
+	}
+
+	primaryPT := header.PayloadType
+	buf := make([]byte, 0, len(blocks)*4+1+len(payload))
+	for _, b := range blocks {
+		ts := header.Timestamp - b.timestamp
+		buf = append(buf, 0x80|primaryPT, byte(ts>>6), byte(ts<<2)|byte(len(b.payload)>>8), byte(len(b.payload)))
+	}
+	buf = append(buf, primaryPT&0x7f)
+	for _, b := range blocks {
+		buf = append(buf, b.payload...)
+	}
+	buf = append(buf, payload...)
+
+	w.history = append(w.history, redBlock{timestamp: header.Timestamp, payload: append([]byte{}, payload...)})
+	if len(w.history) > w.distance {
+		w.history = w.history[len(w.history)-w.distance:]
+	}
+
+	h := *header
+	h.PayloadType = w.payloadType
+	return w.next.Write(&h, buf, a)
+}
+
+// ulpfecWriter wrap video in red and send a xor parity packet per group, RFC 5109
+// sequence numbers are rewritten since fec packets share the ssrc of the media
+type ulpfecWriter struct {
+	sync.Mutex
+	next      interceptor.RTPWriter
+	redPT     uint8
+	fecPT     uint8
+	groupSize int
+	started   bool
+	seq       uint16
+	group     [][]byte
+	baseSeq   uint16
+}
+
+func newULPFECWriter(next interceptor.RTPWriter, redPT, fecPT uint8, groupSize int) *ulpfecWriter {
+	return &ulpfecWriter{next: next, redPT: redPT, fecPT: fecPT, groupSize: groupSize}
+}
+
+func (w *ulpfecWriter) Write(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if !w.started {
+		w.seq = header.SequenceNumber
+		w.started = true
+	}
+	h := *header
+	h.SequenceNumber = w.seq
+	w.seq++
+
+	// protect the media packet as it would be sent without red
+	raw, err := (&rtp.Packet{Header: h, Payload: payload}).Marshal()
+	if err == nil {
+		if len(w.group) == 0 {
+			w.baseSeq = h.SequenceNumber
+		}
+		w.group = append(w.group, raw)
+	}
+
+	primaryPT := h.PayloadType
+	h.PayloadType = w.redPT
+	n, err := w.next.Write(&h, append([]byte{primaryPT & 0x7f}, payload...), a)
+	if err != nil {
+		return n, err
+	}
+
+	if len(w.group) >= w.groupSize {
+		fec := w.encode()
+		w.group = w.group[:0]
+		fh := h
+		fh.SequenceNumber = w.seq
+		fh.Marker = false
+		fh.CSRC = nil
+		w.seq++
+		if _, err := w.next.Write(&fh, append([]byte{w.fecPT}, fec...), a); err != nil {
+			log.Debugf("ulpfec write err=%v", err)
+		}
+	}
+	return n, nil
+}
+
+// encode build a level 0 ulpfec payload with a 16 bits mask over the group
+func (w *ulpfecWriter) encode() []byte {
+	protLen := 0
+	for _, p := range w.group {
+		if len(p)-rtpHeaderSize > protLen {
+			protLen = len(p) - rtpHeaderSize
+		}
+	}
+	buf := make([]byte, 10+4+protLen)
+	var lenRecovery uint16
+	var mask uint16
+	for i, p := range w.group {
+		buf[0] ^= p[0] & 0x3f // P, X, CC
+		buf[1] ^= p[1]        // M, PT
+		for j := 0; j < 4; j++ {
+			buf[4+j] ^= p[4+j] // TS
+		}
+		lenRecovery ^= uint16(len(p) - rtpHeaderSize)
+		for j, b := range p[rtpHeaderSize:] {
+			buf[14+j] ^= b
+		}
+		mask |= 1 << uint(15-i)
+	}
+	binary.BigEndian.PutUint16(buf[2:], w.baseSeq)
+	binary.BigEndian.PutUint16(buf[8:], lenRecovery)
+	binary.BigEndian.PutUint16(buf[10:], uint16(protLen))
+	binary.BigEndian.PutUint16(buf[12:], mask)
+	return buf
+}
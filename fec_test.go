@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fecAnswer(audio, video string) webrtc.SessionDescription {
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=rtpmap:111 opus/48000/2\r\n" + audio +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\nc=IN IP4 0.0.0.0\r\na=rtpmap:96 VP8/90000\r\n" + video}
+}
+
+func TestFECNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		name                       string
+		cfg                        FECConfig
+		audio, video               string
+		audioRED, videoRED, ulpfec webrtc.PayloadType
+	}{
+		{"offered payloads", FECConfig{AudioRedundancy: 1, VideoGroupSize: 4},
+			"a=rtpmap:63 red/48000/2\r\n", "a=rtpmap:116 red/90000\r\na=rtpmap:117 ulpfec/90000\r\n", 63, 116, 117},
+		{"payloads of the answer", FECConfig{AudioRedundancy: 1, VideoGroupSize: 4},
+			"a=rtpmap:100 RED/48000/2\r\n", "a=rtpmap:120 red/90000\r\na=rtpmap:121 ulpfec/90000\r\n", 100, 120, 121},
+		{"red without ulpfec", FECConfig{AudioRedundancy: 1, VideoGroupSize: 4},
+			"", "a=rtpmap:116 red/90000\r\n", 0, 0, 0},
+		{"disabled", FECConfig{},
+			"a=rtpmap:63 red/48000/2\r\n", "a=rtpmap:116 red/90000\r\na=rtpmap:117 ulpfec/90000\r\n", 0, 0, 0},
+		{"not answered", FECConfig{AudioRedundancy: 1, VideoGroupSize: 4}, "", "", 0, 0, 0},
+	} {
+		f := newFECEncoder(tc.cfg)
+		f.negotiate(fecAnswer(tc.audio, tc.video))
+		assert.Equal(t, tc.audioRED, f.audioRED, tc.name)
+		assert.Equal(t, tc.videoRED, f.videoRED, tc.name)
+		assert.Equal(t, tc.ulpfec, f.ulpfec, tc.name)
+	}
+}
+
+// rtpRecorder record the packets written
+type rtpRecorder struct {
+	packets []rtp.Packet
+}
+
+func (r *rtpRecorder) Write(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+	r.packets = append(r.packets, rtp.Packet{Header: *header, Payload: append([]byte{}, payload...)})
+	return header.MarshalSize() + len(payload), nil
+}
+
+func TestREDWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		distance int
+		// the timestamp step of the frames
+		step uint32
+		// the redundant blocks of the last packet
+		blocks int
+	}{
+		{"one", 1, 960, 1},
+		{"two", 2, 960, 2},
+		{"history shorter than distance", 4, 960, 2},
+		{"too old", 2, maxREDTimestampDelta, 0},
+	} {
+		rec := &rtpRecorder{}
+		w := newREDWriter(rec, 100, tc.distance)
+		payloads := [][]byte{{1, 1}, {2, 2, 2}, {3}}
+		for i, p := range payloads {
+			_, err := w.Write(&rtp.Header{PayloadType: opusPayloadType, SequenceNumber: uint16(i), Timestamp: uint32(i) * tc.step}, p, nil)
+			require.NoError(t, err)
+		}
+		require.Len(t, rec.packets, len(payloads), tc.name)
+		last := rec.packets[len(payloads)-1]
+		assert.Equal(t, uint8(100), last.PayloadType, tc.name)
+
+		// rfc 2198 headers then the blocks, oldest first
+		buf := last.Payload
+		var lengths []int
+		for i := 0; i < tc.blocks; i++ {
+			require.True(t, buf[0]&0x80 != 0, tc.name)
+			assert.Equal(t, uint8(opusPayloadType), buf[0]&0x7f, tc.name)
+			offset := uint32(buf[1])<<6 | uint32(buf[2])>>2
+			assert.Equal(t, uint32(tc.blocks-i)*tc.step, offset, tc.name)
+			lengths = append(lengths, int(buf[2]&0x03)<<8|int(buf[3]))
+			buf = buf[4:]
+		}
+		assert.Equal(t, uint8(opusPayloadType), buf[0], "%v: primary header", tc.name)
+		buf = buf[1:]
+		for i, l := range lengths {
+			assert.Equal(t, payloads[len(payloads)-1-tc.blocks+i], buf[:l], tc.name)
+			buf = buf[l:]
+		}
+		assert.Equal(t, payloads[len(payloads)-1], buf, "%v: primary", tc.name)
+	}
+}
+
+// recoverULPFEC rebuild the missing packet of a group from the others and the level 0 fec payload, rfc 5109
+func recoverULPFEC(fec []byte, received [][]byte) []byte {
+	var header [10]byte
+	copy(header[:], fec[:10])
+	protLen := int(binary.BigEndian.Uint16(fec[10:]))
+	payload := append([]byte{}, fec[14:14+protLen]...)
+	length := binary.BigEndian.Uint16(fec[8:])
+	for _, p := range received {
+		header[0] ^= p[0]
+		header[1] ^= p[1]
+		for j := 0; j < 4; j++ {
+			header[4+j] ^= p[4+j]
+		}
+		length ^= uint16(len(p) - rtpHeaderSize)
+		for j, b := range p[rtpHeaderSize:] {
+			payload[j] ^= b
+		}
+	}
+	raw := make([]byte, rtpHeaderSize, rtpHeaderSize+int(length))
+	raw[0] = 0x80 | header[0]&0x3f
+	raw[1] = header[1]
+	copy(raw[4:8], header[4:8])
+	return append(raw, payload[:length]...)
+}
+
+func TestULPFECWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		groupSize int
+		packets   int
+		lost      int
+	}{
+		{"group of 2 lose the first", 2, 2, 0},
+		{"group of 4 lose the last", 4, 4, 3},
+		{"group of 5 lose the middle", 5, 5, 2},
+		{"group of 16", 16, 16, 9},
+	} {
+		rec := &rtpRecorder{}
+		w := newULPFECWriter(rec, 120, 121, tc.groupSize)
+		var sent [][]byte
+		for i := 0; i < tc.packets; i++ {
+			h := &rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(1000 + i), Timestamp: uint32(3000 * (i / 2)), SSRC: 5, Marker: i%2 == 1}
+			payload := make([]byte, 10+i*7)
+			for j := range payload {
+				payload[j] = byte(i*31 + j)
+			}
+			_, err := w.Write(h, payload, nil)
+			require.NoError(t, err)
+			raw, err := (&rtp.Packet{Header: *h, Payload: payload}).Marshal()
+			require.NoError(t, err)
+			sent = append(sent, raw)
+		}
+		// the media in red, then the fec in red with the next sequence number
+		require.Len(t, rec.packets, tc.packets+1, tc.name)
+		for i, p := range rec.packets[:tc.packets] {
+			assert.Equal(t, uint8(120), p.PayloadType, tc.name)
+			assert.Equal(t, uint8(96), p.Payload[0], tc.name)
+			assert.Equal(t, uint16(1000+i), p.SequenceNumber, tc.name)
+		}
+		fec := rec.packets[tc.packets]
+		assert.Equal(t, uint8(120), fec.PayloadType, tc.name)
+		assert.Equal(t, uint16(1000+tc.packets), fec.SequenceNumber, tc.name)
+		require.Equal(t, uint8(121), fec.Payload[0], tc.name)
+		body := fec.Payload[1:]
+		assert.Equal(t, uint16(1000), binary.BigEndian.Uint16(body[2:]), "%v: sn base", tc.name)
+		assert.Equal(t, uint16(0xffff<<uint(16-tc.groupSize)), binary.BigEndian.Uint16(body[12:]), "%v: mask", tc.name)
+
+		var received [][]byte
+		for i, raw := range sent {
+			if i != tc.lost {
+				received = append(received, raw)
+			}
+		}
+		recovered := recoverULPFEC(body, received)
+		want := append([]byte{}, sent[tc.lost]...)
+		// the sequence number and ssrc are not protected, they come from the mask and the stream
+		binary.BigEndian.PutUint16(want[2:], 0)
+		binary.BigEndian.PutUint32(want[8:], 0)
+		assert.Equal(t, want, recovered, tc.name)
+	}
+}
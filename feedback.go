@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtcp"
+)
+
+// NACKConfig is when the lost packets of the subscribed video are asked again to sfu, enabled by default
+// a recorder may nack more for completeness, a live viewer less for latency
+type NACKConfig struct {
+	// Disabled send no nack
+	Disabled bool
+	// Interval is how often the missing packets are nacked, lower is more aggressive, default 100ms
+	Interval time.Duration
+	// SkipLastN is the last received packets whose gaps are not nacked yet, to wait for the reordered ones
+	SkipLastN uint16
+	// Size is the packets of the receive log, a power of 2 from 64 to 32768, default 8192
+	Size uint16
+}
+
+// newNACKGenerator return the nack interceptor of cfg, nil if disabled
+func newNACKGenerator(cfg NACKConfig) (*nack.GeneratorInterceptor, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+	var opts []nack.GeneratorOption
+	if cfg.Interval > 0 {
+		opts = append(opts, nack.GeneratorInterval(cfg.Interval))
+	}
+	if cfg.SkipLastN > 0 {
+		opts = append(opts, nack.GeneratorSkipLastN(cfg.SkipLastN))
+	}
+	if cfg.Size > 0 {
+		opts = append(opts, nack.GeneratorSize(cfg.Size))
+	}
+	return nack.NewGeneratorInterceptor(opts...)
+}
+
+// PLIConfig limit the key frame requests of the subscribed tracks, from the recorders, snapshots or the app
+type PLIConfig struct {
+	// Disabled send no pli or fir
+	Disabled bool
+	// Interval is the min interval between two requests of a track, the others are dropped, 0 is no limit
+	Interval time.Duration
+}
+
+// pliLimiter is an interceptor dropping the key frame requests over the limit of PLIConfig
+type pliLimiter struct {
+	interceptor.NoOp
+	cfg PLIConfig
+
+	mu   sync.Mutex
+	last map[uint32]time.Time
+}
+
+func newPLILimiter(cfg PLIConfig) *pliLimiter {
+	return &pliLimiter{cfg: cfg, last: make(map[uint32]time.Time)}
+}
+
+// allow tell if a request of the ssrcs can be sent now
+func (l *pliLimiter) allow(ssrcs []uint32) bool {
+	if l.cfg.Disabled {
+		return false
+	}
+	if l.cfg.Interval <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	allowed := false
+	for _, ssrc := range ssrcs {
+		if now.Sub(l.last[ssrc]) >= l.cfg.Interval {
+			l.last[ssrc] = now
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// BindRTCPWriter wrap the writer to filter the pli and fir
+func (l *pliLimiter) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, a interceptor.Attributes) (int, error) {
+		kept := pkts[:0:0]
+		for _, pkt := range pkts {
+			switch pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				if !l.allow(pkt.DestinationSSRC()) {
+					log.Debugf("drop key frame request ssrc=%v", pkt.DestinationSSRC())
+					continue
+				}
+			}
+			kept = append(kept, pkt)
+		}
+		if len(kept) == 0 {
+			return 0, nil
+		}
+		return writer.Write(kept, a)
+	})
+}
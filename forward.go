@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+// ForwardOption config ForwardTrack
+type ForwardOption func(*forwardOptions)
+
+type forwardOptions struct {
+	ssrc        *uint32
+	payloadType *uint8
+}
+
+// WithForwardSSRC rewrite the ssrc of the forwarded packets
+func WithForwardSSRC(ssrc uint32) ForwardOption {
+	return func(o *forwardOptions) {
+		o.ssrc = &ssrc
+	}
+}
+
+// WithForwardPayloadType rewrite the payload type of the forwarded packets, e.g. to match an ffmpeg sdp
+func WithForwardPayloadType(pt uint8) ForwardOption {
+	return func(o *forwardOptions) {
+		o.payloadType = &pt
+	}
+}
+
+// UDPForwarder relay the rtp packets of a remote track to an udp address as they are received
+type UDPForwarder struct {
+	tap  *rtpTap
+	ssrc uint32
+	conn net.Conn
+	opts forwardOptions
+	buf  []byte
+
+	packets uint64
+	bytes   uint64
+	once    sync.Once
+}
+
+// writeRTP send a packet, it is called by the reader of the track
+func (f *UDPForwarder) writeRTP(b []byte) {
+	if len(b) < 12 {
+		return
+	}
+	if f.opts.ssrc != nil || f.opts.payloadType != nil {
+		f.buf = append(f.buf[:0], b...)
+		b = f.buf
+		if f.opts.payloadType != nil {
+			b[1] = b[1]&0x80 | *f.opts.payloadType&0x7f
+		}
+		if ssrc := f.opts.ssrc; ssrc != nil {
+			b[8], b[9], b[10], b[11] = byte(*ssrc>>24), byte(*ssrc>>16), byte(*ssrc>>8), byte(*ssrc)
+		}
+	}
+	// udp is lossy anyway, the errors of an absent listener are not fatal
+	if _, err := f.conn.Write(b); err != nil {
+		log.Debugf("forward ssrc=%v to %v err=%v", f.ssrc, f.conn.RemoteAddr(), err)
+		return
+	}
+	atomic.AddUint64(&f.packets, 1)
+	atomic.AddUint64(&f.bytes, uint64(len(b)))
+}
+
+// Packets return the number of packets forwarded
+func (f *UDPForwarder) Packets() uint64 {
+	return atomic.LoadUint64(&f.packets)
+}
+
+// Bytes return the rtp bytes forwarded
+func (f *UDPForwarder) Bytes() uint64 {
+	return atomic.LoadUint64(&f.bytes)
+}
+
+// Close stop forwarding and close the socket
+func (f *UDPForwarder) Close() error {
+	var err error
+	f.once.Do(func() {
+		f.tap.remove(f.ssrc, f)
+		err = f.conn.Close()
+	})
+	return err
+}
+
+// rtpSink get the rtp packets of a ssrc from the tap, b is reused after writeRTP
+type rtpSink interface {
+	writeRTP(b []byte)
+}
+
+// rtpTap hand the received rtp packets of a ssrc to its sinks, the track is still read as usual
+type rtpTap struct {
+	interceptor.NoOp
+
+	mu    sync.RWMutex
+	sinks map[uint32][]rtpSink
+	// the negotiated header extensions of the ssrcs
+	extensions map[uint32][]interceptor.RTPHeaderExtension
+}
+
+func newRTPTap() *rtpTap {
+	return &rtpTap{
+		sinks:      make(map[uint32][]rtpSink),
+		extensions: make(map[uint32][]interceptor.RTPHeaderExtension),
+	}
+}
+
+// extensionID return the id of the header extension uri of ssrc, 0 if not negotiated
+func (t *rtpTap) extensionID(ssrc uint32, uri string) uint8 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, e := range t.extensions[ssrc] {
+		if e.URI == uri {
+			return uint8(e.ID)
+		}
+	}
+	return 0
+}
+
+func (t *rtpTap) add(ssrc uint32, s rtpSink) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sinks[ssrc] = append(t.sinks[ssrc], s)
+}
+
+func (t *rtpTap) remove(ssrc uint32, s rtpSink) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sinks := t.sinks[ssrc]
+	for i, v := range sinks {
+		if v == s {
+			sinks = append(sinks[:i:i], sinks[i+1:]...)
+			break
+		}
+	}
+	if len(sinks) == 0 {
+		delete(t.sinks, ssrc)
+	} else {
+		t.sinks[ssrc] = sinks
+	}
+}
+
+// BindRemoteStream wrap the reader to copy the packets to the sinks of the ssrc
+func (t *rtpTap) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	ssrc := info.SSRC
+	t.mu.Lock()
+	t.extensions[ssrc] = info.RTPHeaderExtensions
+	t.mu.Unlock()
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err == nil {
+			// the sinks are not called with the lock, they may remove themselves
+			t.mu.RLock()
+			sinks := t.sinks[ssrc]
+			t.mu.RUnlock()
+			for _, s := range sinks {
+				s.writeRTP(b[:n])
+			}
+		}
+		return n, attr, err
+	})
+}
+
+// UnbindRemoteStream forget the header extensions of the ssrc
+func (t *rtpTap) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.extensions, info.SSRC)
+}
+
+// Close close the forwarders with the peer connection
+func (t *rtpTap) Close() error {
+	t.mu.Lock()
+	var closers []io.Closer
+	for _, sinks := range t.sinks {
+		for _, s := range sinks {
+			if c, ok := s.(io.Closer); ok {
+				closers = append(closers, c)
+			}
+		}
+	}
+	t.mu.Unlock()
+	for _, c := range closers {
+		c.Close()
+	}
+	return nil
+}
+
+// subscribedTrack return the received track of trackID, nil if unknown
+func (c *Client) subscribedTrack(trackID string) *webrtc.TrackRemote {
+	sub := c.getSub()
+	if sub == nil {
+		return nil
+	}
+	for _, r := range sub.pc.GetReceivers() {
+		if track := r.Track(); track != nil && track.ID() == trackID {
+			return track
+		}
+	}
+	return nil
+}
+
+// ForwardTrack relay the raw rtp of a subscribed track to dstAddr(host:port) over udp, e.g. to ffmpeg,
+// janus or an analytics service, the packets are copied as the track is read so it must still be read,
+// by OnTrack or by default, the forwarder is closed with the subscriber connection
+func (c *Client) ForwardTrack(trackID, dstAddr string, opts ...ForwardOption) (*UDPForwarder, error) {
+	track, sub := c.subscribedTrack(trackID), c.getSub()
+	if track == nil || sub == nil {
+		log.Errorf("id=%v forward unknown remote track %v", c.uid, trackID)
+		return nil, errInvalidTrack
+	}
+	conn, err := net.Dial("udp", dstAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &UDPForwarder{tap: sub.tap, ssrc: uint32(track.SSRC()), conn: conn}
+	for _, o := range opts {
+		o(&f.opts)
+	}
+	sub.tap.add(f.ssrc, f)
+	log.Infof("id=%v forward track=%v ssrc=%v to %v", c.uid, trackID, f.ssrc, dstAddr)
+	return f, nil
+}
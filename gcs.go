@@ -0,0 +1,352 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+)
+
+const (
+	defaultGCSEndpoint = "https://storage.googleapis.com"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMetadataToken   = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// gcsChunkSize is the multiple of the chunks of a resumable upload but the last
+	gcsChunkSize = 256 << 10
+	// gcsResumeIncomplete is the status of a chunk received before the end of the upload
+	gcsResumeIncomplete = 308
+)
+
+// GCSConfig is the bucket of a GCSStorage
+type GCSConfig struct {
+	Bucket string
+	// Prefix is prepended to the file names of the object names
+	Prefix string
+	// CredentialsFile is a service account json key, default GOOGLE_APPLICATION_CREDENTIALS, else the
+	// metadata server of the instance is used
+	CredentialsFile string
+	// Token return an oauth2 access token instead of the credentials, e.g. from golang.org/x/oauth2
+	Token func() (string, error)
+	// ChunkSize is the size of the uploaded chunks, a multiple of 256KB, default 8MB
+	ChunkSize int
+	// Endpoint default to https://storage.googleapis.com, e.g. for an emulator
+	Endpoint string
+	// HTTPClient default to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// GCSStorage upload the files to a gcs bucket while they are written with resumable uploads, the object is
+// not visible before Close, the upload is cancelled on error
+type GCSStorage struct {
+	cfg GCSConfig
+	// the service account, nil for the metadata server
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewGCSStorage create a storage of the bucket of cfg
+func NewGCSStorage(cfg GCSConfig) (*GCSStorage, error) {
+	if cfg.Bucket == "" {
+		return nil, errInvalidStorage
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultUploadPartSize
+	}
+	cfg.ChunkSize -= cfg.ChunkSize % gcsChunkSize
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = gcsChunkSize
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultGCSEndpoint
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	s := &GCSStorage{cfg: cfg}
+	if cfg.Token != nil {
+		return s, nil
+	}
+	file := cfg.CredentialsFile
+	if file == "" {
+		file = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if file != "" {
+		if err := s.loadCredentials(file); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// loadCredentials read a service account json key
+func (s *GCSStorage) loadCredentials(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var creds struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return err
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil || creds.ClientEmail == "" || creds.TokenURI == "" {
+		return errInvalidStorage
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return errInvalidStorage
+	}
+	s.email, s.key, s.tokenURI = creds.ClientEmail, rsaKey, creds.TokenURI
+	return nil
+}
+
+// accessToken return the cached token, refreshed a minute before it expires
+func (s *GCSStorage) accessToken() (string, error) {
+	if s.cfg.Token != nil {
+		return s.cfg.Token()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > time.Minute {
+		return s.token, nil
+	}
+	var newReq func() (*http.Request, error)
+	if s.key != nil {
+		assertion, err := s.assertion()
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}.Encode()
+		newReq = func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, s.tokenURI, strings.NewReader(form))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			return req, err
+		}
+	} else {
+		newReq = func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, gcsMetadataToken, nil)
+			if err == nil {
+				req.Header.Set("Metadata-Flavor", "Google")
+			}
+			return req, err
+		}
+	}
+	_, body, err := storageRequest(s.cfg.HTTPClient, newReq, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", err
+	}
+	s.token, s.expires = res.AccessToken, time.Now().Add(time.Duration(res.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+// assertion return the jwt of the service account exchanged for a token
+func (s *GCSStorage) assertion() (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: s.key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := struct {
+		jwt.Claims
+		Scope string `json:"scope"`
+	}{
+		Claims: jwt.Claims{
+			Issuer:   s.email,
+			Audience: jwt.Audience{s.tokenURI},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Scope: gcsScope,
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// request return a request authorized by the token
+func (s *GCSStorage) request(method, uri string, body []byte, header http.Header) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		token, err := s.accessToken()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	}
+}
+
+// Create start the upload of the object of name
+func (s *GCSStorage) Create(name string) (io.WriteCloser, error) {
+	u := &gcsUpload{storage: s, name: objectKey(s.cfg.Prefix, name)}
+	return newUploadWriter(s.cfg.ChunkSize, u.upload, u.finish), nil
+}
+
+// gcsUpload is a resumable upload, started at the first chunk
+type gcsUpload struct {
+	storage *GCSStorage
+	name    string
+	session string
+}
+
+func (u *gcsUpload) start() error {
+	s := u.storage
+	uri := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s",
+		strings.TrimSuffix(s.cfg.Endpoint, "/"), url.PathEscape(s.cfg.Bucket), url.QueryEscape(u.name))
+	header := http.Header{}
+	header.Set("X-Upload-Content-Type", contentType(u.name))
+	resp, _, err := storageRequest(s.cfg.HTTPClient, s.request(http.MethodPost, uri, nil, header), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	if u.session = resp.Header.Get("Location"); u.session == "" {
+		return fmt.Errorf("start upload %v: %w %v without location", u.name, errStorageStatus, resp.Status)
+	}
+	return nil
+}
+
+// upload send the chunk from the bytes already received, they are asked again after a failure
+func (u *gcsUpload) upload(p uploadPart) error {
+	if u.session == "" {
+		if err := u.start(); err != nil {
+			return err
+		}
+	}
+	s := u.storage
+	end, total := p.offset+int64(len(p.data)), "*"
+	if p.last {
+		total = strconv.FormatInt(end, 10)
+	}
+	sent, failures := p.offset, 0
+	for {
+		header := http.Header{}
+		if sent < end {
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", sent, end-1, total))
+		} else {
+			header.Set("Content-Range", "bytes */"+total)
+		}
+		req, err := s.request(http.MethodPut, u.session, p.data[sent-p.offset:], header)()
+		if err != nil {
+			return err
+		}
+		resp, err := s.cfg.HTTPClient.Do(req)
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			switch {
+			case p.last && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated):
+				return nil
+			case !p.last && resp.StatusCode == gcsResumeIncomplete:
+				r := gcsRange(resp)
+				if r >= end {
+					return nil
+				}
+				// a part of the chunk was not kept, it is sent again
+				if r > sent {
+					sent = r
+					continue
+				}
+				err = fmt.Errorf("upload %v: %w %v at %v", u.name, errStorageStatus, resp.Status, r)
+			default:
+				err = fmt.Errorf("upload %v: %w %v %s", u.name, errStorageStatus, resp.Status, body)
+				if resp.StatusCode < http.StatusInternalServerError {
+					return err
+				}
+			}
+		}
+		if failures++; failures >= uploadRetries {
+			return err
+		}
+		time.Sleep(time.Duration(failures) * 500 * time.Millisecond)
+		if r, rerr := u.received(); rerr == nil && r >= p.offset {
+			if r >= end && !p.last {
+				return nil
+			}
+			if r > end {
+				r = end
+			}
+			sent = r
+		}
+	}
+}
+
+// received ask the bytes received by the session
+func (u *gcsUpload) received() (int64, error) {
+	s := u.storage
+	header := http.Header{}
+	header.Set("Content-Range", "bytes */*")
+	resp, _, err := storageRequest(s.cfg.HTTPClient, s.request(http.MethodPut, u.session, nil, header), gcsResumeIncomplete)
+	if err != nil {
+		return 0, err
+	}
+	return gcsRange(resp), nil
+}
+
+// gcsRange return the end of the Range header of a 308, 0 without
+func gcsRange(resp *http.Response) int64 {
+	r := resp.Header.Get("Range")
+	i := strings.LastIndex(r, "-")
+	if i < 0 {
+		return 0
+	}
+	n, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n + 1
+}
+
+// finish cancel the upload on error, the last chunk completed it
+func (u *gcsUpload) finish(err error) error {
+	if err != nil && u.session != "" {
+		s := u.storage
+		// a cancelled upload answer 499
+		if _, _, cerr := storageRequest(s.cfg.HTTPClient, s.request(http.MethodDelete, u.session, nil, nil), 499); cerr != nil {
+			log.Warnf("cancel upload %v err=%v", u.name, cerr)
+		}
+	}
+	return err
+}
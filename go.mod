@@ -3,13 +3,15 @@ module github.com/pion/ion-sdk-go
 go 1.15
 
 require (
-	github.com/at-wat/ebml-go v0.16.0 // indirect
+	github.com/at-wat/ebml-go v0.16.0
 	github.com/ebml-go/ebml v0.0.0-20160925193348-ca8851a10894 // indirect
 	github.com/ebml-go/webm v0.0.0-20160924163542-629e38feef2a
 	github.com/golang/protobuf v1.4.3
+	github.com/gorilla/websocket v1.4.2
 	github.com/lucsky/cuid v1.0.2
 	github.com/petar/GoLLRB v0.0.0-20190514000832-33fb24c13b99 // indirect
 	github.com/pion/ice/v2 v2.1.7
+	github.com/pion/interceptor v0.0.12
 	github.com/pion/ion-avp v1.8.4
 	github.com/pion/ion-log v1.2.0
 	github.com/pion/ion-sfu v1.10.4-0.20210517163413-6e6e24505e51
@@ -19,8 +21,11 @@ require (
 	github.com/pion/sdp/v3 v3.0.4
 	github.com/pion/webrtc/v3 v3.0.29
 	github.com/sirupsen/logrus v1.8.1
+	github.com/sourcegraph/jsonrpc2 v0.0.0-20210201082850-366fbb520750
 	github.com/square/go-jose/v3 v3.0.0-20200630053402-0a67ce9b0693
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb
+	golang.org/x/net v0.0.0-20210420210106-798c2154c571
 	google.golang.org/grpc v1.35.0
 	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,164 @@
+//go:build gst
+// +build gst
+
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	gst "github.com/pion/ion-sdk-go/pkg/gstreamer-src"
+	"github.com/pion/webrtc/v3"
+)
+
+// GstProducer publish from gstreamer pipelines, e.g. webcams, test sources or rtsp
+// build with -tags gst, it needs cgo and gstreamer-1.0 and gstreamer-app-1.0
+// the sources must output raw media, the producer add the encoder and the appsink
+// e.g. "videotestsrc", "v4l2src ! videoconvert", "rtspsrc location=rtsp://cam ! decodebin"
+type GstProducer struct {
+	id         string
+	videoCodec string
+	videoSrc   string
+	audioSrc   string
+	pipelines  []*gst.Pipeline
+	videoTrack *webrtc.TrackLocalStaticSample
+	audioTrack *webrtc.TrackLocalStaticSample
+	// streamID is gst_<producer> if empty
+	streamID string
+}
+
+// NewGstProducer create a producer of the src pipelines, videoCodec is vp8|vp9|h264, audio is encoded by opus
+// h264-vaapi|h264-nvenc|h264-videotoolbox encode h264 by the gstreamer plugin of the hardware encoder
+// an empty src disable the kind
+func NewGstProducer(id, videoCodec, videoSrc, audioSrc string) *GstProducer {
+	return &GstProducer{
+		id:         id,
+		videoCodec: strings.ToLower(videoCodec),
+		videoSrc:   videoSrc,
+		audioSrc:   audioSrc,
+	}
+}
+
+func (t *GstProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return t.audioTrack
+}
+
+func (t *GstProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return t.videoTrack
+}
+
+// AddTrack add the track and create the pipeline of kind
+func (t *GstProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	var codec webrtc.RTPCodecCapability
+	var gstCodec, src string
+	switch kind {
+	case "video":
+		switch t.videoCodec {
+		case "vp8":
+			codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+		case "vp9":
+			codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}
+		case "h264", "h264-" + HWEncoderVAAPI, "h264-" + HWEncoderNVENC, "h264-" + HWEncoderVideoToolbox:
+			codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+				SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}
+		default:
+			return nil, errUnsupportedCodec
+		}
+		gstCodec, src = t.videoCodec, t.videoSrc
+	case "audio":
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+		gstCodec, src = "opus", t.audioSrc
+	default:
+		return nil, errInvalidKind
+	}
+	if src == "" {
+		return nil, errInvalidKind
+	}
+
+	streamID := t.streamID
+	if streamID == "" {
+		streamID = fmt.Sprintf("gst_%p", t)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, kind, streamID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.pipelines = append(t.pipelines, gst.CreatePipeline(gstCodec, []*webrtc.TrackLocalStaticSample{track}, src))
+	if kind == "video" {
+		t.videoTrack = track
+	} else {
+		t.audioTrack = track
+	}
+	return track, nil
+}
+
+// Start start the pipelines
+func (t *GstProducer) Start() {
+	for _, p := range t.pipelines {
+		p.Start()
+	}
+}
+
+// Stop stop the pipelines
+func (t *GstProducer) Stop() {
+	for _, p := range t.pipelines {
+		p.Stop()
+	}
+}
+
+// SendBytes is not counted, the samples are written by the pipelines
+func (t *GstProducer) SendBytes() uint64 {
+	return 0
+}
+
+// Pause pause the pipelines until Resume
+func (t *GstProducer) Pause() {
+	for _, p := range t.pipelines {
+		p.SetPaused(true)
+	}
+}
+
+// Resume the pipelines, the encoders continue from the frames sent before the pause
+func (t *GstProducer) Resume() {
+	for _, p := range t.pipelines {
+		p.SetPaused(false)
+	}
+}
+
+// SeekTo is not supported by live pipelines
+func (t *GstProducer) SeekTo(d time.Duration) error {
+	return errNotSeekable
+}
+
+// PublishGst publish gstreamer pipelines, see GstProducer
+func (c *Client) PublishGst(videoCodec, videoSrc, audioSrc string) error {
+	pub := c.getPub()
+	if c.noPublish {
+		return errNoPublish
+	}
+	p := NewGstProducer(c.uid, videoCodec, videoSrc, audioSrc)
+	if videoSrc != "" {
+		if _, err := p.AddTrack(pub.pc, "video"); err != nil {
+			return err
+		}
+	}
+	if audioSrc != "" {
+		if _, err := p.AddTrack(pub.pc, "audio"); err != nil {
+			return err
+		}
+	}
+	c.setProducer(p)
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
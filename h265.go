@@ -0,0 +1,96 @@
+package engine
+
+const (
+	h265NALBLA = 16
+	h265NALCRA = 21
+	h265NALAP  = 48
+	h265NALFU  = 49
+	h265NALVPS = 32
+	h265NALAUD = 35
+)
+
+// h265NALType return the type of a nal unit with its 2 bytes header
+func h265NALType(nal []byte) byte {
+	return (nal[0] >> 1) & 0x3f
+}
+
+// h265Payloader packetize annex b access units by rfc 7798, with single nal and fragmentation units
+type h265Payloader struct{}
+
+func (p *h265Payloader) Payload(mtu int, payload []byte) [][]byte {
+	var out [][]byte
+	for _, nal := range splitAnnexB(payload) {
+		if len(nal) < 3 {
+			continue
+		}
+		if len(nal) <= mtu {
+			out = append(out, append([]byte{}, nal...))
+			continue
+		}
+		// fu payload header keep the f bit, the layer id and the tid of the nal
+		header := []byte{nal[0]&0x81 | h265NALFU<<1, nal[1]}
+		typ := h265NALType(nal)
+		data := nal[2:]
+		max := mtu - 3
+		for start := true; len(data) > 0; start = false {
+			n := len(data)
+			if n > max {
+				n = max
+			}
+			fu := typ
+			if start {
+				fu |= 0x80
+			}
+			if n == len(data) {
+				fu |= 0x40
+			}
+			pkt := make([]byte, 0, 3+n)
+			pkt = append(pkt, header...)
+			pkt = append(pkt, fu)
+			pkt = append(pkt, data[:n]...)
+			out = append(out, pkt)
+			data = data[n:]
+		}
+	}
+	return out
+}
+
+// splitAnnexB return the nal units of an annex b stream, without the start codes
+func splitAnnexB(b []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			end := i
+			// 4 bytes start code
+			if end > start && b[end-1] == 0 {
+				end--
+			}
+			nals = append(nals, b[start:end])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(b) {
+		nals = append(nals, b[start:])
+	}
+	return nals
+}
+
+// H265Track is a sample track of h265, pion has no h265 payloader for TrackLocalStaticSample
+// write annex b access units, publish it like other tracks
+type H265Track struct {
+	*payloadTrack
+}
+
+// NewH265Track create a h265 track, the codec must be negotiated by the sfu
+func NewH265Track(id, streamID string) (*H265Track, error) {
+	track, err := newPayloadTrack(h265Codec, id, streamID, &h265Payloader{})
+	if err != nil {
+		return nil, err
+	}
+	return &H265Track{payloadTrack: track}, nil
+}
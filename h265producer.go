@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// defaultFrameRate is the frame rate of elementary streams without WithFrameRate
+const defaultFrameRate = 30
+
+// annexBReader read the access units of a h265 annex b elementary stream
+type annexBReader struct {
+	r       *bufio.Reader
+	started bool
+	// the first nal of the next access unit
+	pending []byte
+}
+
+func newAnnexBReader(r io.Reader) *annexBReader {
+	return &annexBReader{r: bufio.NewReader(r)}
+}
+
+// readNAL return the next nal unit without its start code
+func (a *annexBReader) readNAL() ([]byte, error) {
+	var nal []byte
+	zeros := 0
+	for {
+		b, err := a.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(nal) > 0 {
+				return nal, nil
+			}
+			return nil, err
+		}
+		if b == 1 && zeros >= 2 {
+			nal = nal[:len(nal)-zeros]
+			zeros = 0
+			if !a.started {
+				a.started = true
+				nal = nil
+				continue
+			}
+			if len(nal) > 0 {
+				return nal, nil
+			}
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		nal = append(nal, b)
+	}
+}
+
+// nextAU return the next access unit in annex b, a new one starts at a vps/sps/pps/aud/prefix sei
+// or at a slice with first_slice_segment_in_pic_flag after the slices of the current one
+func (a *annexBReader) nextAU() ([]byte, error) {
+	var au []byte
+	vcl := false
+	add := func(nal []byte) {
+		au = append(au, annexBStartCode...)
+		au = append(au, nal...)
+		if h265NALType(nal) < h265NALVPS {
+			vcl = true
+		}
+	}
+	if a.pending != nil {
+		add(a.pending)
+		a.pending = nil
+	}
+	for {
+		nal, err := a.readNAL()
+		if err != nil {
+			if err == io.EOF && len(au) > 0 {
+				return au, nil
+			}
+			return nil, err
+		}
+		if len(nal) < 3 {
+			continue
+		}
+		typ := h265NALType(nal)
+		first := typ < h265NALVPS && nal[2]&0x80 != 0
+		prefix := (typ >= h265NALVPS && typ <= h265NALAUD) || typ == 39 || (typ >= 41 && typ <= 44)
+		if vcl && (first || prefix) {
+			a.pending = nal
+			return au, nil
+		}
+		add(nal)
+	}
+}
+
+// H265Producer publish a h265 annex b elementary stream, e.g. ffmpeg -c:v libx265 -f hevc out.h265
+// the stream has no timestamps, the access units are sent at a fixed frame rate
+type H265Producer struct {
+	id       string
+	name     string
+	file     *os.File
+	reader   *annexBReader
+	fps      int
+	track    *H265Track
+	sendByte uint64
+	seek     chan time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+	pauseGate
+	progress
+	// Loop restart the file when it ends
+	Loop bool
+}
+
+// NewH265Producer open a h265 file, fps <= 0 is defaultFrameRate
+func NewH265Producer(id, name string, fps int) (*H265Producer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Errorf("unable to open file %s", name)
+		return nil, err
+	}
+	if fps <= 0 {
+		fps = defaultFrameRate
+	}
+	return &H265Producer{
+		id:     id,
+		name:   name,
+		file:   f,
+		reader: newAnnexBReader(f),
+		fps:    fps,
+		seek:   make(chan time.Duration, 1),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// AddTrack add the video track to pc
+func (t *H265Producer) AddTrack(pc *webrtc.PeerConnection) (*H265Track, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	track, err := NewH265Track("video", fmt.Sprintf("h265_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.track = track
+	return track, nil
+}
+
+func (t *H265Producer) Start() {
+	go t.readLoop()
+}
+
+func (t *H265Producer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// SeekTo jump to the irap access unit at or before d, the access units are at fps from the start
+func (t *H265Producer) SeekTo(d time.Duration) error {
+	requestSeek(t.seek, d)
+	return nil
+}
+
+// rewind restart the reader at the first access unit
+func (t *H265Producer) rewind() error {
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	t.reader = newAnnexBReader(t.file)
+	return nil
+}
+
+// seekAU move the reader to the irap access unit at or before d
+func (t *H265Producer) seekAU(d time.Duration) (int, error) {
+	if err := t.rewind(); err != nil {
+		return 0, err
+	}
+	target := int(d * time.Duration(t.fps) / time.Second)
+	key := 0
+	for i := 0; i <= target; i++ {
+		au, err := t.reader.nextAU()
+		if err != nil {
+			break
+		}
+		for _, nal := range splitAnnexB(au) {
+			if typ := h265NALType(nal); typ >= h265NALBLA && typ <= h265NALCRA {
+				key = i
+				break
+			}
+		}
+	}
+	if err := t.rewind(); err != nil {
+		return 0, err
+	}
+	for i := 0; i < key; i++ {
+		if _, err := t.reader.nextAU(); err != nil {
+			return 0, err
+		}
+	}
+	return key, nil
+}
+
+func (t *H265Producer) readLoop() {
+	defer t.file.Close()
+	duration := time.Second / time.Duration(t.fps)
+	// the stream has no timestamps, the frames are at fps
+	clock := newMediaClock()
+	// frame is the index of the next access unit in the file
+	var frame int
+	var end error
+	for n := 0; ; n++ {
+		if !clock.wait(time.Duration(n)*duration, t.done) {
+			return
+		}
+		gap, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		if gap > 0 {
+			clock.reset(time.Duration(n) * duration)
+		}
+		select {
+		case d := <-t.seek:
+			if key, err := t.seekAU(d); err != nil {
+				log.Errorf("id=%v seek h265 %v err=%v", t.id, t.name, err)
+			} else {
+				frame = key
+			}
+		default:
+		}
+		au, err := t.reader.nextAU()
+		if err == io.EOF && t.Loop {
+			if err = t.rewind(); err == nil {
+				au, err = t.reader.nextAU()
+				frame = 0
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("id=%v read h265 %v err=%v", t.id, t.name, err)
+				end = err
+			}
+			break
+		}
+		t.setPosition(time.Duration(frame) * duration)
+		frame++
+		if t.track == nil {
+			continue
+		}
+		if err := t.track.WriteSample(media.Sample{Data: au, Duration: duration + gap}); err != nil {
+			log.Errorf("Track write error=%v", err)
+			continue
+		}
+		atomic.AddUint64(&t.sendByte, uint64(len(au)))
+		t.sent(len(au))
+	}
+	t.ended(end)
+	log.Infof("Exiting h265 producer")
+}
+
+// SendBytes return the total sent bytes
+func (t *H265Producer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
+// publishH265 publish a h265 file by PublishFile, it has no sample track
+func (c *Client) publishH265(file string, o fileOptions) error {
+	p, err := NewH265Producer(c.uid, file, o.fps)
+	if err != nil {
+		return err
+	}
+	if o.loop != nil {
+		p.Loop = *o.loop
+	}
+	c.setProducer(p)
+	if _, err := p.AddTrack(c.getPub().pc); err != nil {
+		return err
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// Health is the engine state reported by ServeHealth
+type Health struct {
+	Closed    bool                `json:"closed"`
+	Ready     bool                `json:"ready"`
+	Sessions  int                 `json:"sessions"`
+	Clients   int                 `json:"clients"`
+	Signaling map[string][]string `json:"signaling"`
+}
+
+// GetHealth return the engine state and signaling reachability
+func (e *Engine) GetHealth() Health {
+	h := Health{Signaling: make(map[string][]string)}
+	select {
+	case <-e.done:
+		h.Closed = true
+	default:
+	}
+
+	e.RLock()
+	for _, m := range e.clients {
+		if len(m) > 0 {
+			h.Sessions++
+		}
+		h.Clients += len(m)
+	}
+	e.RUnlock()
+
+	h.Ready = !h.Closed
+	for addr, states := range e.pool.States() {
+		for _, state := range states {
+			if state == connectivity.TransientFailure || state == connectivity.Shutdown {
+				h.Ready = false
+			}
+			h.Signaling[addr] = append(h.Signaling[addr], state.String())
+		}
+	}
+	return h
+}
+
+// ServeHealth listening /healthz and /readyz for probes
+// /healthz fails after engine closed, /readyz also fails when any signaling connection is down
+func (e *Engine) ServeHealth(haddr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h := e.GetHealth()
+		writeHealth(w, h, !h.Closed)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h := e.GetHealth()
+		writeHealth(w, h, h.Ready)
+	})
+
+	log.Infof("Health Listening %v", haddr)
+	err := http.ListenAndServe(haddr, mux)
+	if err != nil {
+		log.Errorf("ServeHealth error:%v", err)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, h Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		log.Errorf("writeHealth error:%v", err)
+	}
+}
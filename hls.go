@@ -0,0 +1,427 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultHLSSegmentDuration = 2 * time.Second
+	defaultHLSPlaylistSize    = 6
+	hlsPlaylistName           = "index.m3u8"
+	// aacFrameSamples is the samples of an aac lc frame
+	aacFrameSamples = 1024
+	// maxAudioGap re-anchor the aac timestamps to the opus ones, e.g. after dtx
+	maxAudioGap = 100 * time.Millisecond
+)
+
+// aacEncoder decode opus packets and encode them to raw aac lc frames of 1024 samples at 48khz
+type aacEncoder interface {
+	encode(opus []byte) ([][]byte, error)
+	close()
+}
+
+// newAACEncoder is set by the avcodec build tag, else HLSWriter drop the audio
+var newAACEncoder func(channels, bitrate int) (aacEncoder, error)
+
+// HLSConfig is the output of an HLSWriter
+type HLSConfig struct {
+	// Dir write the segments and index.m3u8, empty keep them only in memory for ServeHTTP and the callbacks
+	Dir string
+	// SegmentDuration is the target duration, the segments are cut at the video key frames, default 2s
+	SegmentDuration time.Duration
+	// PlaylistSize is the segments of the live playlist, the older ones are removed, default 6
+	PlaylistSize int
+	// AudioBitrate of the aac encoder in bps, default 64k
+	AudioBitrate int
+	// OnSegment is called when a segment is complete
+	OnSegment func(name string, data []byte, duration time.Duration)
+	// OnPlaylist is called with the playlist updated for a new segment
+	OnPlaylist func(playlist []byte)
+}
+
+type hlsSegment struct {
+	sequence int
+	name     string
+	data     []byte
+	duration time.Duration
+}
+
+// hlsTrack unwrap the rtp timestamps of a track onto the timeline of the writer
+type hlsTrack struct {
+	track *webrtc.TrackRemote
+	// start is the pts(90khz) of the first frame, at its arrival
+	start int64
+	last  uint32
+	ts    int64
+}
+
+func (t *hlsTrack) pts(timestamp uint32) int64 {
+	t.ts += int64(int32(timestamp - t.last))
+	t.last = timestamp
+	return t.start + t.ts*90000/int64(t.track.Codec().ClockRate)
+}
+
+// HLSWriter remux a subscribed h264 video and opus audio to live hls in mpeg-ts segments, e.g. to serve
+// a preview of a room to plain http viewers, set Client.OnTrack to its OnTrack or add the tracks
+// the opus audio is transcoded to aac with the avcodec build tag, it is dropped without
+type HLSWriter struct {
+	cfg   HLSConfig
+	begin time.Time
+
+	mu       sync.Mutex
+	video    *hlsTrack
+	audio    *hlsTrack
+	aac      aacEncoder
+	aacPTS   int64
+	hasAAC   bool
+	mux      *tsMuxer
+	segment  bytes.Buffer
+	segStart int64
+	lastPTS  int64
+	started  bool
+	sequence int
+	segments []hlsSegment
+	closed   bool
+
+	// RequestKeyFrame is called until the first video key frame, Client.StreamHLS send a pli
+	RequestKeyFrame func(track *webrtc.TrackRemote)
+}
+
+// NewHLSWriter create a writer of cfg, the Dir is created
+func NewHLSWriter(cfg HLSConfig) (*HLSWriter, error) {
+	if cfg.SegmentDuration <= 0 {
+		cfg.SegmentDuration = defaultHLSSegmentDuration
+	}
+	if cfg.PlaylistSize <= 0 {
+		cfg.PlaylistSize = defaultHLSPlaylistSize
+	}
+	if cfg.AudioBitrate <= 0 {
+		cfg.AudioBitrate = 64000
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &HLSWriter{cfg: cfg, begin: time.Now()}, nil
+}
+
+// OnTrack add the track if it is the first h264 video or opus audio, others are ignored
+func (h *HLSWriter) OnTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	if err := h.AddTrack(track); err != nil {
+		log.Debugf("hls ignore track=%v codec=%v err=%v", track.ID(), track.Codec().MimeType, err)
+	}
+}
+
+// AddTrack read and remux track, a writer take one video and one audio which must be added before the
+// first segment, it read the track, do not read it elsewhere
+func (h *HLSWriter) AddTrack(track *webrtc.TrackRemote) error {
+	mime := strings.ToLower(track.Codec().MimeType)
+	if mime != mimeTypeH264 && mime != mimeTypeOpus {
+		return errUnsupportedCodec
+	}
+	reader, err := NewFrameReader(track, nil)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := &hlsTrack{track: track}
+	switch {
+	case h.closed || h.started:
+		return errInvalidTrack
+	case mime == mimeTypeH264 && h.video == nil:
+		h.video = t
+	case mime == mimeTypeOpus && h.audio == nil:
+		if newAACEncoder == nil {
+			log.Warnf("hls drop the audio, build with -tags avcodec to transcode opus")
+			return errUnsupportedCodec
+		}
+		channels := int(track.Codec().Channels)
+		if channels == 0 {
+			channels = 2
+		}
+		if h.aac, err = newAACEncoder(channels, h.cfg.AudioBitrate); err != nil {
+			return err
+		}
+		h.audio = t
+	default:
+		return errInvalidTrack
+	}
+	go h.readLoop(t, reader)
+	if t == h.video {
+		go h.requestKeyFrames(track)
+	}
+	return nil
+}
+
+func (h *HLSWriter) requestKeyFrames(track *webrtc.TrackRemote) {
+	ticker := time.NewTicker(keyFrameRequestInterval)
+	defer ticker.Stop()
+	for {
+		h.mu.Lock()
+		done := h.started || h.closed
+		h.mu.Unlock()
+		if done {
+			return
+		}
+		if h.RequestKeyFrame != nil {
+			h.RequestKeyFrame(track)
+		}
+		<-ticker.C
+	}
+}
+
+func (h *HLSWriter) readLoop(t *hlsTrack, reader *FrameReader) {
+	first := true
+	for {
+		s, err := reader.ReadFrame()
+		if err != nil {
+			log.Infof("hls track=%v end err=%v", t.track.ID(), err)
+			return
+		}
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			return
+		}
+		if first {
+			t.start = int64(time.Since(h.begin) * 90000 / time.Second)
+			t.last, first = s.PacketTimestamp, false
+		}
+		pts := t.pts(s.PacketTimestamp)
+		if t == h.video {
+			err = h.writeVideo(pts, s.Data)
+		} else {
+			err = h.writeAudio(pts, s.Data)
+		}
+		h.mu.Unlock()
+		if err != nil {
+			log.Errorf("hls track=%v err=%v", t.track.ID(), err)
+		}
+	}
+}
+
+// h264Key tell if an annex-b access unit has an idr
+func h264Key(au []byte) bool {
+	for _, nal := range splitAnnexB(au) {
+		if len(nal) > 0 && nal[0]&0x1f == 5 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeVideo start a segment at the key frames after the target duration, the first wait a key frame
+func (h *HLSWriter) writeVideo(pts int64, au []byte) error {
+	key := h264Key(au)
+	if !h.started && !key {
+		return nil
+	}
+	if key {
+		h.cut(pts)
+	}
+	// an access unit delimiter before each frame
+	data := append([]byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0}, au...)
+	h.mux.writePES(&h.segment, tsVideoPID, tsVideoStreamID, pts, key, data)
+	h.lastPTS = pts
+	return nil
+}
+
+// writeAudio transcode an opus packet, without video the segments are cut by duration
+func (h *HLSWriter) writeAudio(pts int64, opus []byte) error {
+	if h.video == nil {
+		h.cut(pts)
+	}
+	if !h.started {
+		return nil
+	}
+	frames, err := h.aac.encode(opus)
+	if err != nil {
+		return err
+	}
+	// the aac frames follow the opus timestamps unless they drift apart
+	gap := int64(maxAudioGap * 90000 / time.Second)
+	if d := pts - h.aacPTS; !h.hasAAC || d > gap || -d > gap {
+		h.aacPTS, h.hasAAC = pts, true
+	}
+	channels := int(h.audio.track.Codec().Channels)
+	for _, f := range frames {
+		h.mux.writePES(&h.segment, tsAudioPID, tsAudioStreamID, h.aacPTS, false, append(adtsHeader(len(f), channels), f...))
+		h.aacPTS += aacFrameSamples * 90000 / 48000
+	}
+	if h.video == nil {
+		h.lastPTS = pts
+	}
+	return nil
+}
+
+// adtsHeader is the header of an aac lc frame at 48khz
+func adtsHeader(size, channels int) []byte {
+	if channels == 0 {
+		channels = 2
+	}
+	n := size + 7
+	// profile lc, sampling index 3
+	return []byte{0xff, 0xf1, 0x01<<6 | 3<<2 | byte(channels>>2), byte(channels&3)<<6 | byte(n>>11),
+		byte(n >> 3), byte(n&7)<<5 | 0x1f, 0xfc}
+}
+
+// cut finish the segment if it reached the target duration and start the next at pts
+func (h *HLSWriter) cut(pts int64) {
+	if !h.started {
+		h.started = true
+		h.mux = newTSMuxer(h.video != nil, h.audio != nil)
+		h.segStart = pts
+		h.mux.writeTables(&h.segment)
+		return
+	}
+	duration := time.Duration(pts-h.segStart) * time.Second / 90000
+	if duration < h.cfg.SegmentDuration {
+		return
+	}
+	h.finish(duration)
+	h.segStart = pts
+	h.mux.writeTables(&h.segment)
+}
+
+// finish save the segment and the playlist, h.mu must be held
+func (h *HLSWriter) finish(duration time.Duration) {
+	if h.segment.Len() == 0 {
+		return
+	}
+	seg := hlsSegment{
+		sequence: h.sequence,
+		name:     fmt.Sprintf("segment%d.ts", h.sequence),
+		data:     append([]byte{}, h.segment.Bytes()...),
+		duration: duration,
+	}
+	h.segment.Reset()
+	h.sequence++
+	h.segments = append(h.segments, seg)
+	var removed []hlsSegment
+	if len(h.segments) > h.cfg.PlaylistSize {
+		n := len(h.segments) - h.cfg.PlaylistSize
+		removed = append(removed, h.segments[:n]...)
+		h.segments = append([]hlsSegment{}, h.segments[n:]...)
+	}
+	playlist := h.playlist()
+	if h.cfg.Dir != "" {
+		if err := writeFileAtomic(filepath.Join(h.cfg.Dir, seg.name), seg.data); err != nil {
+			log.Errorf("hls write %v err=%v", seg.name, err)
+		}
+		if err := writeFileAtomic(filepath.Join(h.cfg.Dir, hlsPlaylistName), playlist); err != nil {
+			log.Errorf("hls write %v err=%v", hlsPlaylistName, err)
+		}
+		// the removed segments may still be downloaded, keep them a playlist longer
+		for _, r := range removed {
+			os.Remove(filepath.Join(h.cfg.Dir, fmt.Sprintf("segment%d.ts", r.sequence-h.cfg.PlaylistSize)))
+		}
+	}
+	if h.cfg.OnSegment != nil {
+		h.cfg.OnSegment(seg.name, seg.data, seg.duration)
+	}
+	if h.cfg.OnPlaylist != nil {
+		h.cfg.OnPlaylist(playlist)
+	}
+}
+
+// playlist return the live playlist of the segments, closed by an endlist after Close
+func (h *HLSWriter) playlist() []byte {
+	var target float64
+	for _, s := range h.segments {
+		target = math.Max(target, math.Ceil(s.duration.Seconds()))
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n",
+		int(target), h.sequence-len(h.segments))
+	for _, s := range h.segments {
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), s.name)
+	}
+	if h.closed {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.Bytes()
+}
+
+// writeFileAtomic write a file by renaming a temporary one, so readers never see it partial
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Close finish the last segment and end the playlist, the tracks are not read any more
+func (h *HLSWriter) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	if h.started {
+		h.finish(time.Duration(h.lastPTS-h.segStart) * time.Second / 90000)
+	}
+	if h.aac != nil {
+		h.aac.close()
+	}
+}
+
+// ServeHTTP serve index.m3u8 and the segments of the playlist from memory, e.g. under http.StripPrefix
+func (h *HLSWriter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.URL.Path)
+	h.mu.Lock()
+	var data []byte
+	if name == hlsPlaylistName {
+		if len(h.segments) > 0 {
+			data = h.playlist()
+		}
+	} else {
+		for _, s := range h.segments {
+			if s.name == name {
+				data = s.data
+			}
+		}
+	}
+	h.mu.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if name == hlsPlaylistName {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	w.Write(data)
+}
+
+// StreamHLS remux the first h264 and opus tracks received by the client to hls, see HLSWriter
+// it set OnTrack, close the writer to end the playlist
+func (c *Client) StreamHLS(cfg HLSConfig) (*HLSWriter, error) {
+	h, err := NewHLSWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	h.RequestKeyFrame = func(track *webrtc.TrackRemote) {
+		if err := c.requestKeyFrame(uint32(track.SSRC())); err != nil {
+			log.Debugf("id=%v pli err=%v", c.uid, err)
+		}
+	}
+	c.OnTrack = h.OnTrack
+	return h, nil
+}
@@ -0,0 +1,277 @@
+//go:build avcodec
+// +build avcodec
+
+package engine
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <stdlib.h>
+#include <string.h>
+#include <libavcodec/avcodec.h>
+#include <libavutil/hwcontext.h>
+#include <libavutil/dict.h>
+
+typedef struct {
+	AVCodecContext *ctx;
+	AVBufferRef *device;
+	// frame is in memory, it is uploaded to hwframe if the encoder take hardware frames
+	AVFrame *frame;
+	AVFrame *hwframe;
+	AVPacket *pkt;
+	int64_t pts;
+	// out is the access unit of the last encode
+	uint8_t *out;
+	int outcap;
+} hwenc;
+
+static void hwenc_close(hwenc *e) {
+	if (e->ctx) {
+		avcodec_free_context(&e->ctx);
+	}
+	av_frame_free(&e->frame);
+	av_frame_free(&e->hwframe);
+	av_packet_free(&e->pkt);
+	av_buffer_unref(&e->device);
+	free(e->out);
+	free(e);
+}
+
+// hwenc_has check the libavcodec is built with the encoder of name
+static int hwenc_has(const char *name) {
+	return avcodec_find_encoder_by_name(name) != NULL;
+}
+
+static void hwenc_strerror(int err, char *buf, int size) {
+	av_strerror(err, buf, size);
+}
+
+// hwenc_open open the encoder of name, a device type like vaapi upload the frames as nv12 to its surfaces
+static int hwenc_open(hwenc **out, const char *name, const char *device, const char *options,
+		int width, int height, int fps, int bitrate, int gop) {
+	const AVCodec *codec = avcodec_find_encoder_by_name(name);
+	if (!codec) {
+		return AVERROR_ENCODER_NOT_FOUND;
+	}
+	hwenc *e = calloc(1, sizeof(hwenc));
+	if (!e) {
+		return AVERROR(ENOMEM);
+	}
+	int ret = AVERROR(ENOMEM);
+	AVDictionary *opts = NULL;
+	if (!(e->ctx = avcodec_alloc_context3(codec)) || !(e->frame = av_frame_alloc()) || !(e->pkt = av_packet_alloc())) {
+		goto fail;
+	}
+	e->ctx->width = width;
+	e->ctx->height = height;
+	e->ctx->time_base = (AVRational){1, fps};
+	e->ctx->framerate = (AVRational){fps, 1};
+	e->ctx->bit_rate = bitrate;
+	e->ctx->rc_max_rate = bitrate;
+	e->ctx->rc_buffer_size = bitrate;
+	e->ctx->gop_size = gop;
+	e->ctx->max_b_frames = 0;
+	e->ctx->pix_fmt = AV_PIX_FMT_YUV420P;
+	e->frame->format = AV_PIX_FMT_YUV420P;
+	if (device[0]) {
+		enum AVHWDeviceType type = av_hwdevice_find_type_by_name(device);
+		if (type == AV_HWDEVICE_TYPE_NONE) {
+			ret = AVERROR(ENOSYS);
+			goto fail;
+		}
+		if ((ret = av_hwdevice_ctx_create(&e->device, type, NULL, NULL, 0)) < 0) {
+			goto fail;
+		}
+		AVBufferRef *frames = av_hwframe_ctx_alloc(e->device);
+		if (!frames || !(e->hwframe = av_frame_alloc())) {
+			av_buffer_unref(&frames);
+			ret = AVERROR(ENOMEM);
+			goto fail;
+		}
+		AVHWFramesContext *fc = (AVHWFramesContext *)frames->data;
+		fc->format = type == AV_HWDEVICE_TYPE_VAAPI ? AV_PIX_FMT_VAAPI : AV_PIX_FMT_NONE;
+		fc->sw_format = AV_PIX_FMT_NV12;
+		fc->width = width;
+		fc->height = height;
+		fc->initial_pool_size = 8;
+		if ((ret = av_hwframe_ctx_init(frames)) < 0) {
+			av_buffer_unref(&frames);
+			goto fail;
+		}
+		e->ctx->pix_fmt = fc->format;
+		e->ctx->hw_frames_ctx = frames;
+		e->frame->format = AV_PIX_FMT_NV12;
+	}
+	e->frame->width = width;
+	e->frame->height = height;
+	if ((ret = av_frame_get_buffer(e->frame, 0)) < 0) {
+		goto fail;
+	}
+	if ((ret = av_dict_parse_string(&opts, options, "=", ":", 0)) < 0) {
+		goto fail;
+	}
+	ret = avcodec_open2(e->ctx, codec, &opts);
+	av_dict_free(&opts);
+	if (ret < 0) {
+		goto fail;
+	}
+	*out = e;
+	return 0;
+fail:
+	hwenc_close(e);
+	return ret;
+}
+
+static int hwenc_append(hwenc *e, int n, const uint8_t *data, int size) {
+	if (n + size > e->outcap) {
+		int cap = (n + size) * 2;
+		uint8_t *out = realloc(e->out, cap);
+		if (!out) {
+			return AVERROR(ENOMEM);
+		}
+		e->out = out;
+		e->outcap = cap;
+	}
+	memcpy(e->out + n, data, size);
+	return n + size;
+}
+
+// hwenc_encode encode an i420 frame, it return the size of the annex-b data in out, 0 if buffered
+static int hwenc_encode(hwenc *e, const uint8_t *y, int ys, const uint8_t *u, const uint8_t *v, int cs) {
+	int ret = av_frame_make_writable(e->frame);
+	if (ret < 0) {
+		return ret;
+	}
+	AVFrame *f = e->frame;
+	for (int i = 0; i < f->height; i++) {
+		memcpy(f->data[0] + i * f->linesize[0], y + i * ys, f->width);
+	}
+	int cw = (f->width + 1) / 2, ch = (f->height + 1) / 2;
+	for (int i = 0; i < ch; i++) {
+		if (f->format == AV_PIX_FMT_NV12) {
+			uint8_t *uv = f->data[1] + i * f->linesize[1];
+			for (int j = 0; j < cw; j++) {
+				uv[2 * j] = u[i * cs + j];
+				uv[2 * j + 1] = v[i * cs + j];
+			}
+		} else {
+			memcpy(f->data[1] + i * f->linesize[1], u + i * cs, cw);
+			memcpy(f->data[2] + i * f->linesize[2], v + i * cs, cw);
+		}
+	}
+	if (e->hwframe) {
+		av_frame_unref(e->hwframe);
+		if ((ret = av_hwframe_get_buffer(e->ctx->hw_frames_ctx, e->hwframe, 0)) < 0 ||
+				(ret = av_hwframe_transfer_data(e->hwframe, f, 0)) < 0) {
+			return ret;
+		}
+		f = e->hwframe;
+	}
+	f->pts = e->pts++;
+	if ((ret = avcodec_send_frame(e->ctx, f)) < 0) {
+		return ret;
+	}
+	int n = 0;
+	for (;;) {
+		ret = avcodec_receive_packet(e->ctx, e->pkt);
+		if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+			return n;
+		}
+		if (ret < 0) {
+			return ret;
+		}
+		n = hwenc_append(e, n, e->pkt->data, e->pkt->size);
+		av_packet_unref(e->pkt);
+		if (n < 0) {
+			return n;
+		}
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// avEncoderConfig is a libavcodec h264 encoder, options are the private options like key=value:key=value
+// a device, e.g. vaapi, is opened for the encoders of hardware frames
+type avEncoderConfig struct {
+	codec   string
+	device  string
+	options string
+}
+
+// avEncoders are the hardware encoders of libavcodec, each is registered when the libavcodec is built with it
+var avEncoders = map[string]avEncoderConfig{
+	HWEncoderNVENC: {
+		codec:   "h264_nvenc",
+		options: "preset=llhp:profile=baseline:rc=cbr:zerolatency=1:delay=0",
+	},
+	// the frames are uploaded to the default render node /dev/dri/renderD128
+	HWEncoderVAAPI: {
+		codec:   "h264_vaapi",
+		device:  "vaapi",
+		options: "profile=constrained_baseline:rc_mode=CBR:async_depth=1",
+	},
+	HWEncoderVideoToolbox: {
+		codec:   "h264_videotoolbox",
+		options: "profile=baseline:realtime=1:allow_sw=0",
+	},
+}
+
+func init() {
+	for name, cfg := range avEncoders {
+		cfg := cfg
+		codec := C.CString(cfg.codec)
+		has := C.hwenc_has(codec) != 0
+		C.free(unsafe.Pointer(codec))
+		if !has {
+			continue
+		}
+		registerHWEncoder(name, func(width, height, fps, bitrate, keyInterval int) (videoEncoder, error) {
+			return newAVEncoder(cfg, width, height, fps, bitrate, keyInterval)
+		})
+	}
+}
+
+// avEncoder encode by libavcodec, the hardware encoders share it
+type avEncoder struct {
+	enc *C.hwenc
+}
+
+func avError(op string, ret C.int) error {
+	buf := make([]byte, 128)
+	C.hwenc_strerror(ret, (*C.char)(unsafe.Pointer(&buf[0])), C.int(len(buf)))
+	return fmt.Errorf("%w: %v %v", errHWEncoder, op, C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+}
+
+func newAVEncoder(cfg avEncoderConfig, width, height, fps, bitrate, keyInterval int) (videoEncoder, error) {
+	codec, device, options := C.CString(cfg.codec), C.CString(cfg.device), C.CString(cfg.options)
+	defer C.free(unsafe.Pointer(codec))
+	defer C.free(unsafe.Pointer(device))
+	defer C.free(unsafe.Pointer(options))
+	e := &avEncoder{}
+	if ret := C.hwenc_open(&e.enc, codec, device, options, C.int(width), C.int(height), C.int(fps), C.int(bitrate), C.int(keyInterval)); ret < 0 {
+		return nil, avError("open "+cfg.codec, ret)
+	}
+	return e, nil
+}
+
+func (e *avEncoder) encode(img *image.YCbCr) ([]byte, error) {
+	ret := C.hwenc_encode(e.enc,
+		(*C.uint8_t)(unsafe.Pointer(&img.Y[0])), C.int(img.YStride),
+		(*C.uint8_t)(unsafe.Pointer(&img.Cb[0])), (*C.uint8_t)(unsafe.Pointer(&img.Cr[0])), C.int(img.CStride))
+	if ret < 0 {
+		return nil, avError("encode", ret)
+	}
+	if ret == 0 {
+		return nil, nil
+	}
+	return C.GoBytes(unsafe.Pointer(e.enc.out), ret), nil
+}
+
+func (e *avEncoder) close() {
+	C.hwenc_close(e.enc)
+}
@@ -0,0 +1,79 @@
+package engine
+
+import "sync"
+
+// the hardware h264 encoders, build with -tags avcodec for them, it needs cgo and libavcodec, an encoder is
+// built in when the libavcodec has it: nvenc is h264_nvenc of nvidia, vaapi is h264_vaapi on linux and
+// videotoolbox is h264_videotoolbox on macos
+const (
+	HWEncoderNVENC        = "nvenc"
+	HWEncoderVAAPI        = "vaapi"
+	HWEncoderVideoToolbox = "videotoolbox"
+)
+
+// hwEncoderOrder is the preference of the encoders when no name is given
+var hwEncoderOrder = []string{HWEncoderNVENC, HWEncoderVAAPI, HWEncoderVideoToolbox}
+
+// hwEncoderFactory open an h264 encoder of i420 frames, the frames are encoded to annex-b access units
+// without b frames, an encoder may return no data while it buffer a frame
+type hwEncoderFactory func(width, height, fps, bitrate, keyInterval int) (videoEncoder, error)
+
+var (
+	hwEncodersMu sync.RWMutex
+	hwEncoders   = make(map[string]hwEncoderFactory)
+)
+
+// registerHWEncoder is called by the init of the encoders found in libavcodec
+func registerHWEncoder(name string, f hwEncoderFactory) {
+	hwEncodersMu.Lock()
+	defer hwEncodersMu.Unlock()
+	hwEncoders[name] = f
+}
+
+// HardwareEncoders return the h264 hardware encoders built in, in the order tried by default
+// a built in encoder still fails to open without its device or driver
+func HardwareEncoders() []string {
+	hwEncodersMu.RLock()
+	defer hwEncodersMu.RUnlock()
+	var names []string
+	for _, name := range hwEncoderOrder {
+		if hwEncoders[name] != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// hasHWEncoder check the encoder of name is built in, an empty name is any
+func hasHWEncoder(name string) bool {
+	if name == "" {
+		return len(HardwareEncoders()) > 0
+	}
+	hwEncodersMu.RLock()
+	defer hwEncodersMu.RUnlock()
+	return hwEncoders[name] != nil
+}
+
+// newHWEncoder open the encoder of name, an empty name try the built in encoders in order
+func newHWEncoder(name string, width, height, fps, bitrate, keyInterval int) (videoEncoder, error) {
+	names := []string{name}
+	if name == "" {
+		names = HardwareEncoders()
+	}
+	err := errNoHWEncoder
+	for _, name := range names {
+		hwEncodersMu.RLock()
+		f := hwEncoders[name]
+		hwEncodersMu.RUnlock()
+		if f == nil {
+			continue
+		}
+		var enc videoEncoder
+		if enc, err = f(width, height, fps, bitrate, keyInterval); err == nil {
+			log.Infof("h264 hardware encoder %v %vx%v@%v", name, width, height, fps)
+			return enc, nil
+		}
+		log.Warnf("open h264 hardware encoder %v err=%v", name, err)
+	}
+	return nil, err
+}
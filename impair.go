@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// reorderTimeout send a held packet if no other packet follows it
+const reorderTimeout = 100 * time.Millisecond
+
+// ImpairmentConfig damage the published rtp like a bad network, to test the sfu and the receivers
+// the percentages are 0-100 and apply to each packet independently, the zero value is disabled
+type ImpairmentConfig struct {
+	// Drop is the percentage of packets not sent
+	Drop float64
+	// Duplicate is the percentage of packets sent twice
+	Duplicate float64
+	// Reorder is the percentage of packets sent after the next one
+	Reorder float64
+	// DelayPercent is the percentage of packets sent Delay plus a random Jitter later
+	DelayPercent float64
+	Delay        time.Duration
+	Jitter       time.Duration
+}
+
+func (cfg ImpairmentConfig) enabled() bool {
+	return cfg.Drop > 0 || cfg.Duplicate > 0 || cfg.Reorder > 0 || cfg.DelayPercent > 0
+}
+
+// chance return true for percent of the calls
+func chance(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// impairer is an interceptor impairing the local streams, it is the innermost writer like the network
+type impairer struct {
+	interceptor.NoOp
+
+	sync.RWMutex
+	cfg  ImpairmentConfig
+	ssrc map[uint32]ImpairmentConfig
+}
+
+func newImpairer(cfg ImpairmentConfig) *impairer {
+	return &impairer{
+		cfg:  cfg,
+		ssrc: make(map[uint32]ImpairmentConfig),
+	}
+}
+
+// SetConfig change the impairment of all streams without their own
+func (i *impairer) SetConfig(cfg ImpairmentConfig) {
+	i.Lock()
+	defer i.Unlock()
+	i.cfg = cfg
+}
+
+// SetStreamConfig change the impairment of ssrc, nil restore the config of all streams
+func (i *impairer) SetStreamConfig(ssrc uint32, cfg *ImpairmentConfig) {
+	i.Lock()
+	defer i.Unlock()
+	if cfg == nil {
+		delete(i.ssrc, ssrc)
+	} else {
+		i.ssrc[ssrc] = *cfg
+	}
+}
+
+func (i *impairer) config(ssrc uint32) ImpairmentConfig {
+	i.RLock()
+	defer i.RUnlock()
+	if cfg, ok := i.ssrc[ssrc]; ok {
+		return cfg
+	}
+	return i.cfg
+}
+
+// BindLocalStream wrap the writer to impair the packets
+func (i *impairer) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	s := &impairedStream{writer: writer}
+	ssrc := info.SSRC
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		cfg := i.config(ssrc)
+		if !cfg.enabled() {
+			return s.write(header, payload, a)
+		}
+		return s.impair(cfg, header, payload, a)
+	})
+}
+
+type impairedPacket struct {
+	header  rtp.Header
+	payload []byte
+	attr    interceptor.Attributes
+	copies  int
+}
+
+// send write the copies of p, return the error of the first
+func (p *impairedPacket) send(writer interceptor.RTPWriter) error {
+	var err error
+	for j := 0; j < p.copies; j++ {
+		if _, e := writer.Write(&p.header, p.payload, p.attr); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// impairedStream hold the packet being reordered of a stream
+type impairedStream struct {
+	sync.Mutex
+	writer interceptor.RTPWriter
+	held   *impairedPacket
+	timer  *time.Timer
+}
+
+// write send the packet then the held one
+func (s *impairedStream) write(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+	n, err := s.writer.Write(header, payload, a)
+	s.Lock()
+	held := s.held
+	s.held = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.Unlock()
+	if held != nil {
+		held.send(s.writer)
+	}
+	return n, err
+}
+
+func (s *impairedStream) impair(cfg ImpairmentConfig, header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+	size := header.MarshalSize() + len(payload)
+	if chance(cfg.Drop) {
+		return size, nil
+	}
+	// the writer may reuse the buffers once it returns
+	p := &impairedPacket{header: *header, payload: append([]byte{}, payload...), attr: a, copies: 1}
+	if chance(cfg.Duplicate) {
+		p.copies = 2
+	}
+	if chance(cfg.DelayPercent) {
+		delay := cfg.Delay
+		if cfg.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(cfg.Jitter)))
+		}
+		time.AfterFunc(delay, func() {
+			p.send(s.writer)
+		})
+		return size, nil
+	}
+	if chance(cfg.Reorder) {
+		s.Lock()
+		if s.held == nil {
+			s.held = p
+			s.timer = time.AfterFunc(reorderTimeout, s.flush)
+			s.Unlock()
+			return size, nil
+		}
+		s.Unlock()
+	}
+	if p.copies == 2 {
+		p.copies = 1
+		p.send(s.writer)
+	}
+	return s.write(&p.header, p.payload, p.attr)
+}
+
+// flush send the held packet when no packet followed it
+func (s *impairedStream) flush() {
+	s.Lock()
+	held := s.held
+	s.held = nil
+	s.timer = nil
+	s.Unlock()
+	if held != nil {
+		held.send(s.writer)
+	}
+}
+
+// SetImpairment impair all the published tracks without their own impairment
+func (c *Client) SetImpairment(cfg ImpairmentConfig) {
+	c.getPub().impairer.SetConfig(cfg)
+}
+
+// ImpairTrack impair the rtp of a published track, e.g. a track of a producer, nil restore the impairment of all tracks
+func (c *Client) ImpairTrack(trackID string, cfg *ImpairmentConfig) error {
+	sender := c.getSender(trackID)
+	if sender == nil {
+		return errInvalidTrack
+	}
+	log.Debugf("id=%v track=%v impairment=%+v", c.uid, trackID, cfg)
+	for _, enc := range sender.GetParameters().Encodings {
+		c.getPub().impairer.SetStreamConfig(uint32(enc.SSRC), cfg)
+	}
+	return nil
+}
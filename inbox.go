@@ -0,0 +1,54 @@
+package engine
+
+import "sync"
+
+// inbox hold the sfu offers and candidates until the pcs are ready, then replay them in order
+// once open, messages are handled at once on the signal goroutine
+type inbox struct {
+	sync.Mutex
+	ready   bool
+	pending []func()
+}
+
+func newInbox() *inbox {
+	return &inbox{}
+}
+
+// push handle f now if ready, else queue it, return false if queued
+func (q *inbox) push(f func()) bool {
+	q.Lock()
+	if !q.ready {
+		q.pending = append(q.pending, f)
+		q.Unlock()
+		return false
+	}
+	q.Unlock()
+	f()
+	return true
+}
+
+// open replay the queued messages, messages pushed meanwhile are replayed after them
+func (q *inbox) open() {
+	for {
+		q.Lock()
+		pending := q.pending
+		q.pending = nil
+		if len(pending) == 0 {
+			q.ready = true
+			q.Unlock()
+			return
+		}
+		q.Unlock()
+		for _, f := range pending {
+			f()
+		}
+	}
+}
+
+// close queue the messages again, e.g. while the pcs are rebuilt on reconnect
+func (q *inbox) close() {
+	q.Lock()
+	q.ready = false
+	q.pending = nil
+	q.Unlock()
+}
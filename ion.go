@@ -166,6 +166,12 @@ func NewIonConnector(addr string, uid string, pinfo map[string]interface{}) *Ion
 		}
 	}
 	i.biz.OnMessage = func(from, to string, data map[string]interface{}) {
+		if ctrl, ok := parseControl(data); ok {
+			if i.sfu != nil && (to == "" || to == i.uid) {
+				i.sfu.HandleControl(ctrl)
+			}
+			return
+		}
 		if i.OnMessage != nil {
 			i.OnMessage(Message{
 				From: from,
@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+)
+
+// IVFProducer publish the vp8, vp9 or av1 video of an ivf file, paced by the frame timestamps
+type IVFProducer struct {
+	id       string
+	name     string
+	source   io.Reader
+	reader   *ivfreader.IVFReader
+	header   *ivfreader.IVFFileHeader
+	track    sampleWriter
+	sendByte uint64
+	seek     chan time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+	pauseGate
+	progress
+	// Loop restart the file when it ends, if the reader is an io.Seeker
+	Loop bool
+}
+
+// NewIVFProducer open an ivf file
+func NewIVFProducer(id, name string) (*IVFProducer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Errorf("unable to open file %s", name)
+		return nil, err
+	}
+	p, err := newIVFProducer(id, name, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewIVFProducerFromReader read an ivf file from r, e.g. a http body, seek and loop need an io.Seeker
+// an io.Closer r is closed when the producer ends
+func NewIVFProducerFromReader(id string, r io.Reader) (*IVFProducer, error) {
+	return newIVFProducer(id, fmt.Sprintf("reader_%p", r), r)
+}
+
+func newIVFProducer(id, name string, r io.Reader) (*IVFProducer, error) {
+	reader, header, err := ivfreader.NewWith(r)
+	if err != nil {
+		log.Errorf("read ivf %v err=%v", name, err)
+		return nil, err
+	}
+	if header.TimebaseDenominator == 0 {
+		return nil, errInvalidFile
+	}
+	return &IVFProducer{
+		id:     id,
+		name:   name,
+		source: r,
+		reader: reader,
+		header: header,
+		seek:   make(chan time.Duration, 1),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// AddTrack add the video track to pc, a TrackLocalStaticSample, a VP9Track or an AV1Track
+func (t *IVFProducer) AddTrack(pc *webrtc.PeerConnection) (webrtc.TrackLocal, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	streamID := fmt.Sprintf("ivf_%p", t)
+	var track sampleWriter
+	var err error
+	switch t.header.FourCC {
+	case "VP80":
+		track, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, "video", streamID)
+	case "VP90":
+		track, err = NewVP9Track("video", streamID)
+	case "AV01":
+		track, err = NewAV1Track("video", streamID)
+	default:
+		return nil, errUnsupportedCodec
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.track = track
+	return track, nil
+}
+
+// bindTrack write the vp8 or vp9 frames to track, e.g. a playlist track
+func (t *IVFProducer) bindTrack(kind string, track *webrtc.TrackLocalStaticSample) error {
+	mime := track.Codec().MimeType
+	if kind != "video" || (t.header.FourCC != "VP80" || !strings.EqualFold(mime, webrtc.MimeTypeVP8)) &&
+		(t.header.FourCC != "VP90" || !strings.EqualFold(mime, webrtc.MimeTypeVP9)) {
+		return errUnsupportedCodec
+	}
+	t.track = track
+	return nil
+}
+
+func (t *IVFProducer) Start() {
+	go t.readLoop()
+}
+
+func (t *IVFProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// SeekTo jump to the key frame at or before d
+func (t *IVFProducer) SeekTo(d time.Duration) error {
+	if _, ok := t.source.(io.Seeker); !ok {
+		return errNotSeekable
+	}
+	requestSeek(t.seek, d)
+	return nil
+}
+
+// keyframe tell if an ivf frame is a key frame, an av1 temporal unit with a sequence header
+func (t *IVFProducer) keyframe(frame []byte) bool {
+	switch t.header.FourCC {
+	case "VP80":
+		return len(frame) > 0 && frame[0]&0x01 == 0
+	case "VP90":
+		for _, b := range vp9Frames(frame) {
+			if f, ok := parseVP9Frame(b); ok && f.key {
+				return true
+			}
+		}
+	case "AV01":
+		_, seqHeader := splitOBUs(frame)
+		return seqHeader
+	}
+	return false
+}
+
+// rewind restart the reader at the first frame
+func (t *IVFProducer) rewind() error {
+	s, ok := t.source.(io.Seeker)
+	if !ok {
+		return errNotSeekable
+	}
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var err error
+	t.reader, _, err = ivfreader.NewWith(t.source)
+	return err
+}
+
+// seekFrame move the reader to the key frame at or before d and return its time
+func (t *IVFProducer) seekFrame(d time.Duration) (time.Duration, error) {
+	if err := t.rewind(); err != nil {
+		return 0, err
+	}
+	var key int
+	var keyTime time.Duration
+	for i := 0; ; i++ {
+		frame, header, err := t.reader.ParseNextFrame()
+		if err != nil || t.frameTime(header.Timestamp) > d {
+			break
+		}
+		if t.keyframe(frame) {
+			key, keyTime = i, t.frameTime(header.Timestamp)
+		}
+	}
+	if err := t.rewind(); err != nil {
+		return 0, err
+	}
+	for i := 0; i < key; i++ {
+		if _, _, err := t.reader.ParseNextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	return keyTime, nil
+}
+
+// frameTime convert an ivf timestamp in timebase units
+func (t *IVFProducer) frameTime(ts uint64) time.Duration {
+	return time.Duration(ts) * time.Second * time.Duration(t.header.TimebaseNumerator) / time.Duration(t.header.TimebaseDenominator)
+}
+
+// next return the next frame, it restart the file at the end if Loop
+func (t *IVFProducer) next() ([]byte, time.Duration, bool, error) {
+	frame, header, err := t.reader.ParseNextFrame()
+	if _, ok := t.source.(io.Seeker); err == io.EOF && t.Loop && ok {
+		if err = t.rewind(); err != nil {
+			return nil, 0, false, err
+		}
+		frame, header, err = t.reader.ParseNextFrame()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return frame, t.frameTime(header.Timestamp), true, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return frame, t.frameTime(header.Timestamp), false, nil
+}
+
+func (t *IVFProducer) readLoop() {
+	if c, ok := t.source.(io.Closer); ok {
+		defer c.Close()
+	}
+	clock := newMediaClock()
+	// the looped and seeked times are shifted by base, gap is the pause after the pending frame
+	var base, last, duration, gap time.Duration
+	var pending []byte
+	var end error
+	for {
+		select {
+		case d := <-t.seek:
+			keyTime, err := t.seekFrame(d)
+			if err != nil {
+				log.Errorf("id=%v seek ivf %v err=%v", t.id, t.name, err)
+				t.ended(err)
+				return
+			}
+			if pending != nil {
+				t.write(pending, duration+gap)
+				pending, gap = nil, 0
+			}
+			// the key frame follow the last frame
+			base = last + duration - keyTime
+			log.Debugf("id=%v seek ivf %v to %v", t.id, t.name, keyTime)
+		default:
+		}
+		frame, ts, looped, err := t.next()
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Errorf("id=%v read ivf %v err=%v", t.id, t.name, err)
+				end = err
+			}
+			break
+		}
+		if looped {
+			base = last + duration
+		}
+		ts += base
+		// the duration of a frame is known with the next one
+		if pending != nil {
+			if ts > last {
+				duration = ts - last
+			}
+			t.write(pending, duration+gap)
+			gap = 0
+		}
+		pending, last = frame, ts
+		if !clock.wait(ts, t.done) {
+			return
+		}
+		t.setPosition(ts - base)
+		paused, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		if paused > 0 {
+			// the frame due at the pause is written with the next one
+			clock.reset(ts)
+			gap = paused
+		}
+	}
+	if pending != nil {
+		t.write(pending, duration+gap)
+	}
+	// the last sample last its duration, e.g. before the next file of a playlist
+	if clock.wait(last+duration, t.done) {
+		t.ended(end)
+	}
+	log.Infof("Exiting ivf producer")
+}
+
+func (t *IVFProducer) write(frame []byte, d time.Duration) {
+	if t.track == nil {
+		return
+	}
+	if err := t.track.WriteSample(media.Sample{Data: frame, Duration: d}); err != nil {
+		log.Errorf("Track write error=%v", err)
+		return
+	}
+	atomic.AddUint64(&t.sendByte, uint64(len(frame)))
+	t.sent(len(frame))
+}
+
+// SendBytes return the total sent bytes
+func (t *IVFProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
+// publishIVF publish an ivf producer by PublishFile or PublishReader, av1 has no TrackLocalStaticSample
+func (c *Client) publishIVF(p *IVFProducer, o fileOptions) error {
+	if o.loop != nil {
+		p.Loop = *o.loop
+	}
+	c.setProducer(p)
+	if _, err := p.AddTrack(c.getPub().pc); err != nil {
+		return err
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
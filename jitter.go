@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+)
+
+const (
+	defaultJitterMaxPackets = 512
+	// maxConcealedPackets is the longest audio gap concealed, longer gaps are skipped
+	maxConcealedPackets = 10
+	// jitterReadSize is the buffer of a packet read from the network
+	jitterReadSize = 1500
+)
+
+// JitterLatePolicy is what a jitter buffer do with a packet arriving after its gap was skipped
+type JitterLatePolicy int
+
+const (
+	// JitterLateDrop drop the late packets, the stream stay ordered
+	JitterLateDrop JitterLatePolicy = iota
+	// JitterLateForward pass the late packets as soon as they arrive, e.g. for a samplebuilder which can still use them
+	JitterLateForward
+)
+
+// JitterBufferConfig order the rtp of the subscribed tracks before they are read, disabled by default
+// the packets in order are passed at once, a gap is waited for Delay before being skipped
+type JitterBufferConfig struct {
+	// Delay is the target delay of the missing packets, 0 is disabled
+	Delay time.Duration
+	// MaxPackets is the packets held by track, the gap is skipped when full, default 512
+	MaxPackets int
+	// Late is the policy of the packets arriving after their gap was skipped
+	Late JitterLatePolicy
+	// Conceal replace the skipped audio packets, up to 10, by a copy of the previous one
+	Conceal bool
+}
+
+// jitterBuffer is an interceptor ordering the remote streams, the sinks of the tap get the ordered packets
+type jitterBuffer struct {
+	interceptor.NoOp
+	cfg JitterBufferConfig
+}
+
+func newJitterBuffer(cfg JitterBufferConfig) *jitterBuffer {
+	if cfg.MaxPackets <= 0 {
+		cfg.MaxPackets = defaultJitterMaxPackets
+	}
+	return &jitterBuffer{cfg: cfg}
+}
+
+// BindRemoteStream read the stream in the background and return the reader of the ordered packets
+func (j *jitterBuffer) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	if j.cfg.Delay <= 0 {
+		return reader
+	}
+	s := &jitterStream{
+		cfg:     j.cfg,
+		ssrc:    info.SSRC,
+		audio:   strings.HasPrefix(strings.ToLower(info.MimeType), "audio/"),
+		reader:  reader,
+		packets: make(map[uint16]*jitterPacket),
+		notify:  make(chan struct{}, 1),
+	}
+	go s.readLoop()
+	return interceptor.RTPReaderFunc(s.read)
+}
+
+type jitterPacket struct {
+	data    []byte
+	attr    interceptor.Attributes
+	seq     uint16
+	ts      uint32
+	arrived time.Time
+}
+
+// jitterStream hold the packets of a stream until their turn
+type jitterStream struct {
+	cfg    JitterBufferConfig
+	ssrc   uint32
+	audio  bool
+	reader interceptor.RTPReader
+	notify chan struct{}
+
+	mu      sync.Mutex
+	packets map[uint16]*jitterPacket
+	late    []*jitterPacket
+	// expected is the next sequence number, once started
+	expected uint16
+	started  bool
+	// the last packet passed and the timestamp step, for concealment
+	last    *jitterPacket
+	tsDelta uint32
+	err     error
+}
+
+func (s *jitterStream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// readLoop read the network until the stream is closed
+func (s *jitterStream) readLoop() {
+	for {
+		b := make([]byte, jitterReadSize)
+		n, attr, err := s.reader.Read(b, nil)
+		s.mu.Lock()
+		if err != nil {
+			s.err = err
+			s.mu.Unlock()
+			s.signal()
+			return
+		}
+		if n < 12 {
+			s.mu.Unlock()
+			continue
+		}
+		p := &jitterPacket{
+			data:    b[:n],
+			attr:    attr,
+			seq:     binary.BigEndian.Uint16(b[2:]),
+			ts:      binary.BigEndian.Uint32(b[4:]),
+			arrived: time.Now(),
+		}
+		switch {
+		case s.started && int16(p.seq-s.expected) < 0:
+			if s.cfg.Late == JitterLateForward && len(s.late) < s.cfg.MaxPackets {
+				s.late = append(s.late, p)
+			} else {
+				log.Debugf("jitter ssrc=%v drop late seq=%v", s.ssrc, p.seq)
+			}
+		default:
+			// the oldest is dropped when the track is not read
+			if len(s.packets) >= s.cfg.MaxPackets {
+				delete(s.packets, s.head().seq)
+			}
+			s.packets[p.seq] = p
+		}
+		s.mu.Unlock()
+		s.signal()
+	}
+}
+
+// head return the lowest packet held
+func (s *jitterStream) head() *jitterPacket {
+	var head *jitterPacket
+	for _, p := range s.packets {
+		if head == nil || int16(p.seq-head.seq) < 0 {
+			head = p
+		}
+	}
+	return head
+}
+
+// next return the packet to pass or how long to wait for it, s.mu must be held
+func (s *jitterStream) next() (*jitterPacket, time.Duration) {
+	if len(s.late) > 0 {
+		p := s.late[0]
+		s.late = s.late[1:]
+		return p, 0
+	}
+	if p, ok := s.packets[s.expected]; ok && s.started {
+		delete(s.packets, p.seq)
+		s.pass(p)
+		return p, 0
+	}
+	head := s.head()
+	if head == nil {
+		return nil, -1
+	}
+	if wait := time.Until(head.arrived.Add(s.cfg.Delay)); wait > 0 && len(s.packets) < s.cfg.MaxPackets && s.err == nil {
+		return nil, wait
+	}
+	// the gap is skipped
+	if gap := head.seq - s.expected; s.started && s.cfg.Conceal && s.audio && s.last != nil && gap <= maxConcealedPackets {
+		return s.conceal(), 0
+	}
+	if s.started {
+		log.Debugf("jitter ssrc=%v skip seq=%v-%v", s.ssrc, s.expected, head.seq-1)
+	}
+	delete(s.packets, head.seq)
+	s.pass(head)
+	return head, 0
+}
+
+// pass advance the stream after p
+func (s *jitterStream) pass(p *jitterPacket) {
+	if s.last != nil && p.seq == s.last.seq+1 {
+		s.tsDelta = p.ts - s.last.ts
+	}
+	s.expected, s.started, s.last = p.seq+1, true, p
+}
+
+// conceal return a copy of the last packet in place of the expected one
+func (s *jitterStream) conceal() *jitterPacket {
+	p := &jitterPacket{
+		data: append([]byte{}, s.last.data...),
+		attr: s.last.attr,
+		seq:  s.expected,
+		ts:   s.last.ts + s.tsDelta,
+	}
+	binary.BigEndian.PutUint16(p.data[2:], p.seq)
+	binary.BigEndian.PutUint32(p.data[4:], p.ts)
+	s.pass(p)
+	return p
+}
+
+// read block until a packet is due, the errors of the network are returned once the held packets are passed
+func (s *jitterStream) read(b []byte, _ interceptor.Attributes) (int, interceptor.Attributes, error) {
+	for {
+		s.mu.Lock()
+		p, wait := s.next()
+		err := s.err
+		s.mu.Unlock()
+		if p != nil {
+			return copy(b, p.data), p.attr, nil
+		}
+		if wait < 0 {
+			if err != nil {
+				return 0, nil, err
+			}
+			<-s.notify
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
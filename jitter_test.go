@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/stretchr/testify/assert"
+)
+
+// chanReader is a remote stream of the packets sent on it, closed by io.EOF
+type chanReader chan []byte
+
+func (c chanReader) Read(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+	p, ok := <-c
+	if !ok {
+		return 0, nil, io.EOF
+	}
+	return copy(b, p), a, nil
+}
+
+func TestJitterBuffer(t *testing.T) {
+	packet := func(seq uint16) []byte {
+		b := make([]byte, 13)
+		b[0] = 0x80
+		binary.BigEndian.PutUint16(b[2:], seq)
+		binary.BigEndian.PutUint32(b[4:], uint32(seq)*960)
+		b[12] = byte(seq)
+		return b
+	}
+	type out struct {
+		seq     uint16
+		ts      uint32
+		payload byte
+	}
+	// step feed the packets then read n of them, -1 is to the end of the stream
+	type step struct {
+		feed []uint16
+		read int
+	}
+	delay := 50 * time.Millisecond
+	for _, tc := range []struct {
+		name  string
+		mime  string
+		cfg   JitterBufferConfig
+		steps []step
+		want  []out
+	}{
+		{
+			name:  "in order",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: time.Second},
+			steps: []step{{[]uint16{1, 2, 3}, -1}},
+			want:  []out{{1, 960, 1}, {2, 1920, 2}, {3, 2880, 3}},
+		},
+		{
+			name:  "reorder",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: time.Second},
+			steps: []step{{[]uint16{1, 3, 2, 5, 4}, -1}},
+			want:  []out{{1, 960, 1}, {2, 1920, 2}, {3, 2880, 3}, {4, 3840, 4}, {5, 4800, 5}},
+		},
+		{
+			name:  "reorder at wrap",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: time.Second},
+			steps: []step{{[]uint16{65534, 0, 65535}, -1}},
+			want:  []out{{65534, 65534 * 960, 0xfe}, {65535, 65535 * 960, 0xff}, {0, 0, 0}},
+		},
+		{
+			name:  "skip a gap",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: delay},
+			steps: []step{{[]uint16{1, 2, 4}, 3}, {[]uint16{5}, -1}},
+			want:  []out{{1, 960, 1}, {2, 1920, 2}, {4, 3840, 4}, {5, 4800, 5}},
+		},
+		{
+			name:  "skip the video without concealment",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: delay, Conceal: true},
+			steps: []step{{[]uint16{1, 2, 4}, 3}, {nil, -1}},
+			want:  []out{{1, 960, 1}, {2, 1920, 2}, {4, 3840, 4}},
+		},
+		{
+			name:  "conceal the audio",
+			mime:  mimeTypeOpus,
+			cfg:   JitterBufferConfig{Delay: delay, Conceal: true},
+			steps: []step{{[]uint16{1, 2, 5}, 5}, {nil, -1}},
+			want:  []out{{1, 960, 1}, {2, 1920, 2}, {3, 2880, 2}, {4, 3840, 2}, {5, 4800, 5}},
+		},
+		{
+			name:  "skip a long audio gap",
+			mime:  mimeTypeOpus,
+			cfg:   JitterBufferConfig{Delay: delay, Conceal: true},
+			steps: []step{{[]uint16{1, 2, 2 + maxConcealedPackets + 2}, 3}, {nil, -1}},
+			want:  []out{{1, 960, 1}, {2, 1920, 2}, {2 + maxConcealedPackets + 2, (2 + maxConcealedPackets + 2) * 960, 2 + maxConcealedPackets + 2}},
+		},
+		{
+			name:  "drop late",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: delay},
+			steps: []step{{[]uint16{1, 3}, 2}, {[]uint16{2, 4}, -1}},
+			want:  []out{{1, 960, 1}, {3, 2880, 3}, {4, 3840, 4}},
+		},
+		{
+			name:  "forward late",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: delay, Late: JitterLateForward},
+			steps: []step{{[]uint16{1, 3}, 2}, {[]uint16{2, 4}, -1}},
+			want:  []out{{1, 960, 1}, {3, 2880, 3}, {2, 1920, 2}, {4, 3840, 4}},
+		},
+		{
+			name:  "full",
+			mime:  mimeTypeVP8,
+			cfg:   JitterBufferConfig{Delay: time.Second, MaxPackets: 2},
+			steps: []step{{[]uint16{1}, 1}, {[]uint16{3, 4}, 2}, {nil, -1}},
+			want:  []out{{1, 960, 1}, {3, 2880, 3}, {4, 3840, 4}},
+		},
+	} {
+		feed := make(chanReader)
+		reader := newJitterBuffer(tc.cfg).BindRemoteStream(&interceptor.StreamInfo{SSRC: 1, MimeType: tc.mime}, feed)
+		var got []out
+		b := make([]byte, 1500)
+		read := func() bool {
+			n, _, err := reader.Read(b, nil)
+			if err != nil {
+				assert.Equal(t, io.EOF, err, tc.name)
+				return false
+			}
+			got = append(got, out{binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint32(b[4:]), b[n-1]})
+			return true
+		}
+		for _, s := range tc.steps {
+			for _, seq := range s.feed {
+				feed <- packet(seq)
+			}
+			if s.read < 0 {
+				close(feed)
+				for read() {
+				}
+				continue
+			}
+			for i := 0; i < s.read && read(); i++ {
+			}
+		}
+		assert.Equal(t, tc.want, got, tc.name)
+	}
+}
+
+func TestJitterBufferDisabled(t *testing.T) {
+	feed := make(chanReader)
+	reader := newJitterBuffer(JitterBufferConfig{}).BindRemoteStream(&interceptor.StreamInfo{SSRC: 1}, feed)
+	assert.Equal(t, interceptor.RTPReader(feed), reader)
+}
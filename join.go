@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// event is closed once when something happened
+type event struct {
+	once sync.Once
+	c    chan struct{}
+}
+
+func newEvent() *event {
+	return &event{c: make(chan struct{})}
+}
+
+func (e *event) fire() {
+	e.once.Do(func() { close(e.c) })
+}
+
+// wait block until the event is fired, ctx is done or client is closed
+func (c *Client) wait(ctx context.Context, e *event, timeout error) error {
+	select {
+	case <-e.c:
+		return nil
+	case <-c.notify:
+		return errClientClosed
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return timeout
+		}
+		return ctx.Err()
+	}
+}
+
+// JoinContext join the session and wait for the sfu answer
+// return ErrJoinTimeout if ctx is expired before the answer, close the client before retrying
+func (c *Client) JoinContext(ctx context.Context, sid string, config *JoinConfig) error {
+	if err := c.doJoin(sid, config); err != nil {
+		return err
+	}
+	err := c.wait(ctx, c.answered, ErrJoinTimeout)
+	if err == errClientClosed && c.serverErr != nil {
+		return c.serverErr
+	}
+	return err
+}
+
+// WaitConnected wait for the publisher pc, or the subscriber pc when joined with NoPublish, to be connected
+// return ErrConnectTimeout if ctx is expired
+func (c *Client) WaitConnected(ctx context.Context) error {
+	return c.wait(ctx, c.connected, ErrConnectTimeout)
+}
+
+// WaitFirstTrack wait for the first remote track to arrive
+// return ErrMediaTimeout if ctx is expired
+func (c *Client) WaitFirstTrack(ctx context.Context) error {
+	return c.wait(ctx, c.firstTrack, ErrMediaTimeout)
+}
+
+// onConnectionState fire connected event for the pc carrying media
+func (c *Client) onConnectionState(role int, state webrtc.PeerConnectionState) {
+	if state != webrtc.PeerConnectionStateConnected {
+		return
+	}
+	if role == PUBLISHER || c.noPublish {
+		c.connected.fire()
+	}
+}
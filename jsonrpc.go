@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+	wsjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
+	"golang.org/x/net/proxy"
+)
+
+const jsonrpcDialTimeout = 3 * time.Second
+
+type jsonrpcJoin struct {
+	SID    string                    `json:"sid"`
+	UID    string                    `json:"uid"`
+	Offer  webrtc.SessionDescription `json:"offer"`
+	Config JoinConfig                `json:"config"`
+}
+
+type jsonrpcNegotiation struct {
+	Desc webrtc.SessionDescription `json:"desc"`
+}
+
+type jsonrpcTrickle struct {
+	Target    int                     `json:"target"`
+	Candidate webrtc.ICECandidateInit `json:"candidate"`
+}
+
+// JSONRPCSignal is the json-rpc over websocket signaling of ion-sfu
+type JSONRPCSignal struct {
+	id   string
+	conn *jsonrpc2.Conn
+	ctx  context.Context
+	opts jsonrpcOptions
+	// set when the server stop answering the pings
+	timedOut int32
+
+	signalHandlers
+}
+
+// newSignal create the signaling of Config.Signal
+func (e *Engine) newSignal(addr, uid string) (Signal, error) {
+	if e.getConfig().Signal == SignalJSONRPC {
+		cfg := e.getConfig()
+		tlsCfg, err := cfg.TLS.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		proxyDialer, err := cfg.Proxy.dialer()
+		if err != nil {
+			return nil, err
+		}
+		return newJSONRPCSignal(addr, uid, jsonrpcOptions{
+			tls:              tlsCfg,
+			proxy:            proxyDialer,
+			keepalive:        cfg.KeepaliveInterval,
+			keepaliveTimeout: cfg.keepaliveTimeout(),
+			joinTimeout:      cfg.JoinTimeout,
+			offerTimeout:     cfg.OfferTimeout,
+		})
+	}
+	conn, err := e.pool.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSignalWithConn(conn, uid, func() { e.pool.Put(addr, conn) })
+	if err != nil {
+		return nil, err
+	}
+	s.sendTimeout = e.getConfig().TrickleTimeout
+	s.keepaliveInterval, s.keepaliveTimeout = e.getConfig().KeepaliveInterval, e.getConfig().keepaliveTimeout()
+	return s, nil
+}
+
+// NewJSONRPCSignal dial a json-rpc signaler, addr is like ws://127.0.0.1:7000/ws
+func NewJSONRPCSignal(addr, id string) (*JSONRPCSignal, error) {
+	return newJSONRPCSignal(addr, id, jsonrpcOptions{})
+}
+
+type jsonrpcOptions struct {
+	// tls is used by wss:// addrs
+	tls *tls.Config
+	// proxy dial the websocket, nil is direct
+	proxy proxy.Dialer
+	// ping the server every keepalive, 0 is disabled
+	keepalive        time.Duration
+	keepaliveTimeout time.Duration
+	// wait for the reply of join and offer, 0 is forever
+	joinTimeout  time.Duration
+	offerTimeout time.Duration
+}
+
+func newJSONRPCSignal(addr, id string, opts jsonrpcOptions) (*JSONRPCSignal, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = jsonrpcDialTimeout
+	dialer.TLSClientConfig = opts.tls
+	if opts.proxy != nil {
+		dialer.Proxy = nil
+		dialer.NetDial = opts.proxy.Dial
+	}
+	ws, _, err := dialer.Dial(addr, nil)
+	if err != nil {
+		log.Errorf("[%v] Connecting to sfu:%s failed: %v", id, addr, err)
+		return nil, err
+	}
+	log.Infof("[%v] Connecting to sfu ok: %s", id, addr)
+	s := &JSONRPCSignal{
+		id:   id,
+		ctx:  context.Background(),
+		opts: opts,
+	}
+	s.conn = jsonrpc2.NewConn(s.ctx, wsjsonrpc2.NewObjectStream(ws), s)
+	if opts.keepalive > 0 {
+		go s.keepalive(ws, opts.keepalive, opts.keepaliveTimeout)
+	}
+	go func() {
+		<-s.conn.DisconnectNotify()
+		log.Infof("[%v] json-rpc signal closed", s.id)
+		err := errSignalClosed
+		if atomic.LoadInt32(&s.timedOut) == 1 {
+			err = ErrSignalTimeout
+		}
+		if s.onError != nil {
+			s.onError(err)
+		}
+	}()
+	return s, nil
+}
+
+// keepalive ping the server and close the connection if no pong in interval+timeout
+func (s *JSONRPCSignal) keepalive(ws *websocket.Conn, interval, timeout time.Duration) {
+	lastPong := time.Now().UnixNano()
+	ws.SetPongHandler(func(string) error {
+		atomic.StoreInt64(&lastPong, time.Now().UnixNano())
+		return nil
+	})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.conn.DisconnectNotify():
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&lastPong))) > interval+timeout {
+				log.Warnf("[%v] no pong in %v, close signal", s.id, interval+timeout)
+				atomic.StoreInt32(&s.timedOut, 1)
+				s.conn.Close()
+				return
+			}
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				log.Debugf("[%v] ping err=%v", s.id, err)
+			}
+		}
+	}
+}
+
+// Handle the notifications from sfu, do not call the sfu in it
+func (s *JSONRPCSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	if req.Params == nil {
+		return
+	}
+	switch req.Method {
+	case "offer":
+		var sdp webrtc.SessionDescription
+		if err := json.Unmarshal(*req.Params, &sdp); err != nil {
+			log.Errorf("[%v] [offer] sdp unmarshal error: %v", s.id, err)
+			return
+		}
+		if s.onNegotiate != nil {
+			if err := s.onNegotiate(sdp); err != nil {
+				log.Errorf("[%v] [offer] s.OnNegotiate err=%v", s.id, err)
+			}
+		}
+	case "trickle":
+		var trickle jsonrpcTrickle
+		if err := json.Unmarshal(*req.Params, &trickle); err != nil {
+			log.Errorf("[%v] [trickle] unmarshal error: %v", s.id, err)
+			return
+		}
+		if s.onTrickle != nil {
+			s.onTrickle(trickle.Candidate, trickle.Target)
+		}
+	}
+}
+
+// call call the sfu and wait for the reply, return timeoutErr if no reply in timeout
+func (s *JSONRPCSignal) call(method string, params, result interface{}, timeout time.Duration, timeoutErr error) error {
+	ctx := s.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := s.conn.Call(ctx, method, params, result)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return timeoutErr
+	}
+	return err
+}
+
+// Join send the join and set the answer
+func (s *JSONRPCSignal) Join(sid string, uid string, offer webrtc.SessionDescription, config *JoinConfig) error {
+	log.Infof("[%v] [JSONRPCSignal.Join] sid=%v offer=%v", s.id, sid, offer)
+	if config == nil {
+		config = NewJoinConfig()
+	}
+	var answer webrtc.SessionDescription
+	err := s.call("join", jsonrpcJoin{SID: sid, UID: uid, Offer: offer, Config: *config}, &answer, s.opts.joinTimeout, ErrJoinTimeout)
+	if err != nil {
+		log.Errorf("[%v] err=%v", s.id, err)
+		return err
+	}
+	return s.onSetRemoteSDP(answer)
+}
+
+// Offer send an offer, the answer is set when replied
+func (s *JSONRPCSignal) Offer(sdp webrtc.SessionDescription) {
+	log.Infof("[%v] [JSONRPCSignal.Offer] sdp=%v", s.id, sdp)
+	go func() {
+		var answer webrtc.SessionDescription
+		if err := s.call("offer", jsonrpcNegotiation{Desc: sdp}, &answer, s.opts.offerTimeout, ErrOfferTimeout); err != nil {
+			log.Errorf("[%v] err=%v", s.id, err)
+			return
+		}
+		if err := s.onSetRemoteSDP(answer); err != nil {
+			log.Errorf("[%v] [offer] s.OnSetRemoteSDP err=%v", s.id, err)
+		}
+	}()
+}
+
+// Answer send the sub answer
+func (s *JSONRPCSignal) Answer(sdp webrtc.SessionDescription) {
+	log.Infof("[%v] [JSONRPCSignal.Answer] sdp=%v", s.id, sdp)
+	if err := s.conn.Notify(s.ctx, "answer", jsonrpcNegotiation{Desc: sdp}); err != nil {
+		log.Errorf("[%v] err=%v", s.id, err)
+	}
+}
+
+// Trickle send a candidate
+func (s *JSONRPCSignal) Trickle(candidate *webrtc.ICECandidate, target int) {
+	log.Infof("[%v] [JSONRPCSignal.Trickle] candidate=%v target=%v", s.id, candidate, target)
+	if err := s.conn.Notify(s.ctx, "trickle", jsonrpcTrickle{Target: target, Candidate: candidate.ToJSON()}); err != nil {
+		log.Errorf("[%v] err=%v", s.id, err)
+	}
+}
+
+// setToken do nothing, the json-rpc token is sent in the join config
+func (s *JSONRPCSignal) setToken(token string) {}
+
+// Close close the websocket
+func (s *JSONRPCSignal) Close() {
+	log.Infof("[%v] [JSONRPCSignal.Close]", s.id)
+	s.conn.Close()
+}
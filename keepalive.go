@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const defaultKeepaliveTimeout = 5 * time.Second
+
+func (c Config) keepaliveTimeout() time.Duration {
+	if c.KeepaliveTimeout <= 0 {
+		return defaultKeepaliveTimeout
+	}
+	return c.KeepaliveTimeout
+}
+
+// ping call the grpc health check of the sfu, any answer even unimplemented prove the sfu is alive
+// it return false only if there is no answer in timeout
+func ping(ctx context.Context, conn *grpc.ClientConn, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return status.Code(err) != codes.DeadlineExceeded
+}
+
+// keepalive ping the sfu every interval on the connection of the stream, the stream is canceled with
+// ErrSignalTimeout if a ping is not answered, unlike the http/2 pings of grpc the interval is not limited
+func (s *GRPCSignal) keepalive() {
+	ticker := time.NewTicker(s.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+		if !ping(s.ctx, s.conn, s.keepaliveTimeout) && s.ctx.Err() == nil {
+			log.Warnf("[%v] no ping answer in %v, cancel the stream", s.id, s.keepaliveTimeout)
+			atomic.StoreInt32(&s.timedOut, 1)
+			s.cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/pion/ion-sfu/cmd/signal/grpc/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// idleSFU keep the signal streams open without answering
+type idleSFU struct {
+	pb.UnimplementedSFUServer
+}
+
+func (idleSFU) Signal(stream pb.SFU_SignalServer) error {
+	<-stream.Context().Done()
+	return nil
+}
+
+// stuckHealth never answer the health checks, as a half-open connection
+type stuckHealth struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (stuckHealth) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGRPCKeepalive(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		health  healthpb.HealthServer
+		timeout bool
+	}{
+		// ion-sfu has no health service, unimplemented is an answer
+		{"unimplemented", nil, false},
+		{"stuck", stuckHealth{}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lis := bufconn.Listen(1 << 16)
+			srv := grpc.NewServer()
+			pb.RegisterSFUServer(srv, idleSFU{})
+			if tc.health != nil {
+				healthpb.RegisterHealthServer(srv, tc.health)
+			}
+			go srv.Serve(lis)
+			defer srv.Stop()
+			conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return lis.Dial()
+			}))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			s, err := newSignalWithConn(conn, "keepalive", nil)
+			require.NoError(t, err)
+			s.keepaliveInterval, s.keepaliveTimeout = 20*time.Millisecond, 50*time.Millisecond
+			errs := make(chan error, 1)
+			s.OnError(func(err error) { errs <- err })
+			go s.onSignalHandleOnce()
+			defer s.Close()
+
+			select {
+			case err := <-errs:
+				assert.True(t, tc.timeout, "unexpected error %v", err)
+				assert.Equal(t, ErrSignalTimeout, err)
+			case <-time.After(300 * time.Millisecond):
+				assert.False(t, tc.timeout, "no timeout")
+			}
+		})
+	}
+}
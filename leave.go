@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// LeaveReason tell the peers why a client left
+type LeaveReason string
+
+const (
+	// LeaveNormal is a leave by Client.Close
+	LeaveNormal LeaveReason = "normal"
+	// LeaveError is a leave on a lost signaling or a stuck client
+	LeaveError LeaveReason = "error"
+	// LeaveKicked is a leave asked by the application or the server
+	LeaveKicked LeaveReason = "kicked"
+	// LeaveMigrate is a leave before joining another sfu node
+	LeaveMigrate LeaveReason = "migrate"
+
+	// leaveTimeout is the max wait for the leave to be sent
+	leaveTimeout = 500 * time.Millisecond
+)
+
+// leaver is implemented by signals able to end the session gracefully
+type leaver interface {
+	leave(reason LeaveReason)
+}
+
+// CloseWithReason send a leave to the peers and the sfu, then close the client
+// the peers get the reason by OnPeerLeave, the sfu protocol has no reason so it only see the end of the signaling
+func (c *Client) CloseWithReason(reason LeaveReason) {
+	c.closeOnce.Do(func() {
+		log.Debugf("id=%v leave reason=%v", c.uid, reason)
+		c.sendLeave(reason)
+		c.close()
+	})
+}
+
+// sendLeave announce the leave on the metadata channel and end the signal stream
+func (c *Client) sendLeave(reason LeaveReason) {
+	c.metaLock.RLock()
+	dc := c.metaDC
+	c.metaLock.RUnlock()
+	if dc != nil && dc.ReadyState() == webrtc.DataChannelStateOpen {
+		msg, err := json.Marshal(peerMetadata{UID: c.uid, Leave: reason})
+		if err == nil {
+			if err := dc.Send(msg); err != nil {
+				log.Errorf("id=%v send leave err=%v", c.uid, err)
+			}
+			// the message is lost if the pc is closed before it is sent
+			deadline := time.Now().Add(leaveTimeout)
+			for dc.BufferedAmount() > 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	if l, ok := c.getSignal().(leaver); ok {
+		l.leave(reason)
+	}
+}
+
+// onPeerLeave forget the metadata of a peer and call OnPeerLeave
+func (c *Client) onPeerLeave(uid string, reason LeaveReason) {
+	c.metaLock.Lock()
+	delete(c.peerMeta, uid)
+	c.metaLock.Unlock()
+	if c.OnPeerLeave != nil {
+		c.engine.dispatcher.Dispatch(func() { c.OnPeerLeave(uid, reason) })
+	}
+}
+
+// leave half-close the stream, the sfu close the peer at once, then wait for the sfu to end it
+func (s *GRPCSignal) leave(reason LeaveReason) {
+	s.Lock()
+	stream := s.stream
+	if stream != nil {
+		if err := stream.CloseSend(); err != nil {
+			log.Debugf("[%v] leave err=%v", s.id, err)
+		}
+	}
+	s.Unlock()
+	if stream == nil {
+		return
+	}
+	select {
+	case <-s.done:
+	case <-time.After(leaveTimeout):
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"reflect"
+	"sync/atomic"
+
+	ilog "github.com/pion/ion-log"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is the logger used by sdk, *logrus.Logger satisfies it
+type Logger interface {
+	Tracef(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// levelSetter is implemented by loggers which support Config.LogLevel
+type levelSetter interface {
+	SetLevel(level logrus.Level)
+}
+
+// loggerBox keep the concrete type of the atomic value constant
+type loggerBox struct {
+	Logger
+}
+
+// sdkLogger delegate to the current logger, it is replaced by SetLogger while the sdk goroutines log
+type sdkLogger struct {
+	v atomic.Value
+}
+
+func newSDKLogger(l Logger) *sdkLogger {
+	s := &sdkLogger{}
+	s.v.Store(loggerBox{l})
+	return s
+}
+
+func (s *sdkLogger) get() Logger {
+	return s.v.Load().(loggerBox).Logger
+}
+
+func (s *sdkLogger) Tracef(format string, args ...interface{}) { s.get().Tracef(format, args...) }
+func (s *sdkLogger) Debugf(format string, args ...interface{}) { s.get().Debugf(format, args...) }
+func (s *sdkLogger) Infof(format string, args ...interface{})  { s.get().Infof(format, args...) }
+func (s *sdkLogger) Warnf(format string, args ...interface{})  { s.get().Warnf(format, args...) }
+func (s *sdkLogger) Errorf(format string, args ...interface{}) { s.get().Errorf(format, args...) }
+
+var (
+	log = newSDKLogger(ilog.NewLoggerWithFields(ilog.WarnLevel, "engine", nil))
+)
+
+// SetLogger replace the sdk logger, nil is ignored
+// the logger is process wide, it is shared by all engines
+func SetLogger(l Logger) {
+	if l == nil {
+		return
+	}
+	log.v.Store(loggerBox{l})
+}
+
+// sameLogger compare two loggers, the loggers of a type which can not be compared are different
+func sameLogger(a, b Logger) bool {
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return a == nil && b == nil
+	}
+	return a == b
+}
+
+func setLogLevel(level string) {
+	if level == "" {
+		return
+	}
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		log.Errorf("invalid log level %v: %v", level, err)
+		return
+	}
+	if ls, ok := log.get().(levelSetter); ok {
+		ls.SetLevel(l)
+		return
+	}
+	log.Warnf("logger does not support setting level %v", level)
+}
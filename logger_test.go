@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// levelLogger record the levels set
+type levelLogger struct {
+	Logger
+	mu     sync.Mutex
+	levels []logrus.Level
+}
+
+func (l *levelLogger) SetLevel(level logrus.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.levels = append(l.levels, level)
+}
+
+func TestSetLoggerWhileLogging(t *testing.T) {
+	defer SetLogger(log.get())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				log.Tracef("logging %v", j)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		SetLogger(logrus.New())
+	}
+	wg.Wait()
+}
+
+func TestUpdateConfigLogLevel(t *testing.T) {
+	defer SetLogger(log.get())
+	l := &levelLogger{Logger: logrus.New()}
+	a := NewEngine(Config{Logger: l, LogLevel: "debug"})
+	defer a.Close()
+	b := NewEngine(Config{})
+	defer b.Close()
+	assert.Equal(t, []logrus.Level{logrus.DebugLevel}, l.levels)
+
+	// an update of another setting keep the level and the logger of the process
+	cfg := b.getConfig()
+	cfg.MaxSendBitrate = 1000000
+	b.UpdateConfig(cfg)
+	cfg = a.getConfig()
+	cfg.ConnPoolSize = 2
+	a.UpdateConfig(cfg)
+	assert.Equal(t, []logrus.Level{logrus.DebugLevel}, l.levels)
+	assert.Equal(t, l, log.get())
+
+	cfg.LogLevel = "error"
+	a.UpdateConfig(cfg)
+	assert.Equal(t, []logrus.Level{logrus.DebugLevel, logrus.ErrorLevel}, l.levels)
+
+	assert.True(t, sameLogger(nil, nil))
+	assert.False(t, sameLogger(l, nil))
+	assert.True(t, sameLogger(l, l))
+}
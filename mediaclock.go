@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// maxMediaLag is how late a producer can fall before its clock is reset
+const maxMediaLag = 500 * time.Millisecond
+
+// mediaClock pace the frames of a producer by their timestamps against the wall clock
+// every wait is from the start so the sleeps do not drift, a producer late by more than maxMediaLag,
+// e.g. throttled by the pacer or a slow reader, is re-anchored instead of bursting to catch up
+type mediaClock struct {
+	start time.Time
+}
+
+func newMediaClock() *mediaClock {
+	return &mediaClock{start: time.Now()}
+}
+
+// reset make ts the current time, e.g. after a seek or a pause
+func (c *mediaClock) reset(ts time.Duration) {
+	c.start = time.Now().Add(-ts)
+}
+
+// wait until the time of ts, it return false if done is closed
+func (c *mediaClock) wait(ts time.Duration, done <-chan struct{}) bool {
+	wait := ts - time.Since(c.start)
+	if wait < -maxMediaLag {
+		log.Debugf("media clock late by %v, reset", -wait)
+		c.reset(ts)
+	}
+	if wait <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// pauseGate hold the read loop of a producer while paused, the track is kept
+// the sample due at the pause is sent on resume with the pause added to its duration,
+// so the rtp timestamps jump by the pause like an unmuted source
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{}
+	since   time.Time
+}
+
+// Pause halt the samples until Resume
+func (g *pauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+		g.since = time.Now()
+	}
+}
+
+// Resume the samples after Pause
+func (g *pauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// waitResume block while paused, it return the paused time and false if done is closed
+func (g *pauseGate) waitResume(done <-chan struct{}) (time.Duration, bool) {
+	g.mu.Lock()
+	resumed, since := g.resumed, g.since
+	g.mu.Unlock()
+	if resumed == nil {
+		return 0, true
+	}
+	select {
+	case <-done:
+		return 0, false
+	case <-resumed:
+		return time.Since(since), true
+	}
+}
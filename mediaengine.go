@@ -7,13 +7,15 @@ import (
 
 const (
 	mimeTypeH264 = "video/h264"
+	mimeTypeH265 = "video/h265"
+	mimeTypeAV1  = "video/av1"
 	mimeTypeOpus = "audio/opus"
 	mimeTypeVP8  = "video/vp8"
 	mimeTypeVP9  = "video/vp9"
 )
 
 var (
-	videoRTCPFeedback       = []webrtc.RTCPFeedback{{"goog-remb", ""}, {"ccm", "fir"}, {"nack", ""}, {"nack", "pli"}}
+	videoRTCPFeedback       = []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
 	videoRTPCodecParameters = []webrtc.RTPCodecParameters{
 		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
@@ -47,21 +49,42 @@ var (
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032", RTCPFeedback: videoRTCPFeedback},
 			PayloadType:        123,
 		},
+		{
+			RTPCodecCapability: h265Codec,
+			PayloadType:        h265PayloadType,
+		},
+		{
+			RTPCodecCapability: av1Codec,
+			PayloadType:        av1PayloadType,
+		},
 	}
 )
 
+// h265PayloadType and av1PayloadType are free in the default codecs, so the subscriber could add them
+const (
+	opusPayloadType = 111
+	h265PayloadType = 126
+	av1PayloadType  = 45
+)
+
+var (
+	h265Codec = webrtc.RTPCodecCapability{MimeType: mimeTypeH265, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback}
+	av1Codec  = webrtc.RTPCodecCapability{MimeType: mimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback}
+)
+
 const frameMarking = "urn:ietf:params:rtp-hdrext:framemarking"
 
-func getPublisherMediaEngine(mime string) (*webrtc.MediaEngine, error) {
+// getPublisherMediaEngine register the video codecs of prefs first, so they are first in the offers
+func getPublisherMediaEngine(mime string, prefs []string, fec FECConfig) (*webrtc.MediaEngine, error) {
 	me := &webrtc.MediaEngine{}
 	if err := me.RegisterCodec(webrtc.RTPCodecParameters{
 		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1", RTCPFeedback: nil},
-		PayloadType:        111,
+		PayloadType:        opusPayloadType,
 	}, webrtc.RTPCodecTypeAudio); err != nil {
 		return nil, err
 	}
 
-	for _, codec := range videoRTPCodecParameters {
+	for _, codec := range orderCodecs(videoRTPCodecParameters, prefs) {
 		// register all if mime == ""
 		if mime == "" {
 			if err := me.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
@@ -77,6 +100,10 @@ func getPublisherMediaEngine(mime string) (*webrtc.MediaEngine, error) {
 		}
 	}
 
+	if err := registerFECCodecs(me, fec); err != nil {
+		return nil, err
+	}
+
 	for _, extension := range []string{
 		sdp.SDESMidURI,
 		sdp.SDESRTPStreamIDURI,
@@ -102,6 +129,21 @@ func getPublisherMediaEngine(mime string) (*webrtc.MediaEngine, error) {
 
 func getSubscriberMediaEngine() (*webrtc.MediaEngine, error) {
 	me := &webrtc.MediaEngine{}
-	me.RegisterDefaultCodecs()
+	if err := me.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	// receive h265 and av1 from the sfus negotiating them
+	for _, codec := range []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: h265Codec, PayloadType: h265PayloadType},
+		{RTPCodecCapability: av1Codec, PayloadType: av1PayloadType},
+	} {
+		if err := me.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+	// the levels of the audio tracks, see MeterAudio
+	if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
 	return me, nil
 }
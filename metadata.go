@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// metadataChannel is fanned out by sfu to the peers of the session
+	metadataChannel = "ion-sdk-metadata"
+	// metadataPrefix prefix the metadata keys in the join config, for the biz layer
+	metadataPrefix = "meta."
+)
+
+type peerMetadata struct {
+	UID      string            `json:"uid"`
+	Metadata map[string]string `json:"metadata"`
+	// Leave is set when the peer is leaving
+	Leave LeaveReason `json:"leave,omitempty"`
+}
+
+// SetMetadata attach metadata like display name, role or device to the join
+func (j JoinConfig) SetMetadata(meta map[string]string) *JoinConfig {
+	for k, v := range meta {
+		j[metadataPrefix+k] = v
+	}
+	return &j
+}
+
+func (j JoinConfig) metadata() map[string]string {
+	meta := make(map[string]string)
+	for k, v := range j {
+		if strings.HasPrefix(k, metadataPrefix) {
+			meta[strings.TrimPrefix(k, metadataPrefix)] = v
+		}
+	}
+	return meta
+}
+
+// Metadata return the metadata of this client set at join
+func (c *Client) Metadata() map[string]string {
+	c.metaLock.RLock()
+	defer c.metaLock.RUnlock()
+	return copyMetadata(c.metadata)
+}
+
+// PeerMetadata return the metadata of a remote peer, nil if unknown
+func (c *Client) PeerMetadata(uid string) map[string]string {
+	c.metaLock.RLock()
+	defer c.metaLock.RUnlock()
+	meta, ok := c.peerMeta[uid]
+	if !ok {
+		return nil
+	}
+	return copyMetadata(meta)
+}
+
+// createMetadataChannel announce the metadata to the peers when the channel is open
+// the channel is also used to announce the leave, so it is created without metadata
+func (c *Client) createMetadataChannel(meta map[string]string) error {
+	c.metaLock.Lock()
+	c.metadata = meta
+	c.metaLock.Unlock()
+	dc, err := c.getPub().pc.CreateDataChannel(metadataChannel, &webrtc.DataChannelInit{})
+	if err != nil {
+		return err
+	}
+	c.metaLock.Lock()
+	c.metaDC = dc
+	c.metaLock.Unlock()
+	dc.OnOpen(c.announceMetadata)
+	return nil
+}
+
+func (c *Client) announceMetadata() {
+	c.metaLock.RLock()
+	dc := c.metaDC
+	msg, err := json.Marshal(peerMetadata{UID: c.uid, Metadata: c.metadata})
+	empty := len(c.metadata) == 0
+	c.metaLock.RUnlock()
+	if empty || dc == nil || err != nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	if err := dc.Send(msg); err != nil {
+		log.Errorf("id=%v announce metadata err=%v", c.uid, err)
+	}
+}
+
+// onMetadataChannel receive the metadata of peers
+// announce again on a new peer, so the peers joined later know us
+func (c *Client) onMetadataChannel(dc *webrtc.DataChannel) {
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var m peerMetadata
+		if err := json.Unmarshal(msg.Data, &m); err != nil || m.UID == "" || m.UID == c.uid {
+			return
+		}
+		if m.Leave != "" {
+			c.onPeerLeave(m.UID, m.Leave)
+			return
+		}
+		c.metaLock.Lock()
+		_, known := c.peerMeta[m.UID]
+		c.peerMeta[m.UID] = m.Metadata
+		c.metaLock.Unlock()
+		if !known {
+			c.announceMetadata()
+		}
+		if c.OnPeerMetadata != nil {
+			c.engine.dispatcher.Dispatch(func() { c.OnPeerMetadata(m.UID, copyMetadata(m.Metadata)) })
+		}
+	})
+}
+
+func copyMetadata(meta map[string]string) map[string]string {
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,272 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// matroska element ids, with the length marker
+const (
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvTracks        = 0x1654AE6B
+	mkvTrackEntry    = 0xAE
+	mkvTrackNumber   = 0xD7
+	mkvTrackType     = 0x83
+	mkvCodecID       = 0x86
+	mkvCodecPrivate  = 0x63A2
+	mkvCluster       = 0x1F43B675
+	mkvTimecode      = 0xE7
+	mkvSimpleBlock   = 0xA3
+	mkvBlockGroup    = 0xA0
+	mkvBlock         = 0xA1
+
+	mkvTrackTypeVideo = 1
+	mkvTrackTypeAudio = 2
+
+	// mkvMaxElementSize guard against garbage in the stream
+	mkvMaxElementSize = 64 << 20
+	// mkvUnknownSize is the size of live segments and clusters
+	mkvUnknownSize = -1
+)
+
+// mkvTrack is a track entry of a matroska stream
+type mkvTrack struct {
+	number  uint64
+	kind    string
+	codecID string
+	private []byte
+}
+
+// mkvBlockData is a frame of a track
+type mkvBlockData struct {
+	track uint64
+	time  time.Duration
+	data  []byte
+}
+
+// mkvReader read a matroska/webm stream without seeking, e.g. ffmpeg -f matroska pipe:1
+// the masters are entered instead of parsed as a whole, so live streams of unknown size work
+// laced blocks are dropped, ffmpeg does not lace by default
+type mkvReader struct {
+	r           *bufio.Reader
+	tracks      []*mkvTrack
+	scale       uint64
+	clusterTime uint64
+}
+
+// mkvElements is the elements read, the others are skipped
+var mkvElements = map[uint32]bool{
+	mkvTimecodeScale: true,
+	mkvTrackNumber:   true,
+	mkvTrackType:     true,
+	mkvCodecID:       true,
+	mkvCodecPrivate:  true,
+	mkvTimecode:      true,
+	mkvSimpleBlock:   true,
+	mkvBlock:         true,
+}
+
+var mkvMasters = map[uint32]bool{
+	mkvSegment:    true,
+	mkvInfo:       true,
+	mkvTracks:     true,
+	mkvTrackEntry: true,
+	mkvCluster:    true,
+	mkvBlockGroup: true,
+}
+
+// newMKVReader read the stream until the first cluster, so the tracks are known
+func newMKVReader(r io.Reader) (*mkvReader, error) {
+	m := &mkvReader{r: bufio.NewReader(r), scale: 1000000}
+	for {
+		id, size, err := m.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if id == mkvCluster {
+			if len(m.tracks) == 0 {
+				return nil, errInvalidFile
+			}
+			return m, nil
+		}
+		if mkvMasters[id] {
+			if id == mkvTrackEntry {
+				m.tracks = append(m.tracks, &mkvTrack{})
+			}
+			continue
+		}
+		data, err := m.readElement(id, size)
+		if err != nil {
+			return nil, err
+		}
+		m.header(id, data)
+	}
+}
+
+// header handle the elements of info and tracks
+func (m *mkvReader) header(id uint32, data []byte) {
+	if id == mkvTimecodeScale {
+		if v := readUint(data); v > 0 {
+			m.scale = v
+		}
+		return
+	}
+	if len(m.tracks) == 0 {
+		return
+	}
+	t := m.tracks[len(m.tracks)-1]
+	switch id {
+	case mkvTrackNumber:
+		t.number = readUint(data)
+	case mkvTrackType:
+		switch readUint(data) {
+		case mkvTrackTypeVideo:
+			t.kind = "video"
+		case mkvTrackTypeAudio:
+			t.kind = "audio"
+		}
+	case mkvCodecID:
+		t.codecID = string(data)
+	case mkvCodecPrivate:
+		t.private = data
+	}
+}
+
+// nextBlock return the next frame of the stream
+func (m *mkvReader) nextBlock() (*mkvBlockData, error) {
+	for {
+		id, size, err := m.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if mkvMasters[id] {
+			continue
+		}
+		data, err := m.readElement(id, size)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case mkvTimecode:
+			m.clusterTime = readUint(data)
+		case mkvSimpleBlock, mkvBlock:
+			if b := m.block(data); b != nil {
+				return b, nil
+			}
+		}
+	}
+}
+
+func (m *mkvReader) block(data []byte) *mkvBlockData {
+	track, n := readVint(data)
+	if n <= 0 || len(data) < n+3 {
+		return nil
+	}
+	rel := int16(binary.BigEndian.Uint16(data[n:]))
+	if flags := data[n+2]; flags&0x06 != 0 {
+		log.Debugf("drop laced matroska block of track %v", track)
+		return nil
+	}
+	ts := int64(m.clusterTime) + int64(rel)
+	if ts < 0 {
+		ts = 0
+	}
+	return &mkvBlockData{
+		track: track,
+		time:  time.Duration(uint64(ts) * m.scale),
+		data:  data[n+3:],
+	}
+}
+
+// readHeader read an element id and size
+func (m *mkvReader) readHeader() (uint32, int64, error) {
+	buf, err := m.readVintBytes()
+	if err != nil {
+		return 0, 0, err
+	}
+	var id uint32
+	for _, b := range buf {
+		id = id<<8 | uint32(b)
+	}
+	buf, err = m.readVintBytes()
+	if err != nil {
+		return 0, 0, err
+	}
+	size, _ := readVint(buf)
+	if size == uint64(1)<<(7*uint(len(buf)))-1 {
+		return id, mkvUnknownSize, nil
+	}
+	return id, int64(size), nil
+}
+
+// readVintBytes read the bytes of a vint, with the marker
+func (m *mkvReader) readVintBytes() ([]byte, error) {
+	first, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	l := 1
+	for mask := byte(0x80); l <= 8 && first&mask == 0; mask >>= 1 {
+		l++
+	}
+	if l > 8 {
+		return nil, errInvalidFile
+	}
+	buf := make([]byte, l)
+	buf[0] = first
+	if _, err := io.ReadFull(m.r, buf[1:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readElement read the data of the element, nil if it is skipped
+func (m *mkvReader) readElement(id uint32, size int64) ([]byte, error) {
+	if size == mkvUnknownSize {
+		return nil, errInvalidFile
+	}
+	if !mkvElements[id] {
+		_, err := io.CopyN(ioutil.Discard, m.r, size)
+		return nil, err
+	}
+	if size > mkvMaxElementSize {
+		return nil, errInvalidFile
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(m.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readVint decode a vint without the marker, return its value and length
+func readVint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	l := 1
+	for mask := byte(0x80); l <= 8 && b[0]&mask == 0; mask >>= 1 {
+		l++
+	}
+	if l > 8 || len(b) < l {
+		return 0, 0
+	}
+	v := uint64(b[0]) & uint64(0xff>>uint(l))
+	for _, c := range b[1:l] {
+		v = v<<8 | uint64(c)
+	}
+	return v, l
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// rtpMonitor count rtp bytes per ssrc and record the time of the last rtp received by a transport
+type rtpMonitor struct {
+	interceptor.NoOp
+	t *Transport
+
+	sync.Mutex
+	bytes map[uint32]*uint64
+}
+
+func newRTPMonitor(t *Transport) *rtpMonitor {
+	return &rtpMonitor{
+		t:     t,
+		bytes: make(map[uint32]*uint64),
+	}
+}
+
+// counter return the byte counter of ssrc
+func (m *rtpMonitor) counter(ssrc uint32) *uint64 {
+	m.Lock()
+	defer m.Unlock()
+	cnt, ok := m.bytes[ssrc]
+	if !ok {
+		cnt = new(uint64)
+		m.bytes[ssrc] = cnt
+	}
+	return cnt
+}
+
+// Bytes return the rtp bytes of ssrc
+func (m *rtpMonitor) Bytes(ssrc uint32) uint64 {
+	m.Lock()
+	cnt, ok := m.bytes[ssrc]
+	m.Unlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(cnt)
+}
+
+// BindLocalStream wrap the writer to count sent bytes
+func (m *rtpMonitor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	cnt := m.counter(info.SSRC)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, a)
+		if err == nil {
+			atomic.AddUint64(cnt, uint64(n))
+		}
+		return n, err
+	})
+}
+
+// BindRemoteStream wrap the reader to count received bytes and update Transport.lastRecv
+func (m *rtpMonitor) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	cnt := m.counter(info.SSRC)
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err == nil {
+			atomic.AddUint64(cnt, uint64(n))
+			atomic.StoreInt64(&m.t.lastRecv, time.Now().UnixNano())
+		}
+		return n, attr, err
+	})
+}
@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// mp4Sample is a sample of a mp4 track in decode order
+type mp4Sample struct {
+	offset   int64
+	size     uint32
+	time     time.Duration
+	duration time.Duration
+	sync     bool
+}
+
+// mp4Track is a demuxed trak, only avc1 and Opus sample entries are kept
+type mp4Track struct {
+	kind      string
+	codec     string
+	timescale uint32
+	// h264 length size and parameter sets from avcC
+	lengthSize int
+	sps, pps   [][]byte
+	samples    []mp4Sample
+}
+
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// parseBoxes split data into its child boxes
+func parseBoxes(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, mp4Box{typ: typ, data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func findBox(boxes []mp4Box, path ...string) []byte {
+	for _, b := range boxes {
+		if b.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return b.data
+		}
+		return findBox(parseBoxes(b.data), path[1:]...)
+	}
+	return nil
+}
+
+// readMoov read the top level boxes of a file of fileSize until moov, the media data is read later by offset
+// fragmented mp4 is not supported, samples must be in the moov tables
+func readMoov(r io.ReadSeeker, fileSize int64) ([]byte, error) {
+	var offset int64
+	header := make([]byte, 16)
+	for {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return nil, errInvalidFile
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		hlen := int64(8)
+		switch size {
+		case 0:
+			// the last box extends to the end of file
+			size = fileSize - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return nil, errInvalidFile
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			hlen = 16
+		}
+		if size < hlen || size > fileSize-offset {
+			return nil, errInvalidFile
+		}
+		if typ == "moov" {
+			data := make([]byte, size-hlen)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, errInvalidFile
+			}
+			return data, nil
+		}
+		offset += size
+	}
+}
+
+// parseMP4 demux the tracks of a mp4 file
+func parseMP4(r io.ReadSeeker) ([]*mp4Track, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	moov, err := readMoov(r, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []*mp4Track
+	for _, b := range parseBoxes(moov) {
+		if b.typ != "trak" {
+			continue
+		}
+		t, err := parseTrak(parseBoxes(b.data), fileSize)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks, nil
+}
+
+// parseTrak return nil for tracks which are not audio or video
+func parseTrak(trak []mp4Box, fileSize int64) (*mp4Track, error) {
+	mdia := parseBoxes(findBox(trak, "mdia"))
+	hdlr := findBox(mdia, "hdlr")
+	mdhd := findBox(mdia, "mdhd")
+	if len(hdlr) < 12 || len(mdhd) < 24 {
+		return nil, errInvalidFile
+	}
+	t := &mp4Track{}
+	switch string(hdlr[8:12]) {
+	case "vide":
+		t.kind = "video"
+	case "soun":
+		t.kind = "audio"
+	default:
+		return nil, nil
+	}
+	if mdhd[0] == 1 {
+		if len(mdhd) < 32 {
+			return nil, errInvalidFile
+		}
+		t.timescale = binary.BigEndian.Uint32(mdhd[20:])
+	} else {
+		t.timescale = binary.BigEndian.Uint32(mdhd[12:])
+	}
+	if t.timescale == 0 {
+		return nil, errInvalidFile
+	}
+
+	stbl := parseBoxes(findBox(mdia, "minf", "stbl"))
+	if err := t.parseStsd(findBox(stbl, "stsd")); err != nil {
+		return nil, err
+	}
+	if err := t.parseSamples(stbl, fileSize); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseStsd read the codec of the first sample entry
+func (t *mp4Track) parseStsd(stsd []byte) error {
+	if len(stsd) < 8 {
+		return errInvalidFile
+	}
+	entries := parseBoxes(stsd[8:])
+	if len(entries) == 0 {
+		return errInvalidFile
+	}
+	entry := entries[0]
+	switch entry.typ {
+	case "avc1", "avc3":
+		// 8 bytes sample entry and 70 bytes visual sample entry before the child boxes
+		if len(entry.data) < 78 {
+			return errInvalidFile
+		}
+		avcC := findBox(parseBoxes(entry.data[78:]), "avcC")
+		if err := t.parseAvcC(avcC); err != nil {
+			return err
+		}
+		t.codec = mimeTypeH264
+	case "Opus":
+		t.codec = mimeTypeOpus
+	case "mp4a":
+		log.Errorf("mp4 aac audio is not supported, remux the audio to opus")
+		t.codec = ""
+	default:
+		log.Errorf("mp4 codec %v is not supported", entry.typ)
+	}
+	return nil
+}
+
+func (t *mp4Track) parseAvcC(b []byte) error {
+	if len(b) < 7 {
+		return errInvalidFile
+	}
+	t.lengthSize = int(b[4]&3) + 1
+	n := int(b[5] & 0x1f)
+	b = b[6:]
+	readSets := func(n int) ([][]byte, bool) {
+		var sets [][]byte
+		for i := 0; i < n; i++ {
+			if len(b) < 2 {
+				return nil, false
+			}
+			l := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+l {
+				return nil, false
+			}
+			sets = append(sets, b[2:2+l])
+			b = b[2+l:]
+		}
+		return sets, true
+	}
+	var ok bool
+	if t.sps, ok = readSets(n); !ok || len(b) < 1 {
+		return errInvalidFile
+	}
+	n = int(b[0])
+	b = b[1:]
+	if t.pps, ok = readSets(n); !ok {
+		return errInvalidFile
+	}
+	return nil
+}
+
+// parseSamples build the sample offsets and times from stsz, stco/co64, stsc, stts and stss
+// the samples are in a file of fileSize, it bound the count of uniform samples
+func (t *mp4Track) parseSamples(stbl []mp4Box, fileSize int64) error {
+	stsz := findBox(stbl, "stsz")
+	stsc := findBox(stbl, "stsc")
+	stts := findBox(stbl, "stts")
+	if len(stsz) < 12 || len(stsc) < 8 || len(stts) < 8 {
+		return errInvalidFile
+	}
+
+	uniform := binary.BigEndian.Uint32(stsz[4:])
+	count := int(binary.BigEndian.Uint32(stsz[8:]))
+	if uniform == 0 && len(stsz) < 12+4*count {
+		return errInvalidFile
+	}
+	if uniform > 0 && int64(count) > fileSize/int64(uniform) {
+		return errInvalidFile
+	}
+	t.samples = make([]mp4Sample, count)
+	for i := range t.samples {
+		t.samples[i].size = uniform
+		if uniform == 0 {
+			t.samples[i].size = binary.BigEndian.Uint32(stsz[12+4*i:])
+		}
+	}
+
+	var chunks []int64
+	if stco := findBox(stbl, "stco"); len(stco) >= 8 {
+		n := int(binary.BigEndian.Uint32(stco[4:]))
+		for i := 0; i < n && 8+4*i+4 <= len(stco); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(stco[8+4*i:])))
+		}
+	} else if co64 := findBox(stbl, "co64"); len(co64) >= 8 {
+		n := int(binary.BigEndian.Uint32(co64[4:]))
+		for i := 0; i < n && 8+8*i+8 <= len(co64); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co64[8+8*i:])))
+		}
+	}
+
+	// stsc entries are first chunk(1 based) and samples per chunk, until the next entry
+	entries := int(binary.BigEndian.Uint32(stsc[4:]))
+	sample := 0
+	for e := 0; e < entries && 8+12*e+12 <= len(stsc); e++ {
+		first := int(binary.BigEndian.Uint32(stsc[8+12*e:])) - 1
+		perChunk := int(binary.BigEndian.Uint32(stsc[8+12*e+4:]))
+		last := len(chunks)
+		if e+1 < entries && 8+12*(e+1)+4 <= len(stsc) {
+			last = int(binary.BigEndian.Uint32(stsc[8+12*(e+1):])) - 1
+		}
+		for c := first; c < last && c < len(chunks); c++ {
+			offset := chunks[c]
+			for s := 0; s < perChunk && sample < count; s++ {
+				t.samples[sample].offset = offset
+				offset += int64(t.samples[sample].size)
+				sample++
+			}
+		}
+	}
+	if sample < count {
+		return errInvalidFile
+	}
+
+	entries = int(binary.BigEndian.Uint32(stts[4:]))
+	sample = 0
+	var ts uint64
+	for e := 0; e < entries && 8+8*e+8 <= len(stts); e++ {
+		n := int(binary.BigEndian.Uint32(stts[8+8*e:]))
+		delta := uint64(binary.BigEndian.Uint32(stts[8+8*e+4:]))
+		for i := 0; i < n && sample < count; i++ {
+			t.samples[sample].time = t.duration(ts)
+			t.samples[sample].duration = t.duration(delta)
+			ts += delta
+			sample++
+		}
+	}
+
+	// without stss every sample is a sync sample
+	stss := findBox(stbl, "stss")
+	if len(stss) < 8 {
+		for i := range t.samples {
+			t.samples[i].sync = true
+		}
+		return nil
+	}
+	n := int(binary.BigEndian.Uint32(stss[4:]))
+	for i := 0; i < n && 8+4*i+4 <= len(stss); i++ {
+		if s := int(binary.BigEndian.Uint32(stss[8+4*i:])) - 1; s >= 0 && s < count {
+			t.samples[s].sync = true
+		}
+	}
+	return nil
+}
+
+func (t *mp4Track) duration(units uint64) time.Duration {
+	return time.Duration(units * uint64(time.Second) / uint64(t.timescale))
+}
+
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// annexB convert a length prefixed h264 sample to annex b, with the parameter sets before sync samples
+func (t *mp4Track) annexB(data []byte, sync bool) []byte {
+	out := make([]byte, 0, len(data)+64)
+	if sync {
+		for _, ps := range append(append([][]byte{}, t.sps...), t.pps...) {
+			out = append(out, annexBStartCode...)
+			out = append(out, ps...)
+		}
+	}
+	for len(data) >= t.lengthSize {
+		var l int
+		for i := 0; i < t.lengthSize; i++ {
+			l = l<<8 | int(data[i])
+		}
+		data = data[t.lengthSize:]
+		if l > len(data) {
+			break
+		}
+		out = append(out, annexBStartCode...)
+		out = append(out, data[:l]...)
+		data = data[l:]
+	}
+	return out
+}
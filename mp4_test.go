@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mp4Box32 return a box of typ with the uint32 fields and the payload
+func mp4Box32(typ string, fields []uint32, payload ...[]byte) []byte {
+	b := make([]byte, 8+4*len(fields))
+	copy(b[4:], typ)
+	for i, f := range fields {
+		binary.BigEndian.PutUint32(b[8+4*i:], f)
+	}
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func TestReadMoov(t *testing.T) {
+	ftyp := mp4Box32("ftyp", []uint32{0x69736f6d, 0})
+	moov := []byte("moov payload")
+	// a size of 1 is followed by the 64 bits size
+	large := []byte{0, 0, 0, 1, 'm', 'o', 'o', 'v', 0, 0, 0, 0, 0, 0, 0, byte(16 + len(moov))}
+	for _, tc := range []struct {
+		name string
+		file []byte
+		want []byte
+		err  error
+	}{
+		{"after ftyp", append(ftyp, mp4Box32("moov", nil, moov)...), moov, nil},
+		{"large size", append(append(ftyp, large...), moov...), moov, nil},
+		{"to the end of file", append(append(ftyp, 0, 0, 0, 0, 'm', 'o', 'o', 'v'), moov...), moov, nil},
+		{"over the end of file", append(append(ftyp, 0, 0, 0x10, 0, 'm', 'o', 'o', 'v'), moov...), nil, errInvalidFile},
+		{"over the end of file by large size", append(append(ftyp, large[:8]...), 0xff, 0, 0, 0, 0, 0, 0, 0), nil, errInvalidFile},
+		{"no moov", ftyp, nil, errInvalidFile},
+	} {
+		got, err := readMoov(bytes.NewReader(tc.file), int64(len(tc.file)))
+		assert.Equal(t, tc.err, err, tc.name)
+		assert.Equal(t, tc.want, got, tc.name)
+	}
+}
+
+func TestParseSamples(t *testing.T) {
+	// a chunk at 40 with 1 sample per chunk then 2 per chunk, a sample per second
+	stbl := func(uniform, count uint32, sizes ...uint32) []mp4Box {
+		return parseBoxes(bytes.Join([][]byte{
+			mp4Box32("stsz", append([]uint32{0, uniform, count}, sizes...)),
+			mp4Box32("stco", []uint32{0, 2, 40, 1000}),
+			mp4Box32("stsc", []uint32{0, 2, 1, 1, 1, 2, 2, 1}),
+			mp4Box32("stts", []uint32{0, 1, 3, 1000}),
+			mp4Box32("stss", []uint32{0, 1, 1}),
+		}, nil))
+	}
+	for _, tc := range []struct {
+		name     string
+		stbl     []mp4Box
+		fileSize int64
+		want     []mp4Sample
+		err      error
+	}{
+		{
+			name: "uniform", stbl: stbl(100, 3), fileSize: 1200,
+			want: []mp4Sample{
+				{offset: 40, size: 100, duration: time.Second, sync: true},
+				{offset: 1000, size: 100, time: time.Second, duration: time.Second},
+				{offset: 1100, size: 100, time: 2 * time.Second, duration: time.Second},
+			},
+		},
+		{
+			name: "table", stbl: stbl(0, 3, 10, 20, 30), fileSize: 1200,
+			want: []mp4Sample{
+				{offset: 40, size: 10, duration: time.Second, sync: true},
+				{offset: 1000, size: 20, time: time.Second, duration: time.Second},
+				{offset: 1020, size: 30, time: 2 * time.Second, duration: time.Second},
+			},
+		},
+		// the count is not allocated when the samples can not be in the file
+		{name: "uniform over the file", stbl: stbl(1, 0xffffffff), fileSize: 1200, err: errInvalidFile},
+		{name: "table over the box", stbl: stbl(0, 0xffffffff, 10), fileSize: 1200, err: errInvalidFile},
+	} {
+		track := &mp4Track{timescale: 1000}
+		err := track.parseSamples(tc.stbl, tc.fileSize)
+		assert.Equal(t, tc.err, err, tc.name)
+		if tc.err == nil {
+			require.NoError(t, err, tc.name)
+			assert.Equal(t, tc.want, track.samples, tc.name)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// MP4Producer publish h264 video and opus audio from a mp4 file, paced by the sample times
+// aac audio needs transcoding which is not supported without native libs, remux it to opus
+// b-frames are sent in decode order, baseline h264 is recommended
+type MP4Producer struct {
+	id         string
+	name       string
+	file       *os.File
+	tracks     []*mp4Track
+	selected   []*mp4Track
+	outputs    map[*mp4Track]*webrtc.TrackLocalStaticSample
+	videoTrack *webrtc.TrackLocalStaticSample
+	audioTrack *webrtc.TrackLocalStaticSample
+	sendByte   uint64
+	lastSend   uint64
+	seek       chan time.Duration
+	done       chan struct{}
+	stopOnce   sync.Once
+	pauseGate
+	progress
+	// Loop restart the file when it ends, the rtp timestamps and sequence numbers keep continuous
+	Loop bool
+}
+
+// NewMP4Producer open and demux a mp4 file
+func NewMP4Producer(id, name string) (*MP4Producer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Errorf("unable to open file %s", name)
+		return nil, err
+	}
+	tracks, err := parseMP4(f)
+	if err != nil {
+		f.Close()
+		log.Errorf("parse mp4 %v err=%v", name, err)
+		return nil, err
+	}
+	return &MP4Producer{
+		id:      id,
+		name:    name,
+		file:    f,
+		tracks:  tracks,
+		outputs: make(map[*mp4Track]*webrtc.TrackLocalStaticSample),
+		seek:    make(chan time.Duration, 1),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (t *MP4Producer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return t.audioTrack
+}
+
+func (t *MP4Producer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return t.videoTrack
+}
+
+// AddTrack add the first supported track of kind to pc
+func (t *MP4Producer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "video" && kind != "audio" {
+		return nil, errInvalidKind
+	}
+	var src *mp4Track
+	for _, tr := range t.tracks {
+		if tr.kind == kind && tr.codec != "" {
+			src = tr
+			break
+		}
+	}
+	if src == nil {
+		return nil, errUnsupportedCodec
+	}
+
+	streamID := fmt.Sprintf("mp4_%p", t)
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	if kind == "video" {
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, kind, streamID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.outputs[src] = track
+	t.selected = append(t.selected, src)
+	if kind == "video" {
+		t.videoTrack = track
+	} else {
+		t.audioTrack = track
+	}
+	return track, nil
+}
+
+// bindTrack write the first track of kind to track, e.g. a playlist track, the codecs must match
+func (t *MP4Producer) bindTrack(kind string, track *webrtc.TrackLocalStaticSample) error {
+	for _, tr := range t.tracks {
+		if tr.kind == kind && tr.codec != "" {
+			if !strings.EqualFold(tr.codec, track.Codec().MimeType) {
+				return errUnsupportedCodec
+			}
+			t.outputs[tr] = track
+			t.selected = append(t.selected, tr)
+			return nil
+		}
+	}
+	return errUnsupportedCodec
+}
+
+func (t *MP4Producer) Start() {
+	go t.readLoop()
+}
+
+func (t *MP4Producer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// SeekTo jump to the video sync sample at or before d, the audio restart at the same time
+func (t *MP4Producer) SeekTo(d time.Duration) error {
+	requestSeek(t.seek, d)
+	return nil
+}
+
+// seekIndex return the time of the sync sample at or before d and the next samples of the tracks
+func (t *MP4Producer) seekIndex(d time.Duration) (time.Duration, []int) {
+	target := d
+	for _, tr := range t.selected {
+		if tr.kind != "video" {
+			continue
+		}
+		target = 0
+		for _, s := range tr.samples {
+			if s.time > d {
+				break
+			}
+			if s.sync {
+				target = s.time
+			}
+		}
+	}
+	next := make([]int, len(t.selected))
+	for i, tr := range t.selected {
+		next[i] = sort.Search(len(tr.samples), func(j int) bool {
+			return tr.samples[j].time >= target
+		})
+	}
+	return target, next
+}
+
+// readLoop send the samples of the selected tracks interleaved by time
+func (t *MP4Producer) readLoop() {
+	defer t.file.Close()
+	clock := newMediaClock()
+	// the looped and seeked times are shifted by base, end is the end of the sent samples
+	var base, end time.Duration
+	next := make([]int, len(t.selected))
+	// the pause added to the next sample of the tracks
+	gaps := make(map[*mp4Track]time.Duration)
+	var readErr error
+	for {
+		select {
+		case d := <-t.seek:
+			var target time.Duration
+			target, next = t.seekIndex(d)
+			// the next sample follow the last one
+			base = end - target
+			log.Debugf("id=%v seek mp4 %v to %v", t.id, t.name, target)
+		default:
+		}
+		// pick the track with the earliest next sample
+		best := -1
+		for i, tr := range t.selected {
+			if next[i] >= len(tr.samples) {
+				continue
+			}
+			if best < 0 || tr.samples[next[i]].time < t.selected[best].samples[next[best]].time {
+				best = i
+			}
+		}
+		if best < 0 {
+			if !t.Loop || t.length() == 0 {
+				break
+			}
+			// the next pass start right after the last sample, the packetizers keep counting
+			base += t.length()
+			next = make([]int, len(t.selected))
+			log.Debugf("id=%v loop mp4 %v", t.id, t.name)
+			continue
+		}
+		tr := t.selected[best]
+		s := tr.samples[next[best]]
+		next[best]++
+
+		if !clock.wait(base+s.time, t.done) {
+			return
+		}
+		gap, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		t.setPosition(s.time)
+		if gap > 0 {
+			clock.reset(base + s.time)
+			for _, sel := range t.selected {
+				gaps[sel] += gap
+			}
+		}
+		if e := base + s.time + s.duration; e > end {
+			end = e
+		}
+
+		data := make([]byte, s.size)
+		if _, err := t.file.ReadAt(data, s.offset); err != nil {
+			log.Errorf("mp4 %v read err=%v", t.name, err)
+			readErr = err
+			break
+		}
+		if tr.codec == mimeTypeH264 {
+			data = tr.annexB(data, s.sync)
+		}
+		track := t.outputs[tr]
+		duration := s.duration + gaps[tr]
+		delete(gaps, tr)
+		if err := track.WriteSample(media.Sample{Data: data, Duration: duration}); err != nil {
+			log.Errorf("Track write error=%v", err)
+			continue
+		}
+		log.Tracef("id=%v mime=%v streamid=%v len=%v", t.id, track.Codec().MimeType, track.StreamID(), len(data))
+		atomic.AddUint64(&t.sendByte, uint64(len(data)))
+		t.sent(len(data))
+	}
+	// the last sample last its duration, e.g. before the next file of a playlist
+	if clock.wait(end, t.done) {
+		t.ended(readErr)
+	}
+	log.Infof("Exiting mp4 producer")
+}
+
+// length return the end time of the longest selected track
+func (t *MP4Producer) length() time.Duration {
+	var l time.Duration
+	for _, tr := range t.selected {
+		if n := len(tr.samples); n > 0 {
+			if end := tr.samples[n-1].time + tr.samples[n-1].duration; end > l {
+				l = end
+			}
+		}
+	}
+	return l
+}
+
+// GetSendBandwidth calc the sending bandwidth with cycle(s)
+func (t *MP4Producer) GetSendBandwidth(cycle int) int {
+	sendByte := atomic.LoadUint64(&t.sendByte)
+	bw := int(sendByte-t.lastSend) / cycle / 1000
+	t.lastSend = sendByte
+	return bw
+}
+
+// SendBytes return the total sent bytes
+func (t *MP4Producer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	// the stream types of the pmt
+	tsStreamH264    = 0x1b
+	tsStreamH265    = 0x24
+	tsStreamAAC     = 0x0f
+	tsStreamAACLATM = 0x11
+	tsStreamPrivate = 0x06
+	// tsWrap is the range of the 33 bit pes timestamps
+	tsWrap = 1 << 33
+)
+
+// tsStream is an elementary stream of the pmt, codec is h264, h265, aac, opus or empty
+type tsStream struct {
+	pid   uint16
+	codec string
+	// the pes being assembled
+	pes []byte
+}
+
+// tsFrame is an access unit of h264 or an opus packet
+type tsFrame struct {
+	pid  uint16
+	time time.Duration
+	data []byte
+}
+
+// tsReader demux the first program of a mpeg-ts stream, the sections must fit in a packet
+type tsReader struct {
+	r       io.Reader
+	pkt     [tsPacketSize]byte
+	pmtPID  int
+	streams []*tsStream
+	queue   []tsFrame
+	// the timestamps are unwrapped from last
+	last    int64
+	hasLast bool
+	eof     bool
+}
+
+// newTSReader read the stream until the pmt give the elementary streams
+func newTSReader(r io.Reader) (*tsReader, error) {
+	t := &tsReader{r: r, pmtPID: -1}
+	for t.streams == nil {
+		if err := t.readPacket(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// stream return the elementary stream of pid
+func (t *tsReader) stream(pid uint16) *tsStream {
+	for _, s := range t.streams {
+		if s.pid == pid {
+			return s
+		}
+	}
+	return nil
+}
+
+// readPacket read and handle a ts packet, it resync on the sync byte
+func (t *tsReader) readPacket() error {
+	b := t.pkt[:]
+	if _, err := io.ReadFull(t.r, b); err != nil {
+		return err
+	}
+	for b[0] != tsSyncByte {
+		i := bytes.IndexByte(b[1:], tsSyncByte)
+		if i < 0 {
+			i = len(b) - 1
+		}
+		n := copy(b, b[i+1:])
+		if _, err := io.ReadFull(t.r, b[n:]); err != nil {
+			return err
+		}
+	}
+	start := b[1]&0x40 != 0
+	pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+	payload := b[4:]
+	if b[3]&0x20 != 0 {
+		if int(b[4]) >= len(payload) {
+			return nil
+		}
+		payload = payload[1+int(b[4]):]
+	}
+	if b[3]&0x10 == 0 {
+		return nil
+	}
+	switch {
+	case pid == 0:
+		if start {
+			t.parsePAT(payload)
+		}
+	case int(pid) == t.pmtPID:
+		if start {
+			t.parsePMT(payload)
+		}
+	default:
+		s := t.stream(pid)
+		if s == nil || s.codec == "" {
+			return nil
+		}
+		if start {
+			t.flush(s)
+			s.pes = append(s.pes[:0], payload...)
+		} else if len(s.pes) > 0 {
+			s.pes = append(s.pes, payload...)
+		}
+	}
+	return nil
+}
+
+// section return the table of a psi payload, without the pointer field and the crc
+func tsSection(payload []byte, tableID byte) []byte {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0])+3 {
+		return nil
+	}
+	b := payload[1+int(payload[0]):]
+	if b[0] != tableID {
+		return nil
+	}
+	n := int(b[1]&0x0f)<<8 | int(b[2])
+	if n < 9 || len(b) < 3+n {
+		return nil
+	}
+	return b[:3+n-4]
+}
+
+func (t *tsReader) parsePAT(payload []byte) {
+	b := tsSection(payload, 0x00)
+	for i := 8; i+4 <= len(b); i += 4 {
+		program := int(b[i])<<8 | int(b[i+1])
+		// program 0 is the network pid
+		if program != 0 {
+			t.pmtPID = int(b[i+2]&0x1f)<<8 | int(b[i+3])
+			return
+		}
+	}
+}
+
+func (t *tsReader) parsePMT(payload []byte) {
+	b := tsSection(payload, 0x02)
+	if len(b) < 12 || t.streams != nil {
+		return
+	}
+	i := 12 + (int(b[10]&0x0f)<<8 | int(b[11]))
+	streams := []*tsStream{}
+	for i+5 <= len(b) {
+		typ := b[i]
+		pid := uint16(b[i+1]&0x1f)<<8 | uint16(b[i+2])
+		n := int(b[i+3]&0x0f)<<8 | int(b[i+4])
+		if i+5+n > len(b) {
+			break
+		}
+		s := &tsStream{pid: pid}
+		switch typ {
+		case tsStreamH264:
+			s.codec = "h264"
+		case tsStreamH265:
+			s.codec = "h265"
+		case tsStreamAAC, tsStreamAACLATM:
+			s.codec = "aac"
+		case tsStreamPrivate:
+			// opus has a registration descriptor
+			if bytes.Contains(b[i+5:i+5+n], []byte("Opus")) {
+				s.codec = "opus"
+			}
+		}
+		streams = append(streams, s)
+		i += 5 + n
+	}
+	t.streams = streams
+}
+
+// tsTimestamp decode a 33 bit pes timestamp
+func tsTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// unwrap extend a 33 bit timestamp past its wrap and convert it from 90khz
+func (t *tsReader) unwrap(ts int64) time.Duration {
+	if t.hasLast {
+		// the nearest value to the last timestamp
+		base := t.last - t.last%tsWrap
+		ts += base
+		if ts < t.last-tsWrap/2 {
+			ts += tsWrap
+		} else if ts > t.last+tsWrap/2 && ts >= tsWrap {
+			ts -= tsWrap
+		}
+	}
+	if !t.hasLast || ts > t.last {
+		t.last, t.hasLast = ts, true
+	}
+	return time.Duration(ts * 100000 / 9)
+}
+
+// flush parse the pes assembled for s into frames
+func (t *tsReader) flush(s *tsStream) {
+	b := s.pes
+	s.pes = s.pes[:0]
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return
+	}
+	flags := b[7] >> 6
+	start := 9 + int(b[8])
+	if start > len(b) || flags&0x02 == 0 || len(b) < 14 {
+		return
+	}
+	// the video is paced in decode order
+	ts := tsTimestamp(b[9:])
+	if flags == 0x03 && len(b) >= 19 {
+		ts = tsTimestamp(b[14:])
+	}
+	pts := t.unwrap(ts)
+	data := append([]byte{}, b[start:]...)
+	if s.codec != "opus" {
+		t.queue = append(t.queue, tsFrame{pid: s.pid, time: pts, data: data})
+		return
+	}
+	// the opus packets have a control header with their size
+	for len(data) >= 2 && data[0] == 0x7f && data[1]&0xe0 == 0xe0 {
+		trimStart, trimEnd, ext := data[1]&0x10 != 0, data[1]&0x08 != 0, data[1]&0x04 != 0
+		i, size := 2, 0
+		for i < len(data) {
+			size += int(data[i])
+			i++
+			if data[i-1] != 0xff {
+				break
+			}
+		}
+		if trimStart {
+			i += 2
+		}
+		if trimEnd {
+			i += 2
+		}
+		if ext && i < len(data) {
+			i += 1 + int(data[i])
+		}
+		if i+size > len(data) {
+			return
+		}
+		p := data[i : i+size]
+		t.queue = append(t.queue, tsFrame{pid: s.pid, time: pts, data: p})
+		pts += opusDuration(p)
+		data = data[i+size:]
+	}
+}
+
+// next return the next frame, the pes of the streams are flushed at the end
+func (t *tsReader) next() (tsFrame, error) {
+	for len(t.queue) == 0 {
+		if t.eof {
+			return tsFrame{}, io.EOF
+		}
+		if err := t.readPacket(); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return tsFrame{}, err
+			}
+			t.eof = true
+			for _, s := range t.streams {
+				t.flush(s)
+			}
+		}
+	}
+	f := t.queue[0]
+	t.queue = t.queue[1:]
+	return f, nil
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultNegotiationDebounce = 20 * time.Millisecond
+	defaultNegotiationTimeout  = 10 * time.Second
+)
+
+// negotiator coalesce negotiation requests into one offer and send offers one by one
+// a new offer is only sent after the answer of the previous one is received
+type negotiator struct {
+	sync.Mutex
+	debounce time.Duration
+	timeout  time.Duration
+	timer    *time.Timer
+	inFlight bool
+	pending  bool
+	// generation of the offer in flight, used to drop stale timeouts
+	gen   int
+	offer func() bool
+	// onTimeout is called when no answer arrived in timeout
+	onTimeout func()
+}
+
+func newNegotiator(debounce, timeout time.Duration, offer func() bool, onTimeout func()) *negotiator {
+	if debounce <= 0 {
+		debounce = defaultNegotiationDebounce
+	}
+	if timeout <= 0 {
+		timeout = defaultNegotiationTimeout
+	}
+	return &negotiator{
+		debounce:  debounce,
+		timeout:   timeout,
+		offer:     offer,
+		onTimeout: onTimeout,
+	}
+}
+
+// Request ask for a negotiation, requests in the debounce window are coalesced
+func (n *negotiator) Request() {
+	n.Lock()
+	defer n.Unlock()
+	if n.inFlight {
+		n.pending = true
+		return
+	}
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+	n.timer = time.AfterFunc(n.debounce, n.fire)
+}
+
+// Begin mark an offer sent outside negotiator in flight, e.g. the join offer
+func (n *negotiator) Begin() {
+	n.Lock()
+	defer n.Unlock()
+	n.begin()
+}
+
+// must be called with lock held
+func (n *negotiator) begin() {
+	n.inFlight = true
+	n.gen++
+	gen := n.gen
+	time.AfterFunc(n.timeout, func() {
+		n.Lock()
+		stale := gen != n.gen || !n.inFlight
+		n.Unlock()
+		if !stale {
+			log.Warnf("negotiation timeout, no answer in %v", n.timeout)
+			n.Done()
+			if n.onTimeout != nil {
+				n.onTimeout()
+			}
+		}
+	})
+}
+
+// Done is called when the answer is received, a pending request is scheduled
+func (n *negotiator) Done() {
+	n.Lock()
+	defer n.Unlock()
+	n.inFlight = false
+	if n.pending {
+		n.pending = false
+		if n.timer != nil {
+			n.timer.Stop()
+		}
+		n.timer = time.AfterFunc(n.debounce, n.fire)
+	}
+}
+
+func (n *negotiator) fire() {
+	n.Lock()
+	n.timer = nil
+	if n.inFlight {
+		n.pending = true
+		n.Unlock()
+		return
+	}
+	n.begin()
+	n.Unlock()
+
+	if !n.offer() {
+		n.Lock()
+		n.inFlight = false
+		n.Unlock()
+	}
+}
+
+// Stop cancel the scheduled negotiation
+func (n *negotiator) Stop() {
+	n.Lock()
+	defer n.Unlock()
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+	n.pending = false
+}
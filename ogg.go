@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	oggPageHeaderLen = 27
+	// oggNoGranule is the granule of a page where no packet ends
+	oggNoGranule = ^uint64(0)
+	opusRate     = 48000
+)
+
+// oggReader read the packets of a single stream ogg file, page by page
+type oggReader struct {
+	r       io.Reader
+	partial []byte
+}
+
+// nextPage return the packets completed in the next page and its granule position
+func (o *oggReader) nextPage() ([][]byte, uint64, error) {
+	h := make([]byte, oggPageHeaderLen)
+	if _, err := io.ReadFull(o.r, h); err != nil {
+		return nil, 0, err
+	}
+	if string(h[:4]) != "OggS" {
+		return nil, 0, errInvalidFile
+	}
+	granule := binary.LittleEndian.Uint64(h[6:])
+	lacing := make([]byte, h[26])
+	if _, err := io.ReadFull(o.r, lacing); err != nil {
+		return nil, 0, err
+	}
+	var packets [][]byte
+	for _, l := range lacing {
+		seg := make([]byte, l)
+		if _, err := io.ReadFull(o.r, seg); err != nil {
+			return nil, 0, err
+		}
+		o.partial = append(o.partial, seg...)
+		// a lacing value below 255 end the packet, 255 continue it
+		if l < 255 {
+			packets = append(packets, o.partial)
+			o.partial = nil
+		}
+	}
+	return packets, granule, nil
+}
+
+// opusHeader is the OpusHead of an ogg opus file, RFC 7845
+type opusHeader struct {
+	channels int
+	preSkip  uint64
+}
+
+func parseOpusHead(p []byte) (opusHeader, bool) {
+	if len(p) < 19 || string(p[:8]) != "OpusHead" {
+		return opusHeader{}, false
+	}
+	return opusHeader{channels: int(p[9]), preSkip: uint64(binary.LittleEndian.Uint16(p[10:]))}, true
+}
+
+// opusFrameSizes is the frame duration in 48khz samples by the config of the toc byte, RFC 6716
+var opusFrameSizes = [32]int{
+	480, 960, 1920, 2880, // silk nb
+	480, 960, 1920, 2880, // silk mb
+	480, 960, 1920, 2880, // silk wb
+	480, 960, // hybrid swb
+	480, 960, // hybrid fb
+	120, 240, 480, 960, // celt nb
+	120, 240, 480, 960, // celt wb
+	120, 240, 480, 960, // celt swb
+	120, 240, 480, 960, // celt fb
+}
+
+// opusDuration return the duration of an opus packet from its toc
+func opusDuration(p []byte) time.Duration {
+	if len(p) == 0 {
+		return 0
+	}
+	frames := 1
+	switch p[0] & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(p) < 2 {
+			return 0
+		}
+		frames = int(p[1] & 0x3f)
+	}
+	samples := opusFrameSizes[p[0]>>3] * frames
+	return time.Duration(samples) * time.Second / opusRate
+}
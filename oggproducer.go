@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// OggProducer publish the audio of an ogg opus file, paced by the granule positions
+type OggProducer struct {
+	id         string
+	name       string
+	file       *os.File
+	reader     *oggReader
+	header     opusHeader
+	audioTrack *webrtc.TrackLocalStaticSample
+	sendByte   uint64
+	lastSend   uint64
+	seek       chan time.Duration
+	done       chan struct{}
+	stopOnce   sync.Once
+	pauseGate
+	progress
+	// Loop restart the file when it ends, the rtp timestamps and sequence numbers keep continuous
+	Loop bool
+}
+
+// NewOggProducer open an .ogg/.opus file and read its opus header
+func NewOggProducer(id, name string) (*OggProducer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Errorf("unable to open file %s", name)
+		return nil, err
+	}
+	p := &OggProducer{
+		id:     id,
+		name:   name,
+		file:   f,
+		reader: &oggReader{r: f},
+		seek:   make(chan time.Duration, 1),
+		done:   make(chan struct{}),
+	}
+	packets, _, err := p.reader.nextPage()
+	if err != nil || len(packets) == 0 {
+		f.Close()
+		return nil, errInvalidFile
+	}
+	var ok bool
+	if p.header, ok = parseOpusHead(packets[0]); !ok {
+		f.Close()
+		log.Errorf("%v is not an ogg opus file", name)
+		return nil, errUnsupportedCodec
+	}
+	return p, nil
+}
+
+func (t *OggProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return t.audioTrack
+}
+
+// AddTrack add the opus track to pc, the file has no video
+func (t *OggProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "audio" {
+		return nil, errInvalidKind
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2},
+		"audio", fmt.Sprintf("ogg_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.audioTrack = track
+	return track, nil
+}
+
+// bindTrack write the opus packets to track, e.g. a playlist track
+func (t *OggProducer) bindTrack(kind string, track *webrtc.TrackLocalStaticSample) error {
+	if kind != "audio" || !strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus) {
+		return errUnsupportedCodec
+	}
+	t.audioTrack = track
+	return nil
+}
+
+func (t *OggProducer) Start() {
+	go t.readLoop()
+}
+
+func (t *OggProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// SeekTo jump to the page with the packets at d, every opus packet can start the decoding
+func (t *OggProducer) SeekTo(d time.Duration) error {
+	requestSeek(t.seek, d)
+	return nil
+}
+
+// granuleTime return the time of a granule position without the pre-skip
+func (t *OggProducer) granuleTime(granule uint64) time.Duration {
+	if granule <= t.header.preSkip {
+		return 0
+	}
+	return time.Duration(granule-t.header.preSkip) * time.Second / opusRate
+}
+
+// seekPage restart the file and skip the pages ending before d
+// it return the end time of the skipped pages and the first kept page
+func (t *OggProducer) seekPage(d time.Duration) (time.Duration, [][]byte, uint64, error) {
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return 0, nil, 0, err
+	}
+	t.reader = &oggReader{r: t.file}
+	var start time.Duration
+	for {
+		packets, granule, err := t.reader.nextPage()
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		// no packet end in the page
+		if granule == oggNoGranule {
+			continue
+		}
+		end := t.granuleTime(granule)
+		if end > d {
+			return start, packets, granule, nil
+		}
+		start = end
+	}
+}
+
+// readLoop send the packets at their time, resynced to the granule position at each page end
+func (t *OggProducer) readLoop() {
+	defer t.file.Close()
+	clock := newMediaClock()
+	// base is the start of the current pass when looping, or shifted by a seek
+	var pos, base time.Duration
+	var end error
+	for {
+		var packets [][]byte
+		var granule uint64
+		var err error
+		select {
+		case d := <-t.seek:
+			var start time.Duration
+			start, packets, granule, err = t.seekPage(d)
+			// the next packet follow the last one
+			base = pos - start
+			log.Debugf("id=%v seek ogg %v to %v", t.id, t.name, start)
+		default:
+			packets, granule, err = t.reader.nextPage()
+		}
+		if err == io.EOF && t.Loop && pos > base {
+			if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+				log.Errorf("ogg %v seek err=%v", t.name, err)
+				end = err
+				break
+			}
+			t.reader = &oggReader{r: t.file}
+			base = pos
+			log.Debugf("id=%v loop ogg %v", t.id, t.name)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("ogg %v read err=%v", t.name, err)
+				end = err
+			}
+			break
+		}
+		for _, p := range packets {
+			// skip the headers and empty packets
+			if len(p) == 0 || (len(p) >= 8 && (string(p[:8]) == "OpusHead" || string(p[:8]) == "OpusTags")) {
+				continue
+			}
+			if !clock.wait(pos, t.done) {
+				return
+			}
+			gap, ok := t.waitResume(t.done)
+			if !ok {
+				return
+			}
+			t.setPosition(pos - base)
+			if gap > 0 {
+				clock.reset(pos)
+			}
+			d := opusDuration(p)
+			pos += d
+			if t.audioTrack == nil {
+				continue
+			}
+			if err := t.audioTrack.WriteSample(media.Sample{Data: p, Duration: d + gap}); err != nil {
+				log.Errorf("Track write error=%v", err)
+				continue
+			}
+			atomic.AddUint64(&t.sendByte, uint64(len(p)))
+			t.sent(len(p))
+		}
+		if granule != oggNoGranule && len(packets) > 0 && granule > t.header.preSkip {
+			pos = base + t.granuleTime(granule)
+		}
+	}
+	// the last sample last its duration, e.g. before the next file of a playlist
+	if clock.wait(pos, t.done) {
+		t.ended(end)
+	}
+	log.Infof("Exiting ogg producer")
+}
+
+// GetSendBandwidth calc the sending bandwidth with cycle(s)
+func (t *OggProducer) GetSendBandwidth(cycle int) int {
+	sendByte := atomic.LoadUint64(&t.sendByte)
+	bw := int(sendByte-t.lastSend) / cycle / 1000
+	t.lastSend = sendByte
+	return bw
+}
+
+// SendBytes return the total sent bytes
+func (t *OggProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// pacer limit the sending bitrate with a token bucket of one second burst, the rtp of all the published
+// tracks is paced by pacing, from the producers of the sdk or written by the app
+type pacer struct {
+	sync.Mutex
+	bitrate int
+	tokens  float64
+	last    time.Time
+}
+
+func newPacer(bitrate int) *pacer {
+	return &pacer{
+		bitrate: bitrate,
+		tokens:  float64(bitrate) / 8,
+		last:    time.Now(),
+	}
+}
+
+// SetBitrate change the limit in bits/s, 0 means unlimited
+func (p *pacer) SetBitrate(bitrate int) {
+	p.Lock()
+	defer p.Unlock()
+	p.bitrate = bitrate
+}
+
+// Wait block until n bytes could be sent
+func (p *pacer) Wait(n int) {
+	p.Lock()
+	if p.bitrate <= 0 {
+		p.Unlock()
+		return
+	}
+	now := time.Now()
+	rate := float64(p.bitrate) / 8
+	p.tokens += now.Sub(p.last).Seconds() * rate
+	if p.tokens > rate {
+		p.tokens = rate
+	}
+	p.last = now
+	p.tokens -= float64(n)
+	var delay time.Duration
+	if p.tokens < 0 {
+		delay = time.Duration(-p.tokens / rate * float64(time.Second))
+	}
+	p.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// pacing is the interceptor waiting for the pacer of the client before writing the rtp of the local streams
+// it is the writer next to the network, so fec and rtx are paced with the media
+type pacing struct {
+	interceptor.NoOp
+	pacer atomic.Value
+}
+
+func (p *pacing) setPacer(pacer *pacer) {
+	p.pacer.Store(pacer)
+}
+
+// BindLocalStream wrap the writer to wait for the pacer
+func (p *pacing) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		if pacer, ok := p.pacer.Load().(*pacer); ok && pacer != nil {
+			pacer.Wait(header.MarshalSize() + len(payload))
+		}
+		return writer.Write(header, payload, a)
+	})
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacing(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pacer   *pacer
+		packets int
+		// min and max of the time to write the packets
+		min, max time.Duration
+	}{
+		{"no pacer", nil, 20, 0, 50 * time.Millisecond},
+		{"unlimited", newPacer(0), 20, 0, 50 * time.Millisecond},
+		{"within the burst", newPacer(800000), 80, 0, 50 * time.Millisecond},
+		// 10KB/s, the first second of burst then 5KB
+		{"over the burst", newPacer(80000), 150, 450 * time.Millisecond, 800 * time.Millisecond},
+	} {
+		p := &pacing{}
+		if tc.pacer != nil {
+			p.setPacer(tc.pacer)
+		}
+		written := 0
+		w := p.BindLocalStream(&interceptor.StreamInfo{SSRC: 1}, interceptor.RTPWriterFunc(
+			func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+				written++
+				return header.MarshalSize() + len(payload), nil
+			}))
+		// 100 bytes with the 12 bytes header
+		payload := make([]byte, 88)
+		start := time.Now()
+		for i := 0; i < tc.packets; i++ {
+			_, err := w.Write(&rtp.Header{SequenceNumber: uint16(i)}, payload, nil)
+			assert.NoError(t, err)
+		}
+		elapsed := time.Since(start)
+		assert.Equal(t, tc.packets, written, tc.name)
+		assert.True(t, elapsed >= tc.min && elapsed <= tc.max, "%v: %v", tc.name, elapsed)
+	}
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// pauser drop the rtp of paused local streams, the transceiver and negotiation are kept
+// the sequence numbers of the packets sent after a pause are shifted by the dropped packets so sfu see no
+// loss to nack, a resumed video is sent again from a key frame, asked with request until it comes
+type pauser struct {
+	interceptor.NoOp
+
+	sync.Mutex
+	streams map[uint32]*pausedStream
+}
+
+type pausedStream struct {
+	paused bool
+	// resumed is true until the first packet sent after a pause
+	resumed bool
+	// dropped is the shift of the sequence numbers
+	dropped   uint16
+	request   func()
+	requested time.Time
+}
+
+func newPauser() *pauser {
+	return &pauser{
+		streams: make(map[uint32]*pausedStream),
+	}
+}
+
+// SetPaused pause or resume sending ssrc, request ask a key frame of a resumed video
+func (p *pauser) SetPaused(ssrc uint32, paused bool, request func()) {
+	p.Lock()
+	defer p.Unlock()
+	s := p.streams[ssrc]
+	if s == nil {
+		s = &pausedStream{}
+		p.streams[ssrc] = s
+	}
+	if paused == s.paused {
+		return
+	}
+	s.paused, s.request, s.requested = paused, request, time.Time{}
+	if !paused {
+		s.resumed = true
+	}
+}
+
+// keep tell if the packet is sent and return the shift of its sequence number, the key frame request is
+// returned while a resumed video wait for a key frame
+func (p *pauser) keep(ssrc uint32, video bool, keyFrame func() bool) (bool, uint16, func()) {
+	p.Lock()
+	defer p.Unlock()
+	s := p.streams[ssrc]
+	if s == nil {
+		return true, 0, nil
+	}
+	if !s.paused && s.resumed && (!video || keyFrame()) {
+		s.resumed = false
+	}
+	if !s.paused && !s.resumed {
+		return true, s.dropped, nil
+	}
+	s.dropped++
+	if s.paused || s.request == nil || time.Since(s.requested) < keyFrameRequestInterval {
+		return false, 0, nil
+	}
+	s.requested = time.Now()
+	return false, 0, s.request
+}
+
+// BindLocalStream wrap the writer to drop the paused packets and shift the sequence numbers of the others
+func (p *pauser) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	ssrc, mime := info.SSRC, info.MimeType
+	video := strings.HasPrefix(strings.ToLower(mime), "video/")
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		keep, shift, request := p.keep(ssrc, video, func() bool { return keyFrameStart(mime, payload) })
+		if request != nil {
+			request()
+		}
+		if !keep {
+			return header.MarshalSize() + len(payload), nil
+		}
+		if shift != 0 {
+			h := *header
+			h.SequenceNumber -= shift
+			header = &h
+		}
+		return writer.Write(header, payload, a)
+	})
+}
+
+// PauseTrack stop sending rtp of the published track, the track is still negotiated
+func (c *Client) PauseTrack(trackID string) error {
+	return c.setTrackPaused(trackID, true)
+}
+
+// ResumeTrack resume sending rtp of a paused track, a video resume at its next key frame which is asked with a
+// pli to OnPublisherRTCP
+func (c *Client) ResumeTrack(trackID string) error {
+	return c.setTrackPaused(trackID, false)
+}
+
+func (c *Client) setTrackPaused(trackID string, paused bool) error {
+	sender := c.getSender(trackID)
+	if sender == nil {
+		return errInvalidTrack
+	}
+	log.Debugf("id=%v track=%v paused=%v", c.uid, trackID, paused)
+	for _, enc := range sender.GetParameters().Encodings {
+		ssrc := uint32(enc.SSRC)
+		// the producer of the track answer the pli of OnPublisherRTCP as those of sfu
+		c.getPub().pauser.SetPaused(ssrc, paused, func() {
+			if c.OnPublisherRTCP != nil {
+				c.engine.dispatcher.Dispatch(func() { c.OnPublisherRTCP(&rtcp.PictureLossIndication{MediaSSRC: ssrc}, trackID) })
+			}
+		})
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauser(t *testing.T) {
+	// the first payload byte tell a vp8 key frame, a descriptor with the start bit then a frame without the
+	// inter frame bit
+	key, delta := []byte{0x10, 0x00, 0x00, 0x00}, []byte{0x10, 0x01, 0x00, 0x00}
+	for _, tc := range []struct {
+		name string
+		mime string
+		// the payloads written, p pause and r resume before the next
+		writes []string
+		// the sequence numbers sent
+		want     []uint16
+		requests int
+	}{
+		{"not paused", mimeTypeOpus, []string{"a", "a", "a"}, []uint16{1, 2, 3}, 0},
+		{"audio", mimeTypeOpus, []string{"a", "p", "a", "a", "r", "a", "a"}, []uint16{1, 2, 3}, 0},
+		{"video at key frame", mimeTypeVP8, []string{"k", "d", "p", "d", "r", "k", "d"}, []uint16{1, 2, 3, 4}, 0},
+		{"video wait key frame", mimeTypeVP8, []string{"k", "p", "d", "r", "d", "d", "k", "d"}, []uint16{1, 2, 3}, 1},
+		{"pause twice", mimeTypeVP8, []string{"k", "p", "d", "r", "d", "k", "p", "d", "r", "k"}, []uint16{1, 2, 3}, 1},
+	} {
+		p := newPauser()
+		var sent []uint16
+		w := p.BindLocalStream(&interceptor.StreamInfo{SSRC: 1, MimeType: tc.mime}, interceptor.RTPWriterFunc(
+			func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+				sent = append(sent, header.SequenceNumber)
+				return len(payload), nil
+			}))
+		requests, seq := 0, uint16(0)
+		for _, op := range tc.writes {
+			switch op {
+			case "p", "r":
+				p.SetPaused(1, op == "p", func() { requests++ })
+				continue
+			}
+			payload := []byte{0x01}
+			switch op {
+			case "k":
+				payload = key
+			case "d":
+				payload = delta
+			}
+			seq++
+			h := &rtp.Header{SequenceNumber: seq}
+			_, err := w.Write(h, payload, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, seq, h.SequenceNumber, "%v: the header of the caller is kept", tc.name)
+		}
+		assert.Equal(t, tc.want, sent, tc.name)
+		assert.Equal(t, tc.requests, requests, tc.name)
+	}
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// payloadMTU is the rtp payload size of pion sample tracks
+const payloadMTU = 1200
+
+// sampleWriter is a local track written by samples
+type sampleWriter interface {
+	webrtc.TrackLocal
+	WriteSample(s media.Sample) error
+}
+
+// payloadTrack is a sample track with a payloader of the sdk, for the codecs pion can not packetize
+type payloadTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	packetizer rtp.Packetizer
+	clockRate  uint32
+}
+
+func newPayloadTrack(codec webrtc.RTPCodecCapability, id, streamID string, payloader rtp.Payloader) (*payloadTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(codec, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadTrack{
+		TrackLocalStaticRTP: track,
+		// the ssrc and payload type are set by the track
+		packetizer: rtp.NewPacketizer(payloadMTU, 0, 0, payloader, rtp.NewRandomSequencer(), codec.ClockRate),
+		clockRate:  codec.ClockRate,
+	}, nil
+}
+
+// WriteSample packetize and write a frame
+func (t *payloadTrack) WriteSample(s media.Sample) error {
+	samples := uint32(s.Duration.Seconds() * float64(t.clockRate))
+	for _, pkt := range t.packetizer.Packetize(s.Data, samples) {
+		if err := t.WriteRTP(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
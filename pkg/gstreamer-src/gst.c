@@ -85,4 +85,12 @@ void gstreamer_send_stop_pipeline(GstElement *pipeline) {
   gst_element_set_state(pipeline, GST_STATE_NULL);
 }
 
+void gstreamer_send_pause_pipeline(GstElement *pipeline) {
+  gst_element_set_state(pipeline, GST_STATE_PAUSED);
+}
+
+void gstreamer_send_play_pipeline(GstElement *pipeline) {
+  gst_element_set_state(pipeline, GST_STATE_PLAYING);
+}
+
 
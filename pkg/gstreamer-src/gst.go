@@ -58,6 +58,19 @@ func CreatePipeline(codecName string, tracks []*webrtc.TrackLocalStaticSample, p
 		pipelineStr = pipelineSrc + " ! video/x-raw,format=I420 ! x264enc speed-preset=ultrafast tune=zerolatency key-int-max=20 ! video/x-h264,stream-format=byte-stream ! " + pipelineStr
 		clockRate = videoClockRate
 
+	// the hardware h264 encoders of vaapi, nvidia and macos
+	case "h264-vaapi":
+		pipelineStr = pipelineSrc + " ! videoconvert ! vaapih264enc rate-control=cbr keyframe-period=20 max-bframes=0 ! h264parse config-interval=-1 ! video/x-h264,stream-format=byte-stream,alignment=au ! " + pipelineStr
+		clockRate = videoClockRate
+
+	case "h264-nvenc":
+		pipelineStr = pipelineSrc + " ! videoconvert ! nvh264enc preset=low-latency-hp rc-mode=cbr zerolatency=true gop-size=20 bframes=0 ! h264parse config-interval=-1 ! video/x-h264,stream-format=byte-stream,alignment=au ! " + pipelineStr
+		clockRate = videoClockRate
+
+	case "h264-videotoolbox":
+		pipelineStr = pipelineSrc + " ! videoconvert ! vtenc_h264 realtime=true allow-frame-reordering=false max-keyframe-interval=20 ! h264parse config-interval=-1 ! video/x-h264,stream-format=byte-stream,alignment=au ! " + pipelineStr
+		clockRate = videoClockRate
+
 	case "opus":
 		pipelineStr = pipelineSrc + " ! opusenc ! " + pipelineStr
 		clockRate = audioClockRate
@@ -106,6 +119,16 @@ func (p *Pipeline) Stop() {
 	C.gstreamer_send_stop_pipeline(p.Pipeline)
 }
 
+// SetPaused pause the GStreamer Pipeline, the encoder produce no frame until it is playing again so the
+// frames after the resume refer to the last sent
+func (p *Pipeline) SetPaused(paused bool) {
+	if paused {
+		C.gstreamer_send_pause_pipeline(p.Pipeline)
+	} else {
+		C.gstreamer_send_play_pipeline(p.Pipeline)
+	}
+}
+
 //export goHandlePipelineBuffer
 func goHandlePipelineBuffer(buffer unsafe.Pointer, bufferLen C.int, duration C.int, pipelineID C.int) {
 	pipelinesLock.Lock()
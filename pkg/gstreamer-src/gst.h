@@ -11,6 +11,8 @@ extern void goHandlePipelineBuffer(void *buffer, int bufferLen, int samples, int
 GstElement *gstreamer_send_create_pipeline(char *pipeline);
 void gstreamer_send_start_pipeline(GstElement *pipeline, int pipelineId);
 void gstreamer_send_stop_pipeline(GstElement *pipeline);
+void gstreamer_send_pause_pipeline(GstElement *pipeline);
+void gstreamer_send_play_pipeline(GstElement *pipeline);
 void gstreamer_send_start_mainloop(void);
 
 #endif
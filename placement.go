@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+const (
+	// PlacementRoundRobin pick sfu nodes in turn
+	PlacementRoundRobin = "roundrobin"
+	// PlacementLeastLoaded pick the sfu node with fewest clients
+	PlacementLeastLoaded = "leastloaded"
+	// PlacementSessionAffinity pick the same sfu node for the same session
+	PlacementSessionAffinity = "affinity"
+)
+
+// PickNode select a sfu addr from Config.SFUAddrs by Config.Placement
+// sid is used by session affinity, the others ignore it
+func (e *Engine) PickNode(sid string) (string, error) {
+	cfg := e.getConfig()
+	nodes := cfg.SFUAddrs
+	if len(nodes) == 0 {
+		return "", errNoSFUNode
+	}
+
+	switch cfg.Placement {
+	case PlacementLeastLoaded:
+		loads := e.pool.Loads()
+		best := nodes[0]
+		for _, addr := range nodes[1:] {
+			if loads[addr] < loads[best] {
+				best = addr
+			}
+		}
+		return best, nil
+	case PlacementSessionAffinity:
+		if sid != "" {
+			h := fnv.New32a()
+			h.Write([]byte(sid))
+			return nodes[h.Sum32()%uint32(len(nodes))], nil
+		}
+		fallthrough
+	default:
+		n := atomic.AddUint32(&e.nextNode, 1) - 1
+		return nodes[n%uint32(len(nodes))], nil
+	}
+}
+
+// place move a client picked before the session was known to the node of sid by session affinity
+// it is called at join, nothing was negotiated on the first node
+func (c *Client) place(sid string) error {
+	if !c.placed || c.engine.getConfig().Placement != PlacementSessionAffinity {
+		return nil
+	}
+	addr, err := c.engine.PickNode(sid)
+	if err != nil || addr == c.Addr() {
+		return err
+	}
+	s, err := c.engine.newSignal(addr, c.uid)
+	if err != nil {
+		return err
+	}
+	if ts, ok := s.(tokenSetter); ok {
+		ts.setToken(c.getToken())
+	}
+	c.bindSignal(s)
+	c.signalLock.Lock()
+	old := c.signal
+	c.signal, c.addr = s, addr
+	c.pub.signal = s
+	if c.sub != nil {
+		c.sub.signal = s
+	}
+	c.signalLock.Unlock()
+	old.Close()
+	log.Infof("id=%v placed on %v for session %v", c.uid, addr, sid)
+	return nil
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var placementNodes = []string{"127.0.0.1:50051", "127.0.0.1:50052", "127.0.0.1:50053"}
+
+func TestPickNode(t *testing.T) {
+	for _, tc := range []struct {
+		placement string
+		sids      []string
+		want      []string
+	}{
+		{PlacementRoundRobin, []string{"a", "a", "a", "a"}, []string{placementNodes[0], placementNodes[1], placementNodes[2], placementNodes[0]}},
+		// no load yet, the first node is the least loaded
+		{PlacementLeastLoaded, []string{"a", "b"}, []string{placementNodes[0], placementNodes[0]}},
+		// the same session on the same node, round robin without session
+		{PlacementSessionAffinity, []string{"s1", "s1", "", ""}, nil},
+	} {
+		e := NewEngine(Config{SFUAddrs: placementNodes, Placement: tc.placement})
+		var got []string
+		for _, sid := range tc.sids {
+			addr, err := e.PickNode(sid)
+			require.NoError(t, err)
+			got = append(got, addr)
+		}
+		if tc.want != nil {
+			assert.Equal(t, tc.want, got, tc.placement)
+		} else {
+			assert.Equal(t, got[0], got[1])
+			assert.NotEqual(t, got[2], got[3])
+		}
+		e.Close()
+	}
+
+	_, err := NewEngine(Config{}).PickNode("")
+	assert.Equal(t, errNoSFUNode, err)
+}
+
+func TestAffinityAtJoin(t *testing.T) {
+	e := NewEngine(Config{SFUAddrs: placementNodes, Placement: PlacementSessionAffinity})
+	defer e.Close()
+	want, err := e.PickNode("room")
+	require.NoError(t, err)
+
+	// the clients are created without session on every node, they all move to the node of the session
+	for range placementNodes {
+		c, err := NewClient(e, "", "")
+		require.NoError(t, err)
+		require.NoError(t, c.place("room"))
+		assert.Equal(t, want, c.addr)
+		assert.Equal(t, c.getSignal(), c.pub.signal)
+		// the sub is created after, at join
+		assert.Nil(t, c.sub)
+		c.Close()
+	}
+}
@@ -0,0 +1,324 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// playlistItem is a file producer which can write to the tracks of a playlist
+type playlistItem interface {
+	bindTrack(kind string, track *webrtc.TrackLocalStaticSample) error
+	readLoop()
+	Stop()
+	SendBytes() uint64
+	SeekTo(d time.Duration) error
+	Pause()
+	Resume()
+	progressOf() *progress
+}
+
+// Playlist return a closed channel of files for NewPlaylistProducer
+func Playlist(files ...string) <-chan string {
+	c := make(chan string, len(files))
+	for _, f := range files {
+		c <- f
+	}
+	close(c)
+	return c
+}
+
+// PlaylistProducer play files back to back on the same tracks, the rtp timestamps and sequence
+// numbers keep continuous between the files, e.g. for a 24/7 simulated channel
+// the files are webm, mp4, ogg or ivf, a file without the codecs of the tracks is skipped
+type PlaylistProducer struct {
+	id         string
+	files      <-chan string
+	videoCodec webrtc.RTPCodecCapability
+	videoTrack *webrtc.TrackLocalStaticSample
+	audioTrack *webrtc.TrackLocalStaticSample
+	done       chan struct{}
+	stopOnce   sync.Once
+	// OnProgress and OnEnd are for the whole playlist, OnEnd is called once files is closed and played
+	progress
+	// OnFileEnd is called when a file ends or fails to read, not after Stop
+	OnFileEnd func(file string, err error)
+
+	mu      sync.Mutex
+	current playlistItem
+	paused  bool
+	// the bytes and frames of the finished files
+	sendByte uint64
+	frames   uint64
+}
+
+// NewPlaylistProducer play the files received until files is closed, videoCodec is vp8|vp9|h264, vp8 if empty
+func NewPlaylistProducer(id, videoCodec string, files <-chan string) (*PlaylistProducer, error) {
+	var codec webrtc.RTPCodecCapability
+	switch strings.ToLower(videoCodec) {
+	case "", "vp8":
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	case "vp9":
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}
+	case "h264":
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}
+	default:
+		return nil, errUnsupportedCodec
+	}
+	return &PlaylistProducer{
+		id:         id,
+		files:      files,
+		videoCodec: codec,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func (t *PlaylistProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return t.audioTrack
+}
+
+func (t *PlaylistProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return t.videoTrack
+}
+
+// AddTrack add the video or opus track of the playlist to pc
+func (t *PlaylistProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "video" && kind != "audio" {
+		return nil, errInvalidKind
+	}
+	codec := t.videoCodec
+	if kind == "audio" {
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2}
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, kind, fmt.Sprintf("playlist_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	if kind == "video" {
+		t.videoTrack = track
+	} else {
+		t.audioTrack = track
+	}
+	return track, nil
+}
+
+func (t *PlaylistProducer) Start() {
+	go t.playLoop()
+}
+
+func (t *PlaylistProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.current != nil {
+			t.current.Stop()
+		}
+	})
+}
+
+// SeekTo seek in the current file
+func (t *PlaylistProducer) SeekTo(d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current == nil {
+		return errNotSeekable
+	}
+	return t.current.SeekTo(d)
+}
+
+// Pause halt the current file, the next files start paused until Resume
+func (t *PlaylistProducer) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = true
+	if t.current != nil {
+		t.current.Pause()
+	}
+}
+
+func (t *PlaylistProducer) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = false
+	if t.current != nil {
+		t.current.Resume()
+	}
+}
+
+// open open a file and bind it to the tracks of the playlist
+func (t *PlaylistProducer) open(file string) (playlistItem, error) {
+	var item playlistItem
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".webm":
+		w := NewWebMProducer(t.id, file, 0)
+		if w == nil {
+			return nil, errInvalidFile
+		}
+		w.Loop = false
+		item = w
+	case ".mp4", ".m4a", ".m4v":
+		m, err := NewMP4Producer(t.id, file)
+		if err != nil {
+			return nil, err
+		}
+		item = m
+	case ".ogg", ".opus":
+		o, err := NewOggProducer(t.id, file)
+		if err != nil {
+			return nil, err
+		}
+		item = o
+	case ".ivf":
+		i, err := NewIVFProducer(t.id, file)
+		if err != nil {
+			return nil, err
+		}
+		item = i
+	default:
+		return nil, errInvalidFile
+	}
+	bound := false
+	for kind, track := range map[string]*webrtc.TrackLocalStaticSample{"video": t.videoTrack, "audio": t.audioTrack} {
+		if track == nil {
+			continue
+		}
+		if err := item.bindTrack(kind, track); err != nil {
+			log.Debugf("id=%v playlist %v has no %v track", t.id, file, kind)
+			continue
+		}
+		bound = true
+	}
+	if !bound {
+		item.Stop()
+		return nil, errUnsupportedCodec
+	}
+	return item, nil
+}
+
+// playLoop play the files one after the other, in the goroutine of the file producers
+func (t *PlaylistProducer) playLoop() {
+	for {
+		var file string
+		var ok bool
+		select {
+		case <-t.done:
+			return
+		case file, ok = <-t.files:
+		}
+		if !ok {
+			break
+		}
+		item, err := t.open(file)
+		if err != nil {
+			log.Errorf("id=%v playlist skip %v err=%v", t.id, file, err)
+			continue
+		}
+		t.mu.Lock()
+		select {
+		case <-t.done:
+			t.mu.Unlock()
+			item.Stop()
+			return
+		default:
+		}
+		t.current = item
+		t.follow(file, item.progressOf())
+		if t.paused {
+			item.Pause()
+		}
+		t.mu.Unlock()
+
+		log.Infof("id=%v playlist play %v", t.id, file)
+		item.readLoop()
+		item.Stop()
+
+		t.mu.Lock()
+		t.current = nil
+		t.sendByte += item.SendBytes()
+		t.frames += item.progressOf().Progress().Frames
+		t.mu.Unlock()
+	}
+	t.ended(nil)
+	log.Infof("Exiting playlist producer")
+}
+
+// follow forward the callbacks of the file p to the playlist
+func (t *PlaylistProducer) follow(file string, p *progress) {
+	p.OnProgress = func(s ProducerProgress) {
+		t.mu.Lock()
+		s.Frames += t.frames
+		t.mu.Unlock()
+		if t.OnProgress != nil {
+			t.OnProgress(s)
+		}
+	}
+	p.OnEnd = func(err error) {
+		if t.OnFileEnd != nil {
+			t.OnFileEnd(file, err)
+		}
+	}
+}
+
+// Progress return the position in the current file and the frames of the playlist
+func (t *PlaylistProducer) Progress() ProducerProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var s ProducerProgress
+	if t.current != nil {
+		s = t.current.progressOf().Progress()
+	}
+	s.Frames += t.frames
+	return s
+}
+
+// SendBytes return the total sent bytes
+func (t *PlaylistProducer) SendBytes() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sendByte := t.sendByte
+	if t.current != nil {
+		sendByte += t.current.SendBytes()
+	}
+	return sendByte
+}
+
+// PublishPlaylist publish the files back to back on the same tracks, see NewPlaylistProducer
+func (c *Client) PublishPlaylist(videoCodec string, files <-chan string, video, audio bool) error {
+	pub := c.getPub()
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewPlaylistProducer(c.uid, videoCodec, files)
+	if err != nil {
+		return err
+	}
+	c.setProducer(p)
+	if video {
+		if _, err := p.AddTrack(pub.pc, "video"); err != nil {
+			return err
+		}
+	}
+	if audio {
+		if _, err := p.AddTrack(pub.pc, "audio"); err != nil {
+			return err
+		}
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
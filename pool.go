@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+type pooledConn struct {
+	conn *grpc.ClientConn
+	ref  int
+}
+
+// connPool share grpc connections to the same addr between clients
+type connPool struct {
+	sync.Mutex
+	size int
+	// maxStreams fill a connection with this many streams before dialing another, 0 spread at once
+	maxStreams  int
+	conns       map[string][]*pooledConn
+	dialOptions func(addr string) ([]grpc.DialOption, error)
+}
+
+func newConnPool(size int, dialOptions func(addr string) ([]grpc.DialOption, error)) *connPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &connPool{
+		size:        size,
+		conns:       make(map[string][]*pooledConn),
+		dialOptions: dialOptions,
+	}
+}
+
+// SetSize change the max connections per addr, existing connections are kept
+func (p *connPool) SetSize(size int) {
+	if size <= 0 {
+		size = 1
+	}
+	p.Lock()
+	p.size = size
+	p.Unlock()
+}
+
+// SetMaxStreams change the streams multiplexed on a connection before dialing another
+func (p *connPool) SetMaxStreams(n int) {
+	p.Lock()
+	p.maxStreams = n
+	p.Unlock()
+}
+
+// full check the least used connection should not take more streams
+// must be called with lock held
+func (p *connPool) full(best *pooledConn) bool {
+	if p.maxStreams > 0 {
+		return best.ref >= p.maxStreams
+	}
+	return best.ref > 0
+}
+
+// Get return the least used connection to addr, dial a new one if the pool is not full
+func (p *connPool) Get(addr string) (*grpc.ClientConn, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	var best *pooledConn
+	for _, pc := range p.conns[addr] {
+		if best == nil || pc.ref < best.ref {
+			best = pc
+		}
+	}
+
+	if best == nil || (p.full(best) && len(p.conns[addr]) < p.size) {
+		opts, err := p.dialOptions(addr)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, opts...)
+		if err != nil {
+			log.Errorf("Connecting to sfu:%s failed: %v", addr, err)
+			return nil, err
+		}
+		log.Infof("Connecting to sfu ok: %s", addr)
+		best = &pooledConn{conn: conn}
+		p.conns[addr] = append(p.conns[addr], best)
+	}
+
+	best.ref++
+	return best.conn, nil
+}
+
+// Put release a connection got from Get, it is closed when no one use it
+func (p *connPool) Put(addr string, conn *grpc.ClientConn) {
+	p.Lock()
+	defer p.Unlock()
+	list := p.conns[addr]
+	for i, pc := range list {
+		if pc.conn != conn {
+			continue
+		}
+		pc.ref--
+		if pc.ref <= 0 {
+			pc.conn.Close()
+			p.conns[addr] = append(list[:i], list[i+1:]...)
+			if len(p.conns[addr]) == 0 {
+				delete(p.conns, addr)
+			}
+		}
+		return
+	}
+}
+
+// Loads return the number of clients using connections to each addr
+func (p *connPool) Loads() map[string]int {
+	p.Lock()
+	defer p.Unlock()
+	loads := make(map[string]int, len(p.conns))
+	for addr, list := range p.conns {
+		for _, pc := range list {
+			loads[addr] += pc.ref
+		}
+	}
+	return loads
+}
+
+// States return the connectivity state of every connection by addr
+func (p *connPool) States() map[string][]connectivity.State {
+	p.Lock()
+	defer p.Unlock()
+	states := make(map[string][]connectivity.State, len(p.conns))
+	for addr, list := range p.conns {
+		for _, pc := range list {
+			states[addr] = append(states[addr], pc.conn.GetState())
+		}
+	}
+	return states
+}
+
+// Close close all connections
+func (p *connPool) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for addr, list := range p.conns {
+		for _, pc := range list {
+			pc.conn.Close()
+		}
+		delete(p.conns, addr)
+	}
+}
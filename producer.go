@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Producer publish local media, e.g. a file, by Client.PublishFile
+type Producer interface {
+	// AddTrack add the audio or video track of the producer to pc
+	AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error)
+	Start()
+	Stop()
+	// SendBytes return the total sent bytes
+	SendBytes() uint64
+	// SeekTo jump to the keyframe at or before d, the rtp timestamps keep continuous
+	// live producers return an error
+	SeekTo(d time.Duration) error
+	// Pause halt the samples without closing the track, the rtp timestamps jump by the pause on Resume
+	Pause()
+	Resume()
+}
+
+// sender is the running producer of a client, a Producer or a rtp forwarder
+type sender interface {
+	Stop()
+	SendBytes() uint64
+}
+
+// seeker is a producer which can seek, e.g. the file producers which are not a Producer
+type seeker interface {
+	SeekTo(d time.Duration) error
+}
+
+// pausable is a producer which can pause, e.g. the file producers which are not a Producer
+type pausable interface {
+	Pause()
+	Resume()
+}
+
+// requestSeek queue a seek for a read loop, a pending seek is replaced
+func requestSeek(c chan time.Duration, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	for {
+		select {
+		case c <- d:
+			return
+		default:
+		}
+		select {
+		case <-c:
+		default:
+		}
+	}
+}
+
+// FileOption config PublishFile
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	loop    *bool
+	fps     int
+	bitrate int
+	hw      *string
+}
+
+// WithLoop restart the file when it ends without a renegotiation, webm loops by default
+func WithLoop(loop bool) FileOption {
+	return func(o *fileOptions) {
+		o.loop = &loop
+	}
+}
+
+// WithFrameRate set the frame rate of elementary streams without timestamps, e.g. h265
+func WithFrameRate(fps int) FileOption {
+	return func(o *fileOptions) {
+		o.fps = fps
+	}
+}
+
+// WithBitrate set the encoder bitrate(bps) of raw video files, e.g. y4m
+func WithBitrate(bitrate int) FileOption {
+	return func(o *fileOptions) {
+		o.bitrate = bitrate
+	}
+}
+
+// WithHardwareEncoder encode raw video files to h264 by a hardware encoder, see Y4MProducer.UseHardwareEncoder
+func WithHardwareEncoder(name string) FileOption {
+	return func(o *fileOptions) {
+		o.hw = &name
+	}
+}
+
+// setLoop set the Loop of the file producers
+func setLoop(p Producer, loop bool) {
+	switch t := p.(type) {
+	case *WebMProducer:
+		t.Loop = loop
+	case *MP4Producer:
+		t.Loop = loop
+	case *OggProducer:
+		t.Loop = loop
+	}
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// progressCycle is the period of the bitrate and OnProgress
+const progressCycle = time.Second
+
+// ProducerProgress is a snapshot of a producer
+type ProducerProgress struct {
+	// Position is the media time in the file, or since the start of a generator
+	Position time.Duration
+	// Frames is the count of the sent samples
+	Frames uint64
+	// Bitrate is the sending bitrate of the last cycle in bps
+	Bitrate int
+}
+
+// progress count the samples of a producer and call its callbacks, it is embedded in the producers
+type progress struct {
+	// OnProgress is called every second with the progress, from the read loop so keep it fast
+	OnProgress func(ProducerProgress)
+	// OnEnd is called once when the media ends or fails to read, err is nil at the end
+	// it is not called after Stop or for a looped file
+	OnEnd func(err error)
+
+	mu         sync.Mutex
+	position   time.Duration
+	frames     uint64
+	bitrate    int
+	cycleStart time.Time
+	cycleBytes int
+	endOnce    sync.Once
+}
+
+// progressive is a producer with a progress
+type progressive interface {
+	progressOf() *progress
+}
+
+func (p *progress) progressOf() *progress {
+	return p
+}
+
+// Progress return the current position, sent frames and bitrate
+func (p *progress) Progress() ProducerProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ProducerProgress{Position: p.position, Frames: p.frames, Bitrate: p.bitrate}
+}
+
+// setPosition set the media time of the next sample
+func (p *progress) setPosition(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position = d
+}
+
+// sent count a sample of n bytes, OnProgress is called at the end of a cycle
+func (p *progress) sent(n int) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.cycleStart.IsZero() {
+		p.cycleStart = now
+	}
+	p.frames++
+	p.cycleBytes += n
+	elapsed := now.Sub(p.cycleStart)
+	if elapsed < progressCycle {
+		p.mu.Unlock()
+		return
+	}
+	p.bitrate = int(float64(p.cycleBytes*8) / elapsed.Seconds())
+	p.cycleStart, p.cycleBytes = now, 0
+	s := ProducerProgress{Position: p.position, Frames: p.frames, Bitrate: p.bitrate}
+	onProgress := p.OnProgress
+	p.mu.Unlock()
+	if onProgress != nil {
+		onProgress(s)
+	}
+}
+
+// ended call OnEnd once
+func (p *progress) ended(err error) {
+	p.endOnce.Do(func() {
+		if p.OnEnd != nil {
+			p.OnEnd(err)
+		}
+	})
+}
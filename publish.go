@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+type pubOptions struct {
+	direction webrtc.RTPTransceiverDirection
+	streamID  string
+	trackID   string
+}
+
+// PubOption config PublishTrack
+type PubOption func(*pubOptions)
+
+// WithDirection set the transceiver direction, default sendonly
+func WithDirection(direction webrtc.RTPTransceiverDirection) PubOption {
+	return func(o *pubOptions) {
+		o.direction = direction
+	}
+}
+
+// WithStreamID publish the track with streamID instead of track.StreamID()
+func WithStreamID(streamID string) PubOption {
+	return func(o *pubOptions) {
+		o.streamID = streamID
+	}
+}
+
+// WithTrackID publish the track with trackID(label) instead of track.ID()
+// e.g. publish mic and system audio from tracks created with the same id
+func WithTrackID(trackID string) PubOption {
+	return func(o *pubOptions) {
+		o.trackID = trackID
+	}
+}
+
+// renamedTrack override the id and stream id of a TrackLocal
+type renamedTrack struct {
+	webrtc.TrackLocal
+	id       string
+	streamID string
+}
+
+func (t *renamedTrack) ID() string {
+	return t.id
+}
+
+func (t *renamedTrack) StreamID() string {
+	return t.streamID
+}
+
+// PublishTrack publish any local track, e.g. a TrackLocalStaticSample fed by your own encoder
+func (c *Client) PublishTrack(track webrtc.TrackLocal, opts ...PubOption) (*webrtc.RTPSender, error) {
+	pub := c.getPub()
+	if c.noPublish {
+		return nil, errNoPublish
+	}
+	o := pubOptions{
+		direction: webrtc.RTPTransceiverDirectionSendonly,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if (o.streamID != "" && o.streamID != track.StreamID()) || (o.trackID != "" && o.trackID != track.ID()) {
+		rt := &renamedTrack{TrackLocal: track, id: track.ID(), streamID: track.StreamID()}
+		if o.streamID != "" {
+			rt.streamID = o.streamID
+		}
+		if o.trackID != "" {
+			rt.id = o.trackID
+		}
+		track = rt
+	}
+
+	// tracks are identified by stream id and track id on sfu
+	for _, s := range pub.pc.GetSenders() {
+		if t := s.Track(); t != nil && t.ID() == track.ID() && t.StreamID() == track.StreamID() {
+			log.Errorf("id=%v track %v of stream %v is already published", c.uid, track.ID(), track.StreamID())
+			return nil, errDuplicateTrack
+		}
+	}
+
+	t, err := pub.pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: o.direction,
+	})
+	if err != nil {
+		log.Errorf("id=%v PublishTrack err=%v", c.uid, err)
+		return nil, err
+	}
+	c.OnNegotiationNeeded()
+	return t.Sender(), nil
+}
+
+// UnPublishTrack stop publishing the track of sender, the other tracks keep live
+func (c *Client) UnPublishTrack(sender *webrtc.RTPSender) error {
+	if sender == nil {
+		return errInvalidTrack
+	}
+	err := c.getPub().pc.RemoveTrack(sender)
+	if err != nil {
+		log.Errorf("id=%v UnPublishTrack err=%v", c.uid, err)
+		return err
+	}
+	c.OnNegotiationNeeded()
+	return nil
+}
+
+// UnPublishByTrackID stop publishing the track with trackID
+func (c *Client) UnPublishByTrackID(trackID string) error {
+	sender := c.getSender(trackID)
+	if sender == nil {
+		return errInvalidTrack
+	}
+	return c.UnPublishTrack(sender)
+}
+
+// getSender return the sender of published track trackID
+func (c *Client) getSender(trackID string) *webrtc.RTPSender {
+	for _, s := range c.getPub().pc.GetSenders() {
+		if track := s.Track(); track != nil && track.ID() == trackID {
+			return s
+		}
+	}
+	return nil
+}
+
+// ReplaceTrack send newTrack on sender without renegotiation, like RTCRtpSender.replaceTrack
+// newTrack should use a codec negotiated for sender, nil stop sending
+func (c *Client) ReplaceTrack(sender *webrtc.RTPSender, newTrack webrtc.TrackLocal) error {
+	if sender == nil {
+		return errInvalidTrack
+	}
+	if err := sender.ReplaceTrack(newTrack); err != nil {
+		log.Errorf("id=%v ReplaceTrack err=%v", c.uid, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultReconnectBackoff    = 500 * time.Millisecond
+	defaultReconnectMaxBackoff = 30 * time.Second
+)
+
+// getSignal return the current signaler, it is replaced on reconnect
+func (c *Client) getSignal() Signal {
+	c.signalLock.RLock()
+	defer c.signalLock.RUnlock()
+	return c.signal
+}
+
+// getPub return the current pub, it is replaced on reconnect
+func (c *Client) getPub() *Transport {
+	c.signalLock.RLock()
+	defer c.signalLock.RUnlock()
+	return c.pub
+}
+
+// getSub return the current sub, nil before join, with NoSubscribe and while reconnecting
+func (c *Client) getSub() *Transport {
+	c.signalLock.RLock()
+	defer c.signalLock.RUnlock()
+	return c.sub
+}
+
+func (c *Client) closed() bool {
+	select {
+	case <-c.notify:
+		return true
+	default:
+		return false
+	}
+}
+
+// reconnect start reconnecting after the signaling is lost, return false if disabled
+func (c *Client) reconnect(err error) bool {
+	if c.engine.getConfig().ReconnectAttempts <= 0 || c.sid == "" || c.customSignal {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return true
+	}
+	log.Warnf("id=%v signal lost err=%v, reconnecting", c.uid, err)
+	go c.reconnectLoop()
+	return true
+}
+
+// reconnectLoop redial with jittered exponential backoff, close the client after the last attempt
+func (c *Client) reconnectLoop() {
+	defer atomic.StoreInt32(&c.reconnecting, 0)
+	cfg := c.engine.getConfig()
+	backoff := cfg.ReconnectBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	maxBackoff := cfg.ReconnectMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMaxBackoff
+	}
+
+	var err error
+	for attempt := 1; attempt <= cfg.ReconnectAttempts; attempt++ {
+		delay := backoff << uint(attempt-1)
+		if delay > maxBackoff || delay <= 0 {
+			delay = maxBackoff
+		}
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-c.notify:
+			return
+		case <-time.After(delay):
+		}
+
+		log.Infof("id=%v reconnect attempt %v/%v", c.uid, attempt, cfg.ReconnectAttempts)
+		if c.OnReconnecting != nil {
+			a := attempt
+			c.engine.dispatcher.Dispatch(func() { c.OnReconnecting(a) })
+		}
+		if err = c.rejoin(); err == nil {
+			log.Infof("id=%v reconnected", c.uid)
+			if c.OnReconnected != nil {
+				c.engine.dispatcher.Dispatch(c.OnReconnected)
+			}
+			return
+		}
+		log.Errorf("id=%v reconnect err=%v", c.uid, err)
+	}
+
+	log.Errorf("id=%v reconnect gave up after %v attempts", c.uid, cfg.ReconnectAttempts)
+	if c.OnReconnectFailed != nil {
+		c.engine.dispatcher.Dispatch(func() { c.OnReconnectFailed(err) })
+	}
+	c.CloseWithReason(LeaveError)
+}
+
+// rejoin redial the signal, rebuild the pcs, republish the tracks and join again
+// custom datachannels are not restored
+func (c *Client) rejoin() error {
+	c.refreshToken()
+	if c.discovered {
+		addr, err := c.engine.FindNode(c.sid)
+		if err != nil {
+			return err
+		}
+		c.signalLock.Lock()
+		c.addr = addr
+		c.signalLock.Unlock()
+	}
+	s, err := c.engine.newSignal(c.Addr(), c.uid)
+	if err != nil {
+		return err
+	}
+	if ts, ok := s.(tokenSetter); ok {
+		ts.setToken(c.getToken())
+	}
+	c.inbox.close()
+	c.bindSignal(s)
+	pub := NewTransport(PUBLISHER, s, c.cfg)
+	if pub == nil {
+		s.Close()
+		return errInvalidPC
+	}
+	oldPub := c.getPub()
+	pub.noTrickle = oldPub.noTrickle
+	pub.pacing.setPacer(c.pacer)
+	pub.trace = c.traceSent
+
+	// the new sub is created by join
+	c.signalLock.Lock()
+	oldSignal, oldSub := c.signal, c.sub
+	c.signal, c.pub, c.sub = s, pub, nil
+	c.signalLock.Unlock()
+	oldSignal.Close()
+	if oldSub != nil {
+		oldSub.pc.Close()
+	}
+	c.handleStateChange(PUBLISHER, pub.pc)
+
+	// republish the tracks with their direction
+	for _, t := range oldPub.pc.GetTransceivers() {
+		track := t.Sender().Track()
+		if track == nil {
+			continue
+		}
+		if _, err := pub.pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{Direction: t.Direction()}); err != nil {
+			log.Errorf("id=%v republish track %v err=%v", c.uid, track.ID(), err)
+			continue
+		}
+	}
+	oldPub.pc.Close()
+
+	// remote tracks are sent again by sfu after join
+	c.streamLock.RLock()
+	var remote []string
+	for id := range c.remoteTracks {
+		remote = append(remote, id)
+	}
+	c.streamLock.RUnlock()
+	for _, id := range remote {
+		c.removeRemoteTrack(id)
+	}
+
+	if err := c.join(c.sid, c.joinConfig); err != nil {
+		return err
+	}
+	c.readSenderRTCP()
+	return nil
+}
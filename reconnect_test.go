@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	pb "github.com/pion/ion-sfu/cmd/signal/grpc/proto"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestRejoinWhileReading(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pb.RegisterSFUServer(srv, idleSFU{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	e := NewEngine(Config{})
+	defer e.Close()
+	c, err := NewClient(e, lis.Addr().String(), "")
+	require.NoError(t, err)
+	require.NoError(t, c.doJoin("room", nil))
+	defer c.Close()
+
+	// the stats, the watchdog and the sfu read the transports while they are replaced
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, read := range []func(){
+		func() { e.calcStat(nil, statCycle) },
+		func() { c.checkStuck(statCycle) },
+		func() { c.GetStats() },
+		func() { c.GetSubStats() },
+		func() { c.Trickle(webrtc.ICECandidateInit{Candidate: "candidate"}, SUBSCRIBER) },
+	} {
+		read := read
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					read()
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, c.rejoin())
+	}
+	close(done)
+	wg.Wait()
+	assert.NotNil(t, c.getSub())
+}
@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/at-wat/ebml-go/webm"
+	"github.com/pion/webrtc/v3"
+)
+
+// keyFrameRequestInterval is how often a recorder ask a key frame until it starts
+const keyFrameRequestInterval = time.Second
+
+// partSuffix is added to a local file until it is finalized
+const partSuffix = ".part"
+
+// RecordOption config a WebMRecorder
+type RecordOption func(*recordOptions)
+
+type recordOptions struct {
+	duration time.Duration
+	size     int64
+	storage  Storage
+}
+
+// WithRotation split the recording into segments of duration or size bytes, 0 is no limit, e.g. for
+// multi-hour sessions, a segment end at the first key frame over a limit, a pli is sent for video
+// the segments are file_000.webm, file_001.webm..., on the local disk a segment is written to
+// file_000.webm.part and renamed once finalized, see WithStorage
+func WithRotation(duration time.Duration, size int64) RecordOption {
+	return func(o *recordOptions) {
+		o.duration, o.size = duration, size
+	}
+}
+
+// rotated tell if the recording is split into segments
+func (o recordOptions) rotated() bool {
+	return o.duration > 0 || o.size > 0
+}
+
+// countingWriter count the bytes written to a file, the webm writer write from its own goroutine
+type countingWriter struct {
+	io.WriteCloser
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	atomic.AddInt64(&w.n, int64(n))
+	return n, err
+}
+
+func (w *countingWriter) size() int64 {
+	return atomic.LoadInt64(&w.n)
+}
+
+// WebMRecorder write a remote vp8, vp9 or opus track to a webm file, e.g. in OnTrack of a recording bot
+// the file start at the first key frame, the timecodes are the rtp timestamps from the first frame
+// it read the track, do not read it elsewhere
+type WebMRecorder struct {
+	track  *webrtc.TrackRemote
+	reader *FrameReader
+	file   string
+	opts   recordOptions
+
+	mu     sync.Mutex
+	out    *countingWriter
+	writer webm.BlockWriteCloser
+	closed bool
+	// the segment written, its number and the finalized ones not yet passed to OnSegment
+	name      string
+	segment   int
+	requested time.Time
+	finished  []string
+	// the rtp timestamps are unwrapped from the first frame, written at start
+	last  uint32
+	ts    int64
+	start time.Time
+	done  chan struct{}
+
+	// RequestKeyFrame is called until the first video key frame, Client.RecordTrack send a pli
+	RequestKeyFrame func()
+	// OnSegment is called with each finalized file, once with file if not rotated
+	OnSegment func(file string)
+	// OnClose is called with the error of the track or the file once the file is closed, nil after Stop
+	OnClose func(err error)
+}
+
+// NewWebMRecorder create a recorder of track to file, h264 is not supported by webm
+func NewWebMRecorder(track *webrtc.TrackRemote, file string, opts ...RecordOption) (*WebMRecorder, error) {
+	switch strings.ToLower(track.Codec().MimeType) {
+	case mimeTypeVP8, mimeTypeVP9, mimeTypeOpus:
+	default:
+		return nil, errUnsupportedCodec
+	}
+	reader, err := NewFrameReader(track, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &WebMRecorder{
+		track:  track,
+		reader: reader,
+		file:   file,
+		done:   make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	return r, nil
+}
+
+// Start read and record the track until it ends or Stop
+func (r *WebMRecorder) Start() {
+	go r.readLoop()
+	if r.track.Kind() == webrtc.RTPCodecTypeVideo {
+		go r.requestKeyFrames()
+	}
+}
+
+// Stop close the file, the frames read later are dropped
+func (r *WebMRecorder) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.close()
+}
+
+// Done is closed when the file is closed
+func (r *WebMRecorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// StartTime return the time of the first frame written, zero before the first file is created
+func (r *WebMRecorder) StartTime() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.start
+}
+
+// close finalize the file, r.mu must be held
+func (r *WebMRecorder) close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	defer close(r.done)
+	return r.finalize()
+}
+
+// finalize close the segment and rename it if rotated, r.mu must be held
+func (r *WebMRecorder) finalize() error {
+	if r.out == nil {
+		return nil
+	}
+	var err error
+	if r.writer != nil {
+		// the writer close the file
+		err = r.writer.Close()
+	} else {
+		err = r.out.Close()
+	}
+	r.out, r.writer, r.requested = nil, nil, time.Time{}
+	if err != nil {
+		return err
+	}
+	r.finished = append(r.finished, r.name)
+	return nil
+}
+
+// create create a file of the storage, a rotated local segment is renamed once finalized
+func (r *WebMRecorder) create(name string) (io.WriteCloser, error) {
+	switch {
+	case r.opts.storage != nil:
+		return r.opts.storage.Create(name)
+	case r.opts.rotated():
+		return LocalStorage{}.Create(name)
+	}
+	return os.Create(name)
+}
+
+// segmentFile return the name of the segment n of file
+func segmentFile(file string, n int) string {
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(file, ext), n, ext)
+}
+
+// rotate tell if the segment is over a limit of the rotation, r.mu must be held
+func (r *WebMRecorder) rotate() bool {
+	if ms := r.ts * 1000 / int64(r.track.Codec().ClockRate); r.opts.duration > 0 && time.Duration(ms)*time.Millisecond >= r.opts.duration {
+		return true
+	}
+	return r.opts.size > 0 && r.out.size() >= r.opts.size
+}
+
+// segments pass the finalized files to OnSegment
+func (r *WebMRecorder) segments() {
+	r.mu.Lock()
+	finished := r.finished
+	r.finished = nil
+	r.mu.Unlock()
+	if r.OnSegment == nil {
+		return
+	}
+	for _, file := range finished {
+		r.OnSegment(file)
+	}
+}
+
+func (r *WebMRecorder) requestKeyFrames() {
+	ticker := time.NewTicker(keyFrameRequestInterval)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		started := r.writer != nil || r.closed
+		r.mu.Unlock()
+		if started {
+			return
+		}
+		if r.RequestKeyFrame != nil {
+			r.RequestKeyFrame()
+		}
+		select {
+		case <-ticker.C:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *WebMRecorder) readLoop() {
+	var err error
+	for err == nil {
+		s, rerr := r.reader.ReadFrame()
+		if rerr != nil {
+			err = rerr
+			break
+		}
+		err = r.write(s.Data, s.PacketTimestamp)
+		r.segments()
+	}
+	r.mu.Lock()
+	stopped := r.closed
+	if cerr := r.close(); err == io.EOF || stopped {
+		err = cerr
+	}
+	r.mu.Unlock()
+	r.segments()
+	if err != nil {
+		log.Errorf("record track=%v file=%v err=%v", r.track.ID(), r.file, err)
+	}
+	if r.OnClose != nil {
+		r.OnClose(err)
+	}
+}
+
+// write open the file at the first key frame and write the frame, it return io.EOF once stopped
+// a rotated segment is finalized at the first key frame over a limit and the next one start with it
+func (r *WebMRecorder) write(frame []byte, timestamp uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return io.EOF
+	}
+	key, width, height := keyFrame(r.track.Codec().MimeType, frame)
+	if r.writer != nil && r.opts.rotated() && r.rotate() {
+		if key {
+			if err := r.finalize(); err != nil {
+				return err
+			}
+		} else if time.Since(r.requested) >= keyFrameRequestInterval {
+			r.requested = time.Now()
+			if r.RequestKeyFrame != nil {
+				r.RequestKeyFrame()
+			}
+		}
+	}
+	if r.writer == nil {
+		if !key {
+			return nil
+		}
+		if err := r.open(width, height); err != nil {
+			return err
+		}
+		if r.start.IsZero() {
+			r.start = time.Now()
+		}
+		// each segment start at timecode 0
+		r.last, r.ts = timestamp, 0
+	}
+	r.ts += int64(int32(timestamp - r.last))
+	r.last = timestamp
+	ms := r.ts * 1000 / int64(r.track.Codec().ClockRate)
+	_, err := r.writer.Write(key, ms, frame)
+	return err
+}
+
+// keyFrame tell if a frame is a key frame and its size if known, every audio frame is a key frame
+func keyFrame(mime string, b []byte) (bool, int, int) {
+	switch strings.ToLower(mime) {
+	case mimeTypeVP8:
+		// the size follow the start code of key frames
+		if len(b) < 10 || b[0]&0x01 != 0 {
+			return false, 0, 0
+		}
+		return true, int(binary.LittleEndian.Uint16(b[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(b[8:]) & 0x3fff)
+	case mimeTypeVP9:
+		for _, frame := range vp9Frames(b) {
+			if f, ok := parseVP9Frame(frame); ok && f.key {
+				return true, int(f.width), int(f.height)
+			}
+		}
+		return false, 0, 0
+	case mimeTypeH264:
+		return h264Key(b), 0, 0
+	}
+	return true, 0, 0
+}
+
+// open create the file with the track entry of the codec
+func (r *WebMRecorder) open(width, height int) error {
+	entry := webm.TrackEntry{
+		Name:        r.track.ID(),
+		TrackNumber: 1,
+		TrackUID:    uint64(r.track.SSRC()),
+	}
+	c := r.track.Codec()
+	switch strings.ToLower(c.MimeType) {
+	case mimeTypeVP8, mimeTypeVP9:
+		entry.CodecID = "V_VP8"
+		if strings.EqualFold(c.MimeType, mimeTypeVP9) {
+			entry.CodecID = "V_VP9"
+		}
+		entry.TrackType = 1
+		entry.Video = &webm.Video{PixelWidth: uint64(width), PixelHeight: uint64(height)}
+	default:
+		channels := c.Channels
+		if channels == 0 {
+			channels = 2
+		}
+		entry.CodecID = "A_OPUS"
+		entry.TrackType = 2
+		entry.CodecPrivate = opusHead(int(channels))
+		entry.SeekPreRoll = uint64(80 * time.Millisecond)
+		entry.Audio = &webm.Audio{SamplingFrequency: float64(c.ClockRate), Channels: uint64(channels)}
+	}
+	name := r.file
+	if r.opts.rotated() {
+		name = segmentFile(r.file, r.segment)
+		r.segment++
+	}
+	f, err := r.create(name)
+	if err != nil {
+		return err
+	}
+	out := &countingWriter{WriteCloser: f}
+	writers, err := webm.NewSimpleBlockWriter(out, []webm.TrackEntry{entry})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.name, r.out, r.writer = name, out, writers[0]
+	log.Infof("record track=%v codec=%v to %v", r.track.ID(), c.MimeType, name)
+	return nil
+}
+
+// opusHead is the codec private of an opus track, rfc 7845
+func opusHead(channels int) []byte {
+	b := []byte("OpusHead\x01\x00\x00\x00\x80\xbb\x00\x00\x00\x00\x00")
+	b[9] = byte(channels)
+	return b
+}
+
+// RecordTrack record a remote track to a webm file, call it in OnTrack instead of reading the track
+// a pli is sent until the first key frame, see WebMRecorder and WithRotation
+func (c *Client) RecordTrack(track *webrtc.TrackRemote, file string, opts ...RecordOption) (*WebMRecorder, error) {
+	r, err := NewWebMRecorder(track, file, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestKeyFrame = func() {
+		if err := c.requestKeyFrame(uint32(track.SSRC())); err != nil {
+			log.Debugf("id=%v pli err=%v", c.uid, err)
+		}
+	}
+	r.Start()
+	return r, nil
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/at-wat/ebml-go"
+	"github.com/at-wat/ebml-go/webm"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTrack return a remote track of codec without a receiver, its fields are not exported by pion
+func newTestTrack(kind webrtc.RTPCodecType, codec webrtc.RTPCodecParameters) *webrtc.TrackRemote {
+	track := &webrtc.TrackRemote{}
+	v := reflect.ValueOf(track).Elem()
+	set := func(name string, x interface{}) {
+		f := v.FieldByName(name)
+		reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().Set(reflect.ValueOf(x))
+	}
+	set("id", "track")
+	set("kind", kind)
+	set("codec", codec)
+	return track
+}
+
+// readWebM return the absolute timecodes of the blocks of a webm file
+func readWebM(t *testing.T, file string) []int64 {
+	b, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	var doc struct {
+		Header  webm.EBMLHeader `ebml:"EBML"`
+		Segment webm.Segment    `ebml:"Segment"`
+	}
+	require.NoError(t, ebml.Unmarshal(bytes.NewReader(b), &doc))
+	var timecodes []int64
+	for _, c := range doc.Segment.Cluster {
+		for _, block := range c.SimpleBlock {
+			timecodes = append(timecodes, int64(c.Timecode)+int64(block.Timecode))
+		}
+	}
+	return timecodes
+}
+
+func TestWebMRecorderRotation(t *testing.T) {
+	opus := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeOpus, ClockRate: 48000, Channels: 2}}
+	vp8 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeVP8, ClockRate: 90000}}
+	// a vp8 key frame of 64x48, a delta frame has the inter bit
+	vp8Key := []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a, 64, 0, 48, 0, 0, 0}
+	vp8Delta := []byte{0x11, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	for _, tc := range []struct {
+		name  string
+		kind  webrtc.RTPCodecType
+		codec webrtc.RTPCodecParameters
+		opts  []RecordOption
+		// frames and the timestamp step, key tell the key frames of the video
+		frames int
+		step   uint32
+		key    func(i int) bool
+		// blocks is the frames of each segment, the names are file_000.webm... if rotated
+		blocks   []int
+		requests int
+	}{
+		{
+			name: "not rotated", kind: webrtc.RTPCodecTypeAudio, codec: opus,
+			frames: 100, step: 960,
+			blocks: []int{100},
+		},
+		{
+			// a segment end at the first frame of 1s
+			name: "audio duration", kind: webrtc.RTPCodecTypeAudio, codec: opus,
+			opts:   []RecordOption{WithRotation(time.Second, 0)},
+			frames: 260, step: 960,
+			blocks: []int{51, 51, 51, 51, 51, 5},
+		},
+		{
+			// a segment wait a key frame once over 1s, the first one is asked
+			name: "video duration", kind: webrtc.RTPCodecTypeVideo, codec: vp8,
+			opts:   []RecordOption{WithRotation(time.Second, 0)},
+			frames: 137, step: 3000,
+			key:      func(i int) bool { return i >= 2 && (i-2)%45 == 0 },
+			blocks:   []int{45, 45, 45},
+			requests: 3,
+		},
+		{
+			name: "audio size", kind: webrtc.RTPCodecTypeAudio, codec: opus,
+			opts:   []RecordOption{WithRotation(0, 4000)},
+			frames: 100, step: 960,
+		},
+	} {
+		dir, err := ioutil.TempDir("", "recorder")
+		require.NoError(t, err)
+		file := filepath.Join(dir, "track.webm")
+		r, err := NewWebMRecorder(newTestTrack(tc.kind, tc.codec), file, tc.opts...)
+		require.NoError(t, err, tc.name)
+		requests := 0
+		r.RequestKeyFrame = func() { requests++ }
+		var segments []string
+		r.OnSegment = func(file string) { segments = append(segments, file) }
+		for i := 0; i < tc.frames; i++ {
+			frame := make([]byte, 100)
+			switch {
+			case tc.kind == webrtc.RTPCodecTypeAudio:
+				frame[0] = 0xfc
+			case tc.key(i):
+				copy(frame, vp8Key)
+			default:
+				copy(frame, vp8Delta)
+			}
+			require.NoError(t, r.write(frame, 1000+uint32(i)*tc.step), tc.name)
+			r.segments()
+		}
+		r.Stop()
+		r.segments()
+
+		if tc.blocks == nil {
+			// every segment but the last is over the size
+			require.Greater(t, len(segments), 1, tc.name)
+			total := 0
+			for i, segment := range segments {
+				info, err := os.Stat(segment)
+				require.NoError(t, err, tc.name)
+				if i < len(segments)-1 {
+					assert.GreaterOrEqual(t, info.Size(), int64(4000), tc.name)
+				}
+				total += len(readWebM(t, segment))
+			}
+			assert.Equal(t, tc.frames, total, tc.name)
+		} else {
+			require.Len(t, segments, len(tc.blocks), tc.name)
+			for i, segment := range segments {
+				if len(tc.opts) > 0 {
+					assert.Equal(t, segmentFile(file, i), segment, tc.name)
+				} else {
+					assert.Equal(t, file, segment, tc.name)
+				}
+				timecodes := readWebM(t, segment)
+				require.Len(t, timecodes, tc.blocks[i], "%v segment %v", tc.name, i)
+				// each segment start at 0
+				ms := int64(tc.step) * 1000 / int64(tc.codec.ClockRate)
+				assert.Equal(t, int64(0), timecodes[0], tc.name)
+				assert.InDelta(t, int64(tc.blocks[i]-1)*ms, timecodes[len(timecodes)-1], float64(tc.blocks[i]), tc.name)
+			}
+		}
+		assert.Equal(t, tc.requests, requests, tc.name)
+		// the finalized segments are renamed
+		parts, _ := filepath.Glob(filepath.Join(dir, "*"+partSuffix))
+		assert.Empty(t, parts, tc.name)
+		os.RemoveAll(dir)
+	}
+}
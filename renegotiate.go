@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const defaultNegotiationRetryDelay = time.Second
+
+// onNegotiationError report the failure, rollback the pending sdp and re-offer the pub
+// sub is answerer, sfu re-offer it by itself
+func (c *Client) onNegotiationError(role int, err error) {
+	pub := c.getPub()
+	sub := c.getSub()
+	log.Errorf("id=%v role=%v negotiation err=%v", c.uid, role, err)
+	if c.OnNegotiationError != nil {
+		c.engine.dispatcher.Dispatch(func() { c.OnNegotiationError(role, err) })
+	}
+
+	select {
+	case <-c.notify:
+		return
+	default:
+	}
+
+	rollback := webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}
+	if role == SUBSCRIBER {
+		if sub.pc.SignalingState() == webrtc.SignalingStateHaveRemoteOffer {
+			if err := sub.pc.SetRemoteDescription(rollback); err != nil {
+				log.Errorf("id=%v sub rollback err=%v", c.uid, err)
+			}
+		}
+		return
+	}
+
+	if pub.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := pub.pc.SetLocalDescription(rollback); err != nil {
+			log.Errorf("id=%v pub rollback err=%v", c.uid, err)
+		}
+	}
+	n := atomic.AddInt32(&c.negotiationRetries, 1)
+	if int(n) > c.cfg.NegotiationRetries {
+		log.Errorf("id=%v negotiation failed %v times, give up", c.uid, n)
+		return
+	}
+	delay := c.cfg.NegotiationRetryDelay
+	if delay <= 0 {
+		delay = defaultNegotiationRetryDelay
+	}
+	log.Infof("id=%v retry negotiation %v/%v in %v", c.uid, n, c.cfg.NegotiationRetries, delay*time.Duration(n))
+	time.AfterFunc(delay*time.Duration(n), c.OnNegotiationNeeded)
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// requestKeyFrame send a pli of a subscribed track to sfu
+func (c *Client) requestKeyFrame(ssrc uint32) error {
+	sub := c.getSub()
+	if sub == nil {
+		return errNoSubscribe
+	}
+	return sub.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+}
+
+// readSenderRTCP start reading rtcp for the senders which are not read yet
+func (c *Client) readSenderRTCP() {
+	c.rtcpLock.Lock()
+	defer c.rtcpLock.Unlock()
+	for _, s := range c.getPub().pc.GetSenders() {
+		if s.Track() == nil || c.rtcpSenders[s] {
+			continue
+		}
+		c.rtcpSenders[s] = true
+		go c.senderRTCPLoop(s)
+	}
+}
+
+// senderRTCPLoop read PLI/FIR/NACK/REMB from sfu and call OnPublisherRTCP
+func (c *Client) senderRTCPLoop(s *webrtc.RTPSender) {
+	defer func() {
+		c.rtcpLock.Lock()
+		delete(c.rtcpSenders, s)
+		c.rtcpLock.Unlock()
+	}()
+	for {
+		pkts, _, err := s.ReadRTCP()
+		if err != nil {
+			log.Debugf("id=%v sender rtcp loop exit: %v", c.uid, err)
+			return
+		}
+		if c.OnPublisherRTCP == nil {
+			continue
+		}
+		var trackID string
+		if track := s.Track(); track != nil {
+			trackID = track.ID()
+		}
+		for _, pkt := range pkts {
+			switch pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest, *rtcp.TransportLayerNack, *rtcp.ReceiverEstimatedMaximumBitrate:
+				c.OnPublisherRTCP(pkt, trackID)
+			}
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultRTMPVideoBitrate = 2500000
+	defaultRTMPAudioBitrate = 128000
+	// the payload types of the sdp given to ffmpeg
+	rtmpVideoPayloadType = 96
+	rtmpAudioPayloadType = 111
+	// rtmpKeyFrameRequests is the plis sent once ffmpeg listen, it need a key frame to start
+	rtmpKeyFrameRequests = 3
+	// rtmpStopTimeout is how long ffmpeg may flush the stream before being killed
+	rtmpStopTimeout = 5 * time.Second
+)
+
+// RTMPConfig is the encoding of a RTMPStreamer, the zero values are the defaults
+type RTMPConfig struct {
+	// FFmpeg is the path of the ffmpeg binary, default ffmpeg in PATH
+	FFmpeg string
+	// VideoBitrate of the h264 encoder in bps when the video is not h264, default 2.5M
+	VideoBitrate int
+	// AudioBitrate of the aac encoder in bps, default 128k
+	AudioBitrate int
+	// OnExit is called with the error of ffmpeg once it exits, nil after Stop
+	OnExit func(err error)
+}
+
+// RTMPStreamer push a video and an audio track of the session to a rtmp url, e.g. to restream a room to youtube
+// the rtp is forwarded to a local ffmpeg which copy h264, transcode vp8/vp9 to h264 and opus to aac in flv
+// the tracks must still be read, by OnTrack or by default, see ForwardTrack
+type RTMPStreamer struct {
+	client *Client
+	url    string
+	cfg    RTMPConfig
+	video  *webrtc.TrackRemote
+	audio  *webrtc.TrackRemote
+	sdp    string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+
+	mu         sync.Mutex
+	forwarders []*UDPForwarder
+	stopped    bool
+	exited     bool
+	done       chan struct{}
+}
+
+// rtpPorts return a free even udp port of localhost whose next port is free too, for rtp and rtcp
+func rtpPorts() (int, error) {
+	for i := 0; i < 16; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return 0, err
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+		if port%2 != 0 {
+			port--
+		}
+		even, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			continue
+		}
+		odd, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1})
+		even.Close()
+		if err != nil {
+			continue
+		}
+		odd.Close()
+		return port, nil
+	}
+	return 0, errNoRTPPort
+}
+
+// sdpMedia return the sdp media of a track received on port with the payload type pt
+func sdpMedia(track *webrtc.TrackRemote, port int, pt uint8) string {
+	c := track.Codec()
+	name := c.MimeType[strings.Index(c.MimeType, "/")+1:]
+	s := fmt.Sprintf("m=%s %d RTP/AVP %d\r\na=rtpmap:%d %s/%d", track.Kind(), port, pt, pt, name, c.ClockRate)
+	if c.Channels > 0 {
+		s += fmt.Sprintf("/%d", c.Channels)
+	}
+	s += "\r\n"
+	if c.SDPFmtpLine != "" {
+		s += fmt.Sprintf("a=fmtp:%d %s\r\n", pt, c.SDPFmtpLine)
+	}
+	return s
+}
+
+// NewRTMPStreamer create a streamer of the tracks to url, one of video or audio may be nil
+func NewRTMPStreamer(c *Client, url string, video, audio *webrtc.TrackRemote, cfg RTMPConfig) (*RTMPStreamer, error) {
+	if video == nil && audio == nil {
+		return nil, errInvalidTrack
+	}
+	if video != nil {
+		switch strings.ToLower(video.Codec().MimeType) {
+		case mimeTypeH264, mimeTypeVP8, mimeTypeVP9:
+		default:
+			return nil, errUnsupportedCodec
+		}
+	}
+	if audio != nil && !strings.EqualFold(audio.Codec().MimeType, mimeTypeOpus) {
+		return nil, errUnsupportedCodec
+	}
+	if cfg.FFmpeg == "" {
+		cfg.FFmpeg = "ffmpeg"
+	}
+	if cfg.VideoBitrate <= 0 {
+		cfg.VideoBitrate = defaultRTMPVideoBitrate
+	}
+	if cfg.AudioBitrate <= 0 {
+		cfg.AudioBitrate = defaultRTMPAudioBitrate
+	}
+	return &RTMPStreamer{
+		client: c,
+		url:    url,
+		cfg:    cfg,
+		video:  video,
+		audio:  audio,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Start run ffmpeg and forward the tracks to it
+func (s *RTMPStreamer) Start() error {
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=ion\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\n"
+	type target struct {
+		track *webrtc.TrackRemote
+		port  int
+		pt    uint8
+	}
+	var targets []target
+	for _, t := range []target{{track: s.video, pt: rtmpVideoPayloadType}, {track: s.audio, pt: rtmpAudioPayloadType}} {
+		if t.track == nil {
+			continue
+		}
+		port, err := rtpPorts()
+		if err != nil {
+			return err
+		}
+		t.port = port
+		sdp += sdpMedia(t.track, port, t.pt)
+		targets = append(targets, t)
+	}
+	f, err := ioutil.TempFile("", "ion_rtmp_*.sdp")
+	if err != nil {
+		return err
+	}
+	s.sdp = f.Name()
+	_, err = f.WriteString(sdp)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(s.sdp)
+		return err
+	}
+
+	s.cmd = exec.Command(s.cfg.FFmpeg, s.ffmpegArgs()...)
+	s.cmd.Stderr = &s.stderr
+	if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+		os.Remove(s.sdp)
+		return err
+	}
+	if err := s.cmd.Start(); err != nil {
+		os.Remove(s.sdp)
+		return err
+	}
+	go s.wait()
+	for _, t := range targets {
+		fw, err := s.client.ForwardTrack(t.track.ID(), fmt.Sprintf("127.0.0.1:%d", t.port), WithForwardPayloadType(t.pt))
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		s.mu.Lock()
+		if s.exited {
+			fw.Close()
+		} else {
+			s.forwarders = append(s.forwarders, fw)
+		}
+		s.mu.Unlock()
+	}
+	log.Infof("restream video=%v audio=%v to %v", s.video != nil, s.audio != nil, s.url)
+	if s.video != nil {
+		go s.requestKeyFrames()
+	}
+	return nil
+}
+
+// ffmpegArgs return the ffmpeg command line reading the sdp and pushing flv
+func (s *RTMPStreamer) ffmpegArgs() []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-protocol_whitelist", "file,udp,rtp",
+		"-fflags", "+genpts", "-i", s.sdp}
+	if s.video != nil {
+		if strings.EqualFold(s.video.Codec().MimeType, mimeTypeH264) {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p",
+				"-b:v", fmt.Sprint(s.cfg.VideoBitrate), "-g", "60")
+		}
+	}
+	if s.audio != nil {
+		args = append(args, "-c:a", "aac", "-ar", "44100", "-b:a", fmt.Sprint(s.cfg.AudioBitrate))
+	}
+	return append(args, "-f", "flv", s.url)
+}
+
+// requestKeyFrames send a few plis while ffmpeg probe the stream
+func (s *RTMPStreamer) requestKeyFrames() {
+	for i := 0; i < rtmpKeyFrameRequests; i++ {
+		select {
+		case <-time.After(keyFrameRequestInterval):
+		case <-s.done:
+			return
+		}
+		if err := s.client.requestKeyFrame(uint32(s.video.SSRC())); err != nil {
+			log.Debugf("id=%v pli err=%v", s.client.uid, err)
+		}
+	}
+}
+
+func (s *RTMPStreamer) wait() {
+	err := s.cmd.Wait()
+	os.Remove(s.sdp)
+	s.mu.Lock()
+	s.exited = true
+	forwarders := s.forwarders
+	if s.stopped {
+		err = nil
+	}
+	s.mu.Unlock()
+	for _, f := range forwarders {
+		f.Close()
+	}
+	if err != nil {
+		log.Errorf("restream to %v err=%v %s", s.url, err, s.stderr.Bytes())
+	}
+	close(s.done)
+	if s.cfg.OnExit != nil {
+		s.cfg.OnExit(err)
+	}
+}
+
+// Stop ask ffmpeg to end the stream, it is killed if it does not exit in time
+func (s *RTMPStreamer) Stop() {
+	s.mu.Lock()
+	if s.stopped || s.cmd == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	s.mu.Unlock()
+	// q quit ffmpeg gracefully
+	s.stdin.Write([]byte("q"))
+	s.stdin.Close()
+	select {
+	case <-s.done:
+	case <-time.After(rtmpStopTimeout):
+		s.cmd.Process.Kill()
+		<-s.done
+	}
+}
+
+// Done is closed when ffmpeg exits
+func (s *RTMPStreamer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Restream push the subscribed tracks videoTrackID and audioTrackID to a rtmp url, one may be empty
+// see RTMPStreamer, call Stop to end the stream
+func (c *Client) Restream(url, videoTrackID, audioTrackID string, cfg RTMPConfig) (*RTMPStreamer, error) {
+	var video, audio *webrtc.TrackRemote
+	for _, id := range []string{videoTrackID, audioTrackID} {
+		if id == "" {
+			continue
+		}
+		track := c.subscribedTrack(id)
+		if track == nil {
+			log.Errorf("id=%v restream unknown remote track %v", c.uid, id)
+			return nil, errInvalidTrack
+		}
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			video = track
+		} else {
+			audio = track
+		}
+	}
+	s, err := NewRTMPStreamer(c, url, video, audio, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// rtpForwarder write received rtp packets to a local track without depacketizing them
+// the parameter sets from the sdp of the source are sent before every key frame, as cameras
+// often send them out of band only
+type rtpForwarder struct {
+	track     *webrtc.TrackLocalStaticRTP
+	codec     string
+	paramSets [][]byte
+	// the sequence numbers are shifted by the injected packets
+	seqShift uint16
+	sendByte uint64
+}
+
+func newRTPForwarder(track *webrtc.TrackLocalStaticRTP, paramSets [][]byte) *rtpForwarder {
+	return &rtpForwarder{
+		track:     track,
+		codec:     strings.ToLower(track.Codec().MimeType),
+		paramSets: paramSets,
+	}
+}
+
+func (f *rtpForwarder) write(pkt *rtp.Packet) error {
+	if len(f.paramSets) > 0 && keyFrameStart(f.codec, pkt.Payload) {
+		for _, ps := range f.paramSets {
+			header := pkt.Header
+			header.Marker = false
+			header.SequenceNumber += f.seqShift
+			if err := f.writePacket(&rtp.Packet{Header: header, Payload: ps}); err != nil {
+				return err
+			}
+			f.seqShift++
+		}
+	}
+	pkt.SequenceNumber += f.seqShift
+	return f.writePacket(pkt)
+}
+
+func (f *rtpForwarder) writePacket(pkt *rtp.Packet) error {
+	if err := f.track.WriteRTP(pkt); err != nil {
+		return err
+	}
+	atomic.AddUint64(&f.sendByte, uint64(len(pkt.Payload)))
+	return nil
+}
+
+// keyFrameStart return true for the first packet of a key frame, an idr/irap frame of h264/h265
+func keyFrameStart(mime string, payload []byte) bool {
+	switch strings.ToLower(mime) {
+	case mimeTypeVP8:
+		vp8 := &codecs.VP8Packet{}
+		if _, err := vp8.Unmarshal(payload); err != nil {
+			return false
+		}
+		return vp8.S == 1 && vp8.PID == 0 && len(vp8.Payload) > 0 && vp8.Payload[0]&0x01 == 0
+	case mimeTypeVP9:
+		vp9 := &codecs.VP9Packet{}
+		if _, err := vp9.Unmarshal(payload); err != nil {
+			return false
+		}
+		// the base spatial layer of a picture without inter prediction
+		return vp9.B && !vp9.P && vp9.SID == 0
+	case mimeTypeH264:
+		if len(payload) < 2 {
+			return false
+		}
+		switch payload[0] & 0x1f {
+		case 5:
+			return true
+		case 24:
+			// stap-a, the first nal after its size
+			return len(payload) > 3 && payload[3]&0x1f == 5
+		case 28:
+			// fu-a with the start bit
+			return payload[1]&0x80 != 0 && payload[1]&0x1f == 5
+		}
+	case mimeTypeH265:
+		if len(payload) < 3 {
+			return false
+		}
+		isIRAP := func(t byte) bool { return t >= 16 && t <= 21 }
+		switch t := (payload[0] >> 1) & 0x3f; t {
+		case 48:
+			// aggregation packet, the first nal after its size
+			return len(payload) > 4 && isIRAP((payload[4]>>1)&0x3f)
+		case 49:
+			// fragmentation unit with the start bit
+			return payload[2]&0x80 != 0 && isIRAP(payload[2]&0x3f)
+		default:
+			return isIRAP(t)
+		}
+	}
+	return false
+}
+
+func (f *rtpForwarder) sentBytes() uint64 {
+	return atomic.LoadUint64(&f.sendByte)
+}
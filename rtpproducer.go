@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// rtpReadBufferSize is larger than any packet of a udp payload
+const rtpReadBufferSize = 1500
+
+// RTPSource is an udp port receiving pre-encoded rtp, e.g. ffmpeg -f rtp rtp://127.0.0.1:5004
+type RTPSource struct {
+	// Addr is the listen address like 127.0.0.1:5004
+	Addr string
+	// Codec is the codec of the packets, the kind is from its mime type
+	Codec webrtc.RTPCodecCapability
+	// PayloadType filter the packets, 0 accept all
+	PayloadType uint8
+}
+
+type rtpListener struct {
+	source RTPSource
+	conn   net.PacketConn
+	fwd    *rtpForwarder
+}
+
+// RTPProducer forward the rtp received on udp ports to published tracks, one track per source
+// the packets are not transcoded, send them with a payload size under the mtu
+type RTPProducer struct {
+	id        string
+	listeners []*rtpListener
+	stopOnce  sync.Once
+}
+
+// NewRTPProducer bind the udp ports of the sources
+func NewRTPProducer(id string, sources ...RTPSource) (*RTPProducer, error) {
+	p := &RTPProducer{id: id}
+	for _, s := range sources {
+		if !strings.HasPrefix(strings.ToLower(s.Codec.MimeType), "video/") && !strings.HasPrefix(strings.ToLower(s.Codec.MimeType), "audio/") {
+			p.Stop()
+			return nil, errInvalidKind
+		}
+		conn, err := net.ListenPacket("udp", s.Addr)
+		if err != nil {
+			log.Errorf("listen rtp %v err=%v", s.Addr, err)
+			p.Stop()
+			return nil, err
+		}
+		p.listeners = append(p.listeners, &rtpListener{source: s, conn: conn})
+	}
+	return p, nil
+}
+
+// AddTracks add a track of every source to pc
+func (t *RTPProducer) AddTracks(pc *webrtc.PeerConnection) ([]*webrtc.TrackLocalStaticRTP, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	var tracks []*webrtc.TrackLocalStaticRTP
+	for i, l := range t.listeners {
+		kind := strings.SplitN(strings.ToLower(l.source.Codec.MimeType), "/", 2)[0]
+		track, err := webrtc.NewTrackLocalStaticRTP(l.source.Codec, fmt.Sprintf("%v%v", kind, i), fmt.Sprintf("rtp_%p", t))
+		if err != nil {
+			return nil, err
+		}
+		if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendonly,
+		}); err != nil {
+			log.Errorf("err=%v", err)
+			return nil, err
+		}
+		l.fwd = newRTPForwarder(track, nil)
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+func (t *RTPProducer) Start() {
+	for _, l := range t.listeners {
+		go t.readLoop(l)
+	}
+}
+
+// Stop close the udp ports
+func (t *RTPProducer) Stop() {
+	t.stopOnce.Do(func() {
+		for _, l := range t.listeners {
+			l.conn.Close()
+		}
+	})
+}
+
+func (t *RTPProducer) readLoop(l *rtpListener) {
+	buf := make([]byte, rtpReadBufferSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			log.Infof("id=%v exiting rtp listener %v err=%v", t.id, l.source.Addr, err)
+			return
+		}
+		if l.fwd == nil {
+			continue
+		}
+		var pkt rtp.Packet
+		if err := pkt.Unmarshal(buf[:n]); err != nil {
+			log.Debugf("invalid rtp packet from %v err=%v", l.source.Addr, err)
+			continue
+		}
+		if l.source.PayloadType != 0 && pkt.PayloadType != l.source.PayloadType {
+			continue
+		}
+		if err := l.fwd.write(&pkt); err != nil {
+			log.Errorf("Track write error=%v", err)
+		}
+	}
+}
+
+// SendBytes return the total sent bytes
+func (t *RTPProducer) SendBytes() uint64 {
+	var sendByte uint64
+	for _, l := range t.listeners {
+		if l.fwd != nil {
+			sendByte += l.fwd.sentBytes()
+		}
+	}
+	return sendByte
+}
+
+// PublishRTP publish the rtp received on the udp ports of the sources, see RTPProducer
+func (c *Client) PublishRTP(sources ...RTPSource) error {
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewRTPProducer(c.uid, sources...)
+	if err != nil {
+		return err
+	}
+	c.setProducer(p)
+	if _, err := p.AddTracks(c.getPub().pc); err != nil {
+		return err
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
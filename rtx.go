@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const defaultRTXTime = time.Second
+
+// RTXConfig retransmit published video on nack from sfu
+// pion v3.0 does not signal the rtx ssrc, packets are resent on the media ssrc
+type RTXConfig struct {
+	// HistorySize is the packets kept per track for retransmission, 0 is disabled
+	HistorySize int
+	// RTXTime is the max age of a packet to be resent, default 1s
+	RTXTime time.Duration
+}
+
+type rtxPacket struct {
+	header  rtp.Header
+	payload []byte
+	sent    time.Time
+}
+
+// rtxStream keep the last sent packets of a local stream
+type rtxStream struct {
+	sync.Mutex
+	writer  interceptor.RTPWriter
+	packets []*rtxPacket
+}
+
+func (s *rtxStream) add(header *rtp.Header, payload []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.packets[int(header.SequenceNumber)%len(s.packets)] = &rtxPacket{
+		header:  *header,
+		payload: append([]byte{}, payload...),
+		sent:    time.Now(),
+	}
+}
+
+func (s *rtxStream) get(seq uint16, maxAge time.Duration) *rtxPacket {
+	s.Lock()
+	defer s.Unlock()
+	p := s.packets[int(seq)%len(s.packets)]
+	if p == nil || p.header.SequenceNumber != seq || time.Since(p.sent) > maxAge {
+		return nil
+	}
+	return p
+}
+
+// retransmitter is an interceptor answering nack with the cached packets
+type retransmitter struct {
+	interceptor.NoOp
+
+	cfg RTXConfig
+
+	sync.RWMutex
+	streams map[uint32]*rtxStream
+}
+
+func newRetransmitter(cfg RTXConfig) *retransmitter {
+	if cfg.RTXTime <= 0 {
+		cfg.RTXTime = defaultRTXTime
+	}
+	return &retransmitter{
+		cfg:     cfg,
+		streams: make(map[uint32]*rtxStream),
+	}
+}
+
+// BindLocalStream cache the packets of streams negotiated with nack
+func (r *retransmitter) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if r.cfg.HistorySize <= 0 || !hasNACK(info) {
+		return writer
+	}
+	s := &rtxStream{
+		writer:  writer,
+		packets: make([]*rtxPacket, r.cfg.HistorySize),
+	}
+	r.Lock()
+	r.streams[info.SSRC] = s
+	r.Unlock()
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		s.add(header, payload)
+		return writer.Write(header, payload, a)
+	})
+}
+
+// UnbindLocalStream drop the cache of the stream
+func (r *retransmitter) UnbindLocalStream(info *interceptor.StreamInfo) {
+	r.Lock()
+	delete(r.streams, info.SSRC)
+	r.Unlock()
+}
+
+// BindRTCPReader resend the packets asked by nack
+func (r *retransmitter) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	if r.cfg.HistorySize <= 0 {
+		return reader
+	}
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			if nack, ok := pkt.(*rtcp.TransportLayerNack); ok {
+				r.resend(nack)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+func (r *retransmitter) resend(nack *rtcp.TransportLayerNack) {
+	r.RLock()
+	s := r.streams[nack.MediaSSRC]
+	r.RUnlock()
+	if s == nil {
+		return
+	}
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			p := s.get(seq, r.cfg.RTXTime)
+			if p == nil {
+				continue
+			}
+			if _, err := s.writer.Write(&p.header, p.payload, nil); err != nil {
+				log.Debugf("rtx ssrc=%v seq=%v err=%v", nack.MediaSSRC, seq, err)
+			}
+		}
+	}
+}
+
+func hasNACK(info *interceptor.StreamInfo) bool {
+	for _, fb := range info.RTCPFeedback {
+		if fb.Type == "nack" && fb.Parameter == "" {
+			return true
+		}
+	}
+	return false
+}
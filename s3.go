@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	// minS3PartSize is the smallest part of a multipart upload but the last
+	minS3PartSize = 5 << 20
+)
+
+// S3Config is the bucket of an S3Storage, any s3 compatible storage can be used with Endpoint, e.g. minio
+type S3Config struct {
+	Bucket string
+	// Region of the bucket, default us-east-1
+	Region string
+	// Endpoint is the url of a s3 compatible storage with the bucket in the path, default the aws host of the bucket
+	Endpoint string
+	// AccessKey, SecretKey and SessionToken default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Prefix is prepended to the file names of the object keys
+	Prefix string
+	// PartSize is the size of the uploaded parts, at least 5MB, default 8MB
+	PartSize int
+	// HTTPClient default to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// S3Storage upload the files to a s3 bucket while they are written, a file larger than a part is a multipart
+// upload completed at Close, the object is not visible before, the upload is aborted on error
+type S3Storage struct {
+	cfg S3Config
+}
+
+// NewS3Storage create a storage of the bucket of cfg
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errInvalidStorage
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = defaultUploadPartSize
+	}
+	if cfg.PartSize < minS3PartSize {
+		cfg.PartSize = minS3PartSize
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &S3Storage{cfg: cfg}, nil
+}
+
+// Create start the upload of the object of name
+func (s *S3Storage) Create(name string) (io.WriteCloser, error) {
+	u := &s3Upload{storage: s, key: objectKey(s.cfg.Prefix, name)}
+	return newUploadWriter(s.cfg.PartSize, u.upload, u.finish), nil
+}
+
+// objectURL return the url of key with the query
+func (s *S3Storage) objectURL(key, query string) string {
+	var segments []string
+	for _, seg := range strings.Split(key, "/") {
+		segments = append(segments, awsEscape(seg))
+	}
+	escaped := strings.Join(segments, "/")
+	if s.cfg.Endpoint != "" {
+		escaped = strings.TrimSuffix(s.cfg.Endpoint, "/") + "/" + awsEscape(s.cfg.Bucket) + "/" + escaped
+	} else {
+		escaped = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.cfg.Bucket, s.cfg.Region, escaped)
+	}
+	if query != "" {
+		escaped += "?" + query
+	}
+	return escaped
+}
+
+// request return a signed request of the object key
+func (s *S3Storage) request(method, key, query string, body []byte, header http.Header) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		req, err := http.NewRequest(method, s.objectURL(key, query), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		s.sign(req, body, time.Now())
+		return req, nil
+	}
+}
+
+// sign add the aws signature v4 of req, every header is signed
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonical.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := date[:8] + "/" + s.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.cfg.SecretKey)
+	for _, k := range []string{date[:8], s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, k)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape escape all but the unreserved characters, as the aws signature
+func awsEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// canonicalQuery return the query sorted by key and escaped as the aws signature
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// s3Upload is the upload of an object, a single put if it fit in a part, else a multipart upload
+type s3Upload struct {
+	storage  *S3Storage
+	key      string
+	uploadID string
+	etags    []string
+}
+
+func (u *s3Upload) upload(p uploadPart) error {
+	s := u.storage
+	header := http.Header{}
+	if p.last && u.uploadID == "" {
+		header.Set("Content-Type", contentType(u.key))
+		_, _, err := storageRequest(s.cfg.HTTPClient, s.request(http.MethodPut, u.key, "", p.data, header), http.StatusOK)
+		return err
+	}
+	if u.uploadID == "" {
+		header.Set("Content-Type", contentType(u.key))
+		_, body, err := storageRequest(s.cfg.HTTPClient, s.request(http.MethodPost, u.key, "uploads=", nil, header), http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var res struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.Unmarshal(body, &res); err != nil {
+			return err
+		}
+		u.uploadID = res.UploadID
+	}
+	query := fmt.Sprintf("partNumber=%d&uploadId=%s", len(u.etags)+1, awsEscape(u.uploadID))
+	resp, _, err := storageRequest(s.cfg.HTTPClient, s.request(http.MethodPut, u.key, query, p.data, nil), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	u.etags = append(u.etags, resp.Header.Get("ETag"))
+	return nil
+}
+
+// finish complete the multipart upload, or abort it on error
+func (u *s3Upload) finish(err error) error {
+	if u.uploadID == "" {
+		return err
+	}
+	s := u.storage
+	query := "uploadId=" + awsEscape(u.uploadID)
+	if err != nil {
+		if _, _, aerr := storageRequest(s.cfg.HTTPClient, s.request(http.MethodDelete, u.key, query, nil, nil), http.StatusNoContent); aerr != nil {
+			log.Warnf("abort upload %v err=%v", u.key, aerr)
+		}
+		return err
+	}
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range u.etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	_, res, err := storageRequest(s.cfg.HTTPClient, s.request(http.MethodPost, u.key, query, body, nil), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	// the completion can fail after the 200
+	if bytes.Contains(res, []byte("<Error>")) {
+		return fmt.Errorf("complete upload %v: %w %s", u.key, errStorageStatus, res)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+// sampleSink depacketize the packets of a track from the tap and call the handler with the frames
+type sampleSink struct {
+	tap     *rtpTap
+	ssrc    uint32
+	builder *samplebuilder.SampleBuilder
+	fn      func(media.Sample)
+}
+
+// writeRTP push a copy of the packet, the builder keep it until the frame is complete
+func (s *sampleSink) writeRTP(b []byte) {
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(append([]byte{}, b...)); err != nil {
+		return
+	}
+	s.builder.Push(pkt)
+	for sample := s.builder.Pop(); sample != nil; sample = s.builder.Pop() {
+		s.fn(*sample)
+	}
+}
+
+// attachSampleSink start calling the handler of track, must be called with streamLock held
+func (c *Client) attachSampleSink(track *webrtc.TrackRemote) {
+	fn, sub := c.sampleHandlers[track.ID()], c.getSub()
+	if fn == nil || sub == nil {
+		return
+	}
+	c.detachSampleSink(track.ID())
+	depacketizer, err := newDepacketizer(track.Codec().MimeType)
+	if err != nil {
+		log.Warnf("id=%v no sample of track=%v codec=%v", c.uid, track.ID(), track.Codec().MimeType)
+		return
+	}
+	s := &sampleSink{
+		tap:     sub.tap,
+		ssrc:    uint32(track.SSRC()),
+		builder: samplebuilder.New(maxLateFrames, depacketizer, track.Codec().ClockRate),
+		fn:      fn,
+	}
+	s.tap.add(s.ssrc, s)
+	c.sampleSinks[track.ID()] = s
+}
+
+// detachSampleSink stop the sink of trackID, the handler is kept, must be called with streamLock held
+func (c *Client) detachSampleSink(trackID string) {
+	if s, ok := c.sampleSinks[trackID]; ok {
+		s.tap.remove(s.ssrc, s)
+		delete(c.sampleSinks, trackID)
+	}
+}
+
+// OnSample call fn with the depacketized frames of a remote vp8, vp9, h264 or opus track, e.g. for recording
+// or analysis without a depacketizer, the timestamps are in PacketTimestamp, nil fn remove the handler
+// it can be set before the track is received, the frames are copied as the track is read so it must still
+// be read, by OnTrack or by default, fn is called by the reader and should not block
+func (c *Client) OnSample(trackID string, fn func(media.Sample)) {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+	c.detachSampleSink(trackID)
+	if fn == nil {
+		delete(c.sampleHandlers, trackID)
+		return
+	}
+	c.sampleHandlers[trackID] = fn
+	if track := c.subscribedTrack(trackID); track != nil {
+		c.attachSampleSink(track)
+	}
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// ContentHintDetail is for text and slides, sharp frames at a low framerate
+	ContentHintDetail = "detail"
+	// ContentHintMotion is for video playback, smooth frames at a lower resolution
+	ContentHintMotion = "motion"
+
+	// screenStreamPrefix tag the stream id of screen share, e.g. screen_detail_<streamid>
+	screenStreamPrefix = "screen_"
+)
+
+// ScreenConfig is the capture and encode defaults of screen share
+type ScreenConfig struct {
+	ContentHint string
+	Width       int
+	Height      int
+	FrameRate   float32
+}
+
+// GetScreenConfig return the defaults for a content hint, use it to config your capturer and encoder
+func GetScreenConfig(hint string) ScreenConfig {
+	if hint == ContentHintMotion {
+		return ScreenConfig{ContentHint: ContentHintMotion, Width: 1280, Height: 720, FrameRate: 30}
+	}
+	return ScreenConfig{ContentHint: ContentHintDetail, Width: 1920, Height: 1080, FrameRate: 5}
+}
+
+// PublishScreen publish a screen capture track tagged with the content hint
+// receivers could tell screen from camera by ParseScreenStreamID
+func (c *Client) PublishScreen(track webrtc.TrackLocal, hint string, opts ...PubOption) (*webrtc.RTPSender, error) {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return nil, errInvalidKind
+	}
+	if hint != ContentHintMotion {
+		hint = ContentHintDetail
+	}
+	streamID := screenStreamPrefix + hint + "_" + track.StreamID()
+	opts = append([]PubOption{WithStreamID(streamID)}, opts...)
+	return c.PublishTrack(track, opts...)
+}
+
+// ParseScreenStreamID return the content hint if streamID is published by PublishScreen
+func ParseScreenStreamID(streamID string) (string, bool) {
+	if !strings.HasPrefix(streamID, screenStreamPrefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(streamID, screenStreamPrefix)
+	for _, hint := range []string{ContentHintDetail, ContentHintMotion} {
+		if strings.HasPrefix(rest, hint+"_") {
+			return hint, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,69 @@
+//go:build gst
+// +build gst
+
+package engine
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// ScreenProducer capture a display by gstreamer and publish it tagged like PublishScreen, e.g. for a
+// headless screen sharing agent, build with -tags gst
+// the source is ximagesrc on linux, gdiscreencapsrc on windows and avfvideosrc on macos
+type ScreenProducer struct {
+	*GstProducer
+}
+
+// screenSource return the gstreamer source of screen scaled to the config of hint
+func screenSource(screen int, hint string) (string, error) {
+	var src string
+	switch runtime.GOOS {
+	case "linux":
+		// the x display is $DISPLAY, screen is the x screen number
+		src = fmt.Sprintf("ximagesrc screen-num=%d use-damage=false show-pointer=true", screen)
+	case "windows":
+		src = fmt.Sprintf("gdiscreencapsrc monitor=%d cursor=true", screen)
+	case "darwin":
+		src = fmt.Sprintf("avfvideosrc capture-screen=true capture-screen-cursor=true device-index=%d", screen)
+	default:
+		return "", errNoScreenCapture
+	}
+	conf := GetScreenConfig(hint)
+	// the scale keeps the aspect with borders
+	return fmt.Sprintf("%s ! videorate ! videoscale ! videoconvert ! video/x-raw,width=%d,height=%d,framerate=%d/1",
+		src, conf.Width, conf.Height, int(conf.FrameRate)), nil
+}
+
+// NewScreenProducer capture screen, 0 is the default one, at the size and framerate of the content hint
+// videoCodec is vp8|vp9|h264 or a hardware h264 like h264-vaapi, see NewGstProducer
+func NewScreenProducer(id, videoCodec, hint string, screen int) (*ScreenProducer, error) {
+	if hint != ContentHintMotion {
+		hint = ContentHintDetail
+	}
+	src, err := screenSource(screen, hint)
+	if err != nil {
+		return nil, err
+	}
+	p := NewGstProducer(id, videoCodec, src, "")
+	p.streamID = fmt.Sprintf("%s%s_screen_%p", screenStreamPrefix, hint, p)
+	return &ScreenProducer{GstProducer: p}, nil
+}
+
+// PublishScreenCapture capture and publish a screen, see ScreenProducer and ParseScreenStreamID
+func (c *Client) PublishScreenCapture(videoCodec, hint string, screen int) error {
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewScreenProducer(c.uid, videoCodec, hint, screen)
+	if err != nil {
+		return err
+	}
+	if _, err := p.AddTrack(c.getPub().pc, "video"); err != nil {
+		return err
+	}
+	c.setProducer(p)
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
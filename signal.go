@@ -5,68 +5,214 @@ import (
 	"encoding/json"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/pion/ion-sfu/cmd/signal/grpc/proto"
 	"github.com/pion/webrtc/v3"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-// Signal is a wrapper of grpc
-type Signal struct {
+const (
+	// SignalGRPC is the grpc signaling of ion-sfu, the default
+	SignalGRPC = "grpc"
+	// SignalJSONRPC is the json-rpc over websocket signaling of ion-sfu
+	SignalJSONRPC = "jsonrpc"
+)
+
+// authMetadataKey carry the join token in grpc metadata
+const authMetadataKey = "authorization"
+
+// Signal is the signaling between client and sfu, implement it for a custom transport
+// and create the client by NewClientWithSignal
+type Signal interface {
+	// Join send the join with the pub offer, the answer is passed to OnSetRemoteSDP
+	Join(sid string, uid string, offer webrtc.SessionDescription, config *JoinConfig) error
+	// Offer send a pub offer, the answer is passed to OnSetRemoteSDP
+	Offer(sdp webrtc.SessionDescription)
+	// Answer send the sub answer of an offer passed to OnNegotiate
+	Answer(sdp webrtc.SessionDescription)
+	// Trickle send a candidate, target is PUBLISHER or SUBSCRIBER
+	Trickle(candidate *webrtc.ICECandidate, target int)
+	// OnNegotiate set the callback of sub offers from sfu
+	OnNegotiate(f func(webrtc.SessionDescription) error)
+	// OnTrickle set the callback of candidates from sfu
+	OnTrickle(f func(candidate webrtc.ICECandidateInit, target int))
+	// OnSetRemoteSDP set the callback of pub answers from sfu
+	OnSetRemoteSDP(f func(webrtc.SessionDescription) error)
+	// OnError set the callback of a lost signaling, the client is closed or reconnected
+	OnError(f func(error))
+	Close()
+}
+
+// tokenSetter is a Signal carrying the join token out of the join config
+type tokenSetter interface {
+	setToken(token string)
+}
+
+// signalHandlers is the callbacks of signaling, set before Join
+type signalHandlers struct {
+	onNegotiate    func(webrtc.SessionDescription) error
+	onTrickle      func(candidate webrtc.ICECandidateInit, target int)
+	onSetRemoteSDP func(webrtc.SessionDescription) error
+	onError        func(error)
+}
+
+// OnNegotiate set the callback of sub offers
+func (h *signalHandlers) OnNegotiate(f func(webrtc.SessionDescription) error) {
+	h.onNegotiate = f
+}
+
+// OnTrickle set the callback of candidates
+func (h *signalHandlers) OnTrickle(f func(candidate webrtc.ICECandidateInit, target int)) {
+	h.onTrickle = f
+}
+
+// OnSetRemoteSDP set the callback of pub answers
+func (h *signalHandlers) OnSetRemoteSDP(f func(webrtc.SessionDescription) error) {
+	h.onSetRemoteSDP = f
+}
+
+// OnError set the callback of a lost signaling
+func (h *signalHandlers) OnError(f func(error)) {
+	h.onError = f
+}
+
+// GRPCSignal is a wrapper of grpc
+type GRPCSignal struct {
 	id     string
 	client pb.SFUClient
 	stream pb.SFU_SignalClient
 
-	OnNegotiate    func(webrtc.SessionDescription) error
-	OnTrickle      func(candidate webrtc.ICECandidateInit, target int)
-	OnSetRemoteSDP func(webrtc.SessionDescription) error
-	OnError        func(error)
+	signalHandlers
 
 	ctx        context.Context
 	cancel     context.CancelFunc
 	handleOnce sync.Once
+	closeOnce  sync.Once
+	release    func()
+	// the stream is opened by the first request, with the token in metadata
+	openOnce sync.Once
+	openErr  error
+	token    string
+	// sendTimeout cancel a stream blocked on sending, 0 is disabled
+	sendTimeout time.Duration
+	// ping the sfu on conn every keepaliveInterval, 0 is disabled
+	conn              *grpc.ClientConn
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	timedOut          int32
+	// closed when the sfu end the stream
+	done chan struct{}
+	// advertised by the server in the stream headers
+	headerOnce sync.Once
+	protocol   int
+	version    string
 	sync.Mutex
 }
 
 // NewSignal create a grpc signaler
-func NewSignal(addr, id string) (*Signal, error) {
-	s := &Signal{}
-	s.id = id
+func NewSignal(addr, id string) (*GRPCSignal, error) {
 	// Set up a connection to the sfu server.
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
 	if err != nil {
-		log.Errorf("[%v] Connecting to sfu:%s failed: %v", s.id, addr, err)
+		log.Errorf("[%v] Connecting to sfu:%s failed: %v", id, addr, err)
 		return nil, err
 	}
-	log.Infof("[%v] Connecting to sfu ok: %s", s.id, addr)
+	log.Infof("[%v] Connecting to sfu ok: %s", id, addr)
+	return newSignalWithConn(conn, id, func() { conn.Close() })
+}
 
+// newSignalWithConn create a grpc signaler on an existing connection
+// release is called when the signaler is closed
+func newSignalWithConn(conn *grpc.ClientConn, id string, release func()) (*GRPCSignal, error) {
+	s := &GRPCSignal{}
+	s.id = id
+	s.release = release
+	s.done = make(chan struct{})
 	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.conn = conn
 	s.client = pb.NewSFUClient(conn)
-	s.stream, err = s.client.Signal(s.ctx)
-	if err != nil {
-		log.Errorf("err=%v", err)
-		return nil, err
-	}
 	return s, nil
 }
 
-func (s *Signal) onSignalHandleOnce() {
+// send send a request, the stream is canceled if it is blocked longer than sendTimeout
+func (s *GRPCSignal) send(req *pb.SignalRequest) error {
+	if s.sendTimeout <= 0 {
+		s.Lock()
+		defer s.Unlock()
+		return s.stream.Send(req)
+	}
+	done := make(chan error, 1)
+	go func() {
+		s.Lock()
+		defer s.Unlock()
+		done <- s.stream.Send(req)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.sendTimeout):
+		log.Errorf("[%v] send timeout %v, cancel the stream", s.id, s.sendTimeout)
+		s.cancel()
+		return ErrSignalSendTimeout
+	}
+}
+
+// setToken set the auth token sent as grpc metadata, must be called before Join
+func (s *GRPCSignal) setToken(token string) {
+	s.Lock()
+	s.token = token
+	s.Unlock()
+}
+
+// open open the signal stream once
+func (s *GRPCSignal) open() error {
+	s.openOnce.Do(func() {
+		s.Lock()
+		ctx := s.ctx
+		if s.token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, authMetadataKey, "Bearer "+s.token)
+		}
+		s.Unlock()
+		stream, err := s.client.Signal(ctx)
+		s.Lock()
+		s.stream, s.openErr = stream, err
+		s.Unlock()
+		if s.openErr != nil {
+			log.Errorf("[%v] open signal err=%v", s.id, s.openErr)
+		}
+	})
+	return s.openErr
+}
+
+func (s *GRPCSignal) onSignalHandleOnce() {
 	// onSignalHandle is wrapped in a once and only started after another public
 	// method is called to ensure the user has the opportunity to register handlers
 	s.handleOnce.Do(func() {
 		err := s.onSignalHandle()
-		if s.OnError != nil {
-			s.OnError(err)
+		if atomic.LoadInt32(&s.timedOut) == 1 {
+			err = ErrSignalTimeout
+		}
+		close(s.done)
+		if s.onError != nil {
+			s.onError(err)
 		}
 	})
 }
 
-func (s *Signal) onSignalHandle() error {
+func (s *GRPCSignal) onSignalHandle() error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.keepaliveInterval > 0 {
+		go s.keepalive()
+	}
 	for {
 		//only one goroutine for recving from stream, no need to lock
 		res, err := s.stream.Recv()
@@ -90,6 +236,7 @@ func (s *Signal) onSignalHandle() error {
 			log.Errorf("[%v] Error receiving signal response: %v", s.id, err)
 			return err
 		}
+		s.headerOnce.Do(s.readHeader)
 
 		switch payload := res.Payload.(type) {
 		case *pb.SignalReply_Join:
@@ -103,7 +250,7 @@ func (s *Signal) onSignalHandle() error {
 				return err
 			}
 
-			if err = s.OnSetRemoteSDP(sdp); err != nil {
+			if err = s.onSetRemoteSDP(sdp); err != nil {
 				log.Errorf("[%v] [join] s.OnSetRemoteSDP error %s", s.id, err)
 				return err
 			}
@@ -116,13 +263,13 @@ func (s *Signal) onSignalHandle() error {
 			}
 			if sdp.Type == webrtc.SDPTypeOffer {
 				log.Infof("[%v] [description] got offer call s.OnNegotiate sdp=%+v", s.id, sdp)
-				err := s.OnNegotiate(sdp)
+				err := s.onNegotiate(sdp)
 				if err != nil {
 					log.Errorf("err=%v", err)
 				}
 			} else if sdp.Type == webrtc.SDPTypeAnswer {
 				log.Infof("[%v] [description] got answer call s.OnSetRemoteSDP sdp=%+v", s.id, sdp)
-				err = s.OnSetRemoteSDP(sdp)
+				err = s.onSetRemoteSDP(sdp)
 				if err != nil {
 					log.Errorf("[%v] [description] s.OnSetRemoteSDP err=%s", s.id, err)
 				}
@@ -131,25 +278,27 @@ func (s *Signal) onSignalHandle() error {
 			var candidate webrtc.ICECandidateInit
 			_ = json.Unmarshal([]byte(payload.Trickle.Init), &candidate)
 			log.Infof("[%v] [trickle] type=%v candidate=%v", s.id, payload.Trickle.Target, candidate)
-			s.OnTrickle(candidate, int(payload.Trickle.Target))
+			s.onTrickle(candidate, int(payload.Trickle.Target))
 		default:
 			// log.Errorf("Unknow signal type!!!!%v", payload)
 		}
 	}
 }
 
-func (s *Signal) Join(sid string, uid string, offer webrtc.SessionDescription, config *JoinConfig) error {
+func (s *GRPCSignal) Join(sid string, uid string, offer webrtc.SessionDescription, config *JoinConfig) error {
 	log.Infof("[%v] [Signal.Join] sid=%v offer=%v", s.id, sid, offer)
 	marshalled, err := json.Marshal(offer)
 	if err != nil {
 		return err
 	}
+	if err := s.open(); err != nil {
+		return err
+	}
 	go s.onSignalHandleOnce()
-	s.Lock()
 	if config == nil {
 		config = NewJoinConfig()
 	}
-	err = s.stream.Send(
+	err = s.send(
 		&pb.SignalRequest{
 			Payload: &pb.SignalRequest_Join{
 				Join: &pb.JoinRequest{
@@ -161,23 +310,24 @@ func (s *Signal) Join(sid string, uid string, offer webrtc.SessionDescription, c
 			},
 		},
 	)
-	s.Unlock()
 	if err != nil {
 		log.Errorf("[%v] err=%v", s.id, err)
 	}
 	return err
 }
 
-func (s *Signal) Trickle(candidate *webrtc.ICECandidate, target int) {
+func (s *GRPCSignal) Trickle(candidate *webrtc.ICECandidate, target int) {
 	log.Infof("[%v] [Signal.Trickle] candidate=%v target=%v", s.id, candidate, target)
 	bytes, err := json.Marshal(candidate.ToJSON())
 	if err != nil {
 		log.Errorf("err=%v", err)
 		return
 	}
+	if err := s.open(); err != nil {
+		return
+	}
 	go s.onSignalHandleOnce()
-	s.Lock()
-	err = s.stream.Send(&pb.SignalRequest{
+	err = s.send(&pb.SignalRequest{
 		Payload: &pb.SignalRequest_Trickle{
 			Trickle: &pb.Trickle{
 				Init:   string(bytes),
@@ -185,57 +335,63 @@ func (s *Signal) Trickle(candidate *webrtc.ICECandidate, target int) {
 			},
 		},
 	})
-	s.Unlock()
 	if err != nil {
 		log.Errorf("[%v] err=%v", s.id, err)
 	}
 }
 
-func (s *Signal) Offer(sdp webrtc.SessionDescription) {
+func (s *GRPCSignal) Offer(sdp webrtc.SessionDescription) {
 	log.Infof("[%v] [Signal.Offer] sdp=%v", s.id, sdp)
 	marshalled, err := json.Marshal(sdp)
 	if err != nil {
 		log.Errorf("[%v] err=%v", s.id, err)
 		return
 	}
+	if err := s.open(); err != nil {
+		return
+	}
 	go s.onSignalHandleOnce()
-	s.Lock()
-	err = s.stream.Send(
+	err = s.send(
 		&pb.SignalRequest{
 			Payload: &pb.SignalRequest_Description{
 				Description: marshalled,
 			},
 		},
 	)
-	s.Unlock()
 	if err != nil {
 		log.Errorf("[%v] err=%v", s.id, err)
 	}
 }
 
-func (s *Signal) Answer(sdp webrtc.SessionDescription) {
+func (s *GRPCSignal) Answer(sdp webrtc.SessionDescription) {
 	log.Infof("[%v] [Signal.Answer] sdp=%v", s.id, sdp)
 	marshalled, err := json.Marshal(sdp)
 	if err != nil {
 		log.Errorf("err=%v", err)
 		return
 	}
-	s.Lock()
-	err = s.stream.Send(
+	if err := s.open(); err != nil {
+		return
+	}
+	err = s.send(
 		&pb.SignalRequest{
 			Payload: &pb.SignalRequest_Description{
 				Description: marshalled,
 			},
 		},
 	)
-	s.Unlock()
 	if err != nil {
 		log.Errorf("[%v] err=%v", s.id, err)
 	}
 }
 
-func (s *Signal) Close() {
+func (s *GRPCSignal) Close() {
 	log.Infof("[%v] [Signal.Close]", s.id)
 	s.cancel()
+	s.closeOnce.Do(func() {
+		if s.release != nil {
+			s.release()
+		}
+	})
 	go s.onSignalHandleOnce()
 }
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// SignalStats is the signaling latency of a client, to tell slow signaling from media problems
+type SignalStats struct {
+	// JoinLatency is the time from sending the join to the answer, 0 before the answer
+	JoinLatency time.Duration `json:"joinLatency"`
+	// Offers is the pub renegotiations answered by the sfu
+	Offers int `json:"offers"`
+	// LastOfferRTT, AvgOfferRTT and MaxOfferRTT is the offer to answer time of renegotiations
+	LastOfferRTT time.Duration `json:"lastOfferRTT"`
+	AvgOfferRTT  time.Duration `json:"avgOfferRTT"`
+	MaxOfferRTT  time.Duration `json:"maxOfferRTT"`
+	// TricklesSent and TricklesReceived count the candidates of both pcs
+	TricklesSent     int `json:"tricklesSent"`
+	TricklesReceived int `json:"tricklesReceived"`
+}
+
+// signalStats measure from the traced signaling messages
+type signalStats struct {
+	sync.Mutex
+	stats     SignalStats
+	joinSent  time.Time
+	offerSent time.Time
+	totalRTT  time.Duration
+}
+
+func (s *signalStats) sent(msg SignalMessage) {
+	s.Lock()
+	defer s.Unlock()
+	switch msg.Type {
+	case SignalJoin:
+		s.joinSent = msg.Time
+		s.offerSent = time.Time{}
+		s.stats.JoinLatency = 0
+	case SignalOffer:
+		s.offerSent = msg.Time
+	case SignalTrickle:
+		s.stats.TricklesSent++
+	}
+}
+
+func (s *signalStats) received(msg SignalMessage) {
+	s.Lock()
+	defer s.Unlock()
+	switch msg.Type {
+	case SignalAnswer:
+		if !s.joinSent.IsZero() {
+			s.stats.JoinLatency = msg.Time.Sub(s.joinSent)
+			s.joinSent = time.Time{}
+			return
+		}
+		if s.offerSent.IsZero() {
+			return
+		}
+		rtt := msg.Time.Sub(s.offerSent)
+		s.offerSent = time.Time{}
+		s.stats.Offers++
+		s.totalRTT += rtt
+		s.stats.LastOfferRTT = rtt
+		s.stats.AvgOfferRTT = s.totalRTT / time.Duration(s.stats.Offers)
+		if rtt > s.stats.MaxOfferRTT {
+			s.stats.MaxOfferRTT = rtt
+		}
+	case SignalTrickle:
+		s.stats.TricklesReceived++
+	}
+}
+
+// SignalStats return the signaling latency and counters of the client
+func (c *Client) SignalStats() SignalStats {
+	c.sigStats.Lock()
+	defer c.sigStats.Unlock()
+	return c.sigStats.stats
+}
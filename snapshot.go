@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"golang.org/x/image/vp8"
+)
+
+// snapshotTimeout is how long Snapshot wait for a key frame
+const snapshotTimeout = 5 * time.Second
+
+// snapshotDecoders decode a key frame by codec, vp8 is built in, h264 and vp9 need the avcodec build tag
+var snapshotDecoders = map[string]func(frame []byte) (image.Image, error){
+	mimeTypeVP8: decodeVP8,
+}
+
+func decodeVP8(frame []byte) (image.Image, error) {
+	d := vp8.NewDecoder()
+	d.Init(bytes.NewReader(frame), len(frame))
+	if _, err := d.DecodeFrameHeader(); err != nil {
+		return nil, err
+	}
+	return d.DecodeFrame()
+}
+
+// Snapshot wait for the next key frame of a subscribed video track and decode it, e.g. for thumbnails or
+// moderation, a pli is sent until it comes, the track must still be read, by OnTrack or by default
+func (c *Client) Snapshot(trackID string) (image.Image, error) {
+	track, sub := c.subscribedTrack(trackID), c.getSub()
+	if track == nil || sub == nil {
+		log.Errorf("id=%v snapshot unknown remote track %v", c.uid, trackID)
+		return nil, errInvalidTrack
+	}
+	mime := strings.ToLower(track.Codec().MimeType)
+	decode := snapshotDecoders[mime]
+	if decode == nil {
+		return nil, errUnsupportedCodec
+	}
+	depacketizer, err := newDepacketizer(mime)
+	if err != nil {
+		return nil, err
+	}
+	frames := make(chan []byte, 1)
+	s := &sampleSink{
+		tap:     sub.tap,
+		ssrc:    uint32(track.SSRC()),
+		builder: samplebuilder.New(maxLateFrames, depacketizer, track.Codec().ClockRate),
+		fn: func(sample media.Sample) {
+			if key, _, _ := keyFrame(mime, sample.Data); !key {
+				return
+			}
+			select {
+			case frames <- sample.Data:
+			default:
+			}
+		},
+	}
+	s.tap.add(s.ssrc, s)
+	defer s.tap.remove(s.ssrc, s)
+
+	timeout := time.NewTimer(snapshotTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(keyFrameRequestInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.requestKeyFrame(s.ssrc); err != nil {
+			log.Debugf("id=%v pli err=%v", c.uid, err)
+		}
+		select {
+		case frame := <-frames:
+			return decode(frame)
+		case <-ticker.C:
+		case <-timeout.C:
+			return nil, errSnapshotTimeout
+		}
+	}
+}
+
+// SnapshotJPEG return a Snapshot encoded in jpeg, quality is 1 to 100, 0 is the default of image/jpeg
+func (c *Client) SnapshotJPEG(trackID string, quality int) ([]byte, error) {
+	img, err := c.Snapshot(trackID)
+	if err != nil {
+		return nil, err
+	}
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
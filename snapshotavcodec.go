@@ -0,0 +1,109 @@
+//go:build avcodec
+// +build avcodec
+
+package engine
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <stdlib.h>
+#include <libavcodec/avcodec.h>
+
+// snapshot_decode decode a key frame to a yuv420p frame, the caller free it
+static int snapshot_decode(enum AVCodecID id, uint8_t *data, int size, AVFrame **out) {
+	const AVCodec *codec = avcodec_find_decoder(id);
+	if (!codec) {
+		return AVERROR_DECODER_NOT_FOUND;
+	}
+	AVCodecContext *ctx = avcodec_alloc_context3(codec);
+	AVPacket *pkt = av_packet_alloc();
+	AVFrame *frame = av_frame_alloc();
+	int ret = AVERROR(ENOMEM);
+	if (!ctx || !pkt || !frame) {
+		goto end;
+	}
+	if ((ret = avcodec_open2(ctx, codec, NULL)) < 0) {
+		goto end;
+	}
+	pkt->data = data;
+	pkt->size = size;
+	if ((ret = avcodec_send_packet(ctx, pkt)) < 0) {
+		goto end;
+	}
+	// flush, the frame is not delayed by later ones
+	if ((ret = avcodec_send_packet(ctx, NULL)) < 0) {
+		goto end;
+	}
+	if ((ret = avcodec_receive_frame(ctx, frame)) < 0) {
+		goto end;
+	}
+	if (frame->format != AV_PIX_FMT_YUV420P && frame->format != AV_PIX_FMT_YUVJ420P) {
+		ret = AVERROR_PATCHWELCOME;
+		goto end;
+	}
+	*out = frame;
+	frame = NULL;
+end:
+	av_frame_free(&frame);
+	av_packet_free(&pkt);
+	avcodec_free_context(&ctx);
+	return ret;
+}
+
+static void snapshot_free(AVFrame *frame) {
+	av_frame_free(&frame);
+}
+
+static void snapshot_strerror(int err, char *buf, int size) {
+	av_strerror(err, buf, size);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// build with -tags avcodec to Snapshot h264 and vp9 tracks, it needs cgo and libavcodec
+func init() {
+	snapshotDecoders[mimeTypeH264] = func(frame []byte) (image.Image, error) {
+		return decodeAVFrame(C.AV_CODEC_ID_H264, frame)
+	}
+	snapshotDecoders[mimeTypeVP9] = func(frame []byte) (image.Image, error) {
+		return decodeAVFrame(C.AV_CODEC_ID_VP9, frame)
+	}
+}
+
+// decodeAVFrame decode a key frame by libavcodec and copy its planes
+func decodeAVFrame(id C.enum_AVCodecID, frame []byte) (image.Image, error) {
+	if len(frame) == 0 {
+		return nil, errUnsupportedCodec
+	}
+	// libavcodec may read past the packet
+	data := C.CBytes(append(frame, make([]byte, 64)...))
+	defer C.free(data)
+	var f *C.AVFrame
+	if ret := C.snapshot_decode(id, (*C.uint8_t)(data), C.int(len(frame)), &f); ret < 0 {
+		buf := make([]byte, 128)
+		C.snapshot_strerror(ret, (*C.char)(unsafe.Pointer(&buf[0])), C.int(len(buf)))
+		return nil, fmt.Errorf("snapshot decode: %v", C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+	}
+	defer C.snapshot_free(f)
+	w, h := int(f.width), int(f.height)
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	planes := [][]byte{img.Y, img.Cb, img.Cr}
+	strides := []int{img.YStride, img.CStride, img.CStride}
+	for i, plane := range planes {
+		rows := h
+		if i > 0 {
+			rows = (h + 1) / 2
+		}
+		linesize := int(f.linesize[i])
+		src := C.GoBytes(unsafe.Pointer(f.data[i]), C.int(linesize*rows))
+		for y := 0; y < rows; y++ {
+			copy(plane[y*strides[i]:(y+1)*strides[i]], src[y*linesize:])
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,664 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	srtHeaderSize    = 16
+	srtHandshakeSize = 48
+	// srtMagic is the extension field of a v5 induction response
+	srtMagic   = 0x4a17
+	srtVersion = 0x00010403
+	srtMTU     = 1500
+	// srtFlowWindow is the receive buffer in packets
+	srtFlowWindow     = 8192
+	srtDefaultLatency = 120 * time.Millisecond
+	srtDialTimeout    = 5 * time.Second
+	srtPeerTimeout    = 5 * time.Second
+	srtACKInterval    = 10 * time.Millisecond
+	srtNAKInterval    = 20 * time.Millisecond
+	srtKeepalive      = time.Second
+
+	srtCtrlHandshake = 0x0000
+	srtCtrlKeepalive = 0x0001
+	srtCtrlACK       = 0x0002
+	srtCtrlNAK       = 0x0003
+	srtCtrlShutdown  = 0x0005
+	srtCtrlACKACK    = 0x0006
+	srtCtrlDropReq   = 0x0007
+
+	srtHSInduction  = 0x00000001
+	srtHSConclusion = 0xffffffff
+	// srtHSRejectUnsecure is the rejection of an encrypted peer, 1000 + SRT_REJ_UNSECURE
+	srtHSRejectUnsecure = 1011
+
+	// the extension flags of a conclusion and the extension types
+	srtExtHSREQ   = 0x1
+	srtExtKMREQ   = 0x2
+	srtExtConfig  = 0x4
+	srtCmdHSREQ   = 1
+	srtCmdHSRSP   = 2
+	srtCmdKMREQ   = 3
+	srtCmdSID     = 5
+	srtFlagsLive  = 0x01 | 0x02 | 0x08 | 0x10 | 0x20
+	srtSeqMask    = 0x7fffffff
+	srtCtrlFlag   = 0x80000000
+	srtRangeFlag  = 0x80000000
+	srtMaxNAKSeqs = 128
+)
+
+// srtSeqDiff return a - b in the 31 bit sequence space
+func srtSeqDiff(a, b uint32) int32 {
+	return int32((a-b)<<1) >> 1
+}
+
+// srtConn receive a live srt stream without encryption, as a caller or a listener, it is an io.ReadCloser of the payloads
+// the packets are delivered in order, a loss is asked again by nak and skipped after the latency
+type srtConn struct {
+	conn     *net.UDPConn
+	peer     *net.UDPAddr
+	socketID uint32
+	peerID   uint32
+	latency  time.Duration
+	streamID string
+	start    time.Time
+	out      chan []byte
+	rest     []byte
+	done     chan struct{}
+	once     sync.Once
+
+	mu  sync.Mutex
+	err error
+	// next is the sequence number to deliver, highest the highest received
+	next, highest uint32
+	buf           map[uint32][]byte
+	gapSince      time.Time
+	lastRecv      time.Time
+	lastNAK       time.Time
+	ackNo         uint32
+	acked         uint32
+	ackSent       map[uint32]time.Time
+	rtt           time.Duration
+	// the packets and bytes since the last ack, for the rates of the acks
+	packets, bytes int
+	rateSince      time.Time
+}
+
+// dialSRT connect to rawurl like srt://host:9000?streamid=live, or listen for one caller with mode=listener
+// or an empty host like srt://:9000, latency is in ms
+func dialSRT(rawurl string) (*srtConn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "srt" || u.Port() == "" {
+		return nil, errInvalidSRT
+	}
+	q := u.Query()
+	if q.Get("passphrase") != "" {
+		return nil, errSRTEncrypted
+	}
+	c := &srtConn{
+		socketID: rand.Uint32() & srtSeqMask,
+		latency:  srtDefaultLatency,
+		streamID: q.Get("streamid"),
+		out:      make(chan []byte, srtFlowWindow),
+		done:     make(chan struct{}),
+		buf:      make(map[uint32][]byte),
+		ackSent:  make(map[uint32]time.Time),
+		rtt:      100 * time.Millisecond,
+	}
+	if ms, err := strconv.Atoi(q.Get("latency")); err == nil && ms > 0 {
+		c.latency = time.Duration(ms) * time.Millisecond
+	}
+	mode := q.Get("mode")
+	if mode == "" && u.Hostname() == "" {
+		mode = "listener"
+	}
+	switch mode {
+	case "listener":
+		addr, err := net.ResolveUDPAddr("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		if c.conn, err = net.ListenUDP("udp", addr); err != nil {
+			return nil, err
+		}
+		err = c.accept()
+	case "", "caller":
+		if c.peer, err = net.ResolveUDPAddr("udp", u.Host); err != nil {
+			return nil, err
+		}
+		if c.conn, err = net.ListenUDP("udp", nil); err != nil {
+			return nil, err
+		}
+		err = c.call()
+	default:
+		return nil, errInvalidSRT
+	}
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	c.lastRecv, c.rateSince = time.Now(), time.Now()
+	go c.readLoop()
+	go c.tickLoop()
+	return c, nil
+}
+
+// srtHandshake is the cif of a handshake
+type srtHandshake struct {
+	version    uint32
+	encryption uint16
+	extension  uint16
+	isn        uint32
+	hsType     uint32
+	socketID   uint32
+	cookie     uint32
+	// from the hsreq or hsrsp extension
+	latency  time.Duration
+	streamID string
+	kmreq    bool
+}
+
+func parseSRTHandshake(b []byte) (*srtHandshake, bool) {
+	if len(b) < srtHandshakeSize {
+		return nil, false
+	}
+	h := &srtHandshake{
+		version:    binary.BigEndian.Uint32(b[0:]),
+		encryption: binary.BigEndian.Uint16(b[4:]),
+		extension:  binary.BigEndian.Uint16(b[6:]),
+		isn:        binary.BigEndian.Uint32(b[8:]) & srtSeqMask,
+		hsType:     binary.BigEndian.Uint32(b[20:]),
+		socketID:   binary.BigEndian.Uint32(b[24:]),
+		cookie:     binary.BigEndian.Uint32(b[28:]),
+	}
+	for ext := b[srtHandshakeSize:]; len(ext) >= 4; {
+		typ := binary.BigEndian.Uint16(ext)
+		n := int(binary.BigEndian.Uint16(ext[2:])) * 4
+		if len(ext) < 4+n {
+			break
+		}
+		v := ext[4 : 4+n]
+		switch typ {
+		case srtCmdHSREQ, srtCmdHSRSP:
+			if n >= 12 {
+				// the receiver delay of the peer, in the high 16 bits
+				h.latency = time.Duration(binary.BigEndian.Uint16(v[8:])) * time.Millisecond
+			}
+		case srtCmdSID:
+			h.streamID = srtStreamID(v)
+		case srtCmdKMREQ:
+			h.kmreq = true
+		}
+		ext = ext[4+n:]
+	}
+	return h, true
+}
+
+// srtStreamID decode a sid extension, the bytes are reversed in every 32 bit word
+func srtStreamID(v []byte) string {
+	b := make([]byte, 0, len(v))
+	for i := 0; i+4 <= len(v); i += 4 {
+		b = append(b, v[i+3], v[i+2], v[i+1], v[i])
+	}
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+// marshal the handshake, with the hsreq or hsrsp extension and the sid in a conclusion
+func (h *srtHandshake) marshal(peer *net.UDPAddr, ext uint16) []byte {
+	b := make([]byte, srtHandshakeSize, srtHandshakeSize+32)
+	binary.BigEndian.PutUint32(b[0:], h.version)
+	binary.BigEndian.PutUint16(b[4:], h.encryption)
+	binary.BigEndian.PutUint16(b[6:], h.extension)
+	binary.BigEndian.PutUint32(b[8:], h.isn)
+	binary.BigEndian.PutUint32(b[12:], srtMTU)
+	binary.BigEndian.PutUint32(b[16:], srtFlowWindow)
+	binary.BigEndian.PutUint32(b[20:], h.hsType)
+	binary.BigEndian.PutUint32(b[24:], h.socketID)
+	binary.BigEndian.PutUint32(b[28:], h.cookie)
+	if ip := peer.IP.To4(); ip != nil {
+		copy(b[32:], ip)
+	} else {
+		copy(b[32:], peer.IP.To16())
+	}
+	if ext == 0 {
+		return b
+	}
+	var v [16]byte
+	binary.BigEndian.PutUint16(v[0:], ext)
+	binary.BigEndian.PutUint16(v[2:], 3)
+	binary.BigEndian.PutUint32(v[4:], srtVersion)
+	binary.BigEndian.PutUint32(v[8:], srtFlagsLive)
+	ms := uint16(h.latency / time.Millisecond)
+	binary.BigEndian.PutUint16(v[12:], ms)
+	binary.BigEndian.PutUint16(v[14:], ms)
+	b = append(b, v[:]...)
+	if h.streamID != "" {
+		sid := []byte(h.streamID)
+		for len(sid)%4 != 0 {
+			sid = append(sid, 0)
+		}
+		var head [4]byte
+		binary.BigEndian.PutUint16(head[0:], srtCmdSID)
+		binary.BigEndian.PutUint16(head[2:], uint16(len(sid)/4))
+		b = append(b, head[:]...)
+		for i := 0; i < len(sid); i += 4 {
+			b = append(b, sid[i+3], sid[i+2], sid[i+1], sid[i])
+		}
+	}
+	return b
+}
+
+// writeControl send a control packet to the peer
+func (c *srtConn) writeControl(typ uint16, info uint32, dest uint32, cif []byte) error {
+	b := make([]byte, srtHeaderSize+len(cif))
+	binary.BigEndian.PutUint32(b[0:], srtCtrlFlag|uint32(typ)<<16)
+	binary.BigEndian.PutUint32(b[4:], info)
+	binary.BigEndian.PutUint32(b[8:], uint32(time.Since(c.start)/time.Microsecond))
+	binary.BigEndian.PutUint32(b[12:], dest)
+	copy(b[srtHeaderSize:], cif)
+	_, err := c.conn.WriteToUDP(b, c.peer)
+	return err
+}
+
+// readHandshake return the next handshake from the peer, a listener take the address of the first
+func (c *srtConn) readHandshake() (*srtHandshake, error) {
+	b := make([]byte, srtMTU)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(b)
+		if err != nil {
+			return nil, err
+		}
+		if c.peer != nil && c.peer.String() != addr.String() {
+			continue
+		}
+		if n < srtHeaderSize || binary.BigEndian.Uint32(b)>>16 != srtCtrlFlag>>16|srtCtrlHandshake {
+			continue
+		}
+		h, ok := parseSRTHandshake(b[srtHeaderSize:n])
+		if !ok {
+			continue
+		}
+		if c.peer == nil {
+			c.peer = addr
+		}
+		return h, nil
+	}
+}
+
+// call do the induction and conclusion of a caller
+func (c *srtConn) call() error {
+	c.start = time.Now()
+	isn := rand.Uint32() & srtSeqMask
+	// a v4 induction with the udt dgram type
+	induction := &srtHandshake{version: 4, extension: 2, isn: isn, hsType: srtHSInduction, socketID: c.socketID}
+	deadline := time.Now().Add(srtDialTimeout)
+	var res *srtHandshake
+	for res == nil || res.hsType != srtHSInduction || res.extension != srtMagic {
+		if time.Now().After(deadline) {
+			return errSRTTimeout
+		}
+		if err := c.writeControl(srtCtrlHandshake, 0, 0, induction.marshal(c.peer, 0)); err != nil {
+			return err
+		}
+		c.conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		var err error
+		if res, err = c.readHandshake(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+	}
+	conclusion := &srtHandshake{version: 5, extension: srtExtHSREQ, isn: isn, hsType: srtHSConclusion,
+		socketID: c.socketID, cookie: res.cookie, latency: c.latency, streamID: c.streamID}
+	if c.streamID != "" {
+		conclusion.extension |= srtExtConfig
+	}
+	for {
+		if time.Now().After(deadline) {
+			return errSRTTimeout
+		}
+		if err := c.writeControl(srtCtrlHandshake, 0, 0, conclusion.marshal(c.peer, srtCmdHSREQ)); err != nil {
+			return err
+		}
+		c.conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		res, err := c.readHandshake()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if res.hsType >= 1000 && res.hsType != srtHSConclusion {
+			log.Errorf("srt %v rejected with %v", c.peer, res.hsType)
+			return errSRTRejected
+		}
+		if res.hsType != srtHSConclusion {
+			continue
+		}
+		c.conn.SetReadDeadline(time.Time{})
+		c.peerID, c.next, c.highest = res.socketID, isn, (isn-1)&srtSeqMask
+		if res.latency > c.latency {
+			c.latency = res.latency
+		}
+		return nil
+	}
+}
+
+// accept wait for a caller and answer its induction and conclusion
+func (c *srtConn) accept() error {
+	c.start = time.Now()
+	cookie := rand.Uint32()
+	for {
+		h, err := c.readHandshake()
+		if err != nil {
+			return err
+		}
+		res := &srtHandshake{version: 5, isn: h.isn, hsType: h.hsType, socketID: c.socketID, cookie: cookie}
+		switch {
+		case h.hsType == srtHSInduction:
+			res.extension = srtMagic
+			if err := c.writeControl(srtCtrlHandshake, 0, h.socketID, res.marshal(c.peer, 0)); err != nil {
+				return err
+			}
+		case h.hsType == srtHSConclusion && h.cookie == cookie:
+			if h.encryption != 0 || h.extension&srtExtKMREQ != 0 || h.kmreq {
+				res.hsType = srtHSRejectUnsecure
+				c.writeControl(srtCtrlHandshake, 0, h.socketID, res.marshal(c.peer, 0))
+				return errSRTEncrypted
+			}
+			if h.latency > c.latency {
+				c.latency = h.latency
+			}
+			res.extension, res.latency = srtExtHSREQ, c.latency
+			if err := c.writeControl(srtCtrlHandshake, 0, h.socketID, res.marshal(c.peer, srtCmdHSRSP)); err != nil {
+				return err
+			}
+			c.peerID, c.next, c.highest = h.socketID, h.isn, (h.isn-1)&srtSeqMask
+			c.streamID = h.streamID
+			log.Infof("srt caller %v connected streamid=%v latency=%v", c.peer, c.streamID, c.latency)
+			return nil
+		}
+	}
+}
+
+// readLoop handle the packets until close
+func (c *srtConn) readLoop() {
+	b := make([]byte, srtMTU*2)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(b)
+		if err != nil {
+			c.closeWith(err)
+			return
+		}
+		if n < srtHeaderSize || addr.String() != c.peer.String() {
+			continue
+		}
+		c.mu.Lock()
+		c.lastRecv = time.Now()
+		c.mu.Unlock()
+		word := binary.BigEndian.Uint32(b)
+		if word&srtCtrlFlag == 0 {
+			c.receive(word&srtSeqMask, append([]byte{}, b[srtHeaderSize:n]...))
+			continue
+		}
+		cif := b[srtHeaderSize:n]
+		switch uint16(word >> 16 & 0x7fff) {
+		case srtCtrlShutdown:
+			c.closeWith(io.EOF)
+			return
+		case srtCtrlACKACK:
+			c.ackACK(binary.BigEndian.Uint32(b[4:]))
+		case srtCtrlDropReq:
+			if len(cif) >= 8 {
+				c.drop(binary.BigEndian.Uint32(cif)&srtSeqMask, binary.BigEndian.Uint32(cif[4:])&srtSeqMask)
+			}
+		case srtCtrlHandshake:
+			// the conclusion again, the response was lost
+			if h, ok := parseSRTHandshake(cif); ok && h.hsType == srtHSConclusion && h.socketID == c.peerID {
+				res := &srtHandshake{version: 5, extension: srtExtHSREQ, isn: h.isn, hsType: srtHSConclusion,
+					socketID: c.socketID, cookie: h.cookie, latency: c.latency}
+				c.writeControl(srtCtrlHandshake, 0, c.peerID, res.marshal(c.peer, srtCmdHSRSP))
+			}
+		}
+	}
+}
+
+// receive buffer a data packet and deliver the packets in order
+func (c *srtConn) receive(seq uint32, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := srtSeqDiff(seq, c.next)
+	if d < 0 || d >= srtFlowWindow {
+		return
+	}
+	c.packets++
+	c.bytes += len(payload)
+	if srtSeqDiff(seq, c.highest) > 0 {
+		c.highest = seq
+	}
+	c.buf[seq] = payload
+	if d > 0 && c.gapSince.IsZero() {
+		c.gapSince = time.Now()
+	}
+	c.deliver()
+}
+
+// deliver send the contiguous packets from next, it must be called locked
+func (c *srtConn) deliver() {
+	for {
+		p, ok := c.buf[c.next]
+		if !ok {
+			break
+		}
+		delete(c.buf, c.next)
+		c.next = (c.next + 1) & srtSeqMask
+		select {
+		case c.out <- p:
+		default:
+			log.Warnf("srt %v reader is late, drop a packet", c.peer)
+		}
+	}
+	if len(c.buf) == 0 {
+		c.gapSince = time.Time{}
+	}
+}
+
+// drop skip the packets from first to last, the sender will not retransmit them
+func (c *srtConn) drop(first, last uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if srtSeqDiff(c.next, first) < 0 || srtSeqDiff(c.next, last) > 0 {
+		return
+	}
+	c.next = (last + 1) & srtSeqMask
+	c.deliver()
+}
+
+// ackACK measure the rtt of an ack
+func (c *srtConn) ackACK(ackNo uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sent, ok := c.ackSent[ackNo]; ok {
+		c.rtt = (c.rtt*7 + time.Since(sent)) / 8
+		delete(c.ackSent, ackNo)
+	}
+}
+
+// tickLoop send the acks, naks and keepalives, and skip the losses older than the latency
+func (c *srtConn) tickLoop() {
+	ticker := time.NewTicker(srtACKInterval)
+	defer ticker.Stop()
+	var lastKeepalive time.Time
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		c.mu.Lock()
+		if now.Sub(c.lastRecv) > srtPeerTimeout {
+			c.mu.Unlock()
+			c.closeWith(errSRTTimeout)
+			return
+		}
+		if !c.gapSince.IsZero() && now.Sub(c.gapSince) > c.latency {
+			// too late for a retransmission, skip to the first buffered packet
+			skip := c.highest
+			for seq := range c.buf {
+				if srtSeqDiff(seq, skip) < 0 {
+					skip = seq
+				}
+			}
+			log.Debugf("srt %v lost %v packets", c.peer, srtSeqDiff(skip, c.next))
+			c.next = skip
+			c.gapSince = time.Time{}
+			c.deliver()
+			if len(c.buf) > 0 {
+				c.gapSince = now
+			}
+		}
+		ackNo, ack := c.ackPacket(now)
+		nak := c.nakPacket(now)
+		c.mu.Unlock()
+
+		if ack != nil {
+			c.writeControl(srtCtrlACK, ackNo, c.peerID, ack)
+		}
+		if nak != nil {
+			c.writeControl(srtCtrlNAK, 0, c.peerID, nak)
+		}
+		if now.Sub(lastKeepalive) > srtKeepalive {
+			lastKeepalive = now
+			c.writeControl(srtCtrlKeepalive, 0, c.peerID, nil)
+		}
+	}
+}
+
+// ackPacket return the ack number and the cif of a full ack if next moved, it must be called locked
+func (c *srtConn) ackPacket(now time.Time) (uint32, []byte) {
+	if c.next == c.acked && c.packets == 0 {
+		return 0, nil
+	}
+	c.acked = c.next
+	c.ackNo++
+	c.ackSent[c.ackNo] = now
+	for no, sent := range c.ackSent {
+		if now.Sub(sent) > srtPeerTimeout {
+			delete(c.ackSent, no)
+		}
+	}
+	elapsed := now.Sub(c.rateSince).Seconds()
+	if elapsed <= 0 {
+		elapsed = srtACKInterval.Seconds()
+	}
+	// the last field is the link capacity, estimated by the receive rate
+	b := make([]byte, 28)
+	binary.BigEndian.PutUint32(b[0:], c.next)
+	binary.BigEndian.PutUint32(b[4:], uint32(c.rtt/time.Microsecond))
+	binary.BigEndian.PutUint32(b[8:], uint32(c.rtt/2/time.Microsecond))
+	binary.BigEndian.PutUint32(b[12:], uint32(srtFlowWindow-len(c.buf)))
+	binary.BigEndian.PutUint32(b[16:], uint32(float64(c.packets)/elapsed))
+	binary.BigEndian.PutUint32(b[20:], uint32(float64(c.packets)/elapsed))
+	binary.BigEndian.PutUint32(b[24:], uint32(float64(c.bytes)/elapsed))
+	c.packets, c.bytes, c.rateSince = 0, 0, now
+	return c.ackNo, b
+}
+
+// nakPacket return the cif of the missing packets, a range is first|srtRangeFlag and last, it must be called locked
+func (c *srtConn) nakPacket(now time.Time) []byte {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	interval := c.rtt
+	if interval < srtNAKInterval {
+		interval = srtNAKInterval
+	}
+	if now.Sub(c.lastNAK) < interval {
+		return nil
+	}
+	c.lastNAK = now
+	var b []byte
+	seqs := 0
+	for seq := c.next; srtSeqDiff(seq, c.highest) < 0 && seqs < srtMaxNAKSeqs; {
+		if _, ok := c.buf[seq]; ok {
+			seq = (seq + 1) & srtSeqMask
+			continue
+		}
+		last := seq
+		for srtSeqDiff(last, c.highest) < 0 {
+			if _, ok := c.buf[(last+1)&srtSeqMask]; ok {
+				break
+			}
+			last = (last + 1) & srtSeqMask
+		}
+		var v [8]byte
+		if last == seq {
+			binary.BigEndian.PutUint32(v[:], seq)
+			b = append(b, v[:4]...)
+		} else {
+			binary.BigEndian.PutUint32(v[:], seq|srtRangeFlag)
+			binary.BigEndian.PutUint32(v[4:], last)
+			b = append(b, v[:]...)
+		}
+		seqs++
+		seq = (last + 1) & srtSeqMask
+	}
+	return b
+}
+
+// Read return the payloads in order, the mpeg-ts of a live stream
+func (c *srtConn) Read(p []byte) (int, error) {
+	if len(c.rest) == 0 {
+		select {
+		case c.rest = <-c.out:
+		case <-c.done:
+			select {
+			case c.rest = <-c.out:
+			default:
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return 0, c.err
+			}
+		}
+	}
+	n := copy(p, c.rest)
+	c.rest = c.rest[n:]
+	return n, nil
+}
+
+// closeWith close the connection, the reads return err once the buffered payloads are read
+func (c *srtConn) closeWith(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		if err != io.EOF {
+			log.Debugf("srt %v closed err=%v", c.peer, err)
+		}
+		c.writeControl(srtCtrlShutdown, 0, c.peerID, make([]byte, 4))
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Close send a shutdown and close the connection
+func (c *srtConn) Close() error {
+	c.closeWith(io.EOF)
+	return nil
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// opusControl prefix an opus packet with the control header of ts
+func opusControl(p []byte) []byte {
+	b := []byte{0x7f, 0xe0}
+	n := len(p)
+	for ; n >= 0xff; n -= 0xff {
+		b = append(b, 0xff)
+	}
+	return append(append(b, byte(n)), p...)
+}
+
+func TestTSReaderOpus(t *testing.T) {
+	// 0xfc is a celt packet of 20ms
+	packet := func(size int) []byte {
+		return append([]byte{0xfc}, bytes.Repeat([]byte{1}, size-1)...)
+	}
+	for _, tc := range []struct {
+		name    string
+		pes     [][][]byte
+		garbage []byte
+		want    []time.Duration
+	}{
+		{
+			name: "a packet per pes",
+			pes:  [][][]byte{{packet(100)}, {packet(120)}},
+			want: []time.Duration{0, 20 * time.Millisecond},
+		},
+		{
+			name: "packets in a pes",
+			pes:  [][][]byte{{packet(100), packet(300), packet(255)}},
+			want: []time.Duration{0, 20 * time.Millisecond, 40 * time.Millisecond},
+		},
+		{
+			name:    "resync",
+			pes:     [][][]byte{{packet(10)}},
+			garbage: []byte{0x00, 0x01, 0x02},
+			want:    []time.Duration{0},
+		},
+	} {
+		m := newTSMuxer(false, false)
+		w := &bytes.Buffer{}
+		w.Write(tc.garbage)
+		const pid = 0x102
+		m.section(w, 0, []byte{0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00,
+			0x00, 0x01, 0xe0 | tsPMTPID>>8, tsPMTPID & 0xff})
+		// an opus stream has the registration descriptor of Opus
+		streams := []byte{tsStreamPrivate, 0xe0 | pid>>8, pid & 0xff, 0xf0, 6, 0x05, 4, 'O', 'p', 'u', 's'}
+		n := 13 + len(streams)
+		pmt := []byte{0x02, 0xb0 | byte(n>>8), byte(n), 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe0 | pid>>8, pid & 0xff, 0xf0, 0x00}
+		m.section(w, tsPMTPID, append(pmt, streams...))
+		var sent [][]byte
+		for i, packets := range tc.pes {
+			var data []byte
+			for _, p := range packets {
+				data = append(data, opusControl(p)...)
+				sent = append(sent, p)
+			}
+			m.writePES(w, pid, 0xbd, int64(i)*1800, false, data)
+		}
+
+		r, err := newTSReader(bytes.NewReader(w.Bytes()))
+		require.NoError(t, err, tc.name)
+		require.Len(t, r.streams, 1, tc.name)
+		assert.Equal(t, "opus", r.streams[0].codec, tc.name)
+		var got [][]byte
+		var times []time.Duration
+		for {
+			f, err := r.next()
+			if err != nil {
+				break
+			}
+			got = append(got, f.data)
+			times = append(times, f.time)
+		}
+		assert.Equal(t, sent, got, tc.name)
+		assert.Equal(t, tc.want, times, tc.name)
+	}
+}
+
+func TestSRTSeqDiff(t *testing.T) {
+	for _, tc := range []struct {
+		a, b uint32
+		want int32
+	}{
+		{5, 3, 2},
+		{3, 5, -2},
+		{0, srtSeqMask, 1},
+		{srtSeqMask, 0, -1},
+	} {
+		assert.Equal(t, tc.want, srtSeqDiff(tc.a, tc.b), "%v - %v", tc.a, tc.b)
+	}
+}
+
+func TestSRTReceive(t *testing.T) {
+	// nak decode the cif of a nak in the sequences lost
+	nak := func(b []byte) []uint32 {
+		var seqs []uint32
+		for len(b) >= 4 {
+			seq := binary.BigEndian.Uint32(b)
+			b = b[4:]
+			if seq&srtRangeFlag == 0 {
+				seqs = append(seqs, seq)
+				continue
+			}
+			last := binary.BigEndian.Uint32(b)
+			b = b[4:]
+			for s := seq &^ srtRangeFlag; s != last+1; s = (s + 1) & srtSeqMask {
+				seqs = append(seqs, s)
+			}
+		}
+		return seqs
+	}
+	for _, tc := range []struct {
+		name  string
+		first uint32
+		recv  []uint32
+		// drop is a drop request of the range, before the delivery
+		drop    []uint32
+		deliver []uint32
+		lost    []uint32
+	}{
+		{name: "in order", recv: []uint32{0, 1, 2}, deliver: []uint32{0, 1, 2}},
+		{name: "reorder", recv: []uint32{0, 2, 1, 3}, deliver: []uint32{0, 1, 2, 3}},
+		{name: "loss", recv: []uint32{0, 2, 5}, deliver: []uint32{0}, lost: []uint32{1, 3, 4}},
+		{name: "duplicate and late", recv: []uint32{0, 1, 1, 0, 2}, deliver: []uint32{0, 1, 2}},
+		{name: "drop request", recv: []uint32{0, 3}, drop: []uint32{1, 2}, deliver: []uint32{0, 3}},
+		{name: "wrap", first: srtSeqMask - 1, recv: []uint32{srtSeqMask - 1, 0, srtSeqMask, 1},
+			deliver: []uint32{srtSeqMask - 1, srtSeqMask, 0, 1}},
+		{name: "loss at wrap", first: srtSeqMask, recv: []uint32{srtSeqMask, 2}, deliver: []uint32{srtSeqMask}, lost: []uint32{0, 1}},
+	} {
+		c := &srtConn{
+			out:     make(chan []byte, srtFlowWindow),
+			buf:     make(map[uint32][]byte),
+			ackSent: make(map[uint32]time.Time),
+			next:    tc.first,
+			highest: (tc.first - 1) & srtSeqMask,
+		}
+		for _, seq := range tc.recv {
+			p := make([]byte, 4)
+			binary.BigEndian.PutUint32(p, seq)
+			c.receive(seq, p)
+		}
+		if tc.drop != nil {
+			c.drop(tc.drop[0], tc.drop[1])
+		}
+		var delivered []uint32
+		for len(c.out) > 0 {
+			delivered = append(delivered, binary.BigEndian.Uint32(<-c.out))
+		}
+		assert.Equal(t, tc.deliver, delivered, tc.name)
+		c.mu.Lock()
+		lost := nak(c.nakPacket(time.Now()))
+		c.mu.Unlock()
+		assert.Equal(t, tc.lost, lost, tc.name)
+	}
+}
@@ -0,0 +1,54 @@
+package engine
+
+// SRTProducer receive a mpeg-ts stream by srt and publish its h264 video and opus audio, e.g. from
+// ffmpeg -re -i input -c:v libx264 -c:a libopus -f mpegts srt://host:9000?mode=caller
+// aac audio is not supported without transcoding, the srt encryption neither
+type SRTProducer struct {
+	*StreamProducer
+}
+
+// NewSRTProducer connect to the srt url and read the pmt of the stream, see dialSRT for the url
+// a listener like srt://:9000 block until a caller connects
+func NewSRTProducer(id, url string) (*SRTProducer, error) {
+	conn, err := dialSRT(url)
+	if err != nil {
+		log.Errorf("dial srt %v err=%v", url, err)
+		return nil, err
+	}
+	p, err := NewStreamProducer(id, conn, StreamMPEGTS)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &SRTProducer{StreamProducer: p}, nil
+}
+
+// PublishSRT publish the stream of an srt caller or listener, see SRTProducer
+func (c *Client) PublishSRT(url string, video, audio bool) error {
+	pub := c.getPub()
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewSRTProducer(c.uid, url)
+	if err != nil {
+		return err
+	}
+	if video {
+		if _, err := p.AddTrack(pub.pc, "video"); err != nil {
+			log.Debugf("err=%v", err)
+			p.Stop()
+			return err
+		}
+	}
+	if audio {
+		if _, err := p.AddTrack(pub.pc, "audio"); err != nil {
+			log.Debugf("err=%v", err)
+			p.Stop()
+			return err
+		}
+	}
+	c.setProducer(p)
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
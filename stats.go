@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+// ClientReport is the pion stats of pub and sub with sdk counters
+type ClientReport struct {
+	Pub webrtc.StatsReport
+	Sub webrtc.StatsReport
+	// rtp bytes sent per published track id
+	SentTrackBytes map[string]uint64
+	// rtp bytes received per subscribed track id
+	RecvTrackBytes map[string]uint64
+	// Signal is the signaling latency
+	Signal SignalStats
+}
+
+// GetStats return the stats of pub and sub, with packet loss, jitter and rtt from pion
+func (c *Client) GetStats() ClientReport {
+	pub := c.getPub()
+	sub := c.getSub()
+	r := ClientReport{
+		Pub:            pub.pc.GetStats(),
+		Sub:            c.GetSubStats(),
+		SentTrackBytes: make(map[string]uint64),
+		RecvTrackBytes: make(map[string]uint64),
+		Signal:         c.SignalStats(),
+	}
+	for _, s := range pub.pc.GetSenders() {
+		track := s.Track()
+		if track == nil {
+			continue
+		}
+		for _, enc := range s.GetParameters().Encodings {
+			r.SentTrackBytes[track.ID()] += pub.monitor.Bytes(uint32(enc.SSRC))
+		}
+	}
+	if sub == nil {
+		return r
+	}
+	for _, recv := range sub.pc.GetReceivers() {
+		for _, track := range recv.Tracks() {
+			r.RecvTrackBytes[track.ID()] += sub.monitor.Bytes(uint32(track.SSRC()))
+		}
+	}
+	return r
+}
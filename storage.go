@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUploadPartSize = 8 << 20
+	// uploadQueue is the parts buffered while one is uploaded
+	uploadQueue = 2
+	// uploadRetries is the attempts of a request failing with a network error or a 5xx
+	uploadRetries = 3
+)
+
+// Storage create the files of the recordings, e.g. to stream them to object storage instead of the local disk
+// a file is complete once its Close return nil, until then it is not visible or is removed on error
+type Storage interface {
+	Create(name string) (io.WriteCloser, error)
+}
+
+// WithStorage write the files of the recorder to s, see LocalStorage, S3Storage and GCSStorage
+func WithStorage(s Storage) RecordOption {
+	return func(o *recordOptions) {
+		o.storage = s
+	}
+}
+
+// LocalStorage write the files to the local disk, to name.part renamed to name at Close
+type LocalStorage struct{}
+
+// Create create name.part
+func (LocalStorage) Create(name string) (io.WriteCloser, error) {
+	f, err := os.Create(name + partSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: f, name: name}, nil
+}
+
+type localFile struct {
+	*os.File
+	name string
+}
+
+// Close close the file and rename it, it is removed if it can not be closed
+func (f *localFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+// objectKey return the key of a file name under prefix, with slashes and without leading slash
+func objectKey(prefix, name string) string {
+	return strings.TrimPrefix(path.Join(prefix, filepath.ToSlash(name)), "/")
+}
+
+// contentType return the mime type of a file name
+func contentType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".webm":
+		return "video/webm"
+	case ".ts":
+		return "video/mp2t"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// uploadPart is a part of an uploadWriter, last is the end of the file
+type uploadPart struct {
+	data   []byte
+	offset int64
+	last   bool
+}
+
+// uploadWriter buffer a file in parts uploaded in order in the background, the writes block while the
+// queue is full, an upload error fail the next writes and Close
+type uploadWriter struct {
+	size   int
+	buf    []byte
+	offset int64
+	parts  chan uploadPart
+	done   chan struct{}
+	upload func(p uploadPart) error
+	// finish complete the file, or abort it on error
+	finish func(err error) error
+	closed bool
+
+	mu  sync.Mutex
+	err error
+}
+
+func newUploadWriter(size int, upload func(p uploadPart) error, finish func(err error) error) *uploadWriter {
+	w := &uploadWriter{
+		size:   size,
+		parts:  make(chan uploadPart, uploadQueue),
+		done:   make(chan struct{}),
+		upload: upload,
+		finish: finish,
+	}
+	go w.loop()
+	return w
+}
+
+func (w *uploadWriter) loop() {
+	defer close(w.done)
+	for p := range w.parts {
+		if w.failed() != nil {
+			continue
+		}
+		if err := w.upload(p); err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *uploadWriter) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Write buffer b, the full parts are queued, a part is kept for Close
+func (w *uploadWriter) Write(b []byte) (int, error) {
+	if err := w.failed(); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf, b...)
+	for len(w.buf) > w.size {
+		w.parts <- uploadPart{data: w.buf[:w.size:w.size], offset: w.offset}
+		w.offset += int64(w.size)
+		w.buf = append([]byte{}, w.buf[w.size:]...)
+	}
+	return len(b), nil
+}
+
+// Close upload the last part and complete the file
+func (w *uploadWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.parts <- uploadPart{data: w.buf, offset: w.offset, last: true}
+	close(w.parts)
+	<-w.done
+	w.buf = nil
+	return w.finish(w.failed())
+}
+
+// storageRequest send the request built by newReq, retried on network errors and 5xx, and return the body of a
+// response with one of the ok status
+func storageRequest(client *http.Client, newReq func() (*http.Request, error), ok ...int) (*http.Response, []byte, error) {
+	var err error
+	for i := 0; i < uploadRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 500 * time.Millisecond)
+		}
+		var req *http.Request
+		if req, err = newReq(); err != nil {
+			return nil, nil, err
+		}
+		resp, rerr := client.Do(req)
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+		body, rerr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		for _, status := range ok {
+			if resp.StatusCode == status && rerr == nil {
+				return resp, body, nil
+			}
+		}
+		err = fmt.Errorf("%v %v: %w %v %s", req.Method, req.URL.Path, errStorageStatus, resp.Status, body)
+		if rerr == nil && resp.StatusCode < http.StatusInternalServerError {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, err
+}
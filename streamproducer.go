@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+)
+
+const (
+	// StreamIVF is an ivf stream of vp8/vp9, e.g. ffmpeg -f ivf pipe:1
+	StreamIVF = "ivf"
+	// StreamMatroska is a matroska/webm stream of vp8/vp9/h264 and opus, e.g. ffmpeg -f matroska pipe:1
+	StreamMatroska = "matroska"
+	// StreamMPEGTS is a mpeg-ts stream of h264 and opus, e.g. ffmpeg -c:a libopus -f mpegts pipe:1
+	// aac needs transcoding which is not supported without native libs
+	StreamMPEGTS = "mpegts"
+)
+
+// streamTrack is an output of the stream producer
+type streamTrack struct {
+	track *webrtc.TrackLocalStaticSample
+	// h264 in avcc is converted to annex b
+	avc *mp4Track
+	// the previous video frame, written when the next one give its duration
+	pending     []byte
+	pendingTime time.Duration
+	// the pause added to the next sample
+	gap time.Duration
+}
+
+// StreamProducer publish an ivf or matroska stream read from an io.Reader, typically the stdout of ffmpeg
+// frames are paced by their timestamps, so a realtime source like ffmpeg -re is not delayed
+type StreamProducer struct {
+	id         string
+	format     string
+	reader     io.Reader
+	ivf        *ivfreader.IVFReader
+	ivfHeader  *ivfreader.IVFFileHeader
+	mkv        *mkvReader
+	ts         *tsReader
+	outputs    map[uint64]*streamTrack
+	videoTrack *webrtc.TrackLocalStaticSample
+	audioTrack *webrtc.TrackLocalStaticSample
+	sendByte   uint64
+	lastSend   uint64
+	done       chan struct{}
+	stopOnce   sync.Once
+	pauseGate
+	progress
+}
+
+// NewStreamProducer read the stream header of format StreamIVF, StreamMatroska or StreamMPEGTS
+// an io.Closer reader is closed when the producer stops
+func NewStreamProducer(id string, r io.Reader, format string) (*StreamProducer, error) {
+	p := &StreamProducer{
+		id:      id,
+		format:  format,
+		reader:  r,
+		outputs: make(map[uint64]*streamTrack),
+		done:    make(chan struct{}),
+	}
+	var err error
+	switch format {
+	case StreamIVF:
+		p.ivf, p.ivfHeader, err = ivfreader.NewWith(r)
+	case StreamMatroska:
+		p.mkv, err = newMKVReader(r)
+	case StreamMPEGTS:
+		p.ts, err = newTSReader(r)
+	default:
+		return nil, errInvalidFile
+	}
+	if err != nil {
+		log.Errorf("read %v stream header err=%v", format, err)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (t *StreamProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return t.audioTrack
+}
+
+func (t *StreamProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return t.videoTrack
+}
+
+// AddTrack add the first track of kind with a supported codec to pc
+func (t *StreamProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "video" && kind != "audio" {
+		return nil, errInvalidKind
+	}
+	number, codec, avc, err := t.findTrack(kind)
+	if err != nil {
+		return nil, err
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, kind, fmt.Sprintf("stream_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.outputs[number] = &streamTrack{track: track, avc: avc}
+	if kind == "video" {
+		t.videoTrack = track
+	} else {
+		t.audioTrack = track
+	}
+	return track, nil
+}
+
+// findTrack return the track number and codec of kind, ivf has a single video track 0
+func (t *StreamProducer) findTrack(kind string) (uint64, webrtc.RTPCodecCapability, *mp4Track, error) {
+	vp8 := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	vp9 := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}
+	if t.ivf != nil {
+		if kind == "video" {
+			switch t.ivfHeader.FourCC {
+			case "VP80":
+				return 0, vp8, nil, nil
+			case "VP90":
+				return 0, vp9, nil, nil
+			}
+		}
+		return 0, webrtc.RTPCodecCapability{}, nil, errUnsupportedCodec
+	}
+	if t.ts != nil {
+		for _, s := range t.ts.streams {
+			switch {
+			case kind == "video" && s.codec == "h264":
+				return uint64(s.pid), webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+					SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}, nil, nil
+			case kind == "audio" && s.codec == "opus":
+				return uint64(s.pid), webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2}, nil, nil
+			case kind == "audio" && s.codec == "aac":
+				log.Warnf("id=%v mpegts aac audio is not supported, remux it to opus", t.id)
+			}
+		}
+		return 0, webrtc.RTPCodecCapability{}, nil, errUnsupportedCodec
+	}
+	for _, tr := range t.mkv.tracks {
+		if tr.kind != kind {
+			continue
+		}
+		switch tr.codecID {
+		case "V_VP8":
+			return tr.number, vp8, nil, nil
+		case "V_VP9":
+			return tr.number, vp9, nil, nil
+		case "V_MPEG4/ISO/AVC":
+			avc := &mp4Track{}
+			if err := avc.parseAvcC(tr.private); err != nil {
+				return 0, webrtc.RTPCodecCapability{}, nil, err
+			}
+			return tr.number, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+				SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}, avc, nil
+		case "A_OPUS":
+			return tr.number, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2}, nil, nil
+		}
+	}
+	return 0, webrtc.RTPCodecCapability{}, nil, errUnsupportedCodec
+}
+
+func (t *StreamProducer) Start() {
+	go t.readLoop()
+}
+
+// Stop stop reading, the reader is closed if it is an io.Closer
+func (t *StreamProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		if c, ok := t.reader.(io.Closer); ok {
+			c.Close()
+		}
+	})
+}
+
+// SeekTo is not supported by a stream
+func (t *StreamProducer) SeekTo(d time.Duration) error {
+	return errNotSeekable
+}
+
+// next return the next frame of the stream
+func (t *StreamProducer) next() (uint64, time.Duration, []byte, error) {
+	if t.ivf != nil {
+		frame, header, err := t.ivf.ParseNextFrame()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		ts := time.Duration(header.Timestamp) * time.Second * time.Duration(t.ivfHeader.TimebaseNumerator) / time.Duration(t.ivfHeader.TimebaseDenominator)
+		return 0, ts, frame, nil
+	}
+	if t.ts != nil {
+		f, err := t.ts.next()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		return uint64(f.pid), f.time, f.data, nil
+	}
+	b, err := t.mkv.nextBlock()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return b.track, b.time, b.data, nil
+}
+
+func (t *StreamProducer) readLoop() {
+	clock := newMediaClock()
+	first := time.Duration(-1)
+	var end error
+	for {
+		number, ts, data, err := t.next()
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Errorf("id=%v read %v stream err=%v", t.id, t.format, err)
+				end = err
+			}
+			break
+		}
+		out, ok := t.outputs[number]
+		if !ok {
+			continue
+		}
+		if first < 0 {
+			first = ts
+		}
+		if !clock.wait(ts-first, t.done) {
+			return
+		}
+		gap, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		t.setPosition(ts - first)
+		if gap > 0 {
+			clock.reset(ts - first)
+			for _, o := range t.outputs {
+				o.gap += gap
+			}
+		}
+		if out.avc != nil {
+			data = out.avc.annexB(data, true)
+		}
+		if out.track.Kind() == webrtc.RTPCodecTypeAudio {
+			t.write(out, data, opusDuration(data))
+			continue
+		}
+		// the duration of a video frame is known with the next one
+		if out.pending != nil {
+			t.write(out, out.pending, ts-out.pendingTime)
+		}
+		out.pending, out.pendingTime = data, ts
+	}
+	t.ended(end)
+	log.Infof("Exiting %v stream producer", t.format)
+}
+
+func (t *StreamProducer) write(out *streamTrack, data []byte, d time.Duration) {
+	d += out.gap
+	out.gap = 0
+	if err := out.track.WriteSample(media.Sample{Data: data, Duration: d}); err != nil {
+		log.Errorf("Track write error=%v", err)
+		return
+	}
+	atomic.AddUint64(&t.sendByte, uint64(len(data)))
+	t.sent(len(data))
+}
+
+// GetSendBandwidth calc the sending bandwidth with cycle(s)
+func (t *StreamProducer) GetSendBandwidth(cycle int) int {
+	sendByte := atomic.LoadUint64(&t.sendByte)
+	bw := int(sendByte-t.lastSend) / cycle / 1000
+	t.lastSend = sendByte
+	return bw
+}
+
+// SendBytes return the total sent bytes
+func (t *StreamProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
+// PublishStream publish an ivf, matroska or mpeg-ts stream, e.g. the stdout of
+// ffmpeg -re -i input -c:v libvpx -f ivf pipe:1
+func (c *Client) PublishStream(r io.Reader, format string, video, audio bool) error {
+	pub := c.getPub()
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewStreamProducer(c.uid, r, format)
+	if err != nil {
+		return err
+	}
+	if video {
+		if _, err := p.AddTrack(pub.pc, "video"); err != nil {
+			log.Debugf("err=%v", err)
+			return err
+		}
+	}
+	if audio {
+		if _, err := p.AddTrack(pub.pc, "audio"); err != nil {
+			log.Debugf("err=%v", err)
+			return err
+		}
+	}
+	c.setProducer(p)
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
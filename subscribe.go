@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	layerHigh = "high"
+	layerNone = "none"
+)
+
+// remoteTrack is a track received by sub
+type remoteTrack struct {
+	streamID string
+	kind     webrtc.RTPCodecType
+}
+
+// streamSub is the selection of a remote stream sent to sfu
+type streamSub struct {
+	video string
+	audio bool
+}
+
+// addRemoteTrack record a track got by sub, must be called with streamLock held
+func (c *Client) addRemoteTrack(track *webrtc.TrackRemote) {
+	c.remoteTracks[track.ID()] = remoteTrack{streamID: track.StreamID(), kind: track.Kind()}
+	if _, ok := c.streamSubs[track.StreamID()]; !ok {
+		c.streamSubs[track.StreamID()] = &streamSub{video: layerHigh, audio: true}
+	}
+}
+
+// Subscribe ask sfu to send the remote tracks, video is sent with the high layer
+func (c *Client) Subscribe(trackIDs []string) error {
+	return c.setSubscription(trackIDs, true)
+}
+
+// Unsubscribe ask sfu to stop sending the remote tracks, the tracks are kept and could be subscribed again
+func (c *Client) Unsubscribe(trackIDs []string) error {
+	return c.setSubscription(trackIDs, false)
+}
+
+func (c *Client) setSubscription(trackIDs []string, on bool) error {
+	c.streamLock.Lock()
+	changed := make(map[string]streamSub)
+	for _, id := range trackIDs {
+		rt, ok := c.remoteTracks[id]
+		if !ok {
+			c.streamLock.Unlock()
+			log.Errorf("id=%v unknown remote track %v", c.uid, id)
+			return errInvalidTrack
+		}
+		sub := c.streamSubs[rt.streamID]
+		switch rt.kind {
+		case webrtc.RTPCodecTypeVideo:
+			if !on {
+				sub.video = layerNone
+			} else if sub.video == layerNone {
+				sub.video = layerHigh
+			}
+		case webrtc.RTPCodecTypeAudio:
+			sub.audio = on
+		}
+		changed[rt.streamID] = *sub
+	}
+	c.streamLock.Unlock()
+
+	for streamID, sub := range changed {
+		if err := c.selectRemote(streamID, sub.video, sub.audio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offeredTrack is a track of a sub offer
+type offeredTrack struct {
+	id string
+	remoteTrack
+}
+
+// offeredTracks return the tracks of the msid of a sub offer, the sections sending nothing are skipped
+func offeredTracks(offer webrtc.SessionDescription) ([]offeredTrack, error) {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(offer.SDP)); err != nil {
+		return nil, err
+	}
+	var tracks []offeredTrack
+	for _, m := range parsed.MediaDescriptions {
+		kind := webrtc.NewRTPCodecType(m.MediaName.Media)
+		if kind == 0 || m.MediaName.Port.Value == 0 {
+			continue
+		}
+		if _, ok := m.Attribute("inactive"); ok {
+			continue
+		}
+		if _, ok := m.Attribute("recvonly"); ok {
+			continue
+		}
+		for _, a := range m.Attributes {
+			var msid []string
+			switch a.Key {
+			case "msid":
+				msid = strings.Fields(a.Value)
+			case "ssrc":
+				// a=ssrc:<ssrc> msid:<stream> <track>
+				if i := strings.Index(a.Value, " msid:"); i >= 0 {
+					msid = strings.Fields(a.Value[i+len(" msid:"):])
+				}
+			}
+			if len(msid) == 2 {
+				tracks = append(tracks, offeredTrack{id: msid[1], remoteTrack: remoteTrack{streamID: msid[0], kind: kind}})
+				break
+			}
+		}
+	}
+	return tracks, nil
+}
+
+// refuseOfferedTracks unselect the new streams of a sub offer before it is answered, so sfu send nothing with
+// NoAutoSubscribe, their tracks are recorded to be subscribed later
+func (c *Client) refuseOfferedTracks(offer webrtc.SessionDescription) {
+	tracks, err := offeredTracks(offer)
+	if err != nil {
+		return
+	}
+	var refused []string
+	c.streamLock.Lock()
+	for _, t := range tracks {
+		if _, ok := c.remoteTracks[t.id]; ok {
+			continue
+		}
+		c.remoteTracks[t.id] = t.remoteTrack
+		if _, ok := c.streamSubs[t.streamID]; !ok {
+			c.streamSubs[t.streamID] = &streamSub{video: layerNone}
+			refused = append(refused, t.streamID)
+		}
+	}
+	c.streamLock.Unlock()
+
+	for _, streamID := range refused {
+		if err := c.selectRemote(streamID, layerNone, false); err != nil {
+			log.Warnf("id=%v refuse stream %v err=%v", c.uid, streamID, err)
+		}
+	}
+}
+
+// removeRemoteTrack forget a remote track and fire OnTrackRemoved once
+func (c *Client) removeRemoteTrack(trackID string) {
+	c.streamLock.Lock()
+	rt, ok := c.remoteTracks[trackID]
+	if !ok {
+		c.streamLock.Unlock()
+		return
+	}
+	delete(c.remoteTracks, trackID)
+	c.detachSampleSink(trackID)
+	last := true
+	for _, t := range c.remoteTracks {
+		if t.streamID == rt.streamID {
+			last = false
+			break
+		}
+	}
+	if last {
+		delete(c.remoteStreamId, rt.streamID)
+		delete(c.streamSubs, rt.streamID)
+	}
+	c.streamLock.Unlock()
+
+	log.Debugf("id=%v remote track removed trackID=%v streamID=%v", c.uid, trackID, rt.streamID)
+	if c.OnTrackRemoved != nil {
+		c.engine.dispatcher.Dispatch(func() { c.OnTrackRemoved(trackID, rt.streamID) })
+	}
+}
+
+// checkRemovedTracks fire OnTrackRemoved for the tracks missing from a renegotiation offer of sfu
+// the msid of the offer are used, the receivers of the new tracks have no track until the answer
+func (c *Client) checkRemovedTracks(offer webrtc.SessionDescription) {
+	tracks, err := offeredTracks(offer)
+	if err != nil {
+		return
+	}
+	live := make(map[string]bool)
+	for _, t := range tracks {
+		live[t.id] = true
+	}
+
+	c.streamLock.RLock()
+	var removed []string
+	for id := range c.remoteTracks {
+		if !live[id] {
+			removed = append(removed, id)
+		}
+	}
+	c.streamLock.RUnlock()
+
+	for _, id := range removed {
+		c.removeRemoteTrack(id)
+	}
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const subOffer = `v=0
+o=- 1 1 IN IP4 0.0.0.0
+s=-
+t=0 0
+m=audio 9 UDP/TLS/RTP/SAVPF 111
+c=IN IP4 0.0.0.0
+a=mid:0
+a=msid:stream1 audio1
+a=rtpmap:111 opus/48000/2
+m=video 9 UDP/TLS/RTP/SAVPF 96
+c=IN IP4 0.0.0.0
+a=mid:1
+a=rtpmap:96 VP8/90000
+a=ssrc:1234 cname:stream1
+a=ssrc:1234 msid:stream1 video1
+m=video 9 UDP/TLS/RTP/SAVPF 96
+c=IN IP4 0.0.0.0
+a=mid:2
+a=msid:stream2 video2
+a=rtpmap:96 VP8/90000
+m=application 9 UDP/DTLS/SCTP webrtc-datachannel
+c=IN IP4 0.0.0.0
+a=mid:3
+`
+
+func TestOfferedTracks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sdp  string
+		want []offeredTrack
+	}{
+		{"msid and ssrc msid", subOffer, []offeredTrack{
+			{"audio1", remoteTrack{"stream1", webrtc.RTPCodecTypeAudio}},
+			{"video1", remoteTrack{"stream1", webrtc.RTPCodecTypeVideo}},
+			{"video2", remoteTrack{"stream2", webrtc.RTPCodecTypeVideo}},
+		}},
+		{"inactive", strings.Replace(subOffer, "a=mid:2\n", "a=mid:2\na=inactive\n", 1), []offeredTrack{
+			{"audio1", remoteTrack{"stream1", webrtc.RTPCodecTypeAudio}},
+			{"video1", remoteTrack{"stream1", webrtc.RTPCodecTypeVideo}},
+		}},
+		{"invalid", "not a sdp", nil},
+	} {
+		got, _ := offeredTracks(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: tc.sdp})
+		assert.Equal(t, tc.want, got, tc.name)
+	}
+}
+
+func TestRefuseOfferedTracks(t *testing.T) {
+	c := &Client{
+		remoteTracks: map[string]remoteTrack{"video2": {"stream2", webrtc.RTPCodecTypeVideo}},
+		streamSubs:   map[string]*streamSub{"stream2": {video: layerHigh, audio: true}},
+	}
+	c.refuseOfferedTracks(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: subOffer})
+	// the new tracks can be subscribed, the known stream keep its selection
+	assert.Len(t, c.remoteTracks, 3)
+	assert.Equal(t, &streamSub{video: layerNone}, c.streamSubs["stream1"])
+	assert.Equal(t, &streamSub{video: layerHigh, audio: true}, c.streamSubs["stream2"])
+	// sent once the api datachannel is open
+	assert.Equal(t, []Call{{StreamID: "stream1", Video: layerNone}}, c.apiQueue)
+}
+
+// joinSignal is a signal recording the joins and the last answer of sub, sfu never answer
+type joinSignal struct {
+	joins  int
+	answer webrtc.SessionDescription
+}
+
+func (s *joinSignal) Join(sid string, uid string, offer webrtc.SessionDescription, config *JoinConfig) error {
+	s.joins++
+	return nil
+}
+func (s *joinSignal) Offer(sdp webrtc.SessionDescription)                             {}
+func (s *joinSignal) Answer(sdp webrtc.SessionDescription)                            { s.answer = sdp }
+func (s *joinSignal) Trickle(candidate *webrtc.ICECandidate, target int)              {}
+func (s *joinSignal) OnNegotiate(f func(webrtc.SessionDescription) error)             {}
+func (s *joinSignal) OnTrickle(f func(candidate webrtc.ICECandidateInit, target int)) {}
+func (s *joinSignal) OnSetRemoteSDP(f func(webrtc.SessionDescription) error)          {}
+func (s *joinSignal) OnError(f func(error))                                           {}
+func (s *joinSignal) Close()                                                          {}
+
+func TestJoinSubscriber(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config *JoinConfig
+		sub    bool
+	}{
+		{"default", nil, true},
+		{"no publish", NewJoinConfig().SetNoPublish(), true},
+		{"no subscribe", NewJoinConfig().SetNoSubscribe(), false},
+	} {
+		e := NewEngine(Config{})
+		s := &joinSignal{}
+		c, err := NewClientWithSignal(e, s, "")
+		assert.NoError(t, err)
+		assert.Nil(t, c.SubscriberPC(), "%v: no sub before join", tc.name)
+		assert.NoError(t, c.doJoin("room", tc.config), tc.name)
+		assert.Equal(t, 1, s.joins, tc.name)
+		assert.Equal(t, tc.sub, c.SubscriberPC() != nil, tc.name)
+		if !tc.sub {
+			assert.Equal(t, errNoSubscribe, c.Negotiate(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: subOffer}), tc.name)
+		}
+		c.Close()
+		e.Close()
+	}
+}
+
+func TestNegotiateNoAutoSubscribe(t *testing.T) {
+	e := NewEngine(Config{})
+	defer e.Close()
+	s := &joinSignal{}
+	c, err := NewClientWithSignal(e, s, "")
+	require.NoError(t, err)
+	defer c.Close()
+	var mu sync.Mutex
+	var removed []string
+	c.OnTrackRemoved = func(trackID, streamID string) {
+		mu.Lock()
+		removed = append(removed, trackID)
+		mu.Unlock()
+	}
+	require.NoError(t, c.doJoin("room", NewJoinConfig().SetNoAutoSubscribe()))
+
+	// sfu is a plain pion offerer of the published tracks
+	sfu, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer sfu.Close()
+	// the api datachannel of sfu, the selections are queued until it opens
+	_, err = sfu.CreateDataChannel(API_CHANNEL, nil)
+	require.NoError(t, err)
+	offer := func() {
+		o, err := sfu.CreateOffer(nil)
+		require.NoError(t, err)
+		require.NoError(t, sfu.SetLocalDescription(o))
+		require.NoError(t, c.Negotiate(o))
+		require.NoError(t, sfu.SetRemoteDescription(s.answer))
+	}
+	addTrack := func(kind, id, stream string) *webrtc.RTPSender {
+		mime := mimeTypeVP8
+		if kind == "audio" {
+			mime = mimeTypeOpus
+		}
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: mime}, id, stream)
+		require.NoError(t, err)
+		sender, err := sfu.AddTrack(track)
+		require.NoError(t, err)
+		return sender
+	}
+
+	video := addTrack("video", "video1", "stream1")
+	offer()
+	// a renegotiation offer a new track, the known one is kept
+	addTrack("audio", "audio2", "stream2")
+	offer()
+	assert.NoError(t, c.Subscribe([]string{"video1", "audio2"}))
+
+	// a track removed by sfu is removed once
+	require.NoError(t, sfu.RemoveTrack(video))
+	offer()
+	assert.Equal(t, errInvalidTrack, c.Subscribe([]string{"video1"}))
+	assert.NoError(t, c.Subscribe([]string{"audio2"}))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(removed) == 1
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"video1"}, removed)
+	mu.Unlock()
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	defaultPatternWidth  = 640
+	defaultPatternHeight = 480
+)
+
+// the 75% color bars in bt.601 y, u, v
+var patternBars = [][3]int32{
+	{180, 128, 128}, {162, 44, 142}, {131, 156, 44}, {112, 72, 58},
+	{84, 184, 198}, {65, 100, 212}, {35, 212, 114},
+}
+
+// patternFont is a 3x5 font of the digits, ':' and '.', a row per 3 low bits
+var patternFont = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7}, '3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7}, '6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7}, '9': {7, 5, 7, 1, 7}, ':': {0, 2, 0, 2, 0}, '.': {0, 0, 0, 0, 2},
+}
+
+// testPattern draw the frames of the pattern in 4x4 blocks, the resolution of the encoder
+type testPattern struct {
+	enc    *vp8Encoder
+	img    *vp8Image
+	bw, bh int
+	// the bouncing box position and direction, in blocks
+	x, y, dx, dy int
+}
+
+func newTestPattern(width, height int) *testPattern {
+	enc := newVP8Encoder(width, height)
+	bw, bh := enc.mbw*4, enc.mbh*4
+	return &testPattern{
+		enc: enc,
+		img: &vp8Image{
+			y: make([]int32, bw*bh),
+			u: make([]int32, bw*bh/4),
+			v: make([]int32, bw*bh/4),
+		},
+		// only the visible blocks
+		bw: (width + 3) / 4,
+		bh: (height + 3) / 4,
+		dx: 1,
+		dy: 1,
+	}
+}
+
+// frame draw and encode the frame n at now
+func (p *testPattern) frame(n uint64, now time.Time) []byte {
+	stride := p.enc.mbw * 4
+	y := make([][3]int32, stride*p.enc.mbh*4)
+	// the bars over the top 3/4, the text band below
+	band := p.bh * 3 / 4
+	for by := 0; by < p.enc.mbh*4; by++ {
+		for bx := 0; bx < stride; bx++ {
+			c := [3]int32{16, 128, 128}
+			if by < band && bx < p.bw {
+				c = patternBars[bx*len(patternBars)/p.bw]
+			}
+			y[by*stride+bx] = c
+		}
+	}
+
+	size := p.bh / 4
+	if size < 1 {
+		size = 1
+	}
+	if p.x+p.dx < 0 || p.x+p.dx+size > p.bw {
+		p.dx = -p.dx
+	}
+	if p.y+p.dy < 0 || p.y+p.dy+size > band {
+		p.dy = -p.dy
+	}
+	p.x, p.y = p.x+p.dx, p.y+p.dy
+	for by := p.y; by < p.y+size && by < band; by++ {
+		for bx := p.x; bx < p.x+size && bx < p.bw; bx++ {
+			if by >= 0 && bx >= 0 {
+				y[by*stride+bx] = [3]int32{235, 128, 128}
+			}
+		}
+	}
+
+	text := fmt.Sprintf("%06d %s", n%1000000, now.Format("15:04:05.000"))
+	// 4 font columns per char scaled to the width and the band
+	scale := p.bw / (len(text) * 4)
+	if s := (p.bh - band) / 7; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	top := band + scale
+	for i, r := range text {
+		glyph, ok := patternFont[r]
+		if !ok {
+			continue
+		}
+		for row := 0; row < 5*scale; row++ {
+			for col := 0; col < 3*scale; col++ {
+				if glyph[row/scale]>>(2-uint(col/scale))&1 == 0 {
+					continue
+				}
+				bx, by := scale+i*4*scale+col, top+row
+				if bx < p.bw && by < p.bh {
+					y[by*stride+bx] = [3]int32{235, 128, 128}
+				}
+			}
+		}
+	}
+
+	for i, c := range y {
+		p.img.y[i] = c[0]
+	}
+	// the chroma of a block is the average of its 2x2 luma blocks
+	for by := 0; by < p.enc.mbh*2; by++ {
+		for bx := 0; bx < p.enc.mbw*2; bx++ {
+			var u, v int32
+			for j := 0; j < 4; j++ {
+				c := y[(by*2+j/2)*stride+bx*2+j%2]
+				u += c[1]
+				v += c[2]
+			}
+			p.img.u[by*p.enc.mbw*2+bx] = (u + 2) / 4
+			p.img.v[by*p.enc.mbw*2+bx] = (v + 2) / 4
+		}
+	}
+	return p.enc.encode(p.img)
+}
+
+// TestPatternProducer publish a generated vp8 test pattern, color bars with a moving box and
+// the frame counter and the time burned in, for load tests without media files
+type TestPatternProducer struct {
+	id       string
+	fps      int
+	bitrate  int
+	pattern  *testPattern
+	track    *webrtc.TrackLocalStaticSample
+	sendByte uint64
+	done     chan struct{}
+	stopOnce sync.Once
+	pauseGate
+	progress
+}
+
+// NewTestPatternProducer create a test pattern of width x height at fps, 0 is 640x480 at 30fps
+// every frame is a key frame, there is no inter frame and no rate control: bitrate(bps) only pad each frame
+// with zeros after its partitions up to bitrate/fps bytes to load the network, a larger frame is sent as is
+func NewTestPatternProducer(id string, width, height, fps, bitrate int) *TestPatternProducer {
+	if width <= 0 || height <= 0 {
+		width, height = defaultPatternWidth, defaultPatternHeight
+	}
+	if width > 0x3fff {
+		width = 0x3fff
+	}
+	if height > 0x3fff {
+		height = 0x3fff
+	}
+	if fps <= 0 {
+		fps = defaultFrameRate
+	}
+	return &TestPatternProducer{
+		id:      id,
+		fps:     fps,
+		bitrate: bitrate,
+		pattern: newTestPattern(width, height),
+		done:    make(chan struct{}),
+	}
+}
+
+// AddTrack add the vp8 track to pc, the pattern has no audio
+func (t *TestPatternProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "video" {
+		return nil, errInvalidKind
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		"video", fmt.Sprintf("pattern_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.track = track
+	return track, nil
+}
+
+func (t *TestPatternProducer) Start() {
+	go t.writeLoop()
+}
+
+func (t *TestPatternProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// writeLoop send the frames at their time from the start
+func (t *TestPatternProducer) writeLoop() {
+	interval := time.Second / time.Duration(t.fps)
+	clock := newMediaClock()
+	for n := uint64(0); ; n++ {
+		if !clock.wait(time.Duration(n)*interval, t.done) {
+			return
+		}
+		gap, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		t.setPosition(time.Duration(n) * interval)
+		if gap > 0 {
+			clock.reset(time.Duration(n) * interval)
+		}
+		frame := t.pattern.frame(n, time.Now())
+		// payload padding, the decoder ignore the bytes after the partitions
+		if size := t.bitrate / 8 / t.fps; len(frame) < size {
+			frame = append(frame, make([]byte, size-len(frame))...)
+		}
+		if t.track == nil {
+			continue
+		}
+		if err := t.track.WriteSample(media.Sample{Data: frame, Duration: interval + gap}); err != nil {
+			log.Errorf("Track write error=%v", err)
+			continue
+		}
+		atomic.AddUint64(&t.sendByte, uint64(len(frame)))
+		t.sent(len(frame))
+	}
+}
+
+// SendBytes return the total sent bytes
+func (t *TestPatternProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
+// SeekTo is not supported by a generated source
+func (t *TestPatternProducer) SeekTo(d time.Duration) error {
+	return errNotSeekable
+}
+
+// PublishTestPattern publish a generated vp8 test pattern of key frames padded to bitrate, see
+// NewTestPatternProducer
+func (c *Client) PublishTestPattern(width, height, fps, bitrate int) error {
+	if c.noPublish {
+		return errNoPublish
+	}
+	p := NewTestPatternProducer(c.uid, width, height, fps, bitrate)
+	c.setProducer(p)
+	if _, err := p.AddTrack(c.getPub().pc, "video"); err != nil {
+		return err
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig is the tls of the signaling, for sfu behind a tls load balancer
+type TLSConfig struct {
+	// Enabled dial sfu with tls, the default is insecure
+	Enabled bool `mapstructure:"enabled"`
+	// CAFile is a pem bundle to verify the server, the system roots if empty
+	CAFile string `mapstructure:"cafile"`
+	// ServerName override the name to verify, e.g. when dialing an ip
+	ServerName string `mapstructure:"servername"`
+	// CertFile and KeyFile is the client certificate for mtls
+	CertFile string `mapstructure:"certfile"`
+	KeyFile  string `mapstructure:"keyfile"`
+	// InsecureSkipVerify do not verify the server, for test only
+	InsecureSkipVerify bool `mapstructure:"insecureskipverify"`
+}
+
+// clientConfig build the tls config, nil if tls is disabled
+func (t TLSConfig) clientConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errInvalidCA
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// dialOptions return the grpc dial options of the engine config for addr
+func (e *Engine) dialOptions(addr string) ([]grpc.DialOption, error) {
+	cfg, err := e.getConfig().TLS.clientConfig()
+	if err != nil {
+		log.Errorf("tls config err=%v", err)
+		return nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if creds := e.getConfig().Credentials; creds != nil {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	} else if cfg != nil {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(cfg))}
+	}
+	if !isUnixAddr(addr) {
+		opt, err := e.getConfig().proxyOption()
+		if err != nil {
+			log.Errorf("proxy config err=%v", err)
+			return nil, err
+		}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+	}
+	// user options last so they override the sdk ones
+	return append(opts, e.getConfig().DialOptions...), nil
+}
@@ -0,0 +1,52 @@
+package engine
+
+// tokenKey carry the join token in the join config
+const tokenKey = "token"
+
+// SetToken set the auth token of the join, sent in grpc metadata and the join config
+// call it before Join, or in OnTokenExpired to refresh it on reconnect
+func (c *Client) SetToken(token string) {
+	c.signalLock.Lock()
+	c.token = token
+	c.signalLock.Unlock()
+	if ts, ok := c.getSignal().(tokenSetter); ok {
+		ts.setToken(token)
+	}
+}
+
+func (c *Client) getToken() string {
+	c.signalLock.RLock()
+	defer c.signalLock.RUnlock()
+	return c.token
+}
+
+// refreshToken ask OnTokenExpired for a new token, the current token is kept on error
+func (c *Client) refreshToken() {
+	if c.OnTokenExpired == nil {
+		return
+	}
+	token, err := c.OnTokenExpired()
+	if err != nil {
+		log.Errorf("id=%v refresh token err=%v", c.uid, err)
+		return
+	}
+	c.signalLock.Lock()
+	c.token = token
+	c.signalLock.Unlock()
+}
+
+// withToken return a copy of config with the token
+func (c *Client) withToken(config *JoinConfig) *JoinConfig {
+	token := c.getToken()
+	if token == "" {
+		return config
+	}
+	cfg := NewJoinConfig()
+	if config != nil {
+		for k, v := range *config {
+			(*cfg)[k] = v
+		}
+	}
+	(*cfg)[tokenKey] = token
+	return cfg
+}
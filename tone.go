@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	defaultPtime = 20 * time.Millisecond
+	// toneAmplitude is -6dBFS
+	toneAmplitude = 0.5 * math.MaxInt16
+)
+
+// opusEncoder encode mono 48khz pcm frames of a ptime
+type opusEncoder interface {
+	encode(pcm []int16) ([]byte, error)
+	close()
+}
+
+// newOpusEncoder is set by the opus build tag, the sdk has no native opus encoder
+var newOpusEncoder func(ptime time.Duration) (opusEncoder, error)
+
+// opusSilence return a silent celt packet of ptime, the frames longer than 20ms are in a code 3 packet
+func opusSilence(ptime time.Duration) []byte {
+	// celt fullband configs 28 to 31 are 2.5, 5, 10 and 20ms, mono
+	// the payload decode the silence flag at once
+	frame := []byte{0xff, 0xfe}
+	if ptime <= 20*time.Millisecond {
+		config := 31
+		for d := 20 * time.Millisecond; d > ptime; d /= 2 {
+			config--
+		}
+		return append([]byte{byte(config << 3)}, frame...)
+	}
+	n := int(ptime / (20 * time.Millisecond))
+	// cbr frames, no padding
+	b := []byte{31<<3 | 3, byte(n)}
+	for i := 0; i < n; i++ {
+		b = append(b, frame...)
+	}
+	return b
+}
+
+func validPtime(ptime time.Duration) bool {
+	switch ptime {
+	case 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond:
+		return true
+	}
+	return false
+}
+
+// ToneProducer publish a generated opus sine tone or silence, for tests without recordings
+type ToneProducer struct {
+	id       string
+	freq     float64
+	ptime    time.Duration
+	encoder  opusEncoder
+	phase    float64
+	track    *webrtc.TrackLocalStaticSample
+	sendByte uint64
+	done     chan struct{}
+	stopOnce sync.Once
+	pauseGate
+	progress
+}
+
+// NewToneProducer create a tone of freq(hz) in ptime frames, 0 is 20ms, a freq of 0 is silence
+// the silence is native, the tones need the libopus encoder of the opus build tag
+func NewToneProducer(id string, freq float64, ptime time.Duration) (*ToneProducer, error) {
+	if ptime == 0 {
+		ptime = defaultPtime
+	}
+	if !validPtime(ptime) {
+		return nil, errInvalidPtime
+	}
+	t := &ToneProducer{
+		id:    id,
+		freq:  freq,
+		ptime: ptime,
+		done:  make(chan struct{}),
+	}
+	if freq > 0 {
+		if newOpusEncoder == nil {
+			return nil, errNoOpusEncoder
+		}
+		var err error
+		if t.encoder, err = newOpusEncoder(ptime); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AddTrack add the opus track to pc, the tone has no video
+func (t *ToneProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "audio" {
+		return nil, errInvalidKind
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2},
+		"audio", fmt.Sprintf("tone_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.track = track
+	return track, nil
+}
+
+func (t *ToneProducer) Start() {
+	go t.writeLoop()
+}
+
+func (t *ToneProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// next return the next packet, the phase of the tone keep continuous
+func (t *ToneProducer) next() ([]byte, error) {
+	if t.encoder == nil {
+		return opusSilence(t.ptime), nil
+	}
+	pcm := make([]int16, int(t.ptime*opusRate/time.Second))
+	step := 2 * math.Pi * t.freq / opusRate
+	for i := range pcm {
+		pcm[i] = int16(toneAmplitude * math.Sin(t.phase))
+		t.phase = math.Mod(t.phase+step, 2*math.Pi)
+	}
+	return t.encoder.encode(pcm)
+}
+
+// writeLoop send the packets at their time from the start
+func (t *ToneProducer) writeLoop() {
+	if t.encoder != nil {
+		defer t.encoder.close()
+	}
+	clock := newMediaClock()
+	for n := 0; ; n++ {
+		if !clock.wait(time.Duration(n)*t.ptime, t.done) {
+			return
+		}
+		gap, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		t.setPosition(time.Duration(n) * t.ptime)
+		if gap > 0 {
+			clock.reset(time.Duration(n) * t.ptime)
+		}
+		p, err := t.next()
+		if err != nil {
+			log.Errorf("id=%v opus encode err=%v", t.id, err)
+			t.ended(err)
+			return
+		}
+		if t.track == nil {
+			continue
+		}
+		if err := t.track.WriteSample(media.Sample{Data: p, Duration: t.ptime + gap}); err != nil {
+			log.Errorf("Track write error=%v", err)
+			continue
+		}
+		atomic.AddUint64(&t.sendByte, uint64(len(p)))
+		t.sent(len(p))
+	}
+}
+
+// SendBytes return the total sent bytes
+func (t *ToneProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
+// SeekTo is not supported by a generated source
+func (t *ToneProducer) SeekTo(d time.Duration) error {
+	return errNotSeekable
+}
+
+// PublishTone publish a generated opus tone, see NewToneProducer
+func (c *Client) PublishTone(freq float64, ptime time.Duration) error {
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewToneProducer(c.uid, freq, ptime)
+	if err != nil {
+		return err
+	}
+	c.setProducer(p)
+	if _, err := p.AddTrack(c.getPub().pc, "audio"); err != nil {
+		return err
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
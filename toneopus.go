@@ -0,0 +1,61 @@
+//go:build opus
+// +build opus
+
+package engine
+
+import (
+	"time"
+
+	"github.com/pion/mediadevices/pkg/codec"
+	"github.com/pion/mediadevices/pkg/codec/opus"
+	"github.com/pion/mediadevices/pkg/io/audio"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/mediadevices/pkg/wave"
+)
+
+// build with -tags opus for the tones of ToneProducer, it needs cgo and link the libopus of mediadevices
+func init() {
+	newOpusEncoder = newLibOpusEncoder
+}
+
+// libOpusEncoder encode by the mediadevices encoder, it pull the frame set by encode
+type libOpusEncoder struct {
+	frame  *wave.Int16Interleaved
+	reader codec.ReadCloser
+}
+
+func newLibOpusEncoder(ptime time.Duration) (opusEncoder, error) {
+	params, err := opus.NewParams()
+	if err != nil {
+		return nil, err
+	}
+	params.Latency = opus.Latency(ptime)
+	e := &libOpusEncoder{}
+	r := audio.ReaderFunc(func() (wave.Audio, func(), error) {
+		return e.frame, func() {}, nil
+	})
+	e.reader, err = params.BuildAudioEncoder(r, prop.Media{
+		Audio: prop.Audio{SampleRate: opusRate, ChannelCount: 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *libOpusEncoder) encode(pcm []int16) ([]byte, error) {
+	e.frame = &wave.Int16Interleaved{
+		Data: pcm,
+		Size: wave.ChunkInfo{Len: len(pcm), Channels: 1, SamplingRate: opusRate},
+	}
+	b, release, err := e.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return append([]byte{}, b...), nil
+}
+
+func (e *libOpusEncoder) close() {
+	e.reader.Close()
+}
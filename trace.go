@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// signal message types of SignalMessage
+const (
+	SignalJoin    = "join"
+	SignalOffer   = "offer"
+	SignalAnswer  = "answer"
+	SignalTrickle = "trickle"
+)
+
+// SignalMessage is a signaling message sent to or received from sfu, for tracing
+type SignalMessage struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Target is PUBLISHER or SUBSCRIBER of a trickle
+	Target    int                        `json:"target,omitempty"`
+	SDP       *webrtc.SessionDescription `json:"sdp,omitempty"`
+	Candidate *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
+}
+
+// traceSent measure the message and call OnSignalSent, sent messages are traced before sending
+func (c *Client) traceSent(msg SignalMessage) {
+	msg.Time = time.Now()
+	c.sigStats.sent(msg)
+	if c.OnSignalSent != nil {
+		c.OnSignalSent(msg)
+	}
+}
+
+// traceReceived measure the message and call OnSignalReceived
+func (c *Client) traceReceived(msg SignalMessage) {
+	msg.Time = time.Now()
+	c.sigStats.received(msg)
+	if c.OnSignalReceived != nil {
+		c.OnSignalReceived(msg)
+	}
+}
+
+// trickle send a local candidate of the transport
+func (t *Transport) trickle(candidate *webrtc.ICECandidate) {
+	if t.trace != nil {
+		init := candidate.ToJSON()
+		t.trace(SignalMessage{Type: SignalTrickle, Target: t.role, Candidate: &init})
+	}
+	t.signal.Trickle(candidate, t.role)
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// bitrateWindow is the window of TrackStats.Bitrate
+const bitrateWindow = time.Second
+
+// TrackStats is the reception of a subscribed track, counted as the packets arrive
+type TrackStats struct {
+	TrackID string
+	SSRC    uint32
+	// PacketsReceived count the duplicates, PacketsLost is the expected minus the received, rfc 3550
+	PacketsReceived uint64
+	PacketsLost     int64
+	Bytes           uint64
+	// Jitter is the interarrival jitter, rfc 3550
+	Jitter time.Duration
+	// Bitrate is the rtp bps of the last second, 0 once no packet arrives
+	Bitrate int
+	// KeyFrames is the key frames started, 0 for audio
+	KeyFrames uint64
+	// LastPacket is the arrival of the last packet, zero before the first
+	LastPacket time.Time
+}
+
+// receiveStats is an interceptor computing the TrackStats of the remote streams on arrival
+type receiveStats struct {
+	interceptor.NoOp
+
+	mu      sync.Mutex
+	streams map[uint32]*streamStats
+}
+
+func newReceiveStats() *receiveStats {
+	return &receiveStats{streams: make(map[uint32]*streamStats)}
+}
+
+// streamStats is the counters of a ssrc
+type streamStats struct {
+	mu        sync.Mutex
+	mime      string
+	clockRate float64
+
+	received  uint64
+	bytes     uint64
+	keyFrames uint64
+	// the extended sequence numbers
+	baseSeq uint32
+	maxSeq  uint32
+	cycles  uint32
+	// the jitter in timestamp units and the last transit from the first arrival
+	jitter     float64
+	transit    float64
+	first      time.Time
+	last       time.Time
+	windowTime time.Time
+	window     uint64
+	bitrate    int
+}
+
+// update count a packet arriving at now
+func (s *streamStats) update(b []byte, now time.Time) {
+	if len(b) < 12 {
+		return
+	}
+	seq := binary.BigEndian.Uint16(b[2:])
+	ts := binary.BigEndian.Uint32(b[4:])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.received == 0 {
+		s.baseSeq, s.maxSeq = uint32(seq), uint32(seq)
+		s.first, s.windowTime = now, now
+	} else if d := int16(seq - uint16(s.maxSeq)); d > 0 {
+		if seq < uint16(s.maxSeq) {
+			s.cycles += 1 << 16
+		}
+		s.maxSeq = s.cycles | uint32(seq)
+	}
+	s.received++
+	s.bytes += uint64(len(b))
+
+	if s.clockRate > 0 {
+		transit := now.Sub(s.first).Seconds()*s.clockRate - float64(ts)
+		if !s.last.IsZero() {
+			d := transit - s.transit
+			if d < 0 {
+				d = -d
+			}
+			// a wrap of the timestamps is not jitter
+			if d < s.clockRate*10 {
+				s.jitter += (d - s.jitter) / 16
+			}
+		}
+		s.transit = transit
+	}
+	s.last = now
+
+	if elapsed := now.Sub(s.windowTime); elapsed >= bitrateWindow {
+		s.bitrate = int(float64(s.window*8) / elapsed.Seconds())
+		s.window, s.windowTime = 0, now
+	}
+	s.window += uint64(len(b))
+
+	if s.mime != "" {
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(b); err == nil && keyFrameStart(s.mime, pkt.Payload) {
+			s.keyFrames++
+		}
+	}
+}
+
+func (s *streamStats) stats(now time.Time) TrackStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := TrackStats{
+		PacketsReceived: s.received,
+		Bytes:           s.bytes,
+		KeyFrames:       s.keyFrames,
+		LastPacket:      s.last,
+		Bitrate:         s.bitrate,
+	}
+	if s.received > 0 {
+		t.PacketsLost = int64(s.maxSeq-s.baseSeq+1) - int64(s.received)
+	}
+	if s.clockRate > 0 {
+		t.Jitter = time.Duration(s.jitter / s.clockRate * float64(time.Second))
+	}
+	if now.Sub(s.last) > 2*bitrateWindow {
+		t.Bitrate = 0
+	}
+	return t
+}
+
+// BindRemoteStream wrap the reader to count the packets of the stream
+func (r *receiveStats) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	s := &streamStats{clockRate: float64(info.ClockRate)}
+	if strings.HasPrefix(strings.ToLower(info.MimeType), "video/") {
+		s.mime = info.MimeType
+	}
+	r.mu.Lock()
+	r.streams[info.SSRC] = s
+	r.mu.Unlock()
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err == nil {
+			s.update(b[:n], time.Now())
+		}
+		return n, attr, err
+	})
+}
+
+// UnbindRemoteStream forget the stream
+func (r *receiveStats) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, info.SSRC)
+}
+
+// stats return the stats of ssrc, false if it is not received
+func (r *receiveStats) stats(ssrc uint32) (TrackStats, bool) {
+	r.mu.Lock()
+	s, ok := r.streams[ssrc]
+	r.mu.Unlock()
+	if !ok {
+		return TrackStats{}, false
+	}
+	t := s.stats(time.Now())
+	t.SSRC = ssrc
+	return t, true
+}
+
+// TrackStats return the reception stats of a subscribed track, e.g. to flag a broken incoming stream
+// the packets are counted on arrival, the track must still be read, by OnTrack or by default
+func (c *Client) TrackStats(trackID string) (TrackStats, error) {
+	track, sub := c.subscribedTrack(trackID), c.getSub()
+	if track == nil || sub == nil {
+		return TrackStats{}, errInvalidTrack
+	}
+	t, ok := sub.recvStats.stats(uint32(track.SSRC()))
+	if !ok {
+		return TrackStats{}, errInvalidTrack
+	}
+	t.TrackID = trackID
+	return t, nil
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamStats(t *testing.T) {
+	// k is the index of a frame of 30fps, its packet has the sequence number first+k
+	frames := func(n int, skip func(k int) bool) []int {
+		var ks []int
+		for k := 0; k < n; k++ {
+			if skip == nil || !skip(k) {
+				ks = append(ks, k)
+			}
+		}
+		return ks
+	}
+	for _, tc := range []struct {
+		name  string
+		first uint16
+		// frames is the arrival order
+		frames []int
+		// offset delay the arrival of a frame
+		offset    func(k int) time.Duration
+		keyFrame  func(k int) bool
+		lost      int64
+		jitter    time.Duration
+		keyFrames uint64
+	}{
+		{name: "in order", frames: frames(100, nil)},
+		{name: "loss", frames: frames(100, func(k int) bool { return k%10 == 5 }), lost: 10},
+		{name: "reorder", frames: []int{0, 2, 1, 3, 5, 4, 6}},
+		{name: "duplicates", frames: []int{0, 1, 1, 2, 3, 3}, lost: -2},
+		{name: "wrap", first: 65500, frames: frames(100, nil)},
+		{name: "loss at wrap", first: 65530, frames: frames(20, func(k int) bool { return k == 5 || k == 6 }), lost: 2},
+		{
+			// the transit alternate by 20ms, the jitter converge to 20ms
+			name: "jitter", frames: frames(200, nil),
+			offset: func(k int) time.Duration { return time.Duration(k%2) * 20 * time.Millisecond },
+			jitter: 20 * time.Millisecond,
+		},
+		{
+			name: "key frames", frames: frames(100, nil),
+			keyFrame:  func(k int) bool { return k%25 == 0 },
+			keyFrames: 4,
+		},
+	} {
+		s := &streamStats{clockRate: 90000, mime: mimeTypeVP8}
+		start := time.Unix(1000, 0)
+		var now time.Time
+		for _, k := range tc.frames {
+			// a vp8 descriptor with the start bit then a key or a delta frame
+			payload := []byte{0x10, 0x01, 0x00, 0x00}
+			if tc.keyFrame != nil && tc.keyFrame(k) {
+				payload[1] = 0x00
+			}
+			pkt := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: tc.first + uint16(k), Timestamp: uint32(k) * 3000},
+				Payload: append(payload, make([]byte, 96)...),
+			}
+			b, err := pkt.Marshal()
+			require.NoError(t, err)
+			now = start.Add(time.Duration(k) * time.Second / 30)
+			if tc.offset != nil {
+				now = now.Add(tc.offset(k))
+			}
+			s.update(b, now)
+		}
+		stats := s.stats(now)
+		assert.Equal(t, uint64(len(tc.frames)), stats.PacketsReceived, tc.name)
+		assert.Equal(t, uint64(len(tc.frames)*112), stats.Bytes, tc.name)
+		assert.Equal(t, tc.lost, stats.PacketsLost, tc.name)
+		assert.InDelta(t, float64(tc.jitter), float64(stats.Jitter), float64(time.Millisecond), tc.name)
+		assert.Equal(t, tc.keyFrames, stats.KeyFrames, tc.name)
+		assert.Equal(t, now, stats.LastPacket, tc.name)
+		if len(tc.frames) >= 100 {
+			// 112 bytes at 30 packets a second
+			assert.InDelta(t, 112*8*30, stats.Bitrate, 112*8*3, tc.name)
+			assert.Zero(t, s.stats(now.Add(3*bitrateWindow)).Bitrate, tc.name)
+		}
+	}
+}
+
+func TestReceiveStats(t *testing.T) {
+	r := newReceiveStats()
+	feed := make(chanReader, 1)
+	info := &interceptor.StreamInfo{SSRC: 5, ClockRate: 48000, MimeType: mimeTypeOpus}
+	reader := r.BindRemoteStream(info, feed)
+	_, ok := r.stats(5)
+	assert.True(t, ok)
+
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 1}, Payload: []byte{0xfc}}
+	b, err := pkt.Marshal()
+	require.NoError(t, err)
+	feed <- b
+	_, _, err = reader.Read(make([]byte, 1500), nil)
+	require.NoError(t, err)
+	close(feed)
+	_, _, err = reader.Read(make([]byte, 1500), nil)
+	assert.Equal(t, io.EOF, err)
+
+	stats, ok := r.stats(5)
+	require.True(t, ok)
+	assert.Equal(t, uint32(5), stats.SSRC)
+	assert.Equal(t, uint64(1), stats.PacketsReceived)
+	assert.Equal(t, uint64(len(b)), stats.Bytes)
+	// the audio has no key frames
+	assert.Zero(t, stats.KeyFrames)
+
+	r.UnbindRemoteStream(info)
+	_, ok = r.stats(5)
+	assert.False(t, ok)
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+// maxLateFrames is the packets the receiver wait for a frame to be complete
+const maxLateFrames = 256
+
+// FrameTransform change a whole encoded frame, e.g. encrypt/decrypt or watermark
+// h264 frames are packetized by nal unit, keep the nal headers in clear
+type FrameTransform func(trackID string, frame []byte) ([]byte, error)
+
+// TransformTrack is a sample track calling the transform on every frame before packetization
+type TransformTrack struct {
+	*webrtc.TrackLocalStaticSample
+	transform FrameTransform
+}
+
+// NewTransformTrack create a sample track with a send transform, publish it like other tracks
+func NewTransformTrack(c webrtc.RTPCodecCapability, id, streamID string, transform FrameTransform) (*TransformTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(c, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &TransformTrack{TrackLocalStaticSample: track, transform: transform}, nil
+}
+
+// WriteSample transform the frame and write it
+func (t *TransformTrack) WriteSample(s media.Sample) error {
+	if t.transform != nil {
+		data, err := t.transform(t.ID(), s.Data)
+		if err != nil {
+			return err
+		}
+		s.Data = data
+	}
+	return t.TrackLocalStaticSample.WriteSample(s)
+}
+
+// FrameReader read whole frames of a remote track and call the transform after depacketization
+type FrameReader struct {
+	track     *webrtc.TrackRemote
+	builder   *samplebuilder.SampleBuilder
+	transform FrameTransform
+}
+
+// newDepacketizer return the depacketizer of a codec, vp8, vp9, h264 or opus
+func newDepacketizer(mime string) (rtp.Depacketizer, error) {
+	switch strings.ToLower(mime) {
+	case mimeTypeVP8:
+		return &codecs.VP8Packet{}, nil
+	case mimeTypeVP9:
+		return &codecs.VP9Packet{}, nil
+	case mimeTypeH264:
+		return &codecs.H264Packet{}, nil
+	case mimeTypeOpus:
+		return &codecs.OpusPacket{}, nil
+	}
+	return nil, errInvalidKind
+}
+
+// NewFrameReader create a frame reader, use it in OnTrack instead of reading rtp
+func NewFrameReader(track *webrtc.TrackRemote, transform FrameTransform) (*FrameReader, error) {
+	depacketizer, err := newDepacketizer(track.Codec().MimeType)
+	if err != nil {
+		return nil, err
+	}
+	return &FrameReader{
+		track:     track,
+		builder:   samplebuilder.New(maxLateFrames, depacketizer, track.Codec().ClockRate),
+		transform: transform,
+	}, nil
+}
+
+// ReadFrame block until a frame is complete, return the transformed frame
+func (r *FrameReader) ReadFrame() (*media.Sample, error) {
+	for {
+		if s := r.builder.Pop(); s != nil {
+			if r.transform != nil {
+				data, err := r.transform(r.track.ID(), s.Data)
+				if err != nil {
+					return nil, err
+				}
+				s.Data = data
+			}
+			return s, nil
+		}
+		pkt, _, err := r.track.ReadRTP()
+		if err != nil {
+			return nil, err
+		}
+		r.builder.Push(pkt)
+	}
+}
@@ -1,27 +1,56 @@
 package engine
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
 )
 
+const gatherTimeout = 5 * time.Second
+
 // Transport is pub/sub transport
 type Transport struct {
 	api            *webrtc.DataChannel
-	signal         *Signal
+	signal         Signal
 	pc             *webrtc.PeerConnection
 	role           int
 	config         WebRTCTransportConfig
 	SendCandidates []*webrtc.ICECandidate
 	RecvCandidates []webrtc.ICECandidateInit
+	// guard the candidates queued by the ice agent and the signal until the remote sdp is set
+	// the pc is not asked, it is locked while waiting for the ice agent which call OnICECandidate
+	candLock  sync.Mutex
+	remoteSet bool
+
+	// unix nano of the last rtp received
+	lastRecv  int64
+	monitor   *rtpMonitor
+	recvStats *receiveStats
+	pauser    *pauser
+	pacing    *pacing
+	fec       *fecEncoder
+	rtx       *retransmitter
+	impairer  *impairer
+	tap       *rtpTap
+	jitter    *jitterBuffer
+	// send the sdp after gathering all candidates instead of trickle
+	noTrickle bool
+	// trace the sent candidates
+	trace func(SignalMessage)
 }
 
 // NewTransport create a transport
-func NewTransport(role int, signal *Signal, cfg WebRTCTransportConfig) *Transport {
+func NewTransport(role int, signal Signal, cfg WebRTCTransportConfig) *Transport {
 	t := &Transport{
-		role:   role,
-		signal: signal,
-		config: cfg,
+		role:      role,
+		signal:    signal,
+		config:    cfg,
+		lastRecv:  time.Now().UnixNano(),
+		noTrickle: cfg.NoTrickle,
 	}
 
 	var err error
@@ -29,11 +58,48 @@ func NewTransport(role int, signal *Signal, cfg WebRTCTransportConfig) *Transpor
 	var me *webrtc.MediaEngine
 	cfg.Setting.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
 	if role == PUBLISHER {
-		me, err = getPublisherMediaEngine(cfg.VideoMime)
+		me, err = getPublisherMediaEngine(cfg.VideoMime, cfg.PreferredCodecs, cfg.FEC)
 	} else {
 		me, err = getSubscriberMediaEngine()
 	}
-	api = webrtc.NewAPI(webrtc.WithMediaEngine(me), webrtc.WithSettingEngine(cfg.Setting))
+	ir := &interceptor.Registry{}
+	t.monitor = newRTPMonitor(t)
+	t.recvStats = newReceiveStats()
+	t.pauser = newPauser()
+	t.pacing = &pacing{}
+	t.fec = newFECEncoder(cfg.FEC)
+	t.rtx = newRetransmitter(cfg.RTX)
+	t.impairer = newImpairer(cfg.Impairment)
+	t.tap = newRTPTap()
+	jitter := cfg.JitterBuffer
+	if role != SUBSCRIBER {
+		jitter.Delay = 0
+	}
+	t.jitter = newJitterBuffer(jitter)
+	// the last added is the outermost writer, drop paused packets before protecting and counting
+	// rtx cache the packets after fec rewrite the sequence numbers, the pacing is next to the network, the
+	// impairment is the network after all
+	ir.Add(t.impairer)
+	ir.Add(t.pacing)
+	ir.Add(t.monitor)
+	ir.Add(t.recvStats)
+	ir.Add(t.rtx)
+	ir.Add(t.fec)
+	ir.Add(t.pauser)
+	if role == SUBSCRIBER {
+		// nack on arrival before the jitter buffer skip the gaps
+		generator, err := newNACKGenerator(cfg.NACK)
+		if err != nil {
+			log.Errorf("nack generator error: %v", err)
+		} else if generator != nil {
+			ir.Add(generator)
+		}
+		ir.Add(newPLILimiter(cfg.PLI))
+	}
+	// the received packets are counted on arrival, the forwarders get them once ordered
+	ir.Add(t.jitter)
+	ir.Add(t.tap)
+	api = webrtc.NewAPI(webrtc.WithMediaEngine(me), webrtc.WithSettingEngine(cfg.Setting), webrtc.WithInterceptorRegistry(ir))
 	t.pc, err = api.NewPeerConnection(cfg.Configuration)
 
 	if err != nil {
@@ -56,20 +122,74 @@ func NewTransport(role int, signal *Signal, cfg WebRTCTransportConfig) *Transpor
 			log.Infof("gather candidate done")
 			return
 		}
+		// candidates are in the local description
+		if t.noTrickle {
+			return
+		}
 		//append before join session success
-		if t.pc.CurrentRemoteDescription() == nil {
-			t.SendCandidates = append(t.SendCandidates, c)
-		} else {
-			for _, cand := range t.SendCandidates {
-				t.signal.Trickle(cand, role)
-			}
-			t.SendCandidates = []*webrtc.ICECandidate{}
-			t.signal.Trickle(c, role)
+		if !t.queueSendCandidate(c) {
+			t.trickle(c)
 		}
 	})
 	return t
 }
 
+// queueSendCandidate queue a local candidate until the remote sdp is set, return false if it can be sent
+func (t *Transport) queueSendCandidate(c *webrtc.ICECandidate) bool {
+	t.candLock.Lock()
+	defer t.candLock.Unlock()
+	if t.remoteSet {
+		return false
+	}
+	t.SendCandidates = append(t.SendCandidates, c)
+	return true
+}
+
+// queueRecvCandidate queue a remote candidate until the remote sdp is set, return false if it can be added
+func (t *Transport) queueRecvCandidate(c webrtc.ICECandidateInit) bool {
+	t.candLock.Lock()
+	defer t.candLock.Unlock()
+	if t.remoteSet {
+		return false
+	}
+	t.RecvCandidates = append(t.RecvCandidates, c)
+	return true
+}
+
+// takeCandidates return and clear the queued candidates, called once the remote sdp is set, the next ones
+// are not queued
+func (t *Transport) takeCandidates() ([]*webrtc.ICECandidate, []webrtc.ICECandidateInit) {
+	t.candLock.Lock()
+	defer t.candLock.Unlock()
+	t.remoteSet = true
+	send, recv := t.SendCandidates, t.RecvCandidates
+	t.SendCandidates, t.RecvCandidates = []*webrtc.ICECandidate{}, []webrtc.ICECandidateInit{}
+	return send, recv
+}
+
+// setLocalDescription set the local sdp and return the sdp to send
+// without trickle it wait for the gathering and return the sdp with all candidates
+func (t *Transport) setLocalDescription(desc webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if !t.noTrickle {
+		return desc, t.pc.SetLocalDescription(desc)
+	}
+	gathered := webrtc.GatheringCompletePromise(t.pc)
+	if err := t.pc.SetLocalDescription(desc); err != nil {
+		return desc, err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+		log.Warnf("role=%v gather candidate timeout, send the gathered", t.role)
+	}
+	return *t.pc.LocalDescription(), nil
+}
+
+// LastRecv return the time of the last rtp packet received
+func (t *Transport) LastRecv() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.lastRecv))
+}
+
 func (t *Transport) GetPeerConnection() *webrtc.PeerConnection {
 	return t.pc
 }
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"bytes"
+)
+
+const (
+	tsPMTPID   = 0x1000
+	tsVideoPID = 0x100
+	tsAudioPID = 0x101
+	// the pes stream ids of video and audio
+	tsVideoStreamID = 0xe0
+	tsAudioStreamID = 0xc0
+)
+
+// tsCRC is the crc32 of the psi sections, mpeg-2 without reflection
+func tsCRC(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// tsMuxer write a program of an h264 and an aac stream in mpeg-ts, e.g. the segments of hls
+// the pcr is on the video pid or the audio pid without video
+type tsMuxer struct {
+	video, audio bool
+	cc           map[uint16]byte
+}
+
+func newTSMuxer(video, audio bool) *tsMuxer {
+	return &tsMuxer{video: video, audio: audio, cc: make(map[uint16]byte)}
+}
+
+func (m *tsMuxer) pcrPID() uint16 {
+	if m.video {
+		return tsVideoPID
+	}
+	return tsAudioPID
+}
+
+// section append the crc to a psi section and write it in a packet
+func (m *tsMuxer) section(w *bytes.Buffer, pid uint16, s []byte) {
+	crc := tsCRC(s)
+	s = append(s, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+	// the pointer field
+	payload := append([]byte{0}, s...)
+	m.packets(w, pid, payload, nil)
+}
+
+// writeTables write the pat and the pmt, at the start of every segment
+func (m *tsMuxer) writeTables(w *bytes.Buffer) {
+	// program 1 is on tsPMTPID
+	m.section(w, 0, []byte{0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0x00, 0x01, 0xe0 | tsPMTPID>>8, tsPMTPID & 0xff})
+
+	var streams []byte
+	if m.video {
+		streams = append(streams, tsStreamH264, 0xe0|tsVideoPID>>8, tsVideoPID&0xff, 0xf0, 0x00)
+	}
+	if m.audio {
+		streams = append(streams, tsStreamAAC, 0xe0|tsAudioPID>>8, tsAudioPID&0xff, 0xf0, 0x00)
+	}
+	pcr := m.pcrPID()
+	n := 13 + len(streams)
+	pmt := []byte{0x02, 0xb0 | byte(n>>8), byte(n), 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0xe0 | byte(pcr>>8), byte(pcr), 0xf0, 0x00}
+	m.section(w, tsPMTPID, append(pmt, streams...))
+}
+
+// tsPTS encode a 33 bit timestamp of 90khz with its 4 bits prefix
+func tsPTS(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0e | 0x01,
+		byte(ts >> 22),
+		byte(ts>>14) | 0x01,
+		byte(ts >> 7),
+		byte(ts<<1) | 0x01,
+	}
+}
+
+// writePES write a frame at pts(90khz), key mark a random access point of the video
+func (m *tsMuxer) writePES(w *bytes.Buffer, pid uint16, streamID byte, pts int64, key bool, data []byte) {
+	pts &= tsWrap - 1
+	pes := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x80, 5}
+	// the video length is 0, unbounded
+	if n := 3 + 5 + len(data); streamID != tsVideoStreamID && n <= 0xffff {
+		pes[4], pes[5] = byte(n>>8), byte(n)
+	}
+	pes = append(pes, tsPTS(0x02, pts)...)
+	pes = append(pes, data...)
+
+	// the adaptation fields of the first packet, after their length
+	var af []byte
+	if pid == m.pcrPID() || key {
+		af = []byte{0x00}
+		if key {
+			af[0] |= 0x40
+		}
+		if pid == m.pcrPID() {
+			af[0] |= 0x10
+			af = append(af, byte(pts>>25), byte(pts>>17), byte(pts>>9), byte(pts>>1), byte(pts<<7)|0x7e, 0x00)
+		}
+	}
+	m.packets(w, pid, pes, af)
+}
+
+// packets split the payload in packets, the first start the unit, the last is stuffed
+func (m *tsMuxer) packets(w *bytes.Buffer, pid uint16, payload, af []byte) {
+	start := true
+	for len(payload) > 0 {
+		var pkt [tsPacketSize]byte
+		pkt[0] = tsSyncByte
+		pkt[1] = byte(pid>>8) & 0x1f
+		if start {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		hasAF := af != nil
+		space := tsPacketSize - 4
+		if hasAF {
+			space -= 1 + len(af)
+		}
+		if len(payload) < space {
+			stuff := space - len(payload)
+			if !hasAF {
+				hasAF, stuff = true, stuff-1
+				if stuff > 0 {
+					af, stuff = []byte{0x00}, stuff-1
+				}
+			}
+			for ; stuff > 0; stuff-- {
+				af = append(af, 0xff)
+			}
+		}
+		control := byte(0x10)
+		if hasAF {
+			control = 0x30
+		}
+		pkt[3] = control | m.cc[pid]&0x0f
+		m.cc[pid]++
+		i := 4
+		if hasAF {
+			pkt[i] = byte(len(af))
+			i += 1 + copy(pkt[i+1:], af)
+		}
+		n := copy(pkt[i:], payload)
+		payload = payload[n:]
+		w.Write(pkt[:])
+		start, af = false, nil
+	}
+}
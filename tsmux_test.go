@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTSCRC(t *testing.T) {
+	// the check value of crc-32/mpeg-2
+	assert.Equal(t, uint32(0x0376e6e7), tsCRC([]byte("123456789")))
+	// a section followed by its crc has a remainder of 0
+	s := []byte{0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00}
+	crc := tsCRC(s)
+	assert.Zero(t, tsCRC(append(s, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))))
+}
+
+func TestTSMuxRoundTrip(t *testing.T) {
+	frame := func(size int, b byte) []byte {
+		return bytes.Repeat([]byte{b}, size)
+	}
+	type muxed struct {
+		pid  uint16
+		pts  int64
+		key  bool
+		data []byte
+	}
+	for _, tc := range []struct {
+		name         string
+		video, audio bool
+		frames       []muxed
+		// want is the time of the frames, they are demuxed in order
+		want []time.Duration
+	}{
+		{
+			name:  "video and audio",
+			video: true, audio: true,
+			frames: []muxed{
+				{tsVideoPID, 90000, true, frame(5000, 1)},
+				{tsAudioPID, 90000, false, frame(300, 2)},
+				{tsVideoPID, 93000, false, frame(100, 3)},
+				{tsAudioPID, 91920, false, frame(184, 4)},
+				{tsVideoPID, 96000, false, frame(183, 5)},
+			},
+			want: []time.Duration{time.Second, time.Second, time.Second + 100*time.Millisecond/3,
+				time.Second + 64*time.Millisecond/3, time.Second + 200*time.Millisecond/3},
+		},
+		{
+			name:  "video only",
+			video: true,
+			frames: []muxed{
+				{tsVideoPID, 0, true, frame(1, 1)},
+				{tsVideoPID, 3000, false, frame(176, 2)},
+				{tsVideoPID, 6000, false, frame(177, 3)},
+			},
+			want: []time.Duration{0, time.Second / 30, 2 * time.Second / 30},
+		},
+		{
+			name:  "audio only",
+			audio: true,
+			frames: []muxed{
+				{tsAudioPID, 0, false, frame(400, 1)},
+				{tsAudioPID, 1920, false, frame(20, 2)},
+			},
+			want: []time.Duration{0, 64 * time.Millisecond / 3},
+		},
+		{
+			name:  "timestamp wrap",
+			video: true,
+			frames: []muxed{
+				{tsVideoPID, tsWrap - 3000, true, frame(10, 1)},
+				{tsVideoPID, tsWrap, false, frame(10, 2)},
+				{tsVideoPID, tsWrap + 3000, false, frame(10, 3)},
+			},
+			want: []time.Duration{time.Duration(tsWrap-3000) * 100000 / 9, time.Duration(tsWrap) * 100000 / 9,
+				time.Duration(tsWrap+3000) * 100000 / 9},
+		},
+	} {
+		m := newTSMuxer(tc.video, tc.audio)
+		w := &bytes.Buffer{}
+		m.writeTables(w)
+		for _, f := range tc.frames {
+			streamID := byte(tsVideoStreamID)
+			if f.pid == tsAudioPID {
+				streamID = tsAudioStreamID
+			}
+			m.writePES(w, f.pid, streamID, f.pts, f.key, f.data)
+		}
+		require.Zero(t, w.Len()%tsPacketSize, tc.name)
+
+		r, err := newTSReader(bytes.NewReader(w.Bytes()))
+		require.NoError(t, err, tc.name)
+		var codecs []string
+		for _, s := range r.streams {
+			codecs = append(codecs, s.codec)
+		}
+		var want []string
+		if tc.video {
+			want = append(want, "h264")
+		}
+		if tc.audio {
+			want = append(want, "aac")
+		}
+		assert.Equal(t, want, codecs, tc.name)
+
+		// the unbounded video pes is flushed by the next one, the audio by its length
+		got := map[uint16][]muxed{}
+		var times []time.Duration
+		for {
+			f, err := r.next()
+			if err != nil {
+				break
+			}
+			got[f.pid] = append(got[f.pid], muxed{pid: f.pid, data: f.data})
+			times = append(times, f.time)
+		}
+		for _, pid := range []uint16{tsVideoPID, tsAudioPID} {
+			var sent []muxed
+			for _, f := range tc.frames {
+				if f.pid == pid {
+					sent = append(sent, muxed{pid: pid, data: f.data})
+				}
+			}
+			assert.Equal(t, sent, got[pid], "%v pid %v", tc.name, pid)
+		}
+		assert.ElementsMatch(t, tc.want, times, tc.name)
+	}
+}
+
+func TestTSMuxContinuity(t *testing.T) {
+	m := newTSMuxer(true, false)
+	w := &bytes.Buffer{}
+	for i := 0; i < 3; i++ {
+		m.writePES(w, tsVideoPID, tsVideoStreamID, int64(i)*3000, i == 0, bytes.Repeat([]byte{1}, 1000))
+	}
+	b := w.Bytes()
+	var cc []byte
+	for i := 0; i+tsPacketSize <= len(b); i += tsPacketSize {
+		pid := uint16(b[i+1]&0x1f)<<8 | uint16(b[i+2])
+		require.Equal(t, uint16(tsVideoPID), pid)
+		cc = append(cc, b[i+3]&0x0f)
+		// the first packet of a pes carry the pcr and the random access point of the key frame
+		if b[i+1]&0x40 != 0 {
+			assert.Equal(t, byte(0x30), b[i+3]&0x30)
+			assert.Equal(t, byte(0x10), b[i+5]&0x10)
+			assert.Equal(t, i == 0, b[i+5]&0x40 != 0)
+		}
+	}
+	for i := range cc {
+		assert.Equal(t, byte(i)&0x0f, cc[i])
+	}
+}
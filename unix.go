@@ -0,0 +1,16 @@
+package engine
+
+import "strings"
+
+// unixScheme is the grpc target scheme of unix sockets, e.g. unix:///var/run/ion-sfu.sock
+const unixScheme = "unix:"
+
+// UnixAddr return the sfu addr of a unix socket path, for a sdk running on the sfu host
+func UnixAddr(path string) string {
+	return "unix://" + path
+}
+
+// isUnixAddr return true if addr is a unix socket, which is never proxied
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixScheme) || strings.HasPrefix(addr, "unix-abstract:")
+}
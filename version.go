@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// ProtocolVersion is the signaling protocol of the sdk, the one of ion-sfu v1
+	ProtocolVersion = 1
+	// minServerProtocol is the oldest server protocol the sdk work with
+	minServerProtocol = 1
+
+	// protocolKey is sent in the join config, servers may ignore it
+	protocolKey = "protocol"
+	// the grpc headers a server may set to advertise its protocol and version
+	protocolMetadataKey = "ion-sfu-protocol"
+	versionMetadataKey  = "ion-sfu-version"
+)
+
+// ServerInfo is what the sdk learned about the sfu at join
+type ServerInfo struct {
+	// Protocol is the advertised protocol, ProtocolVersion if the server does not advertise one
+	Protocol int
+	// Version is the advertised server version, empty if unknown
+	Version string
+	// APIChannel is true if the sfu opened the api datachannel, known after the first sub offer
+	APIChannel bool
+}
+
+// versioner is implemented by signals able to read the server version
+type versioner interface {
+	serverVersion() (protocol int, version string)
+}
+
+// ServerInfo return the sfu protocol and capabilities, zero before the join answer
+func (c *Client) ServerInfo() ServerInfo {
+	info, _ := c.server.Load().(ServerInfo)
+	return info
+}
+
+// withProtocol add the sdk protocol to the join config
+func withProtocol(config *JoinConfig) *JoinConfig {
+	cfg := NewJoinConfig()
+	if config != nil {
+		for k, v := range *config {
+			(*cfg)[k] = v
+		}
+	}
+	(*cfg)[protocolKey] = strconv.Itoa(ProtocolVersion)
+	return cfg
+}
+
+// checkServer check the protocol of the server on the join answer, once per join
+func (c *Client) checkServer() error {
+	if !atomic.CompareAndSwapInt32(&c.serverChecked, 0, 1) {
+		return nil
+	}
+	info := ServerInfo{Protocol: ProtocolVersion}
+	if v, ok := c.getSignal().(versioner); ok {
+		if protocol, version := v.serverVersion(); protocol > 0 {
+			info.Protocol, info.Version = protocol, version
+		}
+	}
+	c.server.Store(info)
+	if info.Protocol < minServerProtocol || info.Protocol > ProtocolVersion {
+		err := fmt.Errorf("%w: server protocol %v version %v, sdk support %v-%v",
+			ErrIncompatibleServer, info.Protocol, info.Version, minServerProtocol, ProtocolVersion)
+		log.Errorf("id=%v %v", c.uid, err)
+		c.serverErr = err
+		if c.OnError != nil {
+			c.engine.dispatcher.Dispatch(func() { c.OnError(err) })
+		}
+		go c.CloseWithReason(LeaveError)
+		return err
+	}
+	return nil
+}
+
+// checkAPIChannel note if the sfu negotiate the api datachannel in the sub offer
+func (c *Client) checkAPIChannel(offer webrtc.SessionDescription) {
+	info := c.ServerInfo()
+	info.APIChannel = strings.Contains(offer.SDP, "m=application")
+	c.server.Store(info)
+	atomic.StoreInt32(&c.apiChecked, 1)
+}
+
+// serverVersion return the protocol and version advertised in the stream headers
+func (s *GRPCSignal) serverVersion() (int, string) {
+	s.Lock()
+	defer s.Unlock()
+	return s.protocol, s.version
+}
+
+// readHeader read the server headers, they are received with the first reply
+func (s *GRPCSignal) readHeader() {
+	md, err := s.stream.Header()
+	if err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if v := md.Get(protocolMetadataKey); len(v) > 0 {
+		s.protocol, _ = strconv.Atoi(v[0])
+	}
+	if v := md.Get(versionMetadataKey); len(v) > 0 {
+		s.version = v[0]
+	}
+}
@@ -0,0 +1,387 @@
+package engine
+
+// vp8 token planes, rfc 6386 section 13.3
+const (
+	vp8PlaneYAfterY2 = 0
+	vp8PlaneY2       = 1
+	vp8PlaneUV       = 2
+)
+
+var (
+	vp8CoeffBands = [17]uint8{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	vp8Zigzag     = [16]uint8{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8CatProbs   = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+	// vp8Hadamard is the walsh-hadamard butterfly of the decoder
+	vp8Hadamard = [4][4]int32{{1, 1, 1, 1}, {1, 1, -1, -1}, {1, -1, -1, 1}, {1, -1, 1, -1}}
+)
+
+const (
+	// vp8Q0 is the dequantization factor of the quantizer index 0, the y2 ac is clamped to 8
+	vp8Q0     = 4
+	vp8Y2Q0   = 8
+	vp8MaxDCT = 2048 + 66
+)
+
+// boolEncoder is the boolean entropy encoder of rfc 6386 section 7.3
+type boolEncoder struct {
+	out      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, bitCount: 24}
+}
+
+func (e *boolEncoder) addOne() {
+	i := len(e.out) - 1
+	for i >= 0 && e.out[i] == 255 {
+		e.out[i] = 0
+		i--
+	}
+	if i >= 0 {
+		e.out[i]++
+	}
+}
+
+func (e *boolEncoder) writeBool(prob uint8, b bool) {
+	split := 1 + (((e.rng - 1) * uint32(prob)) >> 8)
+	if b {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.addOne()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.out = append(e.out, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+// writeLiteral write the n low bits of v, most significant first
+func (e *boolEncoder) writeLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.writeBool(128, v>>uint(i)&1 == 1)
+	}
+}
+
+func (e *boolEncoder) flush() []byte {
+	c := e.bitCount
+	v := e.bottom
+	if v&(1<<uint(32-c)) != 0 {
+		e.addOne()
+	}
+	v <<= uint(c & 7)
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for i := 0; i < 4; i++ {
+		e.out = append(e.out, byte(v>>24))
+		v <<= 8
+	}
+	return e.out
+}
+
+// vp8Encoder encode key frames of 4x4 flat blocks, every macroblock is dc predicted and only the dc
+// of the blocks is coded, enough for synthetic patterns without a native encoder
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	// the reconstructed blocks, the predictions use them
+	recY, recU, recV []int32
+}
+
+func newVP8Encoder(width, height int) *vp8Encoder {
+	e := &vp8Encoder{width: width, height: height, mbw: (width + 15) / 16, mbh: (height + 15) / 16}
+	e.recY = make([]int32, e.mbw*4*e.mbh*4)
+	e.recU = make([]int32, e.mbw*2*e.mbh*2)
+	e.recV = make([]int32, e.mbw*2*e.mbh*2)
+	return e
+}
+
+// vp8Image is the target value of every 4x4 block, y is mbw*4 blocks wide and u/v mbw*2
+type vp8Image struct {
+	y, u, v []int32
+}
+
+// encode return a key frame of img
+func (e *vp8Encoder) encode(img *vp8Image) []byte {
+	header := newBoolEncoder()
+	// color space, clamping, no segmentation
+	header.writeLiteral(0, 3)
+	// normal loop filter of level 0, sharpness 0, no deltas
+	header.writeLiteral(0, 1+6+3+1)
+	// one token partition
+	header.writeLiteral(0, 2)
+	// quantizer index 0 without deltas
+	header.writeLiteral(0, 7+5)
+	// refresh entropy probs
+	header.writeLiteral(1, 1)
+	for i := range vp8TokenUpdateProb {
+		for j := range vp8TokenUpdateProb[i] {
+			for k := range vp8TokenUpdateProb[i][j] {
+				for l := range vp8TokenUpdateProb[i][j][k] {
+					header.writeBool(vp8TokenUpdateProb[i][j][k][l], false)
+				}
+			}
+		}
+	}
+	// no mb_no_coeff_skip, every macroblock has tokens
+	header.writeLiteral(0, 1)
+
+	tokens := newBoolEncoder()
+	// the non-zero flags of the y2 and uv blocks above and left
+	aboveY2 := make([]uint8, e.mbw)
+	aboveUV := make([]uint8, e.mbw*4)
+	for mby := 0; mby < e.mbh; mby++ {
+		var leftY2 uint8
+		var leftUV [4]uint8
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			// dc_pred for luma: 1 with 145, 0 with 156, 0 with 163; chroma: 0 with 142
+			header.writeBool(145, true)
+			header.writeBool(156, false)
+			header.writeBool(163, false)
+			header.writeBool(142, false)
+
+			nz := e.encodeY2(tokens, img, mbx, mby, aboveY2[mbx]+leftY2)
+			aboveY2[mbx], leftY2 = nz, nz
+			for i := 0; i < 16; i++ {
+				// the luma blocks have only their dc, in y2
+				tokens.writeBool(vp8DefaultTokenProb[vp8PlaneYAfterY2][vp8CoeffBands[1]][0][0], false)
+			}
+			for p, plane := range [][]int32{img.u, img.v} {
+				rec := e.recU
+				if p == 1 {
+					rec = e.recV
+				}
+				pred := e.predict(rec, e.mbw*2, mbx, mby, 2)
+				for by := 0; by < 2; by++ {
+					for bx := 0; bx < 2; bx++ {
+						i := (mby*2+by)*e.mbw*2 + mbx*2 + bx
+						q := clampDCT((plane[i] - pred) * 2)
+						a, l := &aboveUV[mbx*4+p*2+bx], &leftUV[p*2+by]
+						var coeffs [16]int32
+						coeffs[0] = q
+						nz := writeVP8Block(tokens, vp8PlaneUV, *a+*l, &coeffs, 0)
+						*a, *l = nz, nz
+						rec[i] = clampPixel(pred + (q*vp8Q0+4)>>3)
+					}
+				}
+			}
+		}
+	}
+	first := header.flush()
+	data := tokens.flush()
+
+	frame := make([]byte, 0, 10+len(first)+len(data))
+	// key frame, version 0, shown, with the first partition size
+	tag := uint32(1<<4) | uint32(len(first))<<5
+	frame = append(frame, byte(tag), byte(tag>>8), byte(tag>>16))
+	frame = append(frame, 0x9d, 0x01, 0x2a)
+	frame = append(frame, byte(e.width), byte(e.width>>8&0x3f), byte(e.height), byte(e.height>>8&0x3f))
+	frame = append(frame, first...)
+	return append(frame, data...)
+}
+
+// encodeY2 code the dc of the luma blocks of a macroblock by the walsh-hadamard transform
+func (e *vp8Encoder) encodeY2(tokens *boolEncoder, img *vp8Image, mbx, mby int, ctx uint8) uint8 {
+	stride := e.mbw * 4
+	pred := e.predict(e.recY, stride, mbx, mby, 4)
+	// the dc of a block add (dc+4)>>3 to its pixels
+	var dc [4][4]int32
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			dc[y][x] = (img.y[(mby*4+y)*stride+mbx*4+x] - pred) * 8
+		}
+	}
+	// the inverse of the decoder H*X*Ht/8 is Ht*D*H/2
+	var coeffs [16]int32
+	for r := 0; r < 4; r++ {
+		for c := 0; c < 4; c++ {
+			var sum int32
+			for k := 0; k < 4; k++ {
+				for l := 0; l < 4; l++ {
+					sum += vp8Hadamard[k][r] * dc[k][l] * vp8Hadamard[l][c]
+				}
+			}
+			coeffs[r*4+c] = clampDCT(roundDiv(sum, 2*vp8Y2Q0))
+		}
+	}
+	nz := writeVP8Block(tokens, vp8PlaneY2, ctx, &coeffs, 0)
+
+	// reconstruct like the decoder
+	var deq, tmp [16]int32
+	for i := range coeffs {
+		deq[i] = coeffs[i] * vp8Y2Q0
+	}
+	for i := 0; i < 4; i++ {
+		a1, b1 := deq[i]+deq[12+i], deq[4+i]+deq[8+i]
+		c1, d1 := deq[4+i]-deq[8+i], deq[i]-deq[12+i]
+		tmp[i], tmp[4+i], tmp[8+i], tmp[12+i] = a1+b1, c1+d1, a1-b1, d1-c1
+	}
+	for i := 0; i < 4; i++ {
+		a1, b1 := tmp[4*i]+tmp[4*i+3], tmp[4*i+1]+tmp[4*i+2]
+		c1, d1 := tmp[4*i+1]-tmp[4*i+2], tmp[4*i]-tmp[4*i+3]
+		out := [4]int32{(a1 + b1 + 3) >> 3, (c1 + d1 + 3) >> 3, (a1 - b1 + 3) >> 3, (d1 - c1 + 3) >> 3}
+		for x := 0; x < 4; x++ {
+			e.recY[(mby*4+i)*stride+mbx*4+x] = clampPixel(pred + (out[x]+4)>>3)
+		}
+	}
+	return nz
+}
+
+// predict return the dc prediction of a macroblock of n*n blocks from the reconstructed edges
+func (e *vp8Encoder) predict(rec []int32, stride, mbx, mby, n int) int32 {
+	var sum int32
+	count := 0
+	if mby > 0 {
+		for x := 0; x < n; x++ {
+			sum += rec[(mby*n-1)*stride+mbx*n+x] * 4
+		}
+		count++
+	}
+	if mbx > 0 {
+		for y := 0; y < n; y++ {
+			sum += rec[(mby*n+y)*stride+mbx*n-1] * 4
+		}
+		count++
+	}
+	if count == 0 {
+		return 128
+	}
+	// n*4 pixels per edge
+	shift := uint(2)
+	for size := n * 4; size > 1; size >>= 1 {
+		shift++
+	}
+	shift = shift - 3 + uint(count)
+	return (sum + 1<<(shift-1)) >> shift
+}
+
+// writeVP8Block code the tokens of a block from first, coeffs are in raster order
+// it return 1 if a coefficient is not zero
+func writeVP8Block(e *boolEncoder, plane int, ctx uint8, coeffs *[16]int32, first int) uint8 {
+	last := -1
+	for n := first; n < 16; n++ {
+		if coeffs[vp8Zigzag[n]] != 0 {
+			last = n
+		}
+	}
+	probs := &vp8DefaultTokenProb[plane]
+	p := probs[vp8CoeffBands[first]][ctx]
+	if last < 0 {
+		e.writeBool(p[0], false)
+		return 0
+	}
+	e.writeBool(p[0], true)
+	for n := first; n < 16; {
+		v := coeffs[vp8Zigzag[n]]
+		n++
+		if v == 0 {
+			e.writeBool(p[1], false)
+			p = probs[vp8CoeffBands[n]][0]
+			continue
+		}
+		e.writeBool(p[1], true)
+		a := v
+		if a < 0 {
+			a = -a
+		}
+		if a == 1 {
+			e.writeBool(p[2], false)
+		} else {
+			e.writeBool(p[2], true)
+			switch {
+			case a <= 4:
+				e.writeBool(p[3], false)
+				if a == 2 {
+					e.writeBool(p[4], false)
+				} else {
+					e.writeBool(p[4], true)
+					e.writeBool(p[5], a == 4)
+				}
+			case a <= 10:
+				e.writeBool(p[3], true)
+				e.writeBool(p[6], false)
+				if a <= 6 {
+					e.writeBool(p[7], false)
+					e.writeBool(159, a == 6)
+				} else {
+					e.writeBool(p[7], true)
+					e.writeBool(165, (a-7)>>1 == 1)
+					e.writeBool(145, (a-7)&1 == 1)
+				}
+			default:
+				e.writeBool(p[3], true)
+				e.writeBool(p[6], true)
+				cat := 0
+				for cat < 3 && a >= 3+(16<<uint(cat)) {
+					cat++
+				}
+				e.writeBool(p[8], cat>>1 == 1)
+				e.writeBool(p[9+cat>>1], cat&1 == 1)
+				extra := uint32(a - (3 + 8<<uint(cat)))
+				tab := vp8CatProbs[cat]
+				for i, prob := range tab {
+					e.writeBool(prob, extra>>uint(len(tab)-1-i)&1 == 1)
+				}
+			}
+		}
+		e.writeBool(128, v < 0)
+		if a == 1 {
+			p = probs[vp8CoeffBands[n]][1]
+		} else {
+			p = probs[vp8CoeffBands[n]][2]
+		}
+		if n == 16 {
+			break
+		}
+		e.writeBool(p[0], n <= last)
+		if n > last {
+			break
+		}
+	}
+	return 1
+}
+
+func roundDiv(a, b int32) int32 {
+	if a < 0 {
+		return -((-a + b/2) / b)
+	}
+	return (a + b/2) / b
+}
+
+func clampDCT(v int32) int32 {
+	if v > vp8MaxDCT {
+		return vp8MaxDCT
+	}
+	if v < -vp8MaxDCT {
+		return -vp8MaxDCT
+	}
+	return v
+}
+
+func clampPixel(v int32) int32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVP8Encoder(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		width, height int
+		// block return the y, u, v of the 4x4 block at bx, by
+		block func(bx, by int) (int32, int32, int32)
+	}{
+		{"flat", 16, 16, func(bx, by int) (int32, int32, int32) { return 128, 128, 128 }},
+		{"black", 32, 32, func(bx, by int) (int32, int32, int32) { return 16, 128, 128 }},
+		{"white", 48, 16, func(bx, by int) (int32, int32, int32) { return 235, 128, 128 }},
+		{"odd size", 33, 17, func(bx, by int) (int32, int32, int32) { return 60, 90, 200 }},
+		{"gradient", 64, 48, func(bx, by int) (int32, int32, int32) {
+			return int32(16 + bx*12), int32(128 + by*4), int32(200 - bx*4)
+		}},
+		{"checker", 32, 32, func(bx, by int) (int32, int32, int32) {
+			if (bx+by)%2 == 0 {
+				return 20, 128, 128
+			}
+			return 230, 128, 128
+		}},
+	} {
+		enc := newVP8Encoder(tc.width, tc.height)
+		bw, bh := enc.mbw*4, enc.mbh*4
+		img := &vp8Image{y: make([]int32, bw*bh), u: make([]int32, bw*bh/4), v: make([]int32, bw*bh/4)}
+		for by := 0; by < bh; by++ {
+			for bx := 0; bx < bw; bx++ {
+				y, u, v := tc.block(bx, by)
+				img.y[by*bw+bx] = y
+				if bx%2 == 0 && by%2 == 0 {
+					img.u[by/2*bw/2+bx/2], img.v[by/2*bw/2+bx/2] = u, v
+				}
+			}
+		}
+		// a second frame check the encoder start again from a clean state
+		for i := 0; i < 2; i++ {
+			decoded, err := decodeVP8(enc.encode(img))
+			require.NoError(t, err, tc.name)
+			yuv, ok := decoded.(*image.YCbCr)
+			require.True(t, ok, tc.name)
+			require.Equal(t, image.Rect(0, 0, tc.width, tc.height), yuv.Bounds(), tc.name)
+			for py := 0; py < tc.height; py++ {
+				for px := 0; px < tc.width; px++ {
+					y, u, v := tc.block(px/4, py/4)
+					if px%8 < 4 && py%8 < 4 {
+						// the chroma of the 8x8 block is the top left one
+						assert.InDelta(t, u, yuv.Cb[yuv.COffset(px, py)], 4, "%v cb at %v,%v", tc.name, px, py)
+						assert.InDelta(t, v, yuv.Cr[yuv.COffset(px, py)], 4, "%v cr at %v,%v", tc.name, px, py)
+					}
+					if !assert.InDelta(t, y, yuv.Y[yuv.YOffset(px, py)], 4, "%v y at %v,%v", tc.name, px, py) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTestPatternFrame(t *testing.T) {
+	for _, tc := range []struct {
+		width, height int
+	}{
+		{640, 480},
+		{320, 180},
+		{100, 50},
+	} {
+		p := newTestPattern(tc.width, tc.height)
+		for n := uint64(0); n < 3; n++ {
+			frame := p.frame(n, time.Unix(1600000000, 0))
+			// every frame is a key frame
+			assert.Equal(t, byte(0), frame[0]&0x01)
+			decoded, err := decodeVP8(frame)
+			require.NoError(t, err)
+			assert.Equal(t, image.Rect(0, 0, tc.width, tc.height), decoded.Bounds())
+		}
+	}
+}
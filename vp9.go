@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// vp9HeaderSize is the non-flexible descriptor with a 15 bits picture id
+const vp9HeaderSize = 3
+
+// vp9Frame is the uncompressed header of a vp9 frame
+type vp9Frame struct {
+	key           bool
+	width, height uint16
+}
+
+// vp9BitReader read the bits of an uncompressed header, 0 past the end
+type vp9BitReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *vp9BitReader) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.pos/8 < len(r.b) {
+			v |= uint32(r.b[r.pos/8]>>(7-uint(r.pos%8))) & 1
+		}
+		r.pos++
+	}
+	return v
+}
+
+// parseVP9Frame read the frame type and the size of key frames
+func parseVP9Frame(b []byte) (vp9Frame, bool) {
+	var f vp9Frame
+	r := &vp9BitReader{b: b}
+	if r.read(2) != 2 {
+		return f, false
+	}
+	profile := r.read(1) | r.read(1)<<1
+	if profile == 3 {
+		r.read(1)
+	}
+	// show_existing_frame
+	if r.read(1) == 1 {
+		return f, true
+	}
+	f.key = r.read(1) == 0
+	// show_frame, error_resilient_mode
+	r.read(2)
+	if !f.key {
+		return f, true
+	}
+	if r.read(24) != 0x498342 {
+		return f, false
+	}
+	// color_config
+	if profile >= 2 {
+		r.read(1)
+	}
+	if r.read(3) != 7 {
+		// color_range, subsampling and reserved
+		r.read(1)
+		if profile == 1 || profile == 3 {
+			r.read(3)
+		}
+	} else if profile == 1 || profile == 3 {
+		r.read(1)
+	}
+	f.width = uint16(r.read(16) + 1)
+	f.height = uint16(r.read(16) + 1)
+	return f, true
+}
+
+// vp9Frames split a superframe by its index, a normal frame is returned as is
+func vp9Frames(b []byte) [][]byte {
+	if len(b) == 0 {
+		return nil
+	}
+	last := b[len(b)-1]
+	if last&0xe0 != 0xc0 {
+		return [][]byte{b}
+	}
+	n := int(last&7) + 1
+	mag := int((last>>3)&3) + 1
+	size := 2 + mag*n
+	if len(b) < size || b[len(b)-size] != last {
+		return [][]byte{b}
+	}
+	index := b[len(b)-size+1:]
+	data := b[:len(b)-size]
+	var frames [][]byte
+	for i := 0; i < n; i++ {
+		var l int
+		for j := 0; j < mag; j++ {
+			l |= int(index[i*mag+j]) << (8 * uint(j))
+		}
+		if l > len(data) {
+			return [][]byte{b}
+		}
+		frames = append(frames, data[:l])
+		data = data[l:]
+	}
+	return frames
+}
+
+// vp9Payloader packetize vp9 frames in non-flexible mode, with the inter picture bit and the
+// resolution on key frames, pion marks every frame as intra which breaks the receiver references
+// a superframe is sent as one picture, it is split by the decoder
+type vp9Payloader struct {
+	pictureID   uint16
+	initialized bool
+}
+
+func (p *vp9Payloader) Payload(mtu int, payload []byte) [][]byte {
+	if !p.initialized {
+		p.pictureID = uint16(rand.Intn(0x7fff))
+		p.initialized = true
+	}
+	frames := vp9Frames(payload)
+	if len(frames) == 0 {
+		return nil
+	}
+	var key *vp9Frame
+	for _, b := range frames {
+		if f, ok := parseVP9Frame(b); ok && f.key {
+			key = &f
+			break
+		}
+	}
+
+	header := make([]byte, vp9HeaderSize, vp9HeaderSize+5)
+	// I=1, non-flexible, no layer indices
+	header[0] = 0x80
+	if key == nil {
+		header[0] |= 0x40
+	}
+	binary.BigEndian.PutUint16(header[1:], p.pictureID|0x8000)
+	p.pictureID = (p.pictureID + 1) & 0x7fff
+	var ss []byte
+	if key != nil {
+		// one spatial layer with its resolution, no picture group
+		ss = []byte{0x10, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(ss[1:], key.width)
+		binary.BigEndian.PutUint16(ss[3:], key.height)
+	}
+
+	var out [][]byte
+	for data := payload; len(data) > 0; {
+		first := len(data) == len(payload)
+		h := append([]byte{}, header...)
+		if first {
+			h[0] |= 0x08
+			if ss != nil {
+				h[0] |= 0x02
+				h = append(h, ss...)
+			}
+		}
+		n := mtu - len(h)
+		if n <= 0 {
+			return nil
+		}
+		if n >= len(data) {
+			n = len(data)
+			h[0] |= 0x04
+		}
+		out = append(out, append(h, data[:n]...))
+		data = data[n:]
+	}
+	return out
+}
+
+// VP9Track is a sample track of vp9 with the payloader of the sdk, write frames or superframes
+type VP9Track struct {
+	*payloadTrack
+}
+
+// NewVP9Track create a vp9 track
+func NewVP9Track(id, streamID string) (*VP9Track, error) {
+	track, err := newPayloadTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}, id, streamID, &vp9Payloader{})
+	if err != nil {
+		return nil, err
+	}
+	return &VP9Track{payloadTrack: track}, nil
+}
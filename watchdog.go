@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// watchdog check all clients every WatchdogInterval until engine closed
+func (e *Engine) watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			cfg := e.getConfig()
+			e.RLock()
+			var clients []*Client
+			for _, m := range e.clients {
+				for _, c := range m {
+					if c != nil {
+						clients = append(clients, c)
+					}
+				}
+			}
+			e.RUnlock()
+
+			for _, c := range clients {
+				reason := c.checkStuck(cfg.WatchdogTimeout)
+				if reason == "" {
+					continue
+				}
+				log.Warnf("id=%v sid=%v stuck: %v", c.uid, c.sid, reason)
+				if e.OnClientStuck != nil {
+					c := c
+					e.dispatcher.Dispatch(func() { e.OnClientStuck(c, reason) })
+				}
+				if cfg.WatchdogAutoClose {
+					c.CloseWithReason(LeaveError)
+				}
+			}
+		}
+	}
+}
+
+// checkStuck return why the client is stuck, empty if it is alive
+func (c *Client) checkStuck(timeout time.Duration) string {
+	pub := c.getPub()
+	sub := c.getSub()
+	for _, t := range []*Transport{pub, sub} {
+		if t == nil {
+			continue
+		}
+		switch state := t.pc.ICEConnectionState(); state {
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			return fmt.Sprintf("ice state %v", state)
+		}
+	}
+
+	if timeout <= 0 || sub == nil {
+		return ""
+	}
+	_, subTracks := c.getTrackCount()
+	if subTracks == 0 {
+		return ""
+	}
+	if since := time.Since(sub.LastRecv()); since > timeout {
+		return fmt.Sprintf("no rtp received for %v", since.Round(time.Second))
+	}
+	return ""
+}
@@ -1,10 +1,15 @@
 package engine
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ebml-go/webm"
@@ -15,15 +20,46 @@ import (
 type trackInfo struct {
 	track *webrtc.TrackLocalStaticSample
 	rate  int
+	// the timecode and duration of the last block, video frames are not 20ms
+	lastTimecode time.Duration
+	duration     time.Duration
+	// the media time of the first sample and the clock ticks sent since, see sampleDuration
+	start   time.Duration
+	ticks   int64
+	started bool
+	// the media time of the next sample
+	end time.Duration
+	// the last timecode is before a seek
+	seeked bool
+}
+
+// sampleDuration return the duration of the sample at media time ts lasting frame, so the next rtp timestamp
+// is at ts+frame from the first sample, the truncation of the durations to clock ticks and any drift between
+// the tracks are corrected at every sample instead of accumulating
+func (i *trackInfo) sampleDuration(ts, frame time.Duration) time.Duration {
+	rate := int64(i.track.Codec().ClockRate)
+	if !i.started {
+		i.start, i.started = ts, true
+	}
+	d := ts + frame - i.start
+	next := int64(d/time.Second)*rate + int64(d%time.Second)*rate/int64(time.Second)
+	ticks := next - i.ticks
+	if ticks < 0 {
+		ticks = 0
+	}
+	i.ticks += ticks
+	i.end = ts + frame
+	// round up so that pion truncate it back to ticks
+	return time.Duration((ticks*int64(time.Second) + rate - 1) / rate)
 }
 
 // WebMProducer support streaming by webm which encode with vp8 and opus
 type WebMProducer struct {
 	name          string
 	stop          bool
-	paused        bool
-	pauseChan     chan bool
 	seekChan      chan time.Duration
+	done          chan struct{}
+	stopOnce      sync.Once
 	videoTrack    *webrtc.TrackLocalStaticSample
 	audioTrack    *webrtc.TrackLocalStaticSample
 	offsetSeconds int
@@ -31,9 +67,14 @@ type WebMProducer struct {
 	webm          webm.WebM
 	trackMap      map[uint]*trackInfo
 	videoCodec    string
-	file          *os.File
-	sendByte      int
+	source        io.ReadSeeker
+	sendByte      uint64
+	lastSendByte  uint64
 	id            string
+	pauseGate
+	progress
+	// Loop restart the file when it ends, true by default
+	Loop bool
 }
 
 // NewWebMProducer new a WebMProducer
@@ -43,11 +84,40 @@ func NewWebMProducer(id, name string, offset int) *WebMProducer {
 		log.Errorf("unable to open file %s", name)
 		return nil
 	}
+	p, err := newWebMProducer(id, name, r, offset)
+	if err != nil {
+		r.Close()
+		return nil
+	}
+	return p
+}
+
+// NewWebMProducerFromReader read a webm file from r, e.g. a http body or an object storage download
+// the demuxer needs an io.ReadSeeker, another reader is read in memory first, use PublishStream for live streams
+// an io.Closer r is closed when the producer ends
+func NewWebMProducerFromReader(id string, r io.Reader) (*WebMProducer, error) {
+	name := fmt.Sprintf("reader_%p", r)
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		b, err := ioutil.ReadAll(r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			log.Errorf("read webm %v err=%v", name, err)
+			return nil, err
+		}
+		rs = bytes.NewReader(b)
+	}
+	return newWebMProducer(id, name, rs, 0)
+}
+
+func newWebMProducer(id, name string, r io.ReadSeeker, offset int) (*WebMProducer, error) {
 	var w webm.WebM
 	reader, err := webm.Parse(r, &w)
 	if err != nil {
 		log.Errorf("err=%v", err)
-		return nil
+		return nil, err
 	}
 
 	p := &WebMProducer{
@@ -57,12 +127,13 @@ func NewWebMProducer(id, name string, offset int) *WebMProducer {
 		reader:        reader,
 		webm:          w,
 		trackMap:      make(map[uint]*trackInfo),
-		file:          r,
-		pauseChan:     make(chan bool),
+		source:        r,
 		seekChan:      make(chan time.Duration, 1),
+		done:          make(chan struct{}),
+		Loop:          true,
 	}
 
-	return p
+	return p, nil
 }
 
 func (t *WebMProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
@@ -74,8 +145,11 @@ func (t *WebMProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
 }
 
 func (t *WebMProducer) Stop() {
-	t.stop = true
-	t.reader.Shutdown()
+	t.stopOnce.Do(func() {
+		t.stop = true
+		close(t.done)
+		t.reader.Shutdown()
+	})
 }
 
 func (t *WebMProducer) Start() {
@@ -83,12 +157,13 @@ func (t *WebMProducer) Start() {
 }
 
 func (t *WebMProducer) SeekP(ts int) {
-	seekDuration := time.Duration(ts) * time.Second
-	t.seekChan <- seekDuration
+	t.SeekTo(time.Duration(ts) * time.Second)
 }
 
-func (t *WebMProducer) Pause(pause bool) {
-	t.pauseChan <- pause
+// SeekTo jump to the cluster at or before d, the video restart at its next keyframe
+func (t *WebMProducer) SeekTo(d time.Duration) error {
+	requestSeek(t.seekChan, d)
+	return nil
 }
 
 func (t *WebMProducer) VideoCodec() string {
@@ -165,11 +240,54 @@ func (t *WebMProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc
 	return track, nil
 }
 
+// bindTrack write the first track of kind to track, e.g. a playlist track, the codecs must match
+func (t *WebMProducer) bindTrack(kind string, track *webrtc.TrackLocalStaticSample) error {
+	mime := track.Codec().MimeType
+	if kind == "video" {
+		vTrack := t.webm.FindFirstVideoTrack()
+		if vTrack == nil {
+			return errUnsupportedCodec
+		}
+		if (vTrack.CodecID != "V_VP8" || !strings.EqualFold(mime, webrtc.MimeTypeVP8)) &&
+			(vTrack.CodecID != "V_VP9" || !strings.EqualFold(mime, webrtc.MimeTypeVP9)) {
+			return errUnsupportedCodec
+		}
+		t.videoCodec = mime
+		t.trackMap[vTrack.TrackNumber] = &trackInfo{track: track, rate: 90000}
+		t.videoTrack = track
+		return nil
+	}
+	aTrack := t.webm.FindFirstAudioTrack()
+	if aTrack == nil || aTrack.CodecID != "A_OPUS" || !strings.EqualFold(mime, webrtc.MimeTypeOpus) {
+		return errUnsupportedCodec
+	}
+	t.trackMap[aTrack.TrackNumber] = &trackInfo{track: track, rate: int(aTrack.Audio.OutputSamplingFrequency)}
+	t.audioTrack = track
+	return nil
+}
+
 func (t *WebMProducer) readLoop() {
-	startTime := time.Now()
-	timeEps := 5 * time.Millisecond
+	if c, ok := t.source.(io.Closer); ok {
+		defer c.Close()
+	}
+	clock := newMediaClock()
+	// the tracks share the media time of the blocks, offset from their timecodes by the seeks, restarts and pauses
+	// so the audio and video timestamps stay locked, a seek rebase the timeline to the end of the sent samples
+	var offset time.Duration
+	rebase := func(ts time.Duration) {
+		var end time.Duration
+		for _, info := range t.trackMap {
+			if info.started && info.end > end {
+				end = info.end
+			}
+			info.seeked = true
+		}
+		offset = end - ts
+	}
 
 	seekDuration := time.Duration(-1)
+	// after a seek the clock restart at the first block, the video at a keyframe
+	resync, waitKey := false, false
 
 	if t.offsetSeconds > 0 {
 		t.SeekP(t.offsetSeconds)
@@ -181,21 +299,11 @@ func (t *WebMProducer) readLoop() {
 	}
 
 	for pck := range t.reader.Chan {
-		if t.paused {
-			log.Infof("Paused")
-			// Wait for unpause
-			for pause := range t.pauseChan {
-				if !pause {
-					t.paused = false
-					break
-				}
-			}
-			log.Infof("Unpaused")
-			startTime = time.Now().Add(-pck.Timecode)
-		}
-
 		// Restart when track runs out
 		if pck.Timecode < 0 {
+			if !t.Loop {
+				break
+			}
 			if !t.stop {
 				log.Infof("Restart media")
 				startSeek(0)
@@ -203,55 +311,96 @@ func (t *WebMProducer) readLoop() {
 			continue
 		}
 
-		// Handle seek and pause
+		// Handle seek
 		select {
 		case dur := <-t.seekChan:
 			log.Infof("Seek duration=%v", dur)
 			startSeek(dur)
 			continue
-		case pause := <-t.pauseChan:
-			t.paused = pause
-			if pause {
-				continue
-			}
 		default:
 		}
 
 		// Handle actual seek
 		if seekDuration > -1 && math.Abs(float64((pck.Timecode-seekDuration).Milliseconds())) < 30.0 {
 			log.Infof("Seek happened!!!!")
-			startTime = time.Now().Add(-seekDuration)
 			seekDuration = time.Duration(-1)
+			resync, waitKey = true, true
 			continue
 		}
 
 		// Find sender
 		if track, ok := t.trackMap[pck.TrackNumber]; ok {
-			// Only delay frames we care about
-			timeDiff := pck.Timecode - time.Since(startTime)
-			if timeDiff > timeEps {
-				time.Sleep(timeDiff - time.Millisecond)
+			if track.track.Kind() == webrtc.RTPCodecTypeVideo && waitKey {
+				if !pck.Keyframe {
+					continue
+				}
+				waitKey = false
+			}
+			if resync {
+				clock.reset(pck.Timecode)
+				rebase(pck.Timecode)
+				resync = false
 			}
+			// Only delay frames we care about, drain the reader once stopped
+			if !clock.wait(pck.Timecode, t.done) {
+				continue
+			}
+			gap, ok := t.waitResume(t.done)
+			if !ok {
+				continue
+			}
+			t.setPosition(pck.Timecode)
+			if gap > 0 {
+				log.Infof("Resumed after %v", gap)
+				clock.reset(pck.Timecode)
+				offset += gap
+			}
+
+			// the frame duration is the last timecode delta, a seek or restart keep the last duration
+			if d := pck.Timecode - track.lastTimecode; d > 0 && track.duration > 0 && !track.seeked {
+				track.duration = d
+			} else if track.duration == 0 {
+				track.duration = time.Millisecond * 20
+			}
+			track.lastTimecode, track.seeked = pck.Timecode, false
 
 			// Send samples
-			if ivfErr := track.track.WriteSample(media.Sample{Data: pck.Data, Duration: time.Millisecond * 20}); ivfErr != nil {
+			duration := track.sampleDuration(offset+pck.Timecode, track.duration)
+			if ivfErr := track.track.WriteSample(media.Sample{Data: pck.Data, Duration: duration}); ivfErr != nil {
 				log.Errorf("Track write error=%v", ivfErr)
 			} else {
 				log.Tracef("id=%v mime=%v kind=%v streamid=%v len=%v", t.id, track.track.Codec().MimeType, track.track.Kind(), track.track.StreamID(), len(pck.Data))
-				t.sendByte += len(pck.Data)
+				atomic.AddUint64(&t.sendByte, uint64(len(pck.Data)))
+				t.sent(len(pck.Data))
 			}
 		}
 	}
+	// the last sample last its duration, e.g. before the next file of a playlist
+	var end time.Duration
+	for _, info := range t.trackMap {
+		if e := info.lastTimecode + info.duration; e > end {
+			end = e
+		}
+	}
+	if clock.wait(end, t.done) {
+		t.ended(nil)
+	}
 	log.Infof("Exiting webm producer")
 }
 
 // GetSendBandwidth calc the sending bandwidth with cycle(s)
 func (t *WebMProducer) GetSendBandwidth(cycle int) int {
-	bw := t.sendByte / cycle / 1000
-	t.sendByte = 0
+	sendByte := atomic.LoadUint64(&t.sendByte)
+	bw := int(sendByte-t.lastSendByte) / cycle / 1000
+	t.lastSendByte = sendByte
 	return bw
 }
 
+// SendBytes return the total sent bytes
+func (t *WebMProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
 func ValidateVPFile(name string) (string, bool) {
 	list := strings.Split(name, ".")
 	if len(list) < 2 {
@@ -0,0 +1,463 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	y4mMagic          = "YUV4MPEG2"
+	defaultY4MBitrate = 1000000
+	// defaultKeyFrameInterval is in frames
+	defaultKeyFrameInterval = 60
+)
+
+// y4mReader read the 4:2:0 8 bit frames of a yuv4mpeg2 stream
+type y4mReader struct {
+	r             *bufio.Reader
+	width, height int
+	// the frame rate is num/den
+	num, den int
+}
+
+func newY4MReader(r io.Reader) (*y4mReader, error) {
+	y := &y4mReader{r: bufio.NewReader(r), num: defaultFrameRate, den: 1}
+	line, err := y.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return nil, errInvalidFile
+	}
+	for _, f := range fields[1:] {
+		switch f[0] {
+		case 'W':
+			y.width, _ = strconv.Atoi(f[1:])
+		case 'H':
+			y.height, _ = strconv.Atoi(f[1:])
+		case 'F':
+			rate := strings.SplitN(f[1:], ":", 2)
+			if len(rate) != 2 {
+				return nil, errInvalidFile
+			}
+			y.num, _ = strconv.Atoi(rate[0])
+			y.den, _ = strconv.Atoi(rate[1])
+		case 'C':
+			switch f[1:] {
+			case "420", "420jpeg", "420paldv", "420mpeg2":
+			default:
+				return nil, errUnsupportedY4M
+			}
+		}
+	}
+	if y.width <= 0 || y.height <= 0 || y.width > 0x3fff || y.height > 0x3fff || y.num <= 0 || y.den <= 0 {
+		return nil, errInvalidFile
+	}
+	return y, nil
+}
+
+// interval return the duration of a frame
+func (y *y4mReader) interval() time.Duration {
+	return time.Second * time.Duration(y.den) / time.Duration(y.num)
+}
+
+// next read a frame into img, the frame parameters are ignored
+func (y *y4mReader) next(img *image.YCbCr) error {
+	line, err := y.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if !strings.HasPrefix(line, "FRAME") {
+		return errInvalidFile
+	}
+	for _, plane := range [][]byte{img.Y, img.Cb, img.Cr} {
+		if _, err := io.ReadFull(y.r, plane); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// videoEncoder encode i420 frames to vp8, or h264 by a hardware encoder
+type videoEncoder interface {
+	encode(img *image.YCbCr) ([]byte, error)
+	close()
+}
+
+// newVPXEncoder is set by the vpx build tag, else the frames are encoded by blockEncoder
+var newVPXEncoder func(width, height, fps, bitrate, keyInterval int) (videoEncoder, error)
+
+// blockEncoder is the pure go vp8 encoder, every frame is a key frame of the 4x4 block averages,
+// the frames are padded up to the bitrate like a test pattern
+type blockEncoder struct {
+	enc  *vp8Encoder
+	img  *vp8Image
+	size int
+}
+
+func newBlockEncoder(width, height, fps, bitrate int) *blockEncoder {
+	enc := newVP8Encoder(width, height)
+	bw, bh := enc.mbw*4, enc.mbh*4
+	return &blockEncoder{
+		enc: enc,
+		img: &vp8Image{
+			y: make([]int32, bw*bh),
+			u: make([]int32, bw*bh/4),
+			v: make([]int32, bw*bh/4),
+		},
+		size: bitrate / 8 / fps,
+	}
+}
+
+func (e *blockEncoder) encode(img *image.YCbCr) ([]byte, error) {
+	averageBlocks(e.img.y, e.enc.mbw*4, img.Y, img.YStride, img.Rect.Dx(), img.Rect.Dy())
+	cw, ch := (img.Rect.Dx()+1)/2, (img.Rect.Dy()+1)/2
+	averageBlocks(e.img.u, e.enc.mbw*2, img.Cb, img.CStride, cw, ch)
+	averageBlocks(e.img.v, e.enc.mbw*2, img.Cr, img.CStride, cw, ch)
+	frame := e.enc.encode(e.img)
+	// the decoder ignore the bytes after the partitions
+	if len(frame) < e.size {
+		frame = append(frame, make([]byte, e.size-len(frame))...)
+	}
+	return frame, nil
+}
+
+func (e *blockEncoder) close() {}
+
+// averageBlocks set every 4x4 block of dst to the mean of the plane, the blocks past the edges repeat them
+func averageBlocks(dst []int32, stride int, plane []byte, planeStride, width, height int) {
+	for i := range dst {
+		bx, by := i%stride*4, i/stride*4
+		var sum int32
+		for y := by; y < by+4; y++ {
+			for x := bx; x < bx+4; x++ {
+				px, py := x, y
+				if px >= width {
+					px = width - 1
+				}
+				if py >= height {
+					py = height - 1
+				}
+				sum += int32(plane[py*planeStride+px])
+			}
+		}
+		dst[i] = (sum + 8) / 16
+	}
+}
+
+// Y4MProducer publish the raw video of a yuv4mpeg2 stream encoded to vp8, e.g. the output of a
+// capture pipeline like ffmpeg -f yuv4mpegpipe, only 4:2:0 8 bit is supported
+// libvpx is used with the vpx build tag, else the pure go encoder of key frames of 4x4 blocks
+// UseHardwareEncoder encode to h264 by a hardware encoder instead
+type Y4MProducer struct {
+	id       string
+	source   io.Reader
+	reader   *y4mReader
+	track    *webrtc.TrackLocalStaticSample
+	sendByte uint64
+	done     chan struct{}
+	stopOnce sync.Once
+	pauseGate
+	progress
+	// Loop restart the stream when it ends, if it is an io.Seeker
+	Loop bool
+	// hw is the h264 hardware encoder, any if empty, see UseHardwareEncoder
+	hw    string
+	useHW bool
+
+	mu          sync.Mutex
+	bitrate     int
+	keyInterval int
+	// the encoder is rebuilt with the next frame
+	changed bool
+}
+
+// NewY4MProducer read the header of a y4m stream, bitrate is in bps, keyInterval in frames
+// 0 is 1Mbps and a key frame every 60 frames, an io.Closer r is closed when the producer stops
+func NewY4MProducer(id string, r io.Reader, bitrate, keyInterval int) (*Y4MProducer, error) {
+	reader, err := newY4MReader(r)
+	if err != nil {
+		log.Errorf("read y4m header err=%v", err)
+		return nil, err
+	}
+	if bitrate <= 0 {
+		bitrate = defaultY4MBitrate
+	}
+	if keyInterval <= 0 {
+		keyInterval = defaultKeyFrameInterval
+	}
+	return &Y4MProducer{
+		id:          id,
+		source:      r,
+		reader:      reader,
+		bitrate:     bitrate,
+		keyInterval: keyInterval,
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// UseHardwareEncoder encode to h264 by the hardware encoder of name, e.g. HWEncoderVAAPI, an empty name
+// try the built in ones in order, it must be called before AddTrack
+func (t *Y4MProducer) UseHardwareEncoder(name string) error {
+	if !hasHWEncoder(name) {
+		return errNoHWEncoder
+	}
+	t.hw, t.useHW = name, true
+	return nil
+}
+
+// AddTrack add the vp8 or h264 track to pc, y4m has no audio
+func (t *Y4MProducer) AddTrack(pc *webrtc.PeerConnection, kind string) (*webrtc.TrackLocalStaticSample, error) {
+	if pc == nil {
+		return nil, errInvalidPC
+	}
+	if kind != "video" {
+		return nil, errInvalidKind
+	}
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	if t.useHW {
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, "video", fmt.Sprintf("y4m_%p", t))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		log.Errorf("err=%v", err)
+		return nil, err
+	}
+	t.track = track
+	return track, nil
+}
+
+func (t *Y4MProducer) Start() {
+	go t.readLoop()
+}
+
+func (t *Y4MProducer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// SetBitrate change the encoder bitrate(bps) from the next frame, which is a key frame
+func (t *Y4MProducer) SetBitrate(bitrate int) {
+	if bitrate <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bitrate, t.changed = bitrate, true
+}
+
+// SetKeyFrameInterval change the key frame interval(frames) from the next frame, which is a key frame
+func (t *Y4MProducer) SetKeyFrameInterval(frames int) {
+	if frames <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keyInterval, t.changed = frames, true
+}
+
+// SeekTo is not supported by a raw stream
+func (t *Y4MProducer) SeekTo(d time.Duration) error {
+	return errNotSeekable
+}
+
+// newEncoder return the hardware encoder if used, the libvpx encoder if built with vpx, else the pure go one
+func (t *Y4MProducer) newEncoder() (videoEncoder, error) {
+	t.mu.Lock()
+	bitrate, keyInterval := t.bitrate, t.keyInterval
+	t.changed = false
+	t.mu.Unlock()
+	fps := (t.reader.num + t.reader.den - 1) / t.reader.den
+	if t.useHW {
+		return newHWEncoder(t.hw, t.reader.width, t.reader.height, fps, bitrate, keyInterval)
+	}
+	if newVPXEncoder != nil {
+		return newVPXEncoder(t.reader.width, t.reader.height, fps, bitrate, keyInterval)
+	}
+	return newBlockEncoder(t.reader.width, t.reader.height, fps, bitrate), nil
+}
+
+// rewind restart a seekable stream at its first frame
+func (t *Y4MProducer) rewind() error {
+	s, ok := t.source.(io.Seeker)
+	if !ok {
+		return io.EOF
+	}
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader, err := newY4MReader(t.source)
+	if err != nil {
+		return err
+	}
+	t.reader = reader
+	return nil
+}
+
+func (t *Y4MProducer) readLoop() {
+	if c, ok := t.source.(io.Closer); ok {
+		defer c.Close()
+	}
+	enc, err := t.newEncoder()
+	if err != nil {
+		log.Errorf("id=%v encoder err=%v", t.id, err)
+		t.ended(err)
+		return
+	}
+	defer func() {
+		if enc != nil {
+			enc.close()
+		}
+	}()
+	interval := t.reader.interval()
+	img := image.NewYCbCr(image.Rect(0, 0, t.reader.width, t.reader.height), image.YCbCrSubsampleRatio420)
+	clock := newMediaClock()
+	// frame is the index in the stream, pending is the duration of the frames buffered by the encoder
+	var frame int
+	var pending time.Duration
+	var end error
+	for n := 0; ; n++ {
+		if !clock.wait(time.Duration(n)*interval, t.done) {
+			return
+		}
+		gap, ok := t.waitResume(t.done)
+		if !ok {
+			return
+		}
+		if gap > 0 {
+			clock.reset(time.Duration(n) * interval)
+		}
+		err := t.reader.next(img)
+		if err == io.EOF && t.Loop {
+			// the rewound stream keeps the size of the track
+			if err = t.rewind(); err == nil && (t.reader.width != img.Rect.Dx() || t.reader.height != img.Rect.Dy()) {
+				err = errInvalidFile
+			}
+			if err == nil {
+				err = t.reader.next(img)
+				frame = 0
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("id=%v read y4m err=%v", t.id, err)
+				end = err
+			}
+			break
+		}
+		t.setPosition(time.Duration(frame) * interval)
+		frame++
+		t.mu.Lock()
+		changed := t.changed
+		t.mu.Unlock()
+		if changed {
+			enc.close()
+			if enc, err = t.newEncoder(); err != nil {
+				log.Errorf("id=%v encoder err=%v", t.id, err)
+				end = err
+				break
+			}
+		}
+		data, err := enc.encode(img)
+		if err != nil {
+			log.Errorf("id=%v encode err=%v", t.id, err)
+			end = err
+			break
+		}
+		if len(data) == 0 {
+			pending += interval + gap
+			continue
+		}
+		if t.track == nil {
+			continue
+		}
+		duration := interval + gap + pending
+		pending = 0
+		if err := t.track.WriteSample(media.Sample{Data: data, Duration: duration}); err != nil {
+			log.Errorf("Track write error=%v", err)
+			continue
+		}
+		atomic.AddUint64(&t.sendByte, uint64(len(data)))
+		t.sent(len(data))
+	}
+	t.ended(end)
+	log.Infof("Exiting y4m producer")
+}
+
+// SendBytes return the total sent bytes
+func (t *Y4MProducer) SendBytes() uint64 {
+	return atomic.LoadUint64(&t.sendByte)
+}
+
+// PublishY4M publish a y4m stream encoded to vp8, see NewY4MProducer
+func (c *Client) PublishY4M(r io.Reader, bitrate, keyInterval int) error {
+	if c.noPublish {
+		return errNoPublish
+	}
+	p, err := NewY4MProducer(c.uid, r, bitrate, keyInterval)
+	if err != nil {
+		return err
+	}
+	if _, err := p.AddTrack(c.getPub().pc, "video"); err != nil {
+		return err
+	}
+	c.setProducer(p)
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
+
+// publishY4M publish a y4m file by PublishFile
+func (c *Client) publishY4M(file string, o fileOptions) error {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Errorf("unable to open file %s", file)
+		return err
+	}
+	p, err := NewY4MProducer(c.uid, f, o.bitrate, 0)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if o.hw != nil {
+		if err := p.UseHardwareEncoder(*o.hw); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if o.loop != nil {
+		p.Loop = *o.loop
+	}
+	c.setProducer(p)
+	if _, err := p.AddTrack(c.getPub().pc, "video"); err != nil {
+		return err
+	}
+	p.Start()
+	c.OnNegotiationNeeded()
+	return nil
+}
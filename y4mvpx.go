@@ -0,0 +1,59 @@
+//go:build vpx
+// +build vpx
+
+package engine
+
+import (
+	"image"
+
+	"github.com/pion/mediadevices/pkg/codec"
+	"github.com/pion/mediadevices/pkg/codec/vpx"
+	"github.com/pion/mediadevices/pkg/io/video"
+	"github.com/pion/mediadevices/pkg/prop"
+)
+
+// build with -tags vpx to encode Y4MProducer by libvpx, it needs cgo and link the libvpx of mediadevices
+func init() {
+	newVPXEncoder = newLibVPXEncoder
+}
+
+// libVPXEncoder encode by the mediadevices encoder, it pull the frame set by encode
+type libVPXEncoder struct {
+	frame  *image.YCbCr
+	reader codec.ReadCloser
+}
+
+func newLibVPXEncoder(width, height, fps, bitrate, keyInterval int) (videoEncoder, error) {
+	params, err := vpx.NewVP8Params()
+	if err != nil {
+		return nil, err
+	}
+	params.BitRate = bitrate
+	params.KeyFrameInterval = keyInterval
+	params.RateControlEndUsage = vpx.RateControlCBR
+	e := &libVPXEncoder{}
+	r := video.ReaderFunc(func() (image.Image, func(), error) {
+		return e.frame, func() {}, nil
+	})
+	e.reader, err = params.BuildVideoEncoder(r, prop.Media{
+		Video: prop.Video{Width: width, Height: height, FrameRate: float32(fps)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *libVPXEncoder) encode(img *image.YCbCr) ([]byte, error) {
+	e.frame = img
+	b, release, err := e.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return append([]byte{}, b...), nil
+}
+
+func (e *libVPXEncoder) close() {
+	e.reader.Close()
+}